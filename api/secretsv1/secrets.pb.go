@@ -0,0 +1,589 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.6
+// 	protoc        (unknown)
+// source: api/secrets.proto
+
+// SecretService serves the same secrets as the HTTP API, with the same API keys sent on the x-api-key metadata
+
+package secretsv1
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type GetSecretRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	Name  string                 `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	// version is empty or latest for the latest version
+	Version       string `protobuf:"bytes,2,opt,name=version,proto3" json:"version,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetSecretRequest) Reset() {
+	*x = GetSecretRequest{}
+	mi := &file_api_secrets_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetSecretRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetSecretRequest) ProtoMessage() {}
+
+func (x *GetSecretRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_api_secrets_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetSecretRequest.ProtoReflect.Descriptor instead.
+func (*GetSecretRequest) Descriptor() ([]byte, []int) {
+	return file_api_secrets_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *GetSecretRequest) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *GetSecretRequest) GetVersion() string {
+	if x != nil {
+		return x.Version
+	}
+	return ""
+}
+
+type GetSecretResponse struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	Name  string                 `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	Value string                 `protobuf:"bytes,2,opt,name=value,proto3" json:"value,omitempty"`
+	// version is only set when a specific version was asked for
+	Version       string `protobuf:"bytes,3,opt,name=version,proto3" json:"version,omitempty"`
+	IsFallback    bool   `protobuf:"varint,4,opt,name=is_fallback,json=isFallback,proto3" json:"is_fallback,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetSecretResponse) Reset() {
+	*x = GetSecretResponse{}
+	mi := &file_api_secrets_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetSecretResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetSecretResponse) ProtoMessage() {}
+
+func (x *GetSecretResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_api_secrets_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetSecretResponse.ProtoReflect.Descriptor instead.
+func (*GetSecretResponse) Descriptor() ([]byte, []int) {
+	return file_api_secrets_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *GetSecretResponse) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *GetSecretResponse) GetValue() string {
+	if x != nil {
+		return x.Value
+	}
+	return ""
+}
+
+func (x *GetSecretResponse) GetVersion() string {
+	if x != nil {
+		return x.Version
+	}
+	return ""
+}
+
+func (x *GetSecretResponse) GetIsFallback() bool {
+	if x != nil {
+		return x.IsFallback
+	}
+	return false
+}
+
+type BatchGetSecretsRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Names         []string               `protobuf:"bytes,1,rep,name=names,proto3" json:"names,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *BatchGetSecretsRequest) Reset() {
+	*x = BatchGetSecretsRequest{}
+	mi := &file_api_secrets_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *BatchGetSecretsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*BatchGetSecretsRequest) ProtoMessage() {}
+
+func (x *BatchGetSecretsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_api_secrets_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use BatchGetSecretsRequest.ProtoReflect.Descriptor instead.
+func (*BatchGetSecretsRequest) Descriptor() ([]byte, []int) {
+	return file_api_secrets_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *BatchGetSecretsRequest) GetNames() []string {
+	if x != nil {
+		return x.Names
+	}
+	return nil
+}
+
+type BatchGetSecretsResponse struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// secrets keep the order of the names
+	Secrets       []*BatchSecret `protobuf:"bytes,1,rep,name=secrets,proto3" json:"secrets,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *BatchGetSecretsResponse) Reset() {
+	*x = BatchGetSecretsResponse{}
+	mi := &file_api_secrets_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *BatchGetSecretsResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*BatchGetSecretsResponse) ProtoMessage() {}
+
+func (x *BatchGetSecretsResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_api_secrets_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use BatchGetSecretsResponse.ProtoReflect.Descriptor instead.
+func (*BatchGetSecretsResponse) Descriptor() ([]byte, []int) {
+	return file_api_secrets_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *BatchGetSecretsResponse) GetSecrets() []*BatchSecret {
+	if x != nil {
+		return x.Secrets
+	}
+	return nil
+}
+
+type BatchSecret struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	Name  string                 `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	// code is the google.rpc.Code GetSecret would have failed with, 0 when the value is set
+	Code          int32  `protobuf:"varint,2,opt,name=code,proto3" json:"code,omitempty"`
+	Value         string `protobuf:"bytes,3,opt,name=value,proto3" json:"value,omitempty"`
+	IsFallback    bool   `protobuf:"varint,4,opt,name=is_fallback,json=isFallback,proto3" json:"is_fallback,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *BatchSecret) Reset() {
+	*x = BatchSecret{}
+	mi := &file_api_secrets_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *BatchSecret) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*BatchSecret) ProtoMessage() {}
+
+func (x *BatchSecret) ProtoReflect() protoreflect.Message {
+	mi := &file_api_secrets_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use BatchSecret.ProtoReflect.Descriptor instead.
+func (*BatchSecret) Descriptor() ([]byte, []int) {
+	return file_api_secrets_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *BatchSecret) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *BatchSecret) GetCode() int32 {
+	if x != nil {
+		return x.Code
+	}
+	return 0
+}
+
+func (x *BatchSecret) GetValue() string {
+	if x != nil {
+		return x.Value
+	}
+	return ""
+}
+
+func (x *BatchSecret) GetIsFallback() bool {
+	if x != nil {
+		return x.IsFallback
+	}
+	return false
+}
+
+type ListSecretsRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	PageSize      int32                  `protobuf:"varint,1,opt,name=page_size,json=pageSize,proto3" json:"page_size,omitempty"`
+	PageToken     string                 `protobuf:"bytes,2,opt,name=page_token,json=pageToken,proto3" json:"page_token,omitempty"`
+	WithMetadata  bool                   `protobuf:"varint,3,opt,name=with_metadata,json=withMetadata,proto3" json:"with_metadata,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListSecretsRequest) Reset() {
+	*x = ListSecretsRequest{}
+	mi := &file_api_secrets_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListSecretsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListSecretsRequest) ProtoMessage() {}
+
+func (x *ListSecretsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_api_secrets_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListSecretsRequest.ProtoReflect.Descriptor instead.
+func (*ListSecretsRequest) Descriptor() ([]byte, []int) {
+	return file_api_secrets_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *ListSecretsRequest) GetPageSize() int32 {
+	if x != nil {
+		return x.PageSize
+	}
+	return 0
+}
+
+func (x *ListSecretsRequest) GetPageToken() string {
+	if x != nil {
+		return x.PageToken
+	}
+	return ""
+}
+
+func (x *ListSecretsRequest) GetWithMetadata() bool {
+	if x != nil {
+		return x.WithMetadata
+	}
+	return false
+}
+
+type ListSecretsResponse struct {
+	state   protoimpl.MessageState `protogen:"open.v1"`
+	Secrets []*SecretInfo          `protobuf:"bytes,1,rep,name=secrets,proto3" json:"secrets,omitempty"`
+	// next_page_token is empty on the last page
+	NextPageToken string `protobuf:"bytes,2,opt,name=next_page_token,json=nextPageToken,proto3" json:"next_page_token,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListSecretsResponse) Reset() {
+	*x = ListSecretsResponse{}
+	mi := &file_api_secrets_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListSecretsResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListSecretsResponse) ProtoMessage() {}
+
+func (x *ListSecretsResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_api_secrets_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListSecretsResponse.ProtoReflect.Descriptor instead.
+func (*ListSecretsResponse) Descriptor() ([]byte, []int) {
+	return file_api_secrets_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *ListSecretsResponse) GetSecrets() []*SecretInfo {
+	if x != nil {
+		return x.Secrets
+	}
+	return nil
+}
+
+func (x *ListSecretsResponse) GetNextPageToken() string {
+	if x != nil {
+		return x.NextPageToken
+	}
+	return ""
+}
+
+type SecretInfo struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Name          string                 `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	CreateTime    string                 `protobuf:"bytes,2,opt,name=create_time,json=createTime,proto3" json:"create_time,omitempty"`
+	Labels        map[string]string      `protobuf:"bytes,3,rep,name=labels,proto3" json:"labels,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SecretInfo) Reset() {
+	*x = SecretInfo{}
+	mi := &file_api_secrets_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SecretInfo) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SecretInfo) ProtoMessage() {}
+
+func (x *SecretInfo) ProtoReflect() protoreflect.Message {
+	mi := &file_api_secrets_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SecretInfo.ProtoReflect.Descriptor instead.
+func (*SecretInfo) Descriptor() ([]byte, []int) {
+	return file_api_secrets_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *SecretInfo) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *SecretInfo) GetCreateTime() string {
+	if x != nil {
+		return x.CreateTime
+	}
+	return ""
+}
+
+func (x *SecretInfo) GetLabels() map[string]string {
+	if x != nil {
+		return x.Labels
+	}
+	return nil
+}
+
+var File_api_secrets_proto protoreflect.FileDescriptor
+
+const file_api_secrets_proto_rawDesc = "" +
+	"\n" +
+	"\x11api/secrets.proto\x12\n" +
+	"secrets.v1\"@\n" +
+	"\x10GetSecretRequest\x12\x12\n" +
+	"\x04name\x18\x01 \x01(\tR\x04name\x12\x18\n" +
+	"\aversion\x18\x02 \x01(\tR\aversion\"x\n" +
+	"\x11GetSecretResponse\x12\x12\n" +
+	"\x04name\x18\x01 \x01(\tR\x04name\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value\x12\x18\n" +
+	"\aversion\x18\x03 \x01(\tR\aversion\x12\x1f\n" +
+	"\vis_fallback\x18\x04 \x01(\bR\n" +
+	"isFallback\".\n" +
+	"\x16BatchGetSecretsRequest\x12\x14\n" +
+	"\x05names\x18\x01 \x03(\tR\x05names\"L\n" +
+	"\x17BatchGetSecretsResponse\x121\n" +
+	"\asecrets\x18\x01 \x03(\v2\x17.secrets.v1.BatchSecretR\asecrets\"l\n" +
+	"\vBatchSecret\x12\x12\n" +
+	"\x04name\x18\x01 \x01(\tR\x04name\x12\x12\n" +
+	"\x04code\x18\x02 \x01(\x05R\x04code\x12\x14\n" +
+	"\x05value\x18\x03 \x01(\tR\x05value\x12\x1f\n" +
+	"\vis_fallback\x18\x04 \x01(\bR\n" +
+	"isFallback\"u\n" +
+	"\x12ListSecretsRequest\x12\x1b\n" +
+	"\tpage_size\x18\x01 \x01(\x05R\bpageSize\x12\x1d\n" +
+	"\n" +
+	"page_token\x18\x02 \x01(\tR\tpageToken\x12#\n" +
+	"\rwith_metadata\x18\x03 \x01(\bR\fwithMetadata\"o\n" +
+	"\x13ListSecretsResponse\x120\n" +
+	"\asecrets\x18\x01 \x03(\v2\x16.secrets.v1.SecretInfoR\asecrets\x12&\n" +
+	"\x0fnext_page_token\x18\x02 \x01(\tR\rnextPageToken\"\xb8\x01\n" +
+	"\n" +
+	"SecretInfo\x12\x12\n" +
+	"\x04name\x18\x01 \x01(\tR\x04name\x12\x1f\n" +
+	"\vcreate_time\x18\x02 \x01(\tR\n" +
+	"createTime\x12:\n" +
+	"\x06labels\x18\x03 \x03(\v2\".secrets.v1.SecretInfo.LabelsEntryR\x06labels\x1a9\n" +
+	"\vLabelsEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x012\x85\x02\n" +
+	"\rSecretService\x12H\n" +
+	"\tGetSecret\x12\x1c.secrets.v1.GetSecretRequest\x1a\x1d.secrets.v1.GetSecretResponse\x12Z\n" +
+	"\x0fBatchGetSecrets\x12\".secrets.v1.BatchGetSecretsRequest\x1a#.secrets.v1.BatchGetSecretsResponse\x12N\n" +
+	"\vListSecrets\x12\x1e.secrets.v1.ListSecretsRequest\x1a\x1f.secrets.v1.ListSecretsResponseB#Z!secret-manager-demo/api/secretsv1b\x06proto3"
+
+var (
+	file_api_secrets_proto_rawDescOnce sync.Once
+	file_api_secrets_proto_rawDescData []byte
+)
+
+func file_api_secrets_proto_rawDescGZIP() []byte {
+	file_api_secrets_proto_rawDescOnce.Do(func() {
+		file_api_secrets_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_api_secrets_proto_rawDesc), len(file_api_secrets_proto_rawDesc)))
+	})
+	return file_api_secrets_proto_rawDescData
+}
+
+var file_api_secrets_proto_msgTypes = make([]protoimpl.MessageInfo, 9)
+var file_api_secrets_proto_goTypes = []any{
+	(*GetSecretRequest)(nil),        // 0: secrets.v1.GetSecretRequest
+	(*GetSecretResponse)(nil),       // 1: secrets.v1.GetSecretResponse
+	(*BatchGetSecretsRequest)(nil),  // 2: secrets.v1.BatchGetSecretsRequest
+	(*BatchGetSecretsResponse)(nil), // 3: secrets.v1.BatchGetSecretsResponse
+	(*BatchSecret)(nil),             // 4: secrets.v1.BatchSecret
+	(*ListSecretsRequest)(nil),      // 5: secrets.v1.ListSecretsRequest
+	(*ListSecretsResponse)(nil),     // 6: secrets.v1.ListSecretsResponse
+	(*SecretInfo)(nil),              // 7: secrets.v1.SecretInfo
+	nil,                             // 8: secrets.v1.SecretInfo.LabelsEntry
+}
+var file_api_secrets_proto_depIdxs = []int32{
+	4, // 0: secrets.v1.BatchGetSecretsResponse.secrets:type_name -> secrets.v1.BatchSecret
+	7, // 1: secrets.v1.ListSecretsResponse.secrets:type_name -> secrets.v1.SecretInfo
+	8, // 2: secrets.v1.SecretInfo.labels:type_name -> secrets.v1.SecretInfo.LabelsEntry
+	0, // 3: secrets.v1.SecretService.GetSecret:input_type -> secrets.v1.GetSecretRequest
+	2, // 4: secrets.v1.SecretService.BatchGetSecrets:input_type -> secrets.v1.BatchGetSecretsRequest
+	5, // 5: secrets.v1.SecretService.ListSecrets:input_type -> secrets.v1.ListSecretsRequest
+	1, // 6: secrets.v1.SecretService.GetSecret:output_type -> secrets.v1.GetSecretResponse
+	3, // 7: secrets.v1.SecretService.BatchGetSecrets:output_type -> secrets.v1.BatchGetSecretsResponse
+	6, // 8: secrets.v1.SecretService.ListSecrets:output_type -> secrets.v1.ListSecretsResponse
+	6, // [6:9] is the sub-list for method output_type
+	3, // [3:6] is the sub-list for method input_type
+	3, // [3:3] is the sub-list for extension type_name
+	3, // [3:3] is the sub-list for extension extendee
+	0, // [0:3] is the sub-list for field type_name
+}
+
+func init() { file_api_secrets_proto_init() }
+func file_api_secrets_proto_init() {
+	if File_api_secrets_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_api_secrets_proto_rawDesc), len(file_api_secrets_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   9,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_api_secrets_proto_goTypes,
+		DependencyIndexes: file_api_secrets_proto_depIdxs,
+		MessageInfos:      file_api_secrets_proto_msgTypes,
+	}.Build()
+	File_api_secrets_proto = out.File
+	file_api_secrets_proto_goTypes = nil
+	file_api_secrets_proto_depIdxs = nil
+}
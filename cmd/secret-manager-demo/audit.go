@@ -0,0 +1,277 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"secret-manager-demo/pkg/secrets"
+)
+
+// AuditRecord is an entry of the audit log, it never includes the value
+// The action is put, disable or destroy for writes, and empty for reads
+type AuditRecord struct {
+	Time    time.Time `json:"time"`
+	Caller  string    `json:"caller"`
+	Client  string    `json:"client"`
+	Name    string    `json:"name"`
+	Version string    `json:"version,omitempty"`
+	Action  string    `json:"action,omitempty"`
+	Result  string    `json:"result"`
+}
+
+// AuditLog writes a JSON line per secret access to its sink
+// Records are written before the response so the trail is complete, a nil AuditLog records nothing
+type AuditLog struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// Record writes the record, failures are logged as the access already happened
+func (a *AuditLog) Record(record AuditRecord) {
+	if a == nil {
+		return
+	}
+
+	line, err := json.Marshal(record)
+	if err != nil {
+		slog.Error("writing audit record", "error", err)
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	_, err = a.w.Write(append(line, '\n'))
+	if err != nil {
+		slog.Error("writing audit record", "name", record.Name, "error", err)
+	}
+}
+
+// newAuditLog returns the audit log for the sink, which is stdout, file:<path> or cloud-logging
+func newAuditLog(sink string) (*AuditLog, error) {
+	switch {
+	case sink == "stdout":
+		return &AuditLog{w: os.Stdout}, nil
+	case strings.HasPrefix(sink, "file:"):
+		// Appending keeps the trail of previous runs, rotating the file is left to the platform
+		file, err := os.OpenFile(strings.TrimPrefix(sink, "file:"), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+		if err != nil {
+			return nil, err
+		}
+		return &AuditLog{w: file}, nil
+	case sink == "cloud-logging":
+		project := secrets.GetEnv("GCP_PROJECT", "")
+		if project == "" {
+			return nil, fmt.Errorf("GCP_PROJECT is required for the cloud-logging sink")
+		}
+		credentials, err := secrets.FindDefaultCredentials()
+		if err != nil {
+			return nil, err
+		}
+		return &AuditLog{w: newCloudLoggingWriter(project, secrets.GetEnv("AUDIT_LOG_NAME", "secret-access"), credentials)}, nil
+	default:
+		return nil, fmt.Errorf("unknown sink %q, expected stdout, file:<path> or cloud-logging", sink)
+	}
+}
+
+// callerIdentity tells who made the request, never including credentials
+// It is the subject of a valid token, a short hash of the API key, or the subject of the client certificate,
+// and anonymous when there are none of those
+func callerIdentity(rq *http.Request, options handlerOptions) string {
+	if token, ok := bearerToken(rq); ok && options.JWT != nil {
+		if claims, err := options.JWT.verify(rq.Context(), token); err == nil {
+			return "jwt:" + claims.Subject
+		}
+	}
+	if key := rq.Header.Get(apiKeyHeader); key != "" {
+		hash := sha256.Sum256([]byte(key))
+		return "key:" + hex.EncodeToString(hash[:])[:12]
+	}
+	if rq.TLS != nil && len(rq.TLS.PeerCertificates) > 0 {
+		return "cert:" + rq.TLS.PeerCertificates[0].Subject.String()
+	}
+	return "anonymous"
+}
+
+// recordAccess sends the access to the access events webhook and the audit log, whichever are configured
+func (o handlerOptions) recordAccess(rq *http.Request, now time.Time, name string, version string, result string) {
+	o.AccessEvents.Emit(secrets.AccessEvent{
+		Name:   name,
+		Time:   now,
+		Client: rq.RemoteAddr,
+		Result: result,
+	})
+	if o.Audit == nil {
+		return
+	}
+	o.Audit.Record(AuditRecord{
+		Time:    now,
+		Caller:  callerIdentity(rq, o),
+		Client:  rq.RemoteAddr,
+		Name:    name,
+		Version: version,
+		Result:  result,
+	})
+}
+
+// recordWrite sends the write to the audit log, access events are only sent for reads
+func (o handlerOptions) recordWrite(rq *http.Request, now time.Time, action string, name string, version string, status int) {
+	if o.Audit == nil {
+		return
+	}
+	o.Audit.Record(AuditRecord{
+		Time:    now,
+		Caller:  callerIdentity(rq, o),
+		Client:  rq.RemoteAddr,
+		Name:    name,
+		Version: version,
+		Action:  action,
+		Result:  writeResult(status),
+	})
+}
+
+// writeResult is the result of a write, named like accessResult
+func writeResult(status int) string {
+	switch status {
+	case http.StatusOK, http.StatusCreated, http.StatusNoContent:
+		return "ok"
+	default:
+		return strings.ToLower(strings.ReplaceAll(http.StatusText(status), " ", "-"))
+	}
+}
+
+// resolutionResult is the result of an access for handlers that resolve the secret themselves, named like accessResult
+func resolutionResult(resolution secrets.Resolution, err error) string {
+	switch {
+	case errors.Is(err, secrets.ErrSecretNotFound):
+		return "not-found"
+	case err != nil:
+		return "bad-gateway"
+	case resolution.IsFallback():
+		return "fallback"
+	default:
+		return "ok"
+	}
+}
+
+const (
+	// cloudLoggingBuffer is how many records wait to be written to Cloud Logging
+	cloudLoggingBuffer = 1000
+	// cloudLoggingFlushInterval is how often the waiting records are written
+	cloudLoggingFlushInterval = time.Second
+)
+
+// cloudLoggingWriter writes every line as the JSON payload of an entry on Cloud Logging, on the background
+// Lines are dropped rather than blocking the request path when the buffer is full, and the drops are logged
+type cloudLoggingWriter struct {
+	project     string
+	logName     string
+	credentials secrets.GCPCredentials
+	lines       chan []byte
+	dropped     uint64
+}
+
+func newCloudLoggingWriter(project string, logName string, credentials secrets.GCPCredentials) *cloudLoggingWriter {
+	w := &cloudLoggingWriter{
+		project:     project,
+		logName:     logName,
+		credentials: &secrets.CachedCredentials{Credentials: credentials},
+		lines:       make(chan []byte, cloudLoggingBuffer),
+	}
+	go w.run()
+	return w
+}
+
+// Write queues the line, it never blocks
+func (w *cloudLoggingWriter) Write(line []byte) (int, error) {
+	select {
+	case w.lines <- append([]byte(nil), line...):
+	default:
+		atomic.AddUint64(&w.dropped, 1)
+	}
+	return len(line), nil
+}
+
+// run writes the queued lines in batches on every flush interval
+func (w *cloudLoggingWriter) run() {
+	ticker := time.NewTicker(cloudLoggingFlushInterval)
+	defer ticker.Stop()
+
+	var batch [][]byte
+	for {
+		select {
+		case line := <-w.lines:
+			batch = append(batch, line)
+			continue
+		case <-ticker.C:
+		}
+
+		if dropped := atomic.SwapUint64(&w.dropped, 0); dropped > 0 {
+			slog.Error("dropped audit records, the Cloud Logging buffer was full", "count", dropped)
+		}
+		if len(batch) == 0 {
+			continue
+		}
+		err := w.writeEntries(batch)
+		if err != nil {
+			slog.Error("writing audit records to Cloud Logging", "count", len(batch), "error", err)
+		}
+		batch = nil
+	}
+}
+
+// writeEntries calls entries:write of the Cloud Logging API with a batch of lines
+func (w *cloudLoggingWriter) writeEntries(lines [][]byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	token, err := w.credentials.Token(ctx)
+	if err != nil {
+		return err
+	}
+
+	entries := make([]map[string]interface{}, 0, len(lines))
+	for _, line := range lines {
+		entries = append(entries, map[string]interface{}{"jsonPayload": json.RawMessage(bytes.TrimSpace(line)), "severity": "NOTICE"})
+	}
+	body, err := json.Marshal(map[string]interface{}{
+		"logName":  fmt.Sprintf("projects/%s/logs/%s", w.project, w.logName),
+		"resource": map[string]string{"type": "global"},
+		"entries":  entries,
+	})
+	if err != nil {
+		return err
+	}
+
+	rq, err := http.NewRequestWithContext(ctx, http.MethodPost, "https://logging.googleapis.com/v2/entries:write", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	rq.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token.AccessToken))
+	rq.Header.Set("Content-Type", "application/json")
+	rs, err := secrets.UpstreamClient.Do(rq)
+	if err != nil {
+		return err
+	}
+
+	content, err := secrets.ReadBody(rs)
+	if err != nil {
+		return err
+	}
+	if rs.StatusCode != http.StatusOK {
+		return fmt.Errorf("error %d - %s", rs.StatusCode, content)
+	}
+	return nil
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync/atomic"
+
+	"secret-manager-demo/pkg/secrets"
+)
+
+// apiKeyHeader is the header callers present their API key on
+const apiKeyHeader = "X-API-Key"
+
+// apiKeyHashPrefix marks the keys of the file that are the hex SHA-256 of the key instead of the key itself
+// Hashed keys let the file live in a config repository without handing out the keys
+const apiKeyHashPrefix = "sha256:"
+
+// apiKeys maps every API key to the secret names it may read, "*" allows every secret and "prefix*" the ones with the prefix
+type apiKeys map[string][]string
+
+// parseAPIKeys reads a comma separated list of API keys, every one of them allowed to read every secret
+func parseAPIKeys(list string) apiKeys {
+	keys := apiKeys{}
+	for _, key := range strings.Split(list, ",") {
+		key = strings.TrimSpace(key)
+		if key != "" {
+			keys[key] = []string{"*"}
+		}
+	}
+	return keys
+}
+
+// loadAPIKeys reads the API keys and their allowed secrets from a JSON file
+func loadAPIKeys(path string) (apiKeys, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := apiKeys{}
+	err = json.Unmarshal(content, &keys)
+	if err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// loadAPIKeysFromEnv reads API_KEYS_FILE and API_KEYS, it returns nil when neither is set
+// The keys of the file may be hashed, while the ones listed on API_KEYS can read every secret
+func loadAPIKeysFromEnv() (apiKeys, error) {
+	var keys apiKeys
+	if apiKeysFile := secrets.GetEnv("API_KEYS_FILE", ""); apiKeysFile != "" {
+		var err error
+		keys, err = loadAPIKeys(apiKeysFile)
+		if err != nil {
+			return nil, fmt.Errorf("API_KEYS_FILE: %w", err)
+		}
+	}
+	if apiKeyList := secrets.GetEnv("API_KEYS", ""); apiKeyList != "" {
+		if keys == nil {
+			keys = apiKeys{}
+		}
+		for key, allowed := range parseAPIKeys(apiKeyList) {
+			keys[key] = allowed
+		}
+	}
+	return keys, nil
+}
+
+// Allowlist holds API keys that can be replaced while requests are served, when the configuration is reloaded
+// A nil Allowlist allows every request like nil apiKeys, while a reload can at most empty it, denying every request
+type Allowlist struct {
+	keys atomic.Pointer[apiKeys]
+}
+
+// NewAllowlist returns an allowlist with the keys, or nil for nil keys
+func NewAllowlist(keys apiKeys) *Allowlist {
+	if keys == nil {
+		return nil
+	}
+	a := &Allowlist{}
+	a.Set(keys)
+	return a
+}
+
+// Set replaces the keys, requests in flight keep the ones they started with
+func (a *Allowlist) Set(keys apiKeys) {
+	if keys == nil {
+		keys = apiKeys{}
+	}
+	a.keys.Store(&keys)
+}
+
+// Keys returns the current keys
+func (a *Allowlist) Keys() apiKeys {
+	if a == nil {
+		return nil
+	}
+	return *a.keys.Load()
+}
+
+func (a *Allowlist) authorize(rq *http.Request, name string) int {
+	return a.Keys().authorize(rq, name)
+}
+
+func (a *Allowlist) lookup(key string) ([]string, bool) {
+	return a.Keys().lookup(key)
+}
+
+// authorize tells the status for reading the secret with the API key of the request
+// Without configured API keys every request is allowed, otherwise an unknown key is 401 and a key
+// that is not allowed to read the secret is 403
+func (k apiKeys) authorize(rq *http.Request, name string) int {
+	if k == nil {
+		return http.StatusOK
+	}
+
+	allowed, ok := k.lookup(rq.Header.Get(apiKeyHeader))
+	if !ok {
+		return http.StatusUnauthorized
+	}
+
+	if !allowsSecret(allowed, name) {
+		return http.StatusForbidden
+	}
+	return http.StatusOK
+}
+
+// allowsSecret tells if the allowed names include the secret, a name ending in "*" allows every secret with that prefix
+func allowsSecret(allowed []string, name string) bool {
+	for _, allowedName := range allowed {
+		if prefix, ok := strings.CutSuffix(allowedName, "*"); ok && strings.HasPrefix(name, prefix) {
+			return true
+		}
+		if allowedName == name {
+			return true
+		}
+	}
+	return false
+}
+
+// authorize tells the status for reading the secret, checking the bearer token when the request has one and
+// JWTs are accepted, and the API key otherwise
+func (o handlerOptions) authorize(rq *http.Request, name string) int {
+	if o.JWT != nil {
+		if token, ok := bearerToken(rq); ok {
+			return o.JWT.authorize(rq.Context(), token, name)
+		}
+		if o.APIKeys == nil {
+			return http.StatusUnauthorized
+		}
+	}
+	return o.APIKeys.authorize(rq, name)
+}
+
+// lookup returns the secrets the key may read, finding it as it is or by its hash
+func (k apiKeys) lookup(key string) ([]string, bool) {
+	if key == "" {
+		return nil, false
+	}
+	if allowed, ok := k[key]; ok {
+		return allowed, true
+	}
+
+	hash := sha256.Sum256([]byte(key))
+	allowed, ok := k[apiKeyHashPrefix+hex.EncodeToString(hash[:])]
+	return allowed, ok
+}
+
+// verifiedClientCertificate tells if the request came with a client certificate signed by the CAs of
+// TLS_CLIENT_CA_FILE, certificates are not verified, and so not trusted, without them
+func verifiedClientCertificate(rq *http.Request) bool {
+	return rq.TLS != nil && len(rq.TLS.VerifiedChains) > 0
+}
+
+// requireCredentials answers 401 on every route for requests without a valid token or a known API key, read or write
+// The handlers still check the caller may use the secrets they serve, this keeps the rest of the routes behind credentials too
+// With client certificates required, a verified one is enough on every route, and the only credential without keys or JWTs
+func requireCredentials(options handlerOptions, handler http.Handler) http.Handler {
+	if options.APIKeys == nil && options.JWT == nil && !options.ClientCertificates {
+		return handler
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, rq *http.Request) {
+		if publicPaths[rq.URL.Path] {
+			handler.ServeHTTP(w, rq)
+			return
+		}
+		if (certificatePaths[rq.URL.Path] || options.ClientCertificates) && verifiedClientCertificate(rq) {
+			handler.ServeHTTP(w, rq)
+			return
+		}
+		if options.APIKeys == nil && options.JWT == nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if token, ok := bearerToken(rq); ok && options.JWT != nil {
+			if _, err := options.JWT.verify(rq.Context(), token); err != nil {
+				slog.Warn("rejecting bearer token", "error", err)
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			handler.ServeHTTP(w, rq)
+			return
+		}
+
+		key := rq.Header.Get(apiKeyHeader)
+		_, isKey := options.APIKeys.lookup(key)
+		_, isWriteKey := options.WriteKeys.lookup(key)
+		if !isKey && !isWriteKey {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		handler.ServeHTTP(w, rq)
+	})
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRequireCredentials(t *testing.T) {
+	options := handlerOptions{APIKeys: NewAllowlist(apiKeys{"key": {"db-password"}})}
+	handler := requireCredentials(options, http.HandlerFunc(func(w http.ResponseWriter, rq *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	verified := &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{{}}}}
+
+	tests := []struct {
+		name           string
+		path           string
+		apiKey         string
+		tls            *tls.ConnectionState
+		expectedStatus int
+	}{
+		{name: "probe", path: "/healthz", expectedStatus: http.StatusOK},
+		{name: "webhook without credentials", path: "/mutate", expectedStatus: http.StatusUnauthorized},
+		{name: "webhook over TLS without a client certificate", path: "/mutate", tls: &tls.ConnectionState{}, expectedStatus: http.StatusUnauthorized},
+		{name: "webhook with a verified client certificate", path: "/mutate", tls: verified, expectedStatus: http.StatusOK},
+		{name: "webhook with an API key", path: "/mutate", apiKey: "key", expectedStatus: http.StatusOK},
+		{name: "secrets with a verified client certificate", path: "/get-secret", tls: verified, expectedStatus: http.StatusUnauthorized},
+		{name: "secrets with an API key", path: "/get-secret", apiKey: "key", expectedStatus: http.StatusOK},
+		{name: "secrets with an unknown API key", path: "/get-secret", apiKey: "other", expectedStatus: http.StatusUnauthorized},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rq := httptest.NewRequest(http.MethodPost, test.path, nil)
+			rq.TLS = test.tls
+			if test.apiKey != "" {
+				rq.Header.Set(apiKeyHeader, test.apiKey)
+			}
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, rq)
+			if recorder.Code != test.expectedStatus {
+				t.Errorf("expected %d, got %d", test.expectedStatus, recorder.Code)
+			}
+		})
+	}
+}
+
+func TestRequireCredentialsWithClientCertificates(t *testing.T) {
+	verified := &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{{}}}}
+
+	tests := []struct {
+		name           string
+		options        handlerOptions
+		path           string
+		apiKey         string
+		tls            *tls.ConnectionState
+		expectedStatus int
+	}{
+		{name: "probe without a certificate", path: "/readyz", tls: &tls.ConnectionState{}, expectedStatus: http.StatusOK},
+		{name: "secrets without a certificate", path: "/get-secret", tls: &tls.ConnectionState{}, expectedStatus: http.StatusUnauthorized},
+		{name: "secrets with a verified certificate", path: "/get-secret", tls: verified, expectedStatus: http.StatusOK},
+		{name: "stats with a verified certificate", path: "/stats", tls: verified, expectedStatus: http.StatusOK},
+		{
+			name:           "secrets with an API key and no certificate",
+			options:        handlerOptions{APIKeys: NewAllowlist(apiKeys{"key": {"db-password"}})},
+			path:           "/get-secret",
+			apiKey:         "key",
+			tls:            &tls.ConnectionState{},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "secrets with an unknown API key and no certificate",
+			options:        handlerOptions{APIKeys: NewAllowlist(apiKeys{"key": {"db-password"}})},
+			path:           "/get-secret",
+			apiKey:         "other",
+			tls:            &tls.ConnectionState{},
+			expectedStatus: http.StatusUnauthorized,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.options.ClientCertificates = true
+			handler := requireCredentials(test.options, http.HandlerFunc(func(w http.ResponseWriter, rq *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			}))
+
+			rq := httptest.NewRequest(http.MethodGet, test.path, nil)
+			rq.TLS = test.tls
+			if test.apiKey != "" {
+				rq.Header.Set(apiKeyHeader, test.apiKey)
+			}
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, rq)
+			if recorder.Code != test.expectedStatus {
+				t.Errorf("expected %d, got %d", test.expectedStatus, recorder.Code)
+			}
+		})
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"secret-manager-demo/pkg/secrets"
+)
+
+// maxBatchSize bounds how many secrets a single batch request may ask for
+const maxBatchSize = 100
+
+// maxBatchRequestSize bounds the body of a batch request, which is plenty for maxBatchSize names
+const maxBatchRequestSize = 64 << 10
+
+// batchSecret is the outcome of one of the secrets of a batch, the value is only set when the status is 200
+// The version is only set when latest was pinned to it
+type batchSecret struct {
+	Name       string `json:"name"`
+	Status     int    `json:"status"`
+	Value      string `json:"value,omitempty"`
+	Version    string `json:"version,omitempty"`
+	IsFallback *bool  `json:"isFallback,omitempty"`
+	Configured *bool  `json:"configured,omitempty"`
+}
+
+// getSecretsHandler gets every secret named on the JSON array of the body, fetching up to BatchConcurrency at a time
+// Every secret carries the status /get-secret would have answered with, the response is 200 as long as the body is valid
+func getSecretsHandler(secretGetter secrets.SecretGetter, options handlerOptions) http.HandlerFunc {
+	return func(w http.ResponseWriter, rq *http.Request) {
+		var names []string
+		err := json.NewDecoder(http.MaxBytesReader(w, rq.Body, maxBatchRequestSize)).Decode(&names)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid request, expected a JSON array of secret names: %s", err), http.StatusBadRequest)
+			return
+		}
+		if len(names) > maxBatchSize {
+			http.Error(w, fmt.Sprintf("at most %d secrets can be asked for at once", maxBatchSize), http.StatusBadRequest)
+			return
+		}
+		for _, name := range names {
+			if !secretNamePattern.MatchString(name) {
+				http.Error(w, fmt.Sprintf("invalid secret name %q", name), http.StatusBadRequest)
+				return
+			}
+		}
+
+		ctx, cancel := options.requestContext(rq)
+		defer cancel()
+
+		results, statuses := resolveBatch(ctx, secretGetter, options, rq, names)
+		answers := make([]batchSecret, len(names))
+		for i, name := range names {
+			answers[i] = batchSecret{Name: name, Status: statuses[i]}
+			switch {
+			case results[i].NotConfigured:
+				configured := false
+				answers[i].Configured = &configured
+			case statuses[i] == http.StatusOK:
+				answers[i].Value = results[i].Value
+				answers[i].Version = results[i].Version
+				if options.ResponseVersion == responseV2 {
+					isFallback := results[i].IsFallback
+					answers[i].IsFallback = &isFallback
+				}
+			}
+		}
+
+		writeJSON(w, http.StatusOK, struct {
+			Secrets []batchSecret `json:"secrets"`
+		}{
+			Secrets: answers,
+		})
+	}
+}
+
+// resolveBatch resolves every secret with up to BatchConcurrency at a time, the results keep the order of the names
+// The names must be already validated, every secret is authorized and recorded as an access on its own
+// With PinLatest, a secret named more than once is resolved once, and every entry gets that same version
+func resolveBatch(ctx context.Context, secretGetter secrets.SecretGetter, options handlerOptions, rq *http.Request, names []string) ([]secretResult, []int) {
+	concurrency := options.BatchConcurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	// Every goroutine writes its own slot, so nothing else needs locking
+	results := make([]secretResult, len(names))
+	statuses := make([]int, len(names))
+	first := make(map[string]int, len(names))
+	var wg sync.WaitGroup
+	semaphore := make(chan struct{}, concurrency)
+	for i, name := range names {
+		if _, ok := first[name]; ok {
+			if options.PinLatest {
+				continue
+			}
+		} else {
+			first[name] = i
+		}
+
+		wg.Add(1)
+		semaphore <- struct{}{}
+		go func(i int, name string) {
+			defer wg.Done()
+			defer func() { <-semaphore }()
+
+			if options.PinLatest {
+				results[i], statuses[i] = resolvePinnedSecret(ctx, secretGetter, options, rq, name)
+			} else {
+				results[i], statuses[i] = resolveNamedSecret(ctx, secretGetter, options, rq, name)
+			}
+		}(i, name)
+	}
+	wg.Wait()
+
+	for i, name := range names {
+		if options.PinLatest {
+			results[i], statuses[i] = results[first[name]], statuses[first[name]]
+		}
+		options.recordAccess(rq, secretGetter.Now(), name, results[i].Version, accessResult(results[i], statuses[i]))
+	}
+	return results, statuses
+}
+
+// resolvePinnedSecret is like resolveNamedSecret, but resolves latest to a concrete version and reads that version
+// Backends without versions cannot pin, so their secrets are resolved as usual
+func resolvePinnedSecret(ctx context.Context, secretGetter secrets.SecretGetter, options handlerOptions, rq *http.Request, secretName string) (secretResult, int) {
+	lookupName := options.NameCase.normalize(secretName)
+	pinnedCtx, status := options.authorizeSecret(ctx, rq, lookupName)
+	if status != http.StatusOK {
+		return secretResult{}, status
+	}
+
+	value, version, err := secretGetter.PinLatest(pinnedCtx, lookupName)
+	switch {
+	case errors.Is(err, secrets.ErrVersionsUnavailable):
+		return resolveNamedSecret(ctx, secretGetter, options, rq, secretName)
+	case errors.Is(err, secrets.ErrSecretNotFound):
+		return secretResult{}, http.StatusNotFound
+	case err != nil:
+		return secretResult{}, http.StatusBadGateway
+	}
+
+	return secretResult{
+		Name:    secretName,
+		Value:   value,
+		Source:  secrets.ProviderSource(secretGetter.Provider),
+		Version: version,
+	}, http.StatusOK
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"secret-manager-demo/pkg/secrets"
+)
+
+// rotatingProvider adds a new version of a secret after every read of it, as if it rotated between any two reads
+type rotatingProvider struct {
+	*secrets.MemoryProvider
+	reads atomic.Int32
+}
+
+func (p *rotatingProvider) GetSecret(ctx context.Context, name string) (string, error) {
+	return p.GetSecretVersion(ctx, name, "latest")
+}
+
+func (p *rotatingProvider) GetSecretVersion(ctx context.Context, name string, version string) (string, error) {
+	value, err := p.MemoryProvider.GetSecretVersion(ctx, name, version)
+	if err != nil {
+		return "", err
+	}
+	_, _, err = p.MemoryProvider.PutSecret(ctx, name, fmt.Sprintf("rotated-%d", p.reads.Add(1)))
+	return value, err
+}
+
+func TestGetSecretsHandlerPinLatest(t *testing.T) {
+	tests := []struct {
+		name            string
+		pinLatest       bool
+		expectedValues  []string
+		expectedVersion string
+	}{
+		{name: "pinned", pinLatest: true, expectedValues: []string{"first", "first", "first", "other"}, expectedVersion: "1"},
+		{name: "not pinned", expectedValues: []string{"first", "rotated-1", "rotated-2", "other"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			provider := &rotatingProvider{MemoryProvider: secrets.NewMemoryProvider(map[string]string{"db-password": "first", "api-key": "other"})}
+			secretGetter := secrets.SecretGetter{Provider: provider, RequireFallback: true}
+			handler := getSecretsHandler(secretGetter, handlerOptions{PinLatest: test.pinLatest})
+
+			rq := httptest.NewRequest(http.MethodPost, "/get-secrets", strings.NewReader(`["db-password","db-password","db-password","api-key"]`))
+			rs := httptest.NewRecorder()
+			handler(rs, rq)
+
+			if rs.Code != http.StatusOK {
+				t.Fatalf("expected status 200, got %d", rs.Code)
+			}
+			var response struct {
+				Secrets []batchSecret `json:"secrets"`
+			}
+			if err := json.Unmarshal(rs.Body.Bytes(), &response); err != nil {
+				t.Fatalf("decoding response: %s", err)
+			}
+			if len(response.Secrets) != len(test.expectedValues) {
+				t.Fatalf("expected %d secrets, got %d", len(test.expectedValues), len(response.Secrets))
+			}
+			for i, secret := range response.Secrets {
+				if secret.Value != test.expectedValues[i] {
+					t.Errorf("secret %d: expected %s, got %s", i, test.expectedValues[i], secret.Value)
+				}
+			}
+			for _, secret := range response.Secrets[:3] {
+				if secret.Version != test.expectedVersion {
+					t.Errorf("expected version %q, got %q", test.expectedVersion, secret.Version)
+				}
+			}
+		})
+	}
+}
+
+func TestGetSecretsHandlerPinLatestWithoutVersions(t *testing.T) {
+	provider := &countingProvider{}
+	handler := getSecretsHandler(secrets.SecretGetter{Provider: provider}, handlerOptions{PinLatest: true})
+
+	rq := httptest.NewRequest(http.MethodPost, "/get-secrets", strings.NewReader(`["db-password","db-password"]`))
+	rs := httptest.NewRecorder()
+	handler(rs, rq)
+
+	var response struct {
+		Secrets []batchSecret `json:"secrets"`
+	}
+	if err := json.Unmarshal(rs.Body.Bytes(), &response); err != nil {
+		t.Fatalf("decoding response: %s", err)
+	}
+	for _, secret := range response.Secrets {
+		if secret.Status != http.StatusOK || secret.Value != "value-of-db-password" || secret.Version != "" {
+			t.Errorf("expected the value without a version, got %d with %q at %q", secret.Status, secret.Value, secret.Version)
+		}
+	}
+	// A backend without versions is still read once per secret
+	if provider.calls.Load() != 1 {
+		t.Errorf("expected 1 call to the provider, got %d", provider.calls.Load())
+	}
+}
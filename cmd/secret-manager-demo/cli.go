@@ -0,0 +1,235 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+
+	"secret-manager-demo/pkg/secrets"
+)
+
+// commandContext returns the context for the provider calls of a subcommand, bounded by the request timeout
+func (o handlerOptions) commandContext() (context.Context, context.CancelFunc) {
+	if o.RequestTimeout <= 0 {
+		return context.WithCancel(context.Background())
+	}
+	return context.WithTimeout(context.Background(), o.RequestTimeout)
+}
+
+// runGet resolves the secret named on the arguments like /get-secret does and prints its value, so scripts
+// get the same provider, cache and policies as the server
+// A fallback is only printed when one is given with -fallback, otherwise a missing secret is an error
+func runGet(w io.Writer, secretGetter secrets.SecretGetter, options handlerOptions, args []string) error {
+	flags := flag.NewFlagSet("get", flag.ContinueOnError)
+	version := flags.String("version", "", "specific version of the secret, on backends that keep versions")
+	fallback := flags.String("fallback", "", "value printed when the secret cannot be resolved")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return errors.New("usage: get [-version VERSION] [-fallback VALUE] NAME")
+	}
+
+	name := flags.Arg(0)
+	if !secretNamePattern.MatchString(name) {
+		return fmt.Errorf("invalid secret name %q", name)
+	}
+	if *version != "" && !secretVersionPattern.MatchString(*version) {
+		return fmt.Errorf("invalid version %q", *version)
+	}
+	lookupName := options.NameCase.normalize(name)
+
+	ctx, cancel := options.commandContext()
+	defer cancel()
+
+	var value string
+	if *version != "" {
+		value, err = secretGetter.GetSecretVersion(ctx, lookupName, *version)
+		if err != nil {
+			return err
+		}
+	} else {
+		fallbackSet := false
+		flags.Visit(func(f *flag.Flag) { fallbackSet = fallbackSet || f.Name == "fallback" })
+
+		resolution, err := secretGetter.ResolveContext(ctx, lookupName, *fallback)
+		if err != nil {
+			return err
+		}
+		if resolution.IsFallback() && !fallbackSet {
+			return fmt.Errorf("secret %s is not available", name)
+		}
+		value = resolution.Value
+	}
+
+	_, err = fmt.Fprintln(w, value)
+	return err
+}
+
+// repeatedFlag collects the values of a flag that can be repeated
+type repeatedFlag []string
+
+func (f *repeatedFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *repeatedFlag) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
+
+// runExec resolves the secrets named with -secret, sets them as environment variables and replaces the process
+// with the command after --, so applications get their secrets without speaking the HTTP API
+// A secret is set on the variable of its name, or on another one with -secret VARIABLE=NAME
+// Every secret must resolve to a real value, the command is not started with fallbacks
+func runExec(secretGetter secrets.SecretGetter, options handlerOptions, args []string) error {
+	flags := flag.NewFlagSet("exec", flag.ContinueOnError)
+	var secretFlags repeatedFlag
+	flags.Var(&secretFlags, "secret", "secret to set on the environment, as NAME or VARIABLE=NAME, can be repeated")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	if flags.NArg() == 0 {
+		return errors.New("usage: exec -secret NAME [-secret VARIABLE=NAME]... -- COMMAND [ARGS]...")
+	}
+
+	ctx, cancel := options.commandContext()
+	defer cancel()
+
+	values := map[string]string{}
+	for _, secret := range secretFlags {
+		variable, name, ok := strings.Cut(secret, "=")
+		if !ok {
+			name = variable
+		}
+		if !secretNamePattern.MatchString(name) {
+			return fmt.Errorf("invalid secret name %q", name)
+		}
+
+		resolution, err := secretGetter.ResolveContext(ctx, options.NameCase.normalize(name), "")
+		if err != nil {
+			return fmt.Errorf("secret %s: %w", name, err)
+		}
+		if resolution.IsFallback() {
+			return fmt.Errorf("secret %s is not available", name)
+		}
+		values[variable] = resolution.Value
+	}
+
+	// Secrets override inherited variables, which are dropped as duplicates are read differently by every program
+	var env []string
+	for _, entry := range os.Environ() {
+		variable, _, _ := strings.Cut(entry, "=")
+		if _, ok := values[variable]; !ok {
+			env = append(env, entry)
+		}
+	}
+	for variable, value := range values {
+		env = append(env, variable+"="+value)
+	}
+
+	command, err := exec.LookPath(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	return syscall.Exec(command, flags.Args(), env)
+}
+
+// exportFormats are the formats secrets can be exported as
+var exportFormats = map[string]bool{"dotenv": true, "shell": true, "json": true}
+
+// runExport resolves the named secrets and the ones listed with -prefix, and writes them as a dotenv file, shell
+// exports or a JSON object, for local development
+// Variables are named after the secret without the prefix, uppercase and with dashes as underscores
+func runExport(w io.Writer, secretGetter secrets.SecretGetter, options handlerOptions, args []string) error {
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	prefix := flags.String("prefix", "", "exports every secret listed with the prefix, which is left out of the variable names")
+	format := flags.String("format", "dotenv", "format of the output, dotenv, shell or json")
+	out := flags.String("out", "", "file the output is written to, instead of stdout")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	if !exportFormats[*format] {
+		return fmt.Errorf("unknown format %q, expected dotenv, shell or json", *format)
+	}
+	if *prefix == "" && flags.NArg() == 0 {
+		return errors.New("usage: export [-prefix PREFIX] [-format dotenv|shell|json] [-out PATH] [NAME]...")
+	}
+
+	ctx, cancel := options.commandContext()
+	defer cancel()
+
+	names := flags.Args()
+	if *prefix != "" {
+		for pageToken := ""; ; {
+			page, err := secretGetter.ListSecrets(ctx, 0, pageToken)
+			if err != nil {
+				return err
+			}
+			for _, secret := range page.Secrets {
+				if strings.HasPrefix(secret.Name, *prefix) {
+					names = append(names, secret.Name)
+				}
+			}
+			if pageToken = page.NextPageToken; pageToken == "" {
+				break
+			}
+		}
+	}
+
+	values := map[string]string{}
+	var variables []string
+	for _, name := range names {
+		if !secretNamePattern.MatchString(name) {
+			return fmt.Errorf("invalid secret name %q", name)
+		}
+		resolution, err := secretGetter.ResolveContext(ctx, options.NameCase.normalize(name), "")
+		if err != nil {
+			return fmt.Errorf("secret %s: %w", name, err)
+		}
+		if resolution.IsFallback() {
+			return fmt.Errorf("secret %s is not available", name)
+		}
+
+		variable := profileSecret{Secret: strings.TrimPrefix(name, *prefix)}.variable()
+		if _, ok := values[variable]; !ok {
+			variables = append(variables, variable)
+		}
+		values[variable] = resolution.Value
+	}
+
+	var output bytes.Buffer
+	switch *format {
+	case "dotenv":
+		for _, variable := range variables {
+			output.WriteString(dotenvLine(variable, values[variable]))
+		}
+	case "shell":
+		for _, variable := range variables {
+			fmt.Fprintf(&output, "export %s='%s'\n", variable, strings.ReplaceAll(values[variable], "'", `'\''`))
+		}
+	case "json":
+		content, err := json.MarshalIndent(values, "", "  ")
+		if err != nil {
+			return err
+		}
+		output.Write(append(content, '\n'))
+	}
+
+	if *out != "" {
+		return writeFileAtomically(*out, output.Bytes(), 0600)
+	}
+	_, err = w.Write(output.Bytes())
+	return err
+}
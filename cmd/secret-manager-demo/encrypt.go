@@ -0,0 +1,50 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+
+	"secret-manager-demo/pkg/secrets"
+)
+
+// runEncrypt seals the JSON object of names and values of -in into the encrypted file -out, with the key the
+// encrypted-file backend is configured with
+func runEncrypt(args []string) error {
+	flags := flag.NewFlagSet("encrypt", flag.ContinueOnError)
+	in := flags.String("in", "", "JSON file of the names and values to encrypt")
+	out := flags.String("out", "", "encrypted file to write")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	if *in == "" || *out == "" || flags.NArg() != 0 {
+		return errors.New("usage: encrypt -in FILE -out FILE")
+	}
+
+	content, err := ioutil.ReadFile(*in)
+	if err != nil {
+		return err
+	}
+	values := map[string]string{}
+	err = json.Unmarshal(content, &values)
+	if err != nil {
+		return fmt.Errorf("%s: expected an object of string values: %w", *in, err)
+	}
+
+	key, err := secrets.EncryptedFileKeyFromEnv()
+	if err != nil {
+		return err
+	}
+	aead, err := secrets.NewFileAEAD(key)
+	if err != nil {
+		return err
+	}
+	sealed, err := secrets.SealSecretsFile(aead, values)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomically(*out, sealed, 0600)
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"secret-manager-demo/pkg/secrets"
+)
+
+// syncedFilePerm is the permission of the synced files, read only for the owner like mounted Kubernetes secrets
+const syncedFilePerm = 0400
+
+// FileSync materializes secrets as files under a directory, one file per secret, so containers can mount them
+// without a CSI driver
+type FileSync struct {
+	Dir string
+	// Files maps the name of every file to the secret it holds
+	Files map[string]string
+
+	secretGetter secrets.SecretGetter
+	options      handlerOptions
+	mu           sync.Mutex
+	written      map[string]string
+}
+
+// NewFileSync returns a sync of the secrets to the directory, which is created when missing
+// Secrets are given as NAME, written to a file of that name, or as FILE=NAME
+func NewFileSync(secretGetter secrets.SecretGetter, options handlerOptions, dir string, entries []string) (*FileSync, error) {
+	files := map[string]string{}
+	for _, secret := range entries {
+		file, name, ok := strings.Cut(secret, "=")
+		if !ok {
+			name = file
+		}
+		if !secretNamePattern.MatchString(name) {
+			return nil, fmt.Errorf("invalid secret name %q", name)
+		}
+		// Files starting with a dot are left out, they would clash with the temporary files of the writes
+		if file == "" || strings.HasPrefix(file, ".") || strings.ContainsAny(file, `/\`) {
+			return nil, fmt.Errorf("invalid file name %q", file)
+		}
+		files[file] = name
+	}
+
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return nil, err
+	}
+	return &FileSync{Dir: dir, Files: files, secretGetter: secretGetter, options: options, written: map[string]string{}}, nil
+}
+
+// Sync resolves every secret and writes the files whose secret changed, atomically so readers never see a partial value
+// Every secret is tried even when some fail, leaving their previous files in place, and the failures are returned
+func (s *FileSync) Sync() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ctx, cancel := s.options.commandContext()
+	defer cancel()
+
+	var errs []error
+	for file, name := range s.Files {
+		resolution, err := s.secretGetter.ResolveContext(ctx, s.options.NameCase.normalize(name), "")
+		if err == nil && resolution.IsFallback() {
+			err = errors.New("not available")
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("secret %s: %w", name, err))
+			continue
+		}
+		if written, ok := s.written[file]; ok && written == resolution.Value {
+			continue
+		}
+
+		err = writeFileAtomically(filepath.Join(s.Dir, file), []byte(resolution.Value), syncedFilePerm)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("secret %s: %w", name, err))
+			continue
+		}
+		_, rotated := s.written[file]
+		s.written[file] = resolution.Value
+		slog.Info("synced secret", "name", name, "path", file, "rotated", rotated)
+	}
+	return errors.Join(errs...)
+}
+
+// Watch syncs the secrets on the given interval, so rotated values reach the files, until stop is closed
+func (s *FileSync) Watch(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			err := s.Sync()
+			if err != nil {
+				slog.Error("syncing secrets, keeping the previous files", "dir", s.Dir, "error", err)
+			}
+		}
+	}
+}
+
+// runSync writes the secrets named with -secret under -dir, and keeps them in sync every -interval when it is set
+func runSync(secretGetter secrets.SecretGetter, options handlerOptions, args []string) error {
+	flags := flag.NewFlagSet("sync", flag.ContinueOnError)
+	dir := flags.String("dir", "", "directory the secrets are written to")
+	var secretFlags repeatedFlag
+	flags.Var(&secretFlags, "secret", "secret to write, as NAME or FILE=NAME, can be repeated")
+	interval := flags.Duration("interval", 0, "interval to sync the secrets again on, zero syncs them once")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	if *dir == "" || len(secretFlags) == 0 || flags.NArg() != 0 {
+		return errors.New("usage: sync -dir DIR -secret NAME [-secret FILE=NAME]... [-interval INTERVAL]")
+	}
+
+	fileSync, err := NewFileSync(secretGetter, options, *dir, secretFlags)
+	if err != nil {
+		return err
+	}
+	err = fileSync.Sync()
+	if err != nil {
+		return err
+	}
+	if *interval > 0 {
+		fileSync.Watch(*interval, make(chan struct{}))
+	}
+	return nil
+}
+
+// sidecarRetryInterval is how often the first sync of the sidecar is retried until it succeeds
+const sidecarRetryInterval = 5 * time.Second
+
+// Sidecar keeps the secrets of a pod synced to a shared volume while the server keeps running, it is only ready once
+// every secret was written, so the pod does not receive traffic before its files are in place
+// A nil Sidecar is always ready
+type Sidecar struct {
+	fileSync *FileSync
+	ready    atomic.Bool
+}
+
+// NewSidecar returns a sidecar syncing the secrets to the directory
+func NewSidecar(fileSync *FileSync) *Sidecar {
+	return &Sidecar{fileSync: fileSync}
+}
+
+// Ready tells if every secret was written at least once
+func (s *Sidecar) Ready() bool {
+	return s == nil || s.ready.Load()
+}
+
+// Run syncs the secrets until every one is written, and then again on the given interval, until stop is closed
+// Later failures keep the sidecar ready, as the files of the previous sync are still there
+func (s *Sidecar) Run(interval time.Duration, stop <-chan struct{}) {
+	for {
+		err := s.fileSync.Sync()
+		if err == nil {
+			break
+		}
+		slog.Error("syncing secrets, retrying", "dir", s.fileSync.Dir, "error", err)
+
+		select {
+		case <-stop:
+			return
+		case <-time.After(sidecarRetryInterval):
+		}
+	}
+	s.ready.Store(true)
+	slog.Info("synced every secret, ready", "dir", s.fileSync.Dir)
+
+	s.fileSync.Watch(interval, stop)
+}
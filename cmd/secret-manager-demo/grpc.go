@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"net/http"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"secret-manager-demo/api/secretsv1"
+	"secret-manager-demo/pkg/secrets"
+)
+
+//go:generate protoc -I ../.. --go_out=../.. --go_opt=module=secret-manager-demo --go-grpc_out=../.. --go-grpc_opt=module=secret-manager-demo api/secrets.proto
+
+// grpcRequestKey is the context key of the HTTP/2 request a call came on
+type grpcRequestKey struct{}
+
+// grpcCode maps the status the HTTP API answers with to the gRPC code for the same outcome
+func grpcCode(status int) codes.Code {
+	switch status {
+	case http.StatusOK:
+		return codes.OK
+	case http.StatusBadRequest:
+		return codes.InvalidArgument
+	case http.StatusUnauthorized:
+		return codes.Unauthenticated
+	case http.StatusForbidden:
+		return codes.PermissionDenied
+	case http.StatusNotFound:
+		return codes.NotFound
+	case http.StatusTooManyRequests:
+		return codes.ResourceExhausted
+	case http.StatusNotImplemented:
+		return codes.Unimplemented
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return codes.Unavailable
+	case http.StatusInternalServerError:
+		return codes.Internal
+	default:
+		return codes.Unknown
+	}
+}
+
+// grpcError returns the gRPC status for the status the HTTP API answers with
+func grpcError(httpStatus int) error {
+	return status.Error(grpcCode(httpStatus), http.StatusText(httpStatus))
+}
+
+// grpcHandler serves SecretService of api/secrets.proto over HTTP/2, so it shares the server, TLS and client
+// certificates of the HTTP API
+// The methods share the logic of the HTTP API too, so API keys, name rules and statuses are the same on both
+func grpcHandler(secretGetter secrets.SecretGetter, options handlerOptions) http.Handler {
+	service := &secretService{secretGetter: secretGetter, options: options}
+	server := grpc.NewServer(grpc.UnaryInterceptor(service.intercept))
+	secretsv1.RegisterSecretServiceServer(server, service)
+
+	// The checks of the HTTP API take the request, which the calls find on their context
+	return http.HandlerFunc(func(w http.ResponseWriter, rq *http.Request) {
+		server.ServeHTTP(w, rq.WithContext(context.WithValue(rq.Context(), grpcRequestKey{}, rq)))
+	})
+}
+
+// secretService implements SecretService with the secret getter of the HTTP API
+type secretService struct {
+	secretsv1.UnimplementedSecretServiceServer
+	secretGetter secrets.SecretGetter
+	options      handlerOptions
+}
+
+// grpcRequest returns the HTTP/2 request the call came on
+func grpcRequest(ctx context.Context) *http.Request {
+	rq, _ := ctx.Value(grpcRequestKey{}).(*http.Request)
+	return rq
+}
+
+// intercept applies the rate limit and the request timeout of the HTTP API to every call
+func (s *secretService) intercept(ctx context.Context, message interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if allowed, _ := s.options.RateLimiter.Allow(grpcRequest(ctx)); !allowed {
+		return nil, status.Error(codes.ResourceExhausted, "rate limit exceeded")
+	}
+	if s.options.RequestTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.options.RequestTimeout)
+		defer cancel()
+	}
+	return handler(ctx, message)
+}
+
+// GetSecret serves GetSecret like /get-secret, with the version on the request instead of the query
+func (s *secretService) GetSecret(ctx context.Context, in *secretsv1.GetSecretRequest) (*secretsv1.GetSecretResponse, error) {
+	if !secretNamePattern.MatchString(in.Name) {
+		return nil, grpcError(http.StatusBadRequest)
+	}
+
+	rq := grpcRequest(ctx)
+	var result secretResult
+	var httpStatus int
+	if in.Version != "" && in.Version != "latest" {
+		result, httpStatus = resolveSecretVersion(ctx, s.secretGetter, s.options, rq, in.Name, in.Version)
+	} else {
+		result, httpStatus = resolveNamedSecret(ctx, s.secretGetter, s.options, rq, in.Name)
+	}
+	s.options.recordAccess(rq, s.secretGetter.Now(), in.Name, in.Version, accessResult(result, httpStatus))
+
+	// There is no configured:false on gRPC, unconfigured secrets are not found
+	if result.NotConfigured {
+		return nil, grpcError(http.StatusNotFound)
+	}
+	if httpStatus != http.StatusOK {
+		return nil, grpcError(httpStatus)
+	}
+	return &secretsv1.GetSecretResponse{Name: result.Name, Value: result.Value, Version: result.Version, IsFallback: result.IsFallback}, nil
+}
+
+// BatchGetSecrets serves BatchGetSecrets like /get-secrets, every secret carrying its own code
+func (s *secretService) BatchGetSecrets(ctx context.Context, in *secretsv1.BatchGetSecretsRequest) (*secretsv1.BatchGetSecretsResponse, error) {
+	if len(in.Names) > maxBatchSize {
+		return nil, grpcError(http.StatusBadRequest)
+	}
+	for _, name := range in.Names {
+		if !secretNamePattern.MatchString(name) {
+			return nil, grpcError(http.StatusBadRequest)
+		}
+	}
+
+	results, statuses := resolveBatch(ctx, s.secretGetter, s.options, grpcRequest(ctx), in.Names)
+	response := &secretsv1.BatchGetSecretsResponse{Secrets: make([]*secretsv1.BatchSecret, 0, len(in.Names))}
+	for i, name := range in.Names {
+		httpStatus := statuses[i]
+		if results[i].NotConfigured {
+			httpStatus = http.StatusNotFound
+		}
+
+		secret := &secretsv1.BatchSecret{Name: name, Code: int32(grpcCode(httpStatus))}
+		if httpStatus == http.StatusOK {
+			secret.Value, secret.IsFallback = results[i].Value, results[i].IsFallback
+		}
+		response.Secrets = append(response.Secrets, secret)
+	}
+	return response, nil
+}
+
+// ListSecrets serves ListSecrets like /secrets
+func (s *secretService) ListSecrets(ctx context.Context, in *secretsv1.ListSecretsRequest) (*secretsv1.ListSecretsResponse, error) {
+	rq := grpcRequest(ctx)
+	if httpStatus := s.options.authorize(rq, ""); httpStatus == http.StatusUnauthorized {
+		return nil, grpcError(httpStatus)
+	}
+	if in.PageSize < 0 || in.PageSize > secrets.MaxListPageSize {
+		return nil, grpcError(http.StatusBadRequest)
+	}
+
+	page, httpStatus := listVisibleSecrets(ctx, s.secretGetter, s.options, rq, int(in.PageSize), in.PageToken, in.WithMetadata)
+	if httpStatus != http.StatusOK {
+		return nil, grpcError(httpStatus)
+	}
+
+	response := &secretsv1.ListSecretsResponse{Secrets: make([]*secretsv1.SecretInfo, 0, len(page.Secrets)), NextPageToken: page.NextPageToken}
+	for _, secret := range page.Secrets {
+		response.Secrets = append(response.Secrets, &secretsv1.SecretInfo{Name: secret.Name, CreateTime: secret.CreateTime, Labels: secret.Labels})
+	}
+	return response, nil
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"crypto/x509"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"secret-manager-demo/api/secretsv1"
+	"secret-manager-demo/pkg/secrets"
+)
+
+// newGRPCClient serves grpcHandler over HTTP/2 with TLS, as main does, and returns a client of it
+func newGRPCClient(t *testing.T, secretGetter secrets.SecretGetter, options handlerOptions) secretsv1.SecretServiceClient {
+	t.Helper()
+	server := httptest.NewUnstartedServer(grpcHandler(secretGetter, options))
+	server.EnableHTTP2 = true
+	server.StartTLS()
+	t.Cleanup(server.Close)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(server.Certificate())
+	conn, err := grpc.NewClient(strings.TrimPrefix(server.URL, "https://"), grpc.WithTransportCredentials(credentials.NewClientTLSFromCert(pool, "")))
+	if err != nil {
+		t.Fatalf("connecting: %s", err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	return secretsv1.NewSecretServiceClient(conn)
+}
+
+func TestGRPCGetSecret(t *testing.T) {
+	provider := secrets.NewMemoryProvider(map[string]string{"db-password": "first"})
+	if _, _, err := provider.PutSecret(context.Background(), "db-password", "second"); err != nil {
+		t.Fatalf("adding a version: %s", err)
+	}
+	client := newGRPCClient(t, secrets.SecretGetter{Provider: provider, RequireFallback: true}, handlerOptions{APIKeys: NewAllowlist(apiKeys{"key": {"db-*"}, "other": {"api-*"}})})
+
+	tests := []struct {
+		name         string
+		apiKey       string
+		request      *secretsv1.GetSecretRequest
+		expected     *secretsv1.GetSecretResponse
+		expectedCode codes.Code
+	}{
+		{name: "latest", apiKey: "key", request: &secretsv1.GetSecretRequest{Name: "db-password"}, expected: &secretsv1.GetSecretResponse{Name: "db-password", Value: "second"}},
+		{name: "version", apiKey: "key", request: &secretsv1.GetSecretRequest{Name: "db-password", Version: "1"}, expected: &secretsv1.GetSecretResponse{Name: "db-password", Value: "first", Version: "1"}},
+		{name: "missing", apiKey: "key", request: &secretsv1.GetSecretRequest{Name: "db-missing"}, expectedCode: codes.NotFound},
+		{name: "invalid name", apiKey: "key", request: &secretsv1.GetSecretRequest{Name: "db password"}, expectedCode: codes.InvalidArgument},
+		{name: "not allowed", apiKey: "other", request: &secretsv1.GetSecretRequest{Name: "db-password"}, expectedCode: codes.PermissionDenied},
+		{name: "no key", request: &secretsv1.GetSecretRequest{Name: "db-password"}, expectedCode: codes.Unauthenticated},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := context.Background()
+			if test.apiKey != "" {
+				ctx = metadata.AppendToOutgoingContext(ctx, "x-api-key", test.apiKey)
+			}
+			response, err := client.GetSecret(ctx, test.request)
+			if code := status.Code(err); code != test.expectedCode {
+				t.Fatalf("expected code %s, got %s (%v)", test.expectedCode, code, err)
+			}
+			if test.expected == nil {
+				return
+			}
+			if response.Name != test.expected.Name || response.Value != test.expected.Value || response.Version != test.expected.Version || response.IsFallback {
+				t.Errorf("expected %v, got %v", test.expected, response)
+			}
+		})
+	}
+}
+
+func TestGRPCBatchGetSecrets(t *testing.T) {
+	provider := secrets.NewMemoryProvider(map[string]string{"db-password": "secret", "api-key": "other"})
+	client := newGRPCClient(t, secrets.SecretGetter{Provider: provider, RequireFallback: true}, handlerOptions{})
+
+	response, err := client.BatchGetSecrets(context.Background(), &secretsv1.BatchGetSecretsRequest{Names: []string{"api-key", "missing", "db-password"}})
+	if err != nil {
+		t.Fatalf("getting secrets: %s", err)
+	}
+	expected := []struct {
+		name  string
+		code  codes.Code
+		value string
+	}{{"api-key", codes.OK, "other"}, {"missing", codes.NotFound, ""}, {"db-password", codes.OK, "secret"}}
+	if len(response.Secrets) != len(expected) {
+		t.Fatalf("expected %d secrets, got %d", len(expected), len(response.Secrets))
+	}
+	for i, secret := range response.Secrets {
+		if secret.Name != expected[i].name || codes.Code(secret.Code) != expected[i].code || secret.Value != expected[i].value {
+			t.Errorf("secret %d: expected %v, got %v", i, expected[i], secret)
+		}
+	}
+
+	_, err = client.BatchGetSecrets(context.Background(), &secretsv1.BatchGetSecretsRequest{Names: []string{"db password"}})
+	if code := status.Code(err); code != codes.InvalidArgument {
+		t.Errorf("expected code %s, got %s", codes.InvalidArgument, code)
+	}
+}
+
+func TestGRPCListSecrets(t *testing.T) {
+	provider := secrets.NewMemoryProvider(map[string]string{"db-password": "secret", "db-user": "user", "api-key": "other"})
+	client := newGRPCClient(t, secrets.SecretGetter{Provider: provider, RequireFallback: true}, handlerOptions{APIKeys: NewAllowlist(apiKeys{"key": {"db-*"}})})
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "x-api-key", "key")
+
+	var names []string
+	request := &secretsv1.ListSecretsRequest{PageSize: 1}
+	for {
+		response, err := client.ListSecrets(ctx, request)
+		if err != nil {
+			t.Fatalf("listing secrets: %s", err)
+		}
+		for _, secret := range response.Secrets {
+			names = append(names, secret.Name)
+		}
+		if response.NextPageToken == "" {
+			break
+		}
+		request.PageToken = response.NextPageToken
+	}
+	if strings.Join(names, ",") != "db-password,db-user" {
+		t.Errorf("expected db-password,db-user, got %s", strings.Join(names, ","))
+	}
+
+	_, err := client.ListSecrets(ctx, &secretsv1.ListSecretsRequest{PageSize: -1})
+	if code := status.Code(err); code != codes.InvalidArgument {
+		t.Errorf("expected code %s, got %s", codes.InvalidArgument, code)
+	}
+	_, err = client.ListSecrets(context.Background(), &secretsv1.ListSecretsRequest{})
+	if code := status.Code(err); code != codes.Unauthenticated {
+		t.Errorf("expected code %s, got %s", codes.Unauthenticated, code)
+	}
+}
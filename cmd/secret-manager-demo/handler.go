@@ -0,0 +1,423 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"secret-manager-demo/pkg/secrets"
+)
+
+// secretNamePattern matches the names Secret Manager accepts
+var secretNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,255}$`)
+
+// secretVersionPattern matches the versions of every backend, numbers, ids and labels alike
+var secretVersionPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// nameCase tells how requested secret names are normalized before the lookup
+type nameCase string
+
+const (
+	// nameCaseSensitive keeps names as requested, matching the backend exactly
+	nameCaseSensitive nameCase = ""
+	nameCaseLower     nameCase = "lower"
+	nameCaseUpper     nameCase = "upper"
+)
+
+// parseNameCase parses a nameCase, defaulting to nameCaseSensitive when the value is empty
+func parseNameCase(value string) (nameCase, error) {
+	switch nameCase(value) {
+	case nameCaseSensitive, nameCaseLower, nameCaseUpper:
+		return nameCase(value), nil
+	default:
+		return "", fmt.Errorf("unknown name case %q, expected %q or %q", value, nameCaseLower, nameCaseUpper)
+	}
+}
+
+// normalize returns the name to be used for the lookup
+func (c nameCase) normalize(name string) string {
+	switch c {
+	case nameCaseLower:
+		return strings.ToLower(name)
+	case nameCaseUpper:
+		return strings.ToUpper(name)
+	default:
+		return name
+	}
+}
+
+// responseVersion tells the shape of the responses
+type responseVersion string
+
+const (
+	// responseV1 only includes the name and the value
+	responseV1 responseVersion = "v1"
+	// responseV2 also includes whether the value is the fallback
+	responseV2 responseVersion = "v2"
+)
+
+// parseResponseVersion parses a responseVersion, defaulting to responseV1 when the value is empty
+func parseResponseVersion(value string) (responseVersion, error) {
+	switch responseVersion(value) {
+	case "", responseV1:
+		return responseV1, nil
+	case responseV2:
+		return responseV2, nil
+	default:
+		return "", fmt.Errorf("unknown response version %q, expected %q or %q", value, responseV1, responseV2)
+	}
+}
+
+// handlerOptions tweaks how the handlers interpret requests
+type handlerOptions struct {
+	// NameCase normalizes the requested names, the response still echoes the requested name
+	NameCase nameCase
+	// ResponseVersion tells the shape of the responses
+	ResponseVersion responseVersion
+	// RejectNameConflict answers 400 when the header and the query name different secrets
+	RejectNameConflict bool
+	// AllowDebug allows ?debug=true to include the sources tried on the response
+	AllowDebug bool
+	// RefreshConcurrency bounds how many secrets are fetched at a time when refreshing the cache
+	RefreshConcurrency int
+	// BatchConcurrency bounds how many secrets of a batch request are fetched at a time
+	BatchConcurrency int
+	// PinLatest resolves the latest version of every secret of a batch request once, reading that version for every
+	// entry naming the secret, so a rotation during the batch does not split its reads across versions
+	PinLatest bool
+	// MaxVersions caps how many versions a /get-secret-versions request can ask for, defaultMaxVersions when zero
+	MaxVersions int
+	// VersionConcurrency bounds how many versions of a /get-secret-versions request are fetched at a time
+	VersionConcurrency int
+	// NotConfiguredStatus enables answering {"configured":false} with this status, instead of the fallback,
+	// for secrets on none of the env-only mode sources
+	NotConfiguredStatus int
+	// AccessEvents receives an event per secret access, it is optional
+	AccessEvents *secrets.WebhookEmitter
+	// Audit records every secret access with the identity of the caller, it is optional
+	Audit *AuditLog
+	// Profiles are the named sets of secrets served as dotenv blobs
+	Profiles profiles
+	// APIKeys limits every API key to a set of secrets, every request is allowed when nil
+	APIKeys *Allowlist
+	// JWT verifies bearer tokens and limits every subject to a set of secrets, tokens are not accepted when nil
+	JWT *jwtVerifier
+	// ClientCertificates requires a client certificate verified against TLS_CLIENT_CA_FILE on every route but the
+	// probes, from callers without an API key or a token
+	ClientCertificates bool
+	// WriteKeys limits every API key to the secrets it may write, writes are not served when nil
+	WriteKeys *Allowlist
+	// RateLimiter limits the requests of every client to the secret endpoints, it is optional
+	RateLimiter *RateLimiter
+	// Tracer runs every request on a span exported to OpenTelemetry, it is optional
+	Tracer *secrets.Tracer
+	// RequestTimeout bounds the calls to the provider made for a request, zero means no timeout
+	RequestTimeout time.Duration
+	// Sidecar tells the server is not ready until the secrets of the pod are written, nil when not running as one
+	Sidecar *Sidecar
+	// Rotations tells the changes of the secrets /watch clients follow
+	Rotations *secrets.RotationWatcher
+	// WatchKeepalive is the interval of the keepalive comments on /watch streams, so idle proxies keep them open
+	WatchKeepalive time.Duration
+	// Injector answers the admission webhook injecting secrets into pods, which is not served when nil
+	Injector *Injector
+	// AllowedProjects are the projects requests can ask to read secrets from, instead of the configured one
+	// Their secrets are authorized as project/name, so API keys and subjects need entries like "other-project/db-*"
+	AllowedProjects map[string]bool
+}
+
+// requestContext returns the context of the request, bounded by the request timeout
+// The context is also canceled when the client goes away, so abandoned fetches stop early
+func (o handlerOptions) requestContext(rq *http.Request) (context.Context, context.CancelFunc) {
+	if o.RequestTimeout <= 0 {
+		return context.WithCancel(rq.Context())
+	}
+	return context.WithTimeout(rq.Context(), o.RequestTimeout)
+}
+
+// secretResult is the outcome of resolving a secret for a request
+type secretResult struct {
+	Name       string
+	Value      string
+	Source     secrets.Source
+	IsFallback bool
+	Attempts   []secrets.Attempt
+	// Version is the version that was asked for, empty for the latest one
+	Version string
+	// NotConfigured is set when the secret is on none of the env-only mode sources
+	NotConfigured bool
+}
+
+// writeJSON writes the body as JSON with the given status
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	bytes, err := json.Marshal(body)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_, _ = w.Write(bytes)
+}
+
+// debugInfo tells how a secret was resolved, it never includes the values of the sources tried
+type debugInfo struct {
+	Source   secrets.Source    `json:"source"`
+	Attempts []secrets.Attempt `json:"attempts"`
+}
+
+// getSecretHandler gets the secret value according to the name sent on the header
+func getSecretHandler(secretGetter secrets.SecretGetter, options handlerOptions) http.HandlerFunc {
+	return func(w http.ResponseWriter, rq *http.Request) {
+		result, status := resolveSecret(secretGetter, options, rq)
+
+		// Record the access, requests that do not name a secret are not accesses
+		if secretName, _ := requestedSecretName(rq, options); secretName != "" {
+			options.recordAccess(rq, secretGetter.Now(), secretName, rq.URL.Query().Get("version"), accessResult(result, status))
+		}
+
+		// Unconfigured secrets are told apart from real values, instead of answering a plausible default
+		if result.NotConfigured {
+			writeJSON(w, status, struct {
+				Name       string `json:"name"`
+				Configured bool   `json:"configured"`
+			}{
+				Name:       result.Name,
+				Configured: false,
+			})
+			return
+		}
+
+		if status != http.StatusOK {
+			w.WriteHeader(status)
+			return
+		}
+
+		// The attempts are only included when debugging is allowed and asked for
+		var debug *debugInfo
+		if options.AllowDebug && rq.URL.Query().Get("debug") == "true" {
+			debug = &debugInfo{Source: result.Source, Attempts: result.Attempts}
+		}
+
+		// Create the struct definition for the response, v2 lets clients tell fallbacks apart
+		var response interface{} = struct {
+			Name    string     `json:"name"`
+			Value   string     `json:"value"`
+			Version string     `json:"version,omitempty"`
+			Debug   *debugInfo `json:"debug,omitempty"`
+		}{
+			Name:    result.Name,
+			Value:   result.Value,
+			Version: result.Version,
+			Debug:   debug,
+		}
+		if options.ResponseVersion == responseV2 {
+			response = struct {
+				Name       string     `json:"name"`
+				Value      string     `json:"value"`
+				Version    string     `json:"version,omitempty"`
+				IsFallback bool       `json:"isFallback"`
+				Debug      *debugInfo `json:"debug,omitempty"`
+			}{
+				Name:       result.Name,
+				Value:      result.Value,
+				Version:    result.Version,
+				IsFallback: result.IsFallback,
+				Debug:      debug,
+			}
+		}
+
+		bytes, err := json.Marshal(response)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		// Return the secret value
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write(bytes)
+	}
+}
+
+// requestedSecretName returns the secret name sent on the secret header or the name query parameter
+// The header wins when both are sent, unless RejectNameConflict is set, which makes a conflict a 400
+func requestedSecretName(rq *http.Request, options handlerOptions) (string, int) {
+	headerName := rq.Header.Get("secret")
+	queryName := rq.URL.Query().Get("name")
+
+	if headerName != "" && queryName != "" && headerName != queryName && options.RejectNameConflict {
+		return headerName, http.StatusBadRequest
+	}
+
+	secretName := headerName
+	if secretName == "" {
+		secretName = queryName
+	}
+
+	if !secretNamePattern.MatchString(secretName) {
+		return secretName, http.StatusBadRequest
+	}
+	return secretName, http.StatusOK
+}
+
+// accessResult describes the outcome of an access for the access events
+func accessResult(result secretResult, status int) string {
+	switch {
+	case status != http.StatusOK:
+		return strings.ToLower(strings.ReplaceAll(http.StatusText(status), " ", "-"))
+	case result.IsFallback, result.NotConfigured:
+		return "fallback"
+	default:
+		return "ok"
+	}
+}
+
+// resolveSecret validates the request and resolves the secret, without writing anything
+// The returned status is the one the handler must answer with, the result is only set on 200
+func resolveSecret(secretGetter secrets.SecretGetter, options handlerOptions, rq *http.Request) (secretResult, int) {
+	// Fetch the secret name on the header or the query
+	secretName, status := requestedSecretName(rq, options)
+	if status != http.StatusOK {
+		return secretResult{}, status
+	}
+
+	ctx, cancel := options.requestContext(rq)
+	defer cancel()
+
+	// Asking for latest is the same as not asking for a version
+	if version := rq.URL.Query().Get("version"); version != "" && version != "latest" {
+		return resolveSecretVersion(ctx, secretGetter, options, rq, secretName, version)
+	}
+	return resolveNamedSecret(ctx, secretGetter, options, rq, secretName)
+}
+
+// resolveSecretVersion gets a specific version of the secret, there is no fallback for those
+func resolveSecretVersion(ctx context.Context, secretGetter secrets.SecretGetter, options handlerOptions, rq *http.Request, secretName string, version string) (secretResult, int) {
+	if !secretVersionPattern.MatchString(version) {
+		return secretResult{}, http.StatusBadRequest
+	}
+
+	// Make sure the caller is allowed to read the secret, on the project it asks for if any
+	lookupName := options.NameCase.normalize(secretName)
+	ctx, status := options.authorizeSecret(ctx, rq, lookupName)
+	if status != http.StatusOK {
+		return secretResult{}, status
+	}
+
+	value, err := secretGetter.GetSecretVersion(ctx, lookupName, version)
+	switch {
+	case errors.Is(err, secrets.ErrVersionsUnavailable):
+		return secretResult{}, http.StatusNotImplemented
+	case errors.Is(err, secrets.ErrSecretNotFound):
+		return secretResult{}, http.StatusNotFound
+	case err != nil:
+		return secretResult{}, http.StatusBadGateway
+	}
+
+	return secretResult{
+		Name:    secretName,
+		Value:   value,
+		Source:  secrets.ProviderSource(secretGetter.Provider),
+		Version: version,
+	}, http.StatusOK
+}
+
+// resolveNamedSecret is like resolveSecret, for a name that was already taken from the request and validated
+func resolveNamedSecret(ctx context.Context, secretGetter secrets.SecretGetter, options handlerOptions, rq *http.Request, secretName string) (secretResult, int) {
+	// Make sure the caller is allowed to read the secret, on the project it asks for if any
+	lookupName := options.NameCase.normalize(secretName)
+	ctx, status := options.authorizeSecret(ctx, rq, lookupName)
+	if status != http.StatusOK {
+		return secretResult{}, status
+	}
+
+	// Use the secret getter to get the secret or the fallback
+	resolution, err := secretGetter.ResolveContext(ctx, lookupName, secretGetter.DefaultFallback(lookupName))
+	switch {
+	case errors.Is(err, secrets.ErrSecretNotFound):
+		return secretResult{}, http.StatusNotFound
+	case errors.Is(err, secrets.ErrPermissionDenied):
+		// The service account is misconfigured, which is not the client's fault
+		return secretResult{}, http.StatusBadGateway
+	case errors.Is(err, secrets.ErrOverloaded):
+		return secretResult{}, http.StatusServiceUnavailable
+	case errors.Is(err, secrets.ErrUnavailable):
+		return secretResult{}, http.StatusBadGateway
+	case err != nil:
+		return secretResult{}, http.StatusInternalServerError
+	}
+
+	// On env-only mode, a fallback means the secret is not configured anywhere
+	if options.NotConfiguredStatus != 0 && secretGetter.Provider == nil && resolution.IsFallback() {
+		return secretResult{Name: secretName, NotConfigured: true}, options.NotConfiguredStatus
+	}
+
+	return secretResult{
+		Name:       secretName,
+		Value:      resolution.Value,
+		Source:     resolution.Source,
+		IsFallback: resolution.IsFallback(),
+		Attempts:   resolution.Attempts,
+	}, http.StatusOK
+}
+
+// getSecretMetadataHandler gets the latest version and its create time according to the name sent on the header
+// The value of the secret is never returned by this handler
+func getSecretMetadataHandler(secretGetter secrets.SecretGetter, options handlerOptions) http.HandlerFunc {
+	return func(w http.ResponseWriter, rq *http.Request) {
+		// Fetch the secret name on the header or the query
+		secretName, status := requestedSecretName(rq, options)
+		if status != http.StatusOK {
+			w.WriteHeader(status)
+			return
+		}
+
+		// Make sure the caller is allowed to read the secret, on the project it asks for if any
+		ctx, cancel := options.requestContext(rq)
+		defer cancel()
+		lookupName := options.NameCase.normalize(secretName)
+		ctx, status = options.authorizeSecret(ctx, rq, lookupName)
+		if status != http.StatusOK {
+			w.WriteHeader(status)
+			return
+		}
+
+		metadata, err := secretGetter.GetVersionMetadata(ctx, lookupName)
+		switch {
+		case errors.Is(err, secrets.ErrMetadataUnavailable):
+			w.WriteHeader(http.StatusNotImplemented)
+			return
+		case errors.Is(err, secrets.ErrSecretNotFound):
+			w.WriteHeader(http.StatusNotFound)
+			return
+		case err != nil:
+			slog.Error("fetching version metadata", "name", lookupName, "error", err)
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+
+		bytes, err := json.Marshal(struct {
+			Name string `json:"name"`
+			secrets.VersionMetadata
+		}{
+			Name:            secretName,
+			VersionMetadata: metadata,
+		})
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write(bytes)
+	}
+}
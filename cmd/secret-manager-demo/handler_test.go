@@ -0,0 +1,314 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"secret-manager-demo/pkg/secrets"
+)
+
+// countingProvider serves the value of every secret as its name, counting the calls
+type countingProvider struct {
+	calls atomic.Int32
+}
+
+func (p *countingProvider) GetSecret(ctx context.Context, name string) (string, error) {
+	p.calls.Add(1)
+	return "value-of-" + name, nil
+}
+
+func TestGetSecretHandlerValidatesNames(t *testing.T) {
+	tests := []struct {
+		name           string
+		secret         string
+		version        string
+		expectedStatus int
+	}{
+		{name: "hyphens and underscores", secret: "-_-", expectedStatus: http.StatusOK},
+		{name: "longest name", secret: strings.Repeat("a", 255), expectedStatus: http.StatusOK},
+		{name: "latest version", secret: "db-password", version: "latest", expectedStatus: http.StatusOK},
+		{name: "empty name", secret: "", expectedStatus: http.StatusBadRequest},
+		{name: "too long name", secret: strings.Repeat("a", 256), expectedStatus: http.StatusBadRequest},
+		{name: "slash", secret: "db/password", expectedStatus: http.StatusBadRequest},
+		{name: "path traversal", secret: "..", expectedStatus: http.StatusBadRequest},
+		{name: "dot", secret: "db.password", expectedStatus: http.StatusBadRequest},
+		{name: "percent", secret: "db%2Fpassword", expectedStatus: http.StatusBadRequest},
+		{name: "space", secret: "db password", expectedStatus: http.StatusBadRequest},
+		{name: "version with slash", secret: "db-password", version: "1/2", expectedStatus: http.StatusBadRequest},
+		{name: "version with colon", secret: "db-password", version: "1:access", expectedStatus: http.StatusBadRequest},
+		{name: "too long version", secret: "db-password", version: strings.Repeat("1", 65), expectedStatus: http.StatusBadRequest},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			provider := &countingProvider{}
+			handler := getSecretHandler(secrets.SecretGetter{Provider: provider}, handlerOptions{})
+
+			rq := httptest.NewRequest(http.MethodGet, "/get-secret", nil)
+			rq.Header.Set("secret", test.secret)
+			if test.version != "" {
+				rq.URL.RawQuery = "version=" + test.version
+			}
+			rs := httptest.NewRecorder()
+			handler(rs, rq)
+
+			if rs.Code != test.expectedStatus {
+				t.Errorf("expected status %d, got %d", test.expectedStatus, rs.Code)
+			}
+			// Invalid names never reach the provider
+			if test.expectedStatus == http.StatusBadRequest && provider.calls.Load() != 0 {
+				t.Errorf("expected no calls to the provider, got %d", provider.calls.Load())
+			}
+		})
+	}
+}
+
+func TestGetSecretHandlerNameCase(t *testing.T) {
+	values := map[string]string{"db-password": "lower", "DB-PASSWORD": "upper", "Db-Password": "mixed"}
+
+	tests := []struct {
+		name          string
+		nameCase      nameCase
+		requested     string
+		expectedValue string
+	}{
+		{name: "sensitive exact lower", nameCase: nameCaseSensitive, requested: "db-password", expectedValue: "lower"},
+		{name: "sensitive exact mixed", nameCase: nameCaseSensitive, requested: "Db-Password", expectedValue: "mixed"},
+		{name: "lower from mixed", nameCase: nameCaseLower, requested: "Db-PassWORD", expectedValue: "lower"},
+		{name: "lower from upper", nameCase: nameCaseLower, requested: "DB-PASSWORD", expectedValue: "lower"},
+		{name: "upper from mixed", nameCase: nameCaseUpper, requested: "db-PassWord", expectedValue: "upper"},
+		{name: "upper from lower", nameCase: nameCaseUpper, requested: "db-password", expectedValue: "upper"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			secretGetter := secrets.SecretGetter{Provider: secrets.NewMemoryProvider(values), RequireFallback: true}
+			handler := getSecretHandler(secretGetter, handlerOptions{NameCase: test.nameCase})
+
+			rq := httptest.NewRequest(http.MethodGet, "/get-secret", nil)
+			rq.Header.Set("secret", test.requested)
+			rs := httptest.NewRecorder()
+			handler(rs, rq)
+
+			if rs.Code != http.StatusOK {
+				t.Fatalf("expected status 200, got %d", rs.Code)
+			}
+			var response struct {
+				Name  string `json:"name"`
+				Value string `json:"value"`
+			}
+			if err := json.Unmarshal(rs.Body.Bytes(), &response); err != nil {
+				t.Fatalf("decoding response: %s", err)
+			}
+			// The response echoes the name as requested, whatever name was looked up
+			if response.Name != test.requested {
+				t.Errorf("expected name %s, got %s", test.requested, response.Name)
+			}
+			if response.Value != test.expectedValue {
+				t.Errorf("expected value %s, got %s", test.expectedValue, response.Value)
+			}
+		})
+	}
+}
+
+func TestGetSecretHandlerNameCaseSensitiveMiss(t *testing.T) {
+	secretGetter := secrets.SecretGetter{Provider: secrets.NewMemoryProvider(map[string]string{"db-password": "lower"}), RequireFallback: true, OnNotFound: secrets.PolicyError}
+	handler := getSecretHandler(secretGetter, handlerOptions{})
+
+	rq := httptest.NewRequest(http.MethodGet, "/get-secret", nil)
+	rq.Header.Set("secret", "DB-Password")
+	rs := httptest.NewRecorder()
+	handler(rs, rq)
+
+	if rs.Code != http.StatusNotFound {
+		t.Errorf("expected status 404, got %d", rs.Code)
+	}
+}
+
+func TestParseNameCase(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected nameCase
+		err      bool
+	}{
+		{value: "", expected: nameCaseSensitive},
+		{value: "lower", expected: nameCaseLower},
+		{value: "upper", expected: nameCaseUpper},
+		{value: "Lower", err: true},
+		{value: "title", err: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.value, func(t *testing.T) {
+			actual, err := parseNameCase(test.value)
+			if (err != nil) != test.err {
+				t.Fatalf("expected error %t, got %v", test.err, err)
+			}
+			if actual != test.expected {
+				t.Errorf("expected %q, got %q", test.expected, actual)
+			}
+		})
+	}
+}
+
+func TestRequestedSecretName(t *testing.T) {
+	tests := []struct {
+		name               string
+		header             string
+		query              string
+		rejectNameConflict bool
+		expectedName       string
+		expectedStatus     int
+	}{
+		{name: "header only", header: "db-password", expectedName: "db-password", expectedStatus: http.StatusOK},
+		{name: "query only", query: "db-password", expectedName: "db-password", expectedStatus: http.StatusOK},
+		{name: "both agree", header: "db-password", query: "db-password", expectedName: "db-password", expectedStatus: http.StatusOK},
+		{name: "header wins on conflict", header: "db-password", query: "api-key", expectedName: "db-password", expectedStatus: http.StatusOK},
+		{name: "both agree in strict mode", header: "db-password", query: "db-password", rejectNameConflict: true, expectedName: "db-password", expectedStatus: http.StatusOK},
+		{name: "conflict in strict mode", header: "db-password", query: "api-key", rejectNameConflict: true, expectedStatus: http.StatusBadRequest},
+		{name: "query only in strict mode", query: "db-password", rejectNameConflict: true, expectedName: "db-password", expectedStatus: http.StatusOK},
+		{name: "neither", expectedStatus: http.StatusBadRequest},
+		{name: "valid header wins over invalid query", header: "db-password", query: "db/password", expectedName: "db-password", expectedStatus: http.StatusOK},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rq := httptest.NewRequest(http.MethodGet, "/get-secret", nil)
+			if test.header != "" {
+				rq.Header.Set("secret", test.header)
+			}
+			if test.query != "" {
+				rq.URL.RawQuery = "name=" + test.query
+			}
+
+			name, status := requestedSecretName(rq, handlerOptions{RejectNameConflict: test.rejectNameConflict})
+			if status != test.expectedStatus {
+				t.Fatalf("expected status %d, got %d", test.expectedStatus, status)
+			}
+			if status == http.StatusOK && name != test.expectedName {
+				t.Errorf("expected name %s, got %s", test.expectedName, name)
+			}
+		})
+	}
+}
+
+func TestGetSecretHandlerHeaderWins(t *testing.T) {
+	values := map[string]string{"db-password": "from-header", "api-key": "from-query"}
+	secretGetter := secrets.SecretGetter{Provider: secrets.NewMemoryProvider(values), RequireFallback: true}
+
+	rq := httptest.NewRequest(http.MethodGet, "/get-secret?name=api-key", nil)
+	rq.Header.Set("secret", "db-password")
+	rs := httptest.NewRecorder()
+	getSecretHandler(secretGetter, handlerOptions{})(rs, rq)
+
+	var response struct {
+		Name  string `json:"name"`
+		Value string `json:"value"`
+	}
+	if err := json.Unmarshal(rs.Body.Bytes(), &response); err != nil {
+		t.Fatalf("decoding response: %s", err)
+	}
+	if response.Name != "db-password" || response.Value != "from-header" {
+		t.Errorf("expected the secret of the header, got %s with %s", response.Name, response.Value)
+	}
+}
+
+// failingProvider fails every fetch with its error
+type failingProvider struct {
+	err error
+}
+
+func (p failingProvider) GetSecret(ctx context.Context, name string) (string, error) {
+	return "", p.err
+}
+
+func TestGetSecretHandlerMapsErrors(t *testing.T) {
+	tests := []struct {
+		name           string
+		err            error
+		expectedStatus int
+	}{
+		{name: "not found", err: fmt.Errorf("%w: db-password", secrets.ErrSecretNotFound), expectedStatus: http.StatusNotFound},
+		{name: "permission denied", err: fmt.Errorf("%w: db-password", secrets.ErrPermissionDenied), expectedStatus: http.StatusBadGateway},
+		{name: "upstream failure", err: errors.New("connection reset"), expectedStatus: http.StatusBadGateway},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			secretGetter := secrets.SecretGetter{Provider: failingProvider{err: test.err}, RequireFallback: true}
+
+			rq := httptest.NewRequest(http.MethodGet, "/get-secret", nil)
+			rq.Header.Set("secret", "db-password")
+			rs := httptest.NewRecorder()
+			getSecretHandler(secretGetter, handlerOptions{})(rs, rq)
+
+			if rs.Code != test.expectedStatus {
+				t.Errorf("expected status %d, got %d", test.expectedStatus, rs.Code)
+			}
+		})
+	}
+}
+
+func TestAuthorizeSecretOnProjects(t *testing.T) {
+	keys := apiKeys{
+		"configured-only": {"db-*"},
+		"other-only":      {"other/db-*"},
+		"everything":      {"*"},
+	}
+	options := handlerOptions{APIKeys: NewAllowlist(keys), AllowedProjects: map[string]bool{"other": true}}
+
+	tests := []struct {
+		name           string
+		apiKey         string
+		project        string
+		expectedStatus int
+	}{
+		{name: "configured project", apiKey: "configured-only", expectedStatus: http.StatusOK},
+		{name: "name allowed on the configured project only", apiKey: "configured-only", project: "other", expectedStatus: http.StatusForbidden},
+		{name: "name allowed on the other project", apiKey: "other-only", project: "other", expectedStatus: http.StatusOK},
+		{name: "name allowed on the other project only", apiKey: "other-only", expectedStatus: http.StatusForbidden},
+		{name: "every secret on an allowed project", apiKey: "everything", project: "other", expectedStatus: http.StatusOK},
+		{name: "project that is not allowed", apiKey: "everything", project: "secret-project", expectedStatus: http.StatusForbidden},
+		{name: "unknown key", apiKey: "unknown", project: "other", expectedStatus: http.StatusUnauthorized},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rq := httptest.NewRequest(http.MethodGet, "/get-secret", nil)
+			rq.Header.Set(apiKeyHeader, test.apiKey)
+			if test.project != "" {
+				rq.Header.Set("project", test.project)
+			}
+
+			_, status := options.authorizeSecret(context.Background(), rq, "db-password")
+			if status != test.expectedStatus {
+				t.Errorf("expected status %d, got %d", test.expectedStatus, status)
+			}
+		})
+	}
+}
+
+func TestGetSecretMetadataHandlerAuthorizesProject(t *testing.T) {
+	provider := secrets.NewMemoryProvider(map[string]string{"db-password": "hunter2"})
+	options := handlerOptions{APIKeys: NewAllowlist(apiKeys{"key": {"db-password"}}), AllowedProjects: map[string]bool{"other": true}}
+	handler := getSecretMetadataHandler(secrets.SecretGetter{Provider: provider}, options)
+
+	for project, expectedStatus := range map[string]int{"": http.StatusOK, "other": http.StatusForbidden, "secret-project": http.StatusForbidden} {
+		rq := httptest.NewRequest(http.MethodGet, "/get-secret-metadata?project="+project, nil)
+		rq.Header.Set("secret", "db-password")
+		rq.Header.Set(apiKeyHeader, "key")
+		rs := httptest.NewRecorder()
+		handler(rs, rq)
+
+		if rs.Code != expectedStatus {
+			t.Errorf("project %q: expected status %d, got %d", project, expectedStatus, rs.Code)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+
+	"secret-manager-demo/pkg/secrets"
+)
+
+// healthzHandler tells the process is up, it never calls the provider so a slow backend does not restart the pod
+func healthzHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, rq *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte("ok\n"))
+	}
+}
+
+// readyzHandler tells if secrets can be served, answering 503 while the provider or its credentials are unavailable,
+// and while running as a sidecar, until the secrets of the pod are written
+// The cause is only logged, as probes may be answered to callers that are not allowed to see it
+func readyzHandler(secretGetter secrets.SecretGetter, options handlerOptions) http.HandlerFunc {
+	return func(w http.ResponseWriter, rq *http.Request) {
+		if !options.Sidecar.Ready() {
+			slog.Warn("not ready", "error", "the secrets of the pod were not written yet")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		ctx, cancel := options.requestContext(rq)
+		defer cancel()
+
+		err := secretGetter.CheckReady(ctx)
+		if err != nil {
+			slog.Warn("not ready", "error", err)
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte("ok\n"))
+	}
+}
+
+// publicPaths are the routes served without credentials, the probes as the kubelet has none, neither serves secrets
+var publicPaths = map[string]bool{
+	"/healthz": true,
+	"/readyz":  true,
+}
+
+// certificatePaths are the routes also served to callers with a client certificate verified against
+// TLS_CLIENT_CA_FILE, the admission webhook as the API server authenticates with one instead of a key or token
+var certificatePaths = map[string]bool{
+	"/mutate": true,
+}
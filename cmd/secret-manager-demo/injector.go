@@ -0,0 +1,220 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+
+	"secret-manager-demo/pkg/secrets"
+)
+
+const (
+	// injectAnnotation lists the secrets to inject into a pod, as NAME or FILE=NAME separated by commas
+	injectAnnotation = "secret-manager-demo/inject"
+	// injectPathAnnotation is where the secrets are mounted on every container, defaultInjectPath when missing
+	injectPathAnnotation = "secret-manager-demo/inject-path"
+	defaultInjectPath    = "/secrets"
+	// injectName names the volume and the init container added to pods, which also tells a pod was already injected
+	injectName = "secret-manager-demo-secrets"
+	// maxAdmissionReviewSize bounds the body of admission reviews, which carry the whole pod
+	maxAdmissionReviewSize = 4 << 20
+)
+
+// Injector mutates annotated pods so their secrets are written by an init container to a memory volume
+// mounted on every container, applications then read them as files without any change
+// The init container syncs the secrets once with the identity of the pod, so it only gets what the pod may read,
+// and runs as the user of the pod, which is the only one allowed to read the files
+type Injector struct {
+	// Image is the image of the init container, this same server
+	Image string
+	// Env is the configuration of the init container, like SECRET_BACKEND and GCP_PROJECT
+	// It ends up on the pod spec, so credentials should come from the identity of the pod instead
+	Env map[string]string
+}
+
+// newInjectorFromEnv builds the injector when INJECTOR_IMAGE is set, passing the variables named on INJECTOR_ENV
+// to the init containers with the values they have here, it returns nil otherwise
+func newInjectorFromEnv() *Injector {
+	image := secrets.GetEnv("INJECTOR_IMAGE", "")
+	if image == "" {
+		return nil
+	}
+
+	env := map[string]string{}
+	for _, name := range strings.Split(secrets.GetEnv("INJECTOR_ENV", ""), ",") {
+		name = strings.TrimSpace(name)
+		if value, ok := secrets.LookupEnv(name); ok && name != "" {
+			env[name] = value
+		}
+	}
+	return &Injector{Image: image, Env: env}
+}
+
+// admissionReview is the part of the admission.k8s.io/v1 AdmissionReview the webhook reads and answers
+type admissionReview struct {
+	APIVersion string             `json:"apiVersion"`
+	Kind       string             `json:"kind"`
+	Request    *admissionRequest  `json:"request,omitempty"`
+	Response   *admissionResponse `json:"response,omitempty"`
+}
+
+type admissionRequest struct {
+	UID    string          `json:"uid"`
+	Object json.RawMessage `json:"object"`
+}
+
+type admissionResponse struct {
+	UID       string           `json:"uid"`
+	Allowed   bool             `json:"allowed"`
+	Result    *admissionStatus `json:"status,omitempty"`
+	PatchType string           `json:"patchType,omitempty"`
+	// Patch is marshaled as base64, as the API server expects
+	Patch []byte `json:"patch,omitempty"`
+}
+
+type admissionStatus struct {
+	Message string `json:"message"`
+}
+
+// admissionPod is the part of a pod the injector reads
+type admissionPod struct {
+	Metadata struct {
+		Name         string            `json:"name"`
+		GenerateName string            `json:"generateName"`
+		Annotations  map[string]string `json:"annotations"`
+	} `json:"metadata"`
+	Spec struct {
+		Volumes        []struct{ Name string } `json:"volumes"`
+		InitContainers []admissionContainer    `json:"initContainers"`
+		Containers     []admissionContainer    `json:"containers"`
+	} `json:"spec"`
+}
+
+// admissionContainer is the part of a container the injector reads
+type admissionContainer struct {
+	VolumeMounts []json.RawMessage `json:"volumeMounts"`
+}
+
+// jsonPatchOperation is an operation of the JSON patch answered to the API server
+type jsonPatchOperation struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	Value interface{} `json:"value"`
+}
+
+// patch returns the operations injecting the secrets into the pod, none when it is not annotated or already injected
+func (i *Injector) patch(pod admissionPod) ([]jsonPatchOperation, error) {
+	annotation := strings.TrimSpace(pod.Metadata.Annotations[injectAnnotation])
+	if annotation == "" {
+		return nil, nil
+	}
+	for _, volume := range pod.Spec.Volumes {
+		if volume.Name == injectName {
+			return nil, nil
+		}
+	}
+
+	mountPath := pod.Metadata.Annotations[injectPathAnnotation]
+	if mountPath == "" {
+		mountPath = defaultInjectPath
+	}
+	if !strings.HasPrefix(mountPath, "/") {
+		return nil, fmt.Errorf("%s must be an absolute path, got %q", injectPathAnnotation, mountPath)
+	}
+
+	// The secrets are validated like the sync subcommand would, so a typo fails the pod creation instead of its start
+	args := []string{"sync", "-dir", mountPath}
+	for _, secret := range strings.Split(annotation, ",") {
+		secret = strings.TrimSpace(secret)
+		file, name, ok := strings.Cut(secret, "=")
+		if !ok {
+			name = file
+		}
+		if !secretNamePattern.MatchString(name) || file == "" || strings.HasPrefix(file, ".") || strings.ContainsAny(file, `/\`) {
+			return nil, fmt.Errorf("invalid secret %q on %s", secret, injectAnnotation)
+		}
+		args = append(args, "-secret", secret)
+	}
+
+	env := make([]map[string]string, 0, len(i.Env))
+	for _, name := range slices.Sorted(maps.Keys(i.Env)) {
+		env = append(env, map[string]string{"name": name, "value": i.Env[name]})
+	}
+	mount := map[string]interface{}{"name": injectName, "mountPath": mountPath, "readOnly": true}
+	initContainer := map[string]interface{}{
+		"name":         injectName,
+		"image":        i.Image,
+		"args":         args,
+		"env":          env,
+		"volumeMounts": []interface{}{map[string]interface{}{"name": injectName, "mountPath": mountPath}},
+	}
+	volume := map[string]interface{}{"name": injectName, "emptyDir": map[string]string{"medium": "Memory"}}
+
+	// Adding to a missing array needs the whole array, and to an existing one its end
+	var operations []jsonPatchOperation
+	if len(pod.Spec.Volumes) == 0 {
+		operations = append(operations, jsonPatchOperation{Op: "add", Path: "/spec/volumes", Value: []interface{}{volume}})
+	} else {
+		operations = append(operations, jsonPatchOperation{Op: "add", Path: "/spec/volumes/-", Value: volume})
+	}
+	// The init container goes first and the other init containers get the volume too, so they can read the secrets
+	// The operations apply in order, so the init containers of the pod are one index further once it is added
+	if len(pod.Spec.InitContainers) == 0 {
+		operations = append(operations, jsonPatchOperation{Op: "add", Path: "/spec/initContainers", Value: []interface{}{initContainer}})
+	} else {
+		operations = append(operations, jsonPatchOperation{Op: "add", Path: "/spec/initContainers/0", Value: initContainer})
+	}
+	for index, container := range pod.Spec.InitContainers {
+		operations = append(operations, mountOperation(fmt.Sprintf("/spec/initContainers/%d", index+1), container, mount))
+	}
+	for index, container := range pod.Spec.Containers {
+		operations = append(operations, mountOperation(fmt.Sprintf("/spec/containers/%d", index), container, mount))
+	}
+
+	slog.Info("injecting secrets", "pod", pod.Metadata.Name+pod.Metadata.GenerateName, "secrets", annotation, "path", mountPath)
+	return operations, nil
+}
+
+// mountOperation returns the operation adding the mount to the container on the path
+// Adding to a missing array needs the whole array, and to an existing one its end
+func mountOperation(path string, container admissionContainer, mount interface{}) jsonPatchOperation {
+	if len(container.VolumeMounts) == 0 {
+		return jsonPatchOperation{Op: "add", Path: path + "/volumeMounts", Value: []interface{}{mount}}
+	}
+	return jsonPatchOperation{Op: "add", Path: path + "/volumeMounts/-", Value: mount}
+}
+
+// injectorHandler answers the admission reviews of pod creations with the patch injecting their secrets
+// Pods that are not annotated are allowed unchanged, and the ones with an invalid annotation are denied
+func injectorHandler(injector *Injector) http.HandlerFunc {
+	return func(w http.ResponseWriter, rq *http.Request) {
+		var review admissionReview
+		err := json.NewDecoder(http.MaxBytesReader(w, rq.Body, maxAdmissionReviewSize)).Decode(&review)
+		if err != nil || review.Request == nil {
+			http.Error(w, "invalid request, expected an AdmissionReview", http.StatusBadRequest)
+			return
+		}
+
+		response := &admissionResponse{UID: review.Request.UID, Allowed: true}
+		var pod admissionPod
+		err = json.Unmarshal(review.Request.Object, &pod)
+		if err == nil {
+			var operations []jsonPatchOperation
+			operations, err = injector.patch(pod)
+			if err == nil && len(operations) > 0 {
+				response.PatchType = "JSONPatch"
+				response.Patch, err = json.Marshal(operations)
+			}
+		}
+		if err != nil {
+			slog.Warn("denying pod", "error", err)
+			response = &admissionResponse{UID: review.Request.UID, Allowed: false, Result: &admissionStatus{Message: err.Error()}}
+		}
+
+		writeJSON(w, http.StatusOK, admissionReview{APIVersion: review.APIVersion, Kind: review.Kind, Response: response})
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestInjectorPatchMountsEveryContainer(t *testing.T) {
+	injector := &Injector{Image: "secret-manager-demo"}
+
+	tests := []struct {
+		name          string
+		pod           string
+		expectedPaths []string
+		expectedErr   bool
+	}{
+		{
+			name: "containers only",
+			pod: `{"metadata": {"annotations": {"secret-manager-demo/inject": "db-password"}},
+				"spec": {"containers": [{}, {"volumeMounts": [{"name": "data"}]}]}}`,
+			expectedPaths: []string{"/spec/volumes", "/spec/initContainers", "/spec/containers/0/volumeMounts", "/spec/containers/1/volumeMounts/-"},
+		},
+		{
+			name: "init containers after the injected one",
+			pod: `{"metadata": {"annotations": {"secret-manager-demo/inject": "db-password"}},
+				"spec": {"volumes": [{"name": "data"}], "initContainers": [{"volumeMounts": [{"name": "data"}]}, {}], "containers": [{}]}}`,
+			expectedPaths: []string{
+				"/spec/volumes/-",
+				"/spec/initContainers/0",
+				"/spec/initContainers/1/volumeMounts/-",
+				"/spec/initContainers/2/volumeMounts",
+				"/spec/containers/0/volumeMounts",
+			},
+		},
+		{
+			name:          "not annotated",
+			pod:           `{"spec": {"containers": [{}]}}`,
+			expectedPaths: nil,
+		},
+		{
+			name: "already injected",
+			pod: `{"metadata": {"annotations": {"secret-manager-demo/inject": "db-password"}},
+				"spec": {"volumes": [{"name": "secret-manager-demo-secrets"}], "containers": [{}]}}`,
+			expectedPaths: nil,
+		},
+		{
+			name:        "invalid file",
+			pod:         `{"metadata": {"annotations": {"secret-manager-demo/inject": ".env=db-password"}}, "spec": {"containers": [{}]}}`,
+			expectedErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var pod admissionPod
+			if err := json.Unmarshal([]byte(test.pod), &pod); err != nil {
+				t.Fatalf("decoding pod: %s", err)
+			}
+
+			operations, err := injector.patch(pod)
+			if (err != nil) != test.expectedErr {
+				t.Fatalf("expected error %t, got %v", test.expectedErr, err)
+			}
+			var paths []string
+			for _, operation := range operations {
+				paths = append(paths, operation.Path)
+			}
+			if !reflect.DeepEqual(paths, test.expectedPaths) {
+				t.Errorf("expected %v, got %v", test.expectedPaths, paths)
+			}
+		})
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"secret-manager-demo/pkg/secrets"
+)
+
+// statusRecorder keeps the status code a handler answered with
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(content []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	return r.ResponseWriter.Write(content)
+}
+
+// Flush lets streaming handlers flush through the recorder
+func (r *statusRecorder) Flush() {
+	if flusher, ok := r.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// traced runs every request to the route on a server span
+func traced(tracer *secrets.Tracer, route string, handler http.HandlerFunc) http.HandlerFunc {
+	if tracer == nil {
+		return handler
+	}
+	return func(w http.ResponseWriter, rq *http.Request) {
+		ctx, span := tracer.StartRequestSpan(rq, fmt.Sprintf("%s %s", rq.Method, route))
+		span.SetAttribute("http.request.method", rq.Method)
+		span.SetAttribute("http.route", route)
+
+		recorder := &statusRecorder{ResponseWriter: w}
+		handler(recorder, rq.WithContext(ctx))
+		if recorder.status == 0 {
+			recorder.status = http.StatusOK
+		}
+
+		span.SetAttribute("http.response.status_code", strconv.Itoa(recorder.status))
+		var err error
+		if recorder.status >= http.StatusInternalServerError {
+			err = fmt.Errorf("answered %d", recorder.status)
+		}
+		span.End(err)
+	}
+}
+
+// measured records the status and latency of every request to the route, timed with the clock
+func measured(metrics *secrets.Metrics, clock secrets.Clock, route string, handler http.HandlerFunc) http.HandlerFunc {
+	if metrics == nil {
+		return handler
+	}
+	if clock == nil {
+		clock = secrets.RealClock{}
+	}
+	return func(w http.ResponseWriter, rq *http.Request) {
+		start := clock.Now()
+		recorder := &statusRecorder{ResponseWriter: w}
+		handler(recorder, rq)
+		if recorder.status == 0 {
+			recorder.status = http.StatusOK
+		}
+		metrics.ObserveRequest(route, recorder.status, clock.Now().Sub(start))
+	}
+}
+
+// metricsHandler serves the metrics in the Prometheus text format
+func metricsHandler(metrics *secrets.Metrics) http.HandlerFunc {
+	return func(w http.ResponseWriter, rq *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		_ = metrics.WriteText(w)
+	}
+}
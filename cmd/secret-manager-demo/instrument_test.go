@@ -0,0 +1,52 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"secret-manager-demo/pkg/secrets"
+)
+
+func TestMeasuredRecordsRequests(t *testing.T) {
+	metrics := secrets.NewMetrics()
+	clock := secrets.NewFakeClock(time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC))
+	handler := measured(metrics, clock, "/get-secret", func(w http.ResponseWriter, rq *http.Request) {
+		clock.Advance(30 * time.Millisecond)
+		w.WriteHeader(http.StatusNotFound)
+	})
+	handler(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/get-secret", nil))
+
+	recorder := httptest.NewRecorder()
+	metricsHandler(metrics)(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body := recorder.Body.String()
+	if !strings.HasPrefix(recorder.Header().Get("Content-Type"), "text/plain") {
+		t.Errorf("expected the Prometheus text format, got %q", recorder.Header().Get("Content-Type"))
+	}
+	if !strings.Contains(body, `secret_manager_http_requests_total{route="/get-secret",code="404"} 1`) {
+		t.Errorf("expected the request counted by route and status, got\n%s", body)
+	}
+	// The latency is told with the clock, so it lands on the 50ms bucket and not the 25ms one
+	if !strings.Contains(body, `le="0.05"} 1`) || !strings.Contains(body, `le="0.025"} 0`) {
+		t.Errorf("expected a latency of 30ms, got\n%s", body)
+	}
+}
+
+func TestTracedRunsOnASpan(t *testing.T) {
+	tracer := secrets.NewTracer("http://127.0.0.1:0", "secret-manager", 10, nil)
+	var onSpan bool
+	handler := traced(tracer, "/get-secret", func(w http.ResponseWriter, rq *http.Request) {
+		_, span := secrets.StartSpan(rq.Context(), "child")
+		onSpan = span != nil
+		span.End(nil)
+	})
+
+	rq := httptest.NewRequest(http.MethodGet, "/get-secret", nil)
+	rq.Header.Set("traceparent", "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01")
+	handler(httptest.NewRecorder(), rq)
+	if !onSpan {
+		t.Error("expected the handler to run on a span")
+	}
+}
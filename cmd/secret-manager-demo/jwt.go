@@ -0,0 +1,346 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"secret-manager-demo/pkg/secrets"
+)
+
+// errInvalidToken is returned for bearer tokens that are malformed, expired or not signed by the issuer
+var errInvalidToken = errors.New("invalid bearer token")
+
+const (
+	// jwksRefreshInterval bounds how often the keys are fetched again for tokens signed with an unknown key
+	jwksRefreshInterval = time.Minute
+	// jwtClockSkew is the leeway given to the expiry and not before times of tokens
+	jwtClockSkew = 30 * time.Second
+)
+
+// jwtVerifier verifies the bearer tokens of callers, like Kubernetes service account tokens or OIDC ID tokens
+// Every subject can only read the secrets it is allowed to, the same way API keys do
+type jwtVerifier struct {
+	// Issuer is the iss claim tokens must have, its discovery document tells the keys when JWKSURL is empty
+	Issuer string
+	// Audience is one of the aud claims tokens must have, so tokens meant for other services are rejected
+	Audience string
+	// JWKSURL is where the keys tokens are signed with are published
+	JWKSURL string
+	// Subjects maps the sub claim of tokens to the secret names they may read
+	Subjects *Allowlist
+	// Clock tells the time tokens are checked against, the real one when nil
+	Clock secrets.Clock
+
+	mu        sync.Mutex
+	keys      map[string]crypto.PublicKey
+	fetchedAt time.Time
+}
+
+// jwtClaims are the claims of a token that are verified
+type jwtClaims struct {
+	Issuer    string      `json:"iss"`
+	Subject   string      `json:"sub"`
+	Audience  jwtAudience `json:"aud"`
+	ExpiresAt int64       `json:"exp"`
+	NotBefore int64       `json:"nbf"`
+}
+
+// jwtAudience is the aud claim, which is either a string or an array of them
+type jwtAudience []string
+
+func (a *jwtAudience) UnmarshalJSON(data []byte) error {
+	var single string
+	if json.Unmarshal(data, &single) == nil {
+		*a = jwtAudience{single}
+		return nil
+	}
+	return json.Unmarshal(data, (*[]string)(a))
+}
+
+// authorize tells the status for reading the secret with the token, 401 for invalid tokens and 403 for subjects
+// that are not allowed to read the secret
+func (v *jwtVerifier) authorize(ctx context.Context, token string, name string) int {
+	claims, err := v.verify(ctx, token)
+	if err != nil {
+		slog.Warn("rejecting bearer token", "error", err)
+		return http.StatusUnauthorized
+	}
+
+	if !allowsSecret(v.Subjects.Keys()[claims.Subject], name) {
+		return http.StatusForbidden
+	}
+	return http.StatusOK
+}
+
+// verify checks the signature and claims of the token, returning the claims of a valid one
+func (v *jwtVerifier) verify(ctx context.Context, token string) (jwtClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return jwtClaims{}, fmt.Errorf("%w: expected three parts", errInvalidToken)
+	}
+
+	header := struct {
+		Algorithm string `json:"alg"`
+		KeyID     string `json:"kid"`
+	}{}
+	err := decodeJWTPart(parts[0], &header)
+	if err != nil {
+		return jwtClaims{}, err
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return jwtClaims{}, fmt.Errorf("%w: %v", errInvalidToken, err)
+	}
+
+	key, err := v.key(ctx, header.KeyID)
+	if err != nil {
+		return jwtClaims{}, err
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if !verifyJWTSignature(header.Algorithm, key, digest[:], signature) {
+		return jwtClaims{}, fmt.Errorf("%w: bad %s signature", errInvalidToken, header.Algorithm)
+	}
+
+	var claims jwtClaims
+	err = decodeJWTPart(parts[1], &claims)
+	if err != nil {
+		return jwtClaims{}, err
+	}
+
+	now := v.now()
+	switch {
+	case claims.Issuer != v.Issuer:
+		return jwtClaims{}, fmt.Errorf("%w: unexpected issuer %q", errInvalidToken, claims.Issuer)
+	case !claims.Audience.contains(v.Audience):
+		return jwtClaims{}, fmt.Errorf("%w: not meant for audience %q", errInvalidToken, v.Audience)
+	case claims.ExpiresAt == 0 || now.Add(-jwtClockSkew).After(time.Unix(claims.ExpiresAt, 0)):
+		return jwtClaims{}, fmt.Errorf("%w: expired", errInvalidToken)
+	case claims.NotBefore != 0 && now.Add(jwtClockSkew).Before(time.Unix(claims.NotBefore, 0)):
+		return jwtClaims{}, fmt.Errorf("%w: not valid yet", errInvalidToken)
+	}
+	return claims, nil
+}
+
+// now returns the current time according to the clock
+func (v *jwtVerifier) now() time.Time {
+	if v.Clock == nil {
+		return secrets.RealClock{}.Now()
+	}
+	return v.Clock.Now()
+}
+
+func (a jwtAudience) contains(audience string) bool {
+	for _, value := range a {
+		if value == audience {
+			return true
+		}
+	}
+	return false
+}
+
+// decodeJWTPart decodes the base64url JSON of the header or the claims
+func decodeJWTPart(part string, target interface{}) error {
+	content, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return fmt.Errorf("%w: %v", errInvalidToken, err)
+	}
+	err = json.Unmarshal(content, target)
+	if err != nil {
+		return fmt.Errorf("%w: %v", errInvalidToken, err)
+	}
+	return nil
+}
+
+// verifyJWTSignature verifies RS256 and ES256 signatures, the algorithms Kubernetes and most OIDC providers sign with
+// The algorithm must match the type of the key, so a token cannot pick a weaker check than the key was published for
+func verifyJWTSignature(algorithm string, key crypto.PublicKey, digest []byte, signature []byte) bool {
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		return algorithm == "RS256" && rsa.VerifyPKCS1v15(key, crypto.SHA256, digest, signature) == nil
+	case *ecdsa.PublicKey:
+		// ES256 signatures are r and s of 32 bytes each, not ASN.1
+		if algorithm != "ES256" || len(signature) != 64 {
+			return false
+		}
+		r := new(big.Int).SetBytes(signature[:32])
+		s := new(big.Int).SetBytes(signature[32:])
+		return ecdsa.Verify(key, digest, r, s)
+	default:
+		return false
+	}
+}
+
+// key returns the public key with the ID, fetching the keys again when it is unknown, as issuers rotate them
+func (v *jwtVerifier) key(ctx context.Context, keyID string) (crypto.PublicKey, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if key, ok := v.keys[keyID]; ok {
+		return key, nil
+	}
+	if v.now().Sub(v.fetchedAt) < jwksRefreshInterval {
+		return nil, fmt.Errorf("%w: unknown key %q", errInvalidToken, keyID)
+	}
+
+	keys, err := v.fetchKeys(ctx)
+	v.fetchedAt = v.now()
+	if err != nil {
+		return nil, fmt.Errorf("fetching the keys of %s: %w", v.Issuer, err)
+	}
+	v.keys = keys
+
+	key, ok := v.keys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown key %q", errInvalidToken, keyID)
+	}
+	return key, nil
+}
+
+// fetchKeys reads the JWKS, finding its URL on the discovery document of the issuer when it is not configured
+func (v *jwtVerifier) fetchKeys(ctx context.Context) (map[string]crypto.PublicKey, error) {
+	jwksURL := v.JWKSURL
+	if jwksURL == "" {
+		discovery := struct {
+			JWKSURI string `json:"jwks_uri"`
+		}{}
+		err := getJSON(ctx, strings.TrimSuffix(v.Issuer, "/")+"/.well-known/openid-configuration", &discovery)
+		if err != nil {
+			return nil, err
+		}
+		if discovery.JWKSURI == "" {
+			return nil, errors.New("the discovery document has no jwks_uri")
+		}
+		jwksURL = discovery.JWKSURI
+	}
+
+	jwks := struct {
+		Keys []struct {
+			KeyType string `json:"kty"`
+			KeyID   string `json:"kid"`
+			Use     string `json:"use"`
+			N       string `json:"n"`
+			E       string `json:"e"`
+			Curve   string `json:"crv"`
+			X       string `json:"x"`
+			Y       string `json:"y"`
+		} `json:"keys"`
+	}{}
+	err := getJSON(ctx, jwksURL, &jwks)
+	if err != nil {
+		return nil, err
+	}
+
+	// Keys of other types or for encryption are skipped, they cannot sign the tokens accepted here
+	keys := map[string]crypto.PublicKey{}
+	for _, jwk := range jwks.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		switch {
+		case jwk.KeyType == "RSA":
+			n, errN := base64.RawURLEncoding.DecodeString(jwk.N)
+			e, errE := base64.RawURLEncoding.DecodeString(jwk.E)
+			if errN != nil || errE != nil || len(e) > 4 {
+				return nil, fmt.Errorf("malformed RSA key %q", jwk.KeyID)
+			}
+			keys[jwk.KeyID] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		case jwk.KeyType == "EC" && jwk.Curve == "P-256":
+			x, errX := base64.RawURLEncoding.DecodeString(jwk.X)
+			y, errY := base64.RawURLEncoding.DecodeString(jwk.Y)
+			if errX != nil || errY != nil {
+				return nil, fmt.Errorf("malformed EC key %q", jwk.KeyID)
+			}
+			key := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+			if !key.Curve.IsOnCurve(key.X, key.Y) {
+				return nil, fmt.Errorf("EC key %q is not on P-256", jwk.KeyID)
+			}
+			keys[jwk.KeyID] = key
+		}
+	}
+	return keys, nil
+}
+
+// getJSON gets the URL and reads the JSON it answers with into the target
+func getJSON(ctx context.Context, getUrl string, target interface{}) error {
+	rq, err := http.NewRequestWithContext(ctx, http.MethodGet, getUrl, nil)
+	if err != nil {
+		return err
+	}
+
+	rs, err := secrets.UpstreamClient.Do(rq)
+	if err != nil {
+		return err
+	}
+
+	bytes, err := secrets.ReadBody(rs)
+	if err != nil {
+		return err
+	}
+	if rs.StatusCode != http.StatusOK {
+		return fmt.Errorf("error %d getting %s", rs.StatusCode, getUrl)
+	}
+	return json.Unmarshal(bytes, target)
+}
+
+// bearerToken returns the token of the Authorization header, if the request has one
+func bearerToken(rq *http.Request) (string, bool) {
+	scheme, token, ok := strings.Cut(rq.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") || token == "" {
+		return "", false
+	}
+	return token, true
+}
+
+// newJWTVerifierFromEnv builds the verifier when JWT_ISSUER is set, it returns nil otherwise
+// Tokens are checked against the time of the clock
+func newJWTVerifierFromEnv(clock secrets.Clock) (*jwtVerifier, error) {
+	issuer := secrets.GetEnv("JWT_ISSUER", "")
+	if issuer == "" {
+		return nil, nil
+	}
+
+	audience := secrets.GetEnv("JWT_AUDIENCE", "")
+	if audience == "" {
+		return nil, errors.New("JWT_AUDIENCE is required with JWT_ISSUER")
+	}
+
+	subjects, err := loadJWTSubjectsFromEnv()
+	if err != nil {
+		return nil, err
+	}
+
+	return &jwtVerifier{
+		Issuer:   issuer,
+		Audience: audience,
+		JWKSURL:  secrets.GetEnv("JWT_JWKS_URL", ""),
+		Subjects: NewAllowlist(subjects),
+		Clock:    clock,
+	}, nil
+}
+
+// loadJWTSubjectsFromEnv reads the secrets every subject may read from JWT_SUBJECTS_FILE, which is required
+func loadJWTSubjectsFromEnv() (apiKeys, error) {
+	subjectsFile := secrets.GetEnv("JWT_SUBJECTS_FILE", "")
+	if subjectsFile == "" {
+		return nil, errors.New("JWT_SUBJECTS_FILE is required with JWT_ISSUER")
+	}
+	subjects, err := loadAPIKeys(subjectsFile)
+	if err != nil {
+		return nil, fmt.Errorf("JWT_SUBJECTS_FILE: %w", err)
+	}
+	return subjects, nil
+}
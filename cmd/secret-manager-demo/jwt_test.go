@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"secret-manager-demo/pkg/secrets"
+)
+
+// signTestJWT signs the claims with RS256 under the key ID
+func signTestJWT(t *testing.T, key *rsa.PrivateKey, keyID string, claims map[string]interface{}) string {
+	t.Helper()
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "kid": keyID})
+	if err != nil {
+		t.Fatalf("encoding header: %s", err)
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatalf("encoding claims: %s", err)
+	}
+
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatalf("signing token: %s", err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func TestJWTVerifierFollowsTheClock(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generating key: %s", err)
+	}
+	issuedAt := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	token := signTestJWT(t, key, "key-1", map[string]interface{}{
+		"iss": "https://issuer",
+		"sub": "app",
+		"aud": "secret-manager",
+		"nbf": issuedAt.Unix(),
+		"exp": issuedAt.Add(time.Hour).Unix(),
+	})
+
+	tests := []struct {
+		name    string
+		now     time.Time
+		invalid bool
+	}{
+		{name: "before not before", now: issuedAt.Add(-jwtClockSkew - time.Second), invalid: true},
+		{name: "within the skew before not before", now: issuedAt.Add(-jwtClockSkew + time.Second)},
+		{name: "valid", now: issuedAt.Add(30 * time.Minute)},
+		{name: "within the skew after expiring", now: issuedAt.Add(time.Hour + jwtClockSkew - time.Second)},
+		{name: "expired", now: issuedAt.Add(time.Hour + jwtClockSkew + time.Second), invalid: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			verifier := &jwtVerifier{
+				Issuer:   "https://issuer",
+				Audience: "secret-manager",
+				Clock:    secrets.NewFakeClock(test.now),
+				keys:     map[string]crypto.PublicKey{"key-1": &key.PublicKey},
+			}
+
+			_, err := verifier.verify(context.Background(), token)
+			if test.invalid != errors.Is(err, errInvalidToken) {
+				t.Errorf("expected invalid %t, got %v", test.invalid, err)
+			}
+			if !test.invalid && err != nil {
+				t.Errorf("expected a valid token, got %s", err)
+			}
+		})
+	}
+}
+
+func TestJWTVerifierRefetchInterval(t *testing.T) {
+	clock := secrets.NewFakeClock(time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC))
+	verifier := &jwtVerifier{Issuer: "https://issuer", Clock: clock, keys: map[string]crypto.PublicKey{}, fetchedAt: clock.Now()}
+
+	// Unknown keys are not fetched again within the refresh interval, so tokens cannot make the server hammer the issuer
+	clock.Advance(jwksRefreshInterval - time.Second)
+	_, err := verifier.key(context.Background(), "unknown")
+	if !errors.Is(err, errInvalidToken) {
+		t.Errorf("expected an unknown key without fetching, got %v", err)
+	}
+}
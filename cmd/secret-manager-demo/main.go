@@ -0,0 +1,707 @@
+package main
+
+import (
+	"crypto/tls"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"secret-manager-demo/pkg/secrets"
+)
+
+func main() {
+
+	// Get the optional config file first, as every other setting can come from it
+	// Environment variables override its values, so a shared file can be tuned per deployment
+	configPath := flag.String("config", secrets.GetEnv("CONFIG_FILE", ""), "YAML or TOML config file, environment variables override its values")
+	sidecar := flag.Bool("sidecar", false, "write the secrets of SIDECAR_SECRETS under SIDECAR_DIR and keep them refreshed while serving")
+	flag.Parse()
+	if *configPath != "" {
+		values, err := secrets.LoadConfigFile(*configPath)
+		if err != nil {
+			slog.Error("invalid configuration", "error", fmt.Errorf("config file %s: %w", *configPath, err))
+			os.Exit(1)
+		}
+		secrets.SetConfigValues(values)
+	}
+
+	// Set up the logger, secret values are scrubbed from every line whatever the level
+	redactor := secrets.NewRedactor()
+	logger, err := secrets.NewLogger(os.Stderr, secrets.GetEnv("LOG_LEVEL", ""), secrets.GetEnv("LOG_FORMAT", ""), redactor)
+	if err != nil {
+		slog.Error("invalid configuration", "error", err)
+		os.Exit(1)
+	}
+	slog.SetDefault(logger)
+
+	// Get the client the calls to the backend go through, before building the backend as some discover their settings
+	secrets.UpstreamClient, err = secrets.NewUpstreamClientFromEnv()
+	if err != nil {
+		slog.Error("invalid configuration", "error", err)
+		os.Exit(1)
+	}
+
+	// Get the backend secrets come from, GCP Secret Manager when there is a project and environment variables otherwise
+	backend := secrets.GetEnv("SECRET_BACKEND", "")
+	if backend == "" && secrets.GetEnv("GCP_PROJECT", "") != "" {
+		backend = "gcp"
+	}
+	var provider secrets.SecretProvider
+	if backend != "" && backend != "env" {
+		provider, err = secrets.NewProvider(backend)
+		if err != nil {
+			slog.Error("invalid configuration", "error", fmt.Errorf("SECRET_BACKEND: %w", err))
+			os.Exit(1)
+		}
+	}
+
+	// Get the policies for authoritative errors coming from the provider
+	onForbidden, err := secrets.ParsePolicy(secrets.GetEnv("ON_FORBIDDEN", ""))
+	if err != nil {
+		slog.Error("invalid configuration", "error", fmt.Errorf("ON_FORBIDDEN: %w", err))
+		os.Exit(1)
+	}
+	onNotFound, err := secrets.ParsePolicy(secrets.GetEnv("ON_NOT_FOUND", ""))
+	if err != nil {
+		slog.Error("invalid configuration", "error", fmt.Errorf("ON_NOT_FOUND: %w", err))
+		os.Exit(1)
+	}
+
+	// Get the optional path for persisting last known good values
+	var diskCache *secrets.DiskCache
+	if cacheFile := secrets.GetEnv("CACHE_FILE", ""); cacheFile != "" {
+		diskCache = secrets.LoadDiskCache(cacheFile)
+	}
+
+	// Get the optional dotenv file used on env-only mode, which can be reloaded on change
+	var envFile *secrets.EnvFile
+	if envFilePath := secrets.GetEnv("ENV_FILE", ""); envFilePath != "" {
+		envFile, err = secrets.LoadEnvFile(envFilePath)
+		if err != nil {
+			slog.Error("invalid configuration", "error", fmt.Errorf("ENV_FILE: %w", err))
+			os.Exit(1)
+		}
+
+		watchEnvFile, err := secrets.GetEnvBool("WATCH_ENV_FILE", false)
+		if err != nil {
+			slog.Error("invalid configuration", "error", err)
+			os.Exit(1)
+		}
+		watchInterval, err := secrets.GetEnvDuration("WATCH_ENV_FILE_INTERVAL", time.Second)
+		if err != nil {
+			slog.Error("invalid configuration", "error", err)
+			os.Exit(1)
+		}
+		if watchEnvFile {
+			go envFile.Watch(watchInterval, make(chan struct{}))
+		}
+	}
+
+	// Get whether a missing secret on env-only mode fails loudly instead of using the fallback
+	strictEnv, err := secrets.GetEnvBool("STRICT_ENV", false)
+	if err != nil {
+		slog.Error("invalid configuration", "error", err)
+		os.Exit(1)
+	}
+
+	// Get whether failures without an explicit fallback are errors instead of made up defaults
+	requireFallback, err := secrets.GetEnvBool("REQUIRE_FALLBACK", false)
+	if err != nil {
+		slog.Error("invalid configuration", "error", err)
+		os.Exit(1)
+	}
+
+	// Get the TTL for version metadata, which is used to track rotation
+	versionMetadataTTL, err := secrets.GetEnvDuration("VERSION_METADATA_TTL", 0)
+	if err != nil {
+		slog.Error("invalid configuration", "error", err)
+		os.Exit(1)
+	}
+
+	secretGetter := secrets.SecretGetter{
+		Provider:        provider,
+		Prefix:          secrets.GetEnv("SECRET_PREFIX", ""),
+		EnvFile:         envFile,
+		StrictEnv:       strictEnv,
+		RequireFallback: requireFallback,
+		OnForbidden:     onForbidden,
+		OnNotFound:      onNotFound,
+		DiskCache:       diskCache,
+		Redactor:        redactor,
+		Clock:           secrets.RealClock{},
+	}
+	secretGetter.VersionMetadataCache = secrets.NewTTLCache(versionMetadataTTL, secretGetter.Clock)
+
+	// Get the optional retry overrides for specific secrets, which every backend honours
+	if retryPoliciesFile := secrets.GetEnv("RETRY_POLICIES_FILE", ""); retryPoliciesFile != "" {
+		secretGetter.RetryOverrides, err = secrets.LoadRetryOverrides(retryPoliciesFile)
+		if err != nil {
+			slog.Error("invalid configuration", "error", fmt.Errorf("RETRY_POLICIES_FILE: %w", err))
+			os.Exit(1)
+		}
+	}
+
+	// Get whether metrics are kept and served on /metrics
+	metricsEnabled, err := secrets.GetEnvBool("METRICS_ENABLED", true)
+	if err != nil {
+		slog.Error("invalid configuration", "error", err)
+		os.Exit(1)
+	}
+	if metricsEnabled {
+		secretGetter.Metrics = secrets.NewMetrics()
+	}
+
+	// Get the cache for values, which can be shared with other processes through a local directory
+	secretCacheTTL, err := secrets.GetEnvDuration("SECRET_CACHE_TTL", 0)
+	if err != nil {
+		slog.Error("invalid configuration", "error", err)
+		os.Exit(1)
+	}
+	// Get the window in which the last known good value is served on transient errors
+	staleWindow, err := secrets.GetEnvDuration("STALE_WINDOW", 0)
+	if err != nil {
+		slog.Error("invalid configuration", "error", err)
+		os.Exit(1)
+	}
+	secretGetter.StaleCache = secrets.NewTTLCache(staleWindow, secretGetter.Clock)
+
+	// Get the latency above which the provider is shed, and what to answer meanwhile
+	shedLatencyThreshold, err := secrets.GetEnvDuration("SHED_LATENCY_THRESHOLD", 0)
+	if err != nil {
+		slog.Error("invalid configuration", "error", err)
+		os.Exit(1)
+	}
+	secretGetter.Shedder = secrets.NewLoadShedder(shedLatencyThreshold, secretGetter.Clock)
+	secretGetter.OnShed, err = secrets.ParsePolicy(secrets.GetEnv("ON_SHED", ""))
+	if err != nil {
+		slog.Error("invalid configuration", "error", fmt.Errorf("ON_SHED: %w", err))
+		os.Exit(1)
+	}
+
+	// Get how many consecutive failures open the circuit breaker, and for how long it stays open
+	breakerThreshold, err := secrets.GetEnvInt("BREAKER_FAILURE_THRESHOLD", 5)
+	if err != nil {
+		slog.Error("invalid configuration", "error", err)
+		os.Exit(1)
+	}
+	breakerCooldown, err := secrets.GetEnvDuration("BREAKER_COOLDOWN", 30*time.Second)
+	if err != nil {
+		slog.Error("invalid configuration", "error", err)
+		os.Exit(1)
+	}
+	secretGetter.Breaker = secrets.NewCircuitBreaker(breakerThreshold, breakerCooldown, secretGetter.Clock)
+	secretGetter.Flights = secrets.NewSingleFlight()
+
+	if secretCacheTTL > 0 {
+		secretGetter.Cache = secrets.NewMemoryCache(secretCacheTTL, secretGetter.Clock)
+		if secretCacheDir := secrets.GetEnv("SECRET_CACHE_DIR", ""); secretCacheDir != "" {
+			secretGetter.Cache, err = secrets.NewSharedCache(secretCacheDir, secretCacheTTL, secretGetter.Clock)
+			if err != nil {
+				slog.Error("invalid configuration", "error", fmt.Errorf("SECRET_CACHE_DIR: %w", err))
+				os.Exit(1)
+			}
+		}
+	}
+
+	// Preload the secrets ahead of the first requests, failing startup only when asked to
+	if preloadSecrets := splitNames(secrets.GetEnv("PRELOAD_SECRETS", "")); len(preloadSecrets) > 0 {
+		preloadConcurrency, err := secrets.GetEnvInt("PRELOAD_CONCURRENCY", 8)
+		if err != nil {
+			slog.Error("invalid configuration", "error", err)
+			os.Exit(1)
+		}
+		preloadDeadline, err := secrets.GetEnvDuration("PRELOAD_DEADLINE", 0)
+		if err != nil {
+			slog.Error("invalid configuration", "error", err)
+			os.Exit(1)
+		}
+		preloadFatal, err := secrets.GetEnvBool("PRELOAD_FATAL", false)
+		if err != nil {
+			slog.Error("invalid configuration", "error", err)
+			os.Exit(1)
+		}
+
+		missing := secretGetter.Preload(preloadSecrets, preloadConcurrency, preloadDeadline)
+		slog.Info(secretGetter.Served.Summary())
+		if len(missing) > 0 {
+			slog.Warn("secrets not preloaded", "names", strings.Join(missing, ", "))
+			if preloadFatal {
+				os.Exit(1)
+			}
+		}
+	}
+
+	// Log which secrets this instance serves, to help mapping what every service consumes
+	servedSummaryInterval, err := secrets.GetEnvDuration("SERVED_SUMMARY_INTERVAL", 0)
+	if err != nil {
+		slog.Error("invalid configuration", "error", err)
+		os.Exit(1)
+	}
+	if servedSummaryInterval > 0 {
+		go func() {
+			for range time.Tick(servedSummaryInterval) {
+				slog.Info(secretGetter.Served.Summary())
+			}
+		}()
+	}
+
+	// Get the idle window after which cached values are evicted, regardless of their TTL
+	cacheIdleTimeout, err := secrets.GetEnvDuration("CACHE_IDLE_TIMEOUT", 0)
+	if err != nil {
+		slog.Error("invalid configuration", "error", err)
+		os.Exit(1)
+	}
+	if cacheIdleTimeout > 0 {
+		go func() {
+			for range time.Tick(cacheIdleTimeout / 2) {
+				if evicted := secretGetter.SweepIdle(cacheIdleTimeout); evicted > 0 {
+					slog.Info("evicted idle cached values", "count", evicted)
+				}
+			}
+		}()
+	}
+
+	// Get the options for interpreting requests
+	secretNameCase, err := parseNameCase(secrets.GetEnv("SECRET_NAME_CASE", ""))
+	if err != nil {
+		slog.Error("invalid configuration", "error", fmt.Errorf("SECRET_NAME_CASE: %w", err))
+		os.Exit(1)
+	}
+	responseVersion, err := parseResponseVersion(secrets.GetEnv("RESPONSE_VERSION", ""))
+	if err != nil {
+		slog.Error("invalid configuration", "error", fmt.Errorf("RESPONSE_VERSION: %w", err))
+		os.Exit(1)
+	}
+	rejectNameConflict, err := secrets.GetEnvBool("REJECT_NAME_CONFLICT", false)
+	if err != nil {
+		slog.Error("invalid configuration", "error", err)
+		os.Exit(1)
+	}
+	allowDebug, err := secrets.GetEnvBool("ALLOW_DEBUG", false)
+	if err != nil {
+		slog.Error("invalid configuration", "error", err)
+		os.Exit(1)
+	}
+	refreshConcurrency, err := secrets.GetEnvInt("REFRESH_CONCURRENCY", 4)
+	if err != nil {
+		slog.Error("invalid configuration", "error", err)
+		os.Exit(1)
+	}
+	batchConcurrency, err := secrets.GetEnvInt("BATCH_CONCURRENCY", 8)
+	if err != nil {
+		slog.Error("invalid configuration", "error", err)
+		os.Exit(1)
+	}
+	pinLatest, err := secrets.GetEnvBool("PIN_LATEST_IN_BATCH", false)
+	if err != nil {
+		slog.Error("invalid configuration", "error", err)
+		os.Exit(1)
+	}
+	maxVersions, err := secrets.GetEnvInt("MAX_VERSIONS_PER_REQUEST", defaultMaxVersions)
+	if err != nil {
+		slog.Error("invalid configuration", "error", err)
+		os.Exit(1)
+	}
+	versionConcurrency, err := secrets.GetEnvInt("VERSION_CONCURRENCY", 4)
+	if err != nil {
+		slog.Error("invalid configuration", "error", err)
+		os.Exit(1)
+	}
+	notConfiguredStatus, err := secrets.GetEnvInt("NOT_CONFIGURED_STATUS", 0)
+	if err != nil {
+		slog.Error("invalid configuration", "error", err)
+		os.Exit(1)
+	}
+	if notConfiguredStatus != 0 && notConfiguredStatus != http.StatusOK && notConfiguredStatus != http.StatusNotFound {
+		slog.Error("invalid configuration", "error", fmt.Errorf("NOT_CONFIGURED_STATUS: expected %d or %d", http.StatusOK, http.StatusNotFound))
+		os.Exit(1)
+	}
+	// Get the bound of the provider calls made for a request, so a hung upstream cannot hold handlers forever
+	requestTimeout, err := secrets.GetEnvDuration("REQUEST_TIMEOUT", 30*time.Second)
+	if err != nil {
+		slog.Error("invalid configuration", "error", err)
+		os.Exit(1)
+	}
+	options := handlerOptions{
+		NameCase:            secretNameCase,
+		ResponseVersion:     responseVersion,
+		RejectNameConflict:  rejectNameConflict,
+		AllowDebug:          allowDebug,
+		RefreshConcurrency:  refreshConcurrency,
+		BatchConcurrency:    batchConcurrency,
+		PinLatest:           pinLatest,
+		MaxVersions:         maxVersions,
+		VersionConcurrency:  versionConcurrency,
+		NotConfiguredStatus: notConfiguredStatus,
+		RequestTimeout:      requestTimeout,
+		AllowedProjects:     map[string]bool{},
+	}
+	// Get the projects requests can ask for on the project header or query, which none can by default
+	for _, project := range strings.Split(secrets.GetEnv("GCP_ALLOWED_PROJECTS", ""), ",") {
+		if project = strings.TrimSpace(project); project != "" {
+			options.AllowedProjects[project] = true
+		}
+	}
+
+	// Get the optional OpenTelemetry collector, the requests are traced when there is one
+	tracesUrl := secrets.GetEnv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT", "")
+	if endpoint := secrets.GetEnv("OTEL_EXPORTER_OTLP_ENDPOINT", ""); tracesUrl == "" && endpoint != "" {
+		tracesUrl = strings.TrimSuffix(endpoint, "/") + "/v1/traces"
+	}
+	if tracesUrl != "" {
+		tracerBuffer, err := secrets.GetEnvInt("OTEL_BUFFER", 2048)
+		if err != nil {
+			slog.Error("invalid configuration", "error", err)
+			os.Exit(1)
+		}
+		options.Tracer = secrets.NewTracer(tracesUrl, secrets.GetEnv("OTEL_SERVICE_NAME", "secret-manager-demo"), tracerBuffer, secretGetter.Clock)
+		// The calls to providers go through the upstream client, so they carry the trace context of the request
+		secrets.UpstreamClient.Transport = secrets.TracingTransport{Base: secrets.UpstreamClient.Transport}
+	}
+
+	// Get the optional rate limit per client of the secret endpoints, in requests per second
+	rateLimit, err := secrets.GetEnvInt("RATE_LIMIT", 0)
+	if err != nil {
+		slog.Error("invalid configuration", "error", err)
+		os.Exit(1)
+	}
+	rateLimitBurst, err := secrets.GetEnvInt("RATE_LIMIT_BURST", 0)
+	if err != nil {
+		slog.Error("invalid configuration", "error", err)
+		os.Exit(1)
+	}
+	rateLimitBy := secrets.GetEnv("RATE_LIMIT_BY", rateLimitByIP)
+	if rateLimitBy != rateLimitByIP && rateLimitBy != rateLimitByKey {
+		slog.Error("invalid configuration", "error", fmt.Errorf("RATE_LIMIT_BY: expected %s or %s", rateLimitByIP, rateLimitByKey))
+		os.Exit(1)
+	}
+	options.RateLimiter = NewRateLimiter(rateLimit, rateLimitBurst, rateLimitBy, secretGetter.Clock)
+
+	// Get the optional profiles, named sets of secrets served as dotenv blobs
+	if profilesFile := secrets.GetEnv("PROFILES_FILE", ""); profilesFile != "" {
+		options.Profiles, err = loadProfiles(profilesFile)
+		if err != nil {
+			slog.Error("invalid configuration", "error", fmt.Errorf("PROFILES_FILE: %w", err))
+			os.Exit(1)
+		}
+	}
+
+	// Get the optional API keys, every key can only read its allowed secrets
+	readKeys, err := loadAPIKeysFromEnv()
+	if err != nil {
+		slog.Error("invalid configuration", "error", err)
+		os.Exit(1)
+	}
+	options.APIKeys = NewAllowlist(readKeys)
+
+	// Get the optional JWT verification, so workloads can present their Kubernetes or OIDC tokens instead of API keys
+	options.JWT, err = newJWTVerifierFromEnv(secretGetter.Clock)
+	if err != nil {
+		slog.Error("invalid configuration", "error", err)
+		os.Exit(1)
+	}
+
+	// Get the optional write keys, every key can only write its allowed secrets and writes are disabled without them
+	if writeKeysFile := secrets.GetEnv("WRITE_API_KEYS_FILE", ""); writeKeysFile != "" {
+		writeKeys, err := loadAPIKeys(writeKeysFile)
+		if err != nil {
+			slog.Error("invalid configuration", "error", fmt.Errorf("WRITE_API_KEYS_FILE: %w", err))
+			os.Exit(1)
+		}
+		options.WriteKeys = NewAllowlist(writeKeys)
+	}
+
+	// Get the optional webhook receiving access events
+	if webhookUrl := secrets.GetEnv("WEBHOOK_URL", ""); webhookUrl != "" {
+		webhookBuffer, err := secrets.GetEnvInt("WEBHOOK_BUFFER", 100)
+		if err != nil {
+			slog.Error("invalid configuration", "error", err)
+			os.Exit(1)
+		}
+		options.AccessEvents = secrets.NewWebhookEmitter(webhookUrl, webhookBuffer)
+	}
+
+	// Get the optional audit log, recording who accessed which secret
+	if auditSink := secrets.GetEnv("AUDIT_LOG", ""); auditSink != "" {
+		options.Audit, err = newAuditLog(auditSink)
+		if err != nil {
+			slog.Error("invalid configuration", "error", fmt.Errorf("AUDIT_LOG: %w", err))
+			os.Exit(1)
+		}
+	}
+
+	// Get the secrets watched for rotation, more are watched while /watch clients follow them
+	// Their cached values are dropped as soon as they change
+	var watchedSecrets []string
+	if watchSecrets := secrets.GetEnv("WATCH_SECRETS", ""); watchSecrets != "" {
+		for _, name := range strings.Split(watchSecrets, ",") {
+			name = strings.TrimSpace(name)
+			if !secretNamePattern.MatchString(name) {
+				slog.Error("invalid configuration", "error", fmt.Errorf("WATCH_SECRETS: invalid secret name %q", name))
+				os.Exit(1)
+			}
+			watchedSecrets = append(watchedSecrets, name)
+		}
+	}
+	options.Rotations = secrets.NewRotationWatcher(secretGetter, watchedSecrets)
+	options.Rotations.OnChange(func(change secrets.SecretChange) {
+		secretGetter.Invalidate(change.Name)
+	})
+
+	// Post the changes to the optional webhooks, signed so receivers can trust them
+	if changeWebhooks := secrets.GetEnv("CHANGE_WEBHOOK_URLS", ""); changeWebhooks != "" {
+		signingKey := secrets.GetEnv("CHANGE_WEBHOOK_SIGNING_KEY", "")
+		if signingKey == "" {
+			slog.Error("invalid configuration", "error", "CHANGE_WEBHOOK_SIGNING_KEY is required with CHANGE_WEBHOOK_URLS")
+			os.Exit(1)
+		}
+		var urls []string
+		for _, url := range strings.Split(changeWebhooks, ",") {
+			urls = append(urls, strings.TrimSpace(url))
+		}
+		options.Rotations.OnChange(secrets.NewChangeNotifier(urls, signingKey, 100, secretGetter.Clock).Notify)
+	}
+	watchSecretsInterval, err := secrets.GetEnvDuration("WATCH_SECRETS_INTERVAL", time.Minute)
+	if err != nil {
+		slog.Error("invalid configuration", "error", err)
+		os.Exit(1)
+	}
+
+	// Get the interval of the keepalive comments of /watch streams, under the idle timeout of common proxies
+	options.WatchKeepalive, err = secrets.GetEnvDuration("WATCH_KEEPALIVE_INTERVAL", 15*time.Second)
+	if err != nil {
+		slog.Error("invalid configuration", "error", err)
+		os.Exit(1)
+	}
+
+	// Get the optional Pub/Sub subscription to the notifications of the secrets, invalidating them as they change
+	var invalidator *secrets.PubSubInvalidator
+	if subscription := secrets.GetEnv("PUBSUB_SUBSCRIPTION", ""); subscription != "" {
+		invalidator, err = secrets.NewPubSubInvalidator(secretGetter, subscription, secrets.GetEnv("GCP_PROJECT", ""))
+		if err != nil {
+			slog.Error("invalid configuration", "error", fmt.Errorf("PUBSUB_SUBSCRIPTION: %w", err))
+			os.Exit(1)
+		}
+	}
+
+	// Run as a sidecar when asked to, writing the secrets of the pod to a shared volume before being ready
+	var sidecarInterval time.Duration
+	if *sidecar {
+		sidecarSecrets := splitNames(secrets.GetEnv("SIDECAR_SECRETS", ""))
+		fileSync, err := NewFileSync(secretGetter, options, secrets.GetEnv("SIDECAR_DIR", defaultInjectPath), sidecarSecrets)
+		if err != nil {
+			slog.Error("invalid configuration", "error", fmt.Errorf("SIDECAR_SECRETS: %w", err))
+			os.Exit(1)
+		}
+		sidecarInterval, err = secrets.GetEnvDuration("SIDECAR_REFRESH_INTERVAL", time.Minute)
+		if err != nil {
+			slog.Error("invalid configuration", "error", err)
+			os.Exit(1)
+		}
+		options.Sidecar = NewSidecar(fileSync)
+	}
+
+	// Get the optional admission webhook, injecting the secrets of annotated pods with an init container
+	options.Injector = newInjectorFromEnv()
+
+	routes := serverRoutes(secretGetter, options)
+
+	// Subcommands use the configuration of the server instead of serving it
+	switch flag.Arg(0) {
+	case "routes":
+		// The routes subcommand prints the effective route table
+		err = printRoutes(os.Stdout, routes)
+		if err != nil {
+			slog.Error("invalid configuration", "error", err)
+			os.Exit(1)
+		}
+		return
+	case "get":
+		// The get subcommand prints the value of a secret, for scripts and Makefiles
+		err = runGet(os.Stdout, secretGetter, options, flag.Args()[1:])
+		if err != nil {
+			slog.Error("getting secret", "error", err)
+			os.Exit(1)
+		}
+		return
+	case "exec":
+		// The exec subcommand only returns when the command could not be started
+		err = runExec(secretGetter, options, flag.Args()[1:])
+		slog.Error("running command", "error", err)
+		os.Exit(1)
+	case "render":
+		// The render subcommand writes templates referencing secrets, for applications that only read files
+		err = runRender(secretGetter, options, flag.Args()[1:])
+		if err != nil {
+			slog.Error("rendering templates", "error", err)
+			os.Exit(1)
+		}
+		return
+	case "export":
+		// The export subcommand writes secrets as a dotenv file, shell exports or JSON, for local development
+		err = runExport(os.Stdout, secretGetter, options, flag.Args()[1:])
+		if err != nil {
+			slog.Error("exporting secrets", "error", err)
+			os.Exit(1)
+		}
+		return
+	case "sync":
+		// The sync subcommand writes secrets as files under a directory, keeping them updated on rotation
+		err = runSync(secretGetter, options, flag.Args()[1:])
+		if err != nil {
+			slog.Error("syncing secrets", "error", err)
+			os.Exit(1)
+		}
+		return
+	case "encrypt":
+		// The encrypt subcommand writes the encrypted files the encrypted-file backend reads
+		err = runEncrypt(flag.Args()[1:])
+		if err != nil {
+			slog.Error("encrypting secrets", "error", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	// Get the TLS configuration, it is validated even when TLS is not enabled so mistakes fail early
+	tlsConfig, err := secrets.NewTLSConfig(secrets.GetEnv("TLS_MIN_VERSION", ""), secrets.GetEnv("TLS_CIPHER_SUITES", ""))
+	if err != nil {
+		slog.Error("invalid configuration", "error", err)
+		os.Exit(1)
+	}
+
+	// Get the certificate, which can be reloaded when cert-manager or similar rotate the files
+	tlsCertFile, tlsKeyFile := secrets.GetEnv("TLS_CERT_FILE", ""), secrets.GetEnv("TLS_KEY_FILE", "")
+	serveTLS := tlsCertFile != "" || tlsKeyFile != ""
+	var certificate *Certificate
+	if serveTLS {
+		certificate, err = LoadCertificate(tlsCertFile, tlsKeyFile)
+		if err != nil {
+			slog.Error("invalid configuration", "error", fmt.Errorf("TLS_CERT_FILE: %w", err))
+			os.Exit(1)
+		}
+		tlsConfig.GetCertificate = certificate.GetCertificate
+
+		watchCert, err := secrets.GetEnvBool("WATCH_TLS_CERT", false)
+		if err != nil {
+			slog.Error("invalid configuration", "error", err)
+			os.Exit(1)
+		}
+		watchInterval, err := secrets.GetEnvDuration("WATCH_TLS_CERT_INTERVAL", time.Minute)
+		if err != nil {
+			slog.Error("invalid configuration", "error", err)
+			os.Exit(1)
+		}
+		if watchCert {
+			go certificate.Watch(watchInterval, make(chan struct{}))
+		}
+	}
+
+	// Require client certificates signed by the CA bundle when there is one, so only known workloads can connect
+	// The handshake only verifies the certificates given, the routes require them, so the kubelet can still probe
+	if clientCAFile := secrets.GetEnv("TLS_CLIENT_CA_FILE", ""); clientCAFile != "" {
+		if !serveTLS {
+			slog.Error("invalid configuration", "error", "TLS_CLIENT_CA_FILE requires TLS_CERT_FILE and TLS_KEY_FILE")
+			os.Exit(1)
+		}
+		tlsConfig.ClientCAs, err = loadClientCAs(clientCAFile)
+		if err != nil {
+			slog.Error("invalid configuration", "error", fmt.Errorf("TLS_CLIENT_CA_FILE: %w", err))
+			os.Exit(1)
+		}
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+		options.ClientCertificates = true
+	}
+
+	// Reload the API keys, the JWT subjects, the cache TTLs and the certificate on SIGHUP, and when their files change
+	// if asked to, so policy changes do not need a restart
+	reloader := &Reloader{
+		ConfigPath:   *configPath,
+		APIKeys:      options.APIKeys,
+		WriteKeys:    options.WriteKeys,
+		JWT:          options.JWT,
+		Certificate:  certificate,
+		SecretGetter: secretGetter,
+	}
+	reloadSignals := make(chan os.Signal, 1)
+	signal.Notify(reloadSignals, syscall.SIGHUP)
+	go reloader.ReloadOnSignal(reloadSignals)
+
+	watchConfig, err := secrets.GetEnvBool("WATCH_CONFIG", false)
+	if err != nil {
+		slog.Error("invalid configuration", "error", err)
+		os.Exit(1)
+	}
+	watchConfigInterval, err := secrets.GetEnvDuration("WATCH_CONFIG_INTERVAL", time.Second)
+	if err != nil {
+		slog.Error("invalid configuration", "error", err)
+		os.Exit(1)
+	}
+	if watchConfig {
+		go reloader.Watch(watchConfigInterval, make(chan struct{}))
+	}
+
+	// Watch the secrets for rotation
+	go options.Rotations.Run(watchSecretsInterval, make(chan struct{}))
+
+	// Invalidate the secrets on their notifications
+	if invalidator != nil {
+		go invalidator.Run(make(chan struct{}))
+	}
+
+	// Write the secrets of the pod as a sidecar, /readyz answers 503 until they are all written
+	if options.Sidecar != nil {
+		go options.Sidecar.Run(sidecarInterval, make(chan struct{}))
+	}
+
+	// Serve the gRPC API on its own address when one is configured, it shares the certificate with HTTPS
+	if grpcAddr := secrets.GetEnv("GRPC_ADDR", ""); grpcAddr != "" {
+		grpcServer := &http.Server{Addr: grpcAddr, Handler: grpcHandler(secretGetter, options), TLSConfig: tlsConfig.Clone()}
+		// There are no probes on the gRPC address, so the handshake requires the client certificates itself
+		if options.ClientCertificates {
+			grpcServer.TLSConfig.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+		go func() {
+			var err error
+			if serveTLS {
+				err = grpcServer.ListenAndServeTLS("", "")
+			} else {
+				// Plaintext gRPC is HTTP/2 without TLS, which has to be enabled explicitly
+				grpcServer.Protocols = new(http.Protocols)
+				grpcServer.Protocols.SetUnencryptedHTTP2(true)
+				err = grpcServer.ListenAndServe()
+			}
+			slog.Error("gRPC server stopped", "error", err)
+			os.Exit(1)
+		}()
+	}
+
+	// Set up the HTTP server for getting secrets on LISTEN_ADDR, serving HTTPS when a certificate is configured
+	server := &http.Server{Addr: secrets.GetEnv("LISTEN_ADDR", ":8080"), Handler: withProtocolChecks(requireCredentials(options, newServeMux(routes))), TLSConfig: tlsConfig}
+	if serveTLS {
+		err = server.ListenAndServeTLS("", "")
+	} else {
+		err = server.ListenAndServe()
+	}
+	if err != nil {
+		slog.Error("server stopped", "error", err)
+		os.Exit(1)
+	}
+}
+
+// splitNames splits a comma separated list of names, ignoring blanks
+func splitNames(value string) []string {
+	var names []string
+	for _, name := range strings.Split(value, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
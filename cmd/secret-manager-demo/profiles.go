@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"secret-manager-demo/pkg/secrets"
+)
+
+// profileSecret is a secret that belongs to a profile, exposed under an optional variable name
+type profileSecret struct {
+	Secret string `json:"secret"`
+	Env    string `json:"env"`
+}
+
+// variable returns the name of the variable for the secret, derived from the secret name if not set
+func (p profileSecret) variable() string {
+	if p.Env != "" {
+		return p.Env
+	}
+	return strings.ToUpper(strings.ReplaceAll(p.Secret, "-", "_"))
+}
+
+// profiles maps a profile name, usually an application, to the secrets it needs
+type profiles map[string][]profileSecret
+
+// loadProfiles reads the profiles from a JSON file
+func loadProfiles(path string) (profiles, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	p := profiles{}
+	err = json.Unmarshal(content, &p)
+	if err != nil {
+		return nil, err
+	}
+
+	for name, profileSecrets := range p {
+		for _, secret := range profileSecrets {
+			if !secretNamePattern.MatchString(secret.Secret) {
+				return nil, fmt.Errorf("profile %s: invalid secret name %q", name, secret.Secret)
+			}
+		}
+	}
+	return p, nil
+}
+
+// dotenvLine formats a variable as a dotenv line, quoting the value so it is safe to source
+func dotenvLine(name string, value string) string {
+	replacer := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "$", `\$`, "`", "\\`", "\n", `\n`)
+	return fmt.Sprintf("%s=\"%s\"\n", name, replacer.Replace(value))
+}
+
+// getProfileHandler returns every secret of the profile on the path as a dotenv blob
+func getProfileHandler(secretGetter secrets.SecretGetter, options handlerOptions) http.HandlerFunc {
+	return func(w http.ResponseWriter, rq *http.Request) {
+		profileSecrets, ok := options.Profiles[strings.TrimPrefix(rq.URL.Path, "/profile/")]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		// Make sure the caller is allowed to read every secret of the profile
+		for _, secret := range profileSecrets {
+			if status := options.authorize(rq, secret.Secret); status != http.StatusOK {
+				w.WriteHeader(status)
+				return
+			}
+		}
+
+		ctx, cancel := options.requestContext(rq)
+		defer cancel()
+
+		var blob strings.Builder
+		for _, secret := range profileSecrets {
+			resolution, err := secretGetter.ResolveContext(ctx, secret.Secret, secretGetter.DefaultFallback(secret.Secret))
+			options.recordAccess(rq, secretGetter.Now(), secret.Secret, "", resolutionResult(resolution, err))
+			switch {
+			case errors.Is(err, secrets.ErrSecretNotFound):
+				w.WriteHeader(http.StatusNotFound)
+				return
+			case err != nil:
+				w.WriteHeader(http.StatusBadGateway)
+				return
+			}
+			blob.WriteString(dotenvLine(secret.variable(), resolution.Value))
+		}
+
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte(blob.String()))
+	}
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"context"
+	"net/http"
+
+	"secret-manager-demo/pkg/secrets"
+)
+
+// authorizeSecret checks the caller may read the secret, on the project the request asks for on the project header
+// or query if any, and returns the context scoped to that project
+// Secrets of other projects are authorized as project/name, so a caller allowed to read a name on the configured
+// project is not allowed to read it on every project, and only the allowed projects can be asked for at all
+func (o handlerOptions) authorizeSecret(ctx context.Context, rq *http.Request, name string) (context.Context, int) {
+	project := rq.Header.Get("project")
+	if project == "" {
+		project = rq.URL.Query().Get("project")
+	}
+
+	authorizedName := name
+	if project != "" {
+		authorizedName = project + "/" + name
+	}
+	if status := o.authorize(rq, authorizedName); status != http.StatusOK {
+		return ctx, status
+	}
+
+	if project == "" {
+		return ctx, http.StatusOK
+	}
+	if !o.AllowedProjects[project] {
+		return ctx, http.StatusForbidden
+	}
+	return secrets.WithProject(ctx, project), http.StatusOK
+}
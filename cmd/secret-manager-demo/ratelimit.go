@@ -0,0 +1,134 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"secret-manager-demo/pkg/secrets"
+)
+
+// Ways of telling clients apart for rate limiting
+const (
+	rateLimitByIP  = "ip"
+	rateLimitByKey = "key"
+)
+
+// rateLimiterSweepSize is how many clients are tracked before the idle ones are dropped
+const rateLimiterSweepSize = 10000
+
+// RateLimiter keeps a token bucket per client, so a caller stuck in a retry loop cannot use up the quota of the backend
+// A nil RateLimiter allows every request
+type RateLimiter struct {
+	rate  float64
+	burst float64
+	by    string
+	clock secrets.Clock
+
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+}
+
+// tokenBucket holds the tokens left for a client as of the last time it was updated
+type tokenBucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// NewRateLimiter returns a limiter allowing rate requests per second with bursts of burst requests per client,
+// a zero rate disables rate limiting
+func NewRateLimiter(rate int, burst int, by string, clock secrets.Clock) *RateLimiter {
+	if rate <= 0 {
+		return nil
+	}
+	if burst < 1 {
+		burst = rate
+	}
+	if clock == nil {
+		clock = secrets.RealClock{}
+	}
+	return &RateLimiter{rate: float64(rate), burst: float64(burst), by: by, clock: clock, buckets: map[string]*tokenBucket{}}
+}
+
+// Allow takes a token from the bucket of the client of the request, when it is empty it tells how long until the next one
+func (l *RateLimiter) Allow(rq *http.Request) (bool, time.Duration) {
+	if l == nil {
+		return true, 0
+	}
+
+	client := l.client(rq)
+	now := l.clock.Now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if len(l.buckets) >= rateLimiterSweepSize {
+		l.sweep(now)
+	}
+
+	bucket, ok := l.buckets[client]
+	if !ok {
+		bucket = &tokenBucket{tokens: l.burst, updated: now}
+		l.buckets[client] = bucket
+	}
+
+	bucket.tokens = math.Min(l.burst, bucket.tokens+now.Sub(bucket.updated).Seconds()*l.rate)
+	bucket.updated = now
+	if bucket.tokens < 1 {
+		return false, time.Duration((1 - bucket.tokens) / l.rate * float64(time.Second))
+	}
+	bucket.tokens--
+	return true, 0
+}
+
+// sweep drops the buckets that are full again, which are the same as a new one
+func (l *RateLimiter) sweep(now time.Time) {
+	for client, bucket := range l.buckets {
+		if bucket.tokens+now.Sub(bucket.updated).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, client)
+		}
+	}
+}
+
+// client tells the client of the request, by API key or token when limiting by key and by the remote IP otherwise
+// Requests without credentials are limited by IP either way
+func (l *RateLimiter) client(rq *http.Request) string {
+	if l.by == rateLimitByKey {
+		credential := rq.Header.Get(apiKeyHeader)
+		if token, ok := bearerToken(rq); ok {
+			credential = token
+		}
+		if credential != "" {
+			// Only a hash is kept, so the keys are not held in memory longer than the request
+			hash := sha256.Sum256([]byte(credential))
+			return "key:" + hex.EncodeToString(hash[:])
+		}
+	}
+
+	host, _, err := net.SplitHostPort(rq.RemoteAddr)
+	if err != nil {
+		host = rq.RemoteAddr
+	}
+	return "ip:" + host
+}
+
+// rateLimited answers 429 with Retry-After when the client of the request is over its rate
+func rateLimited(limiter *RateLimiter, handler http.HandlerFunc) http.HandlerFunc {
+	if limiter == nil {
+		return handler
+	}
+	return func(w http.ResponseWriter, rq *http.Request) {
+		allowed, wait := limiter.Allow(rq)
+		if !allowed {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		handler(w, rq)
+	}
+}
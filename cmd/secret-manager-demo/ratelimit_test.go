@@ -0,0 +1,168 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"secret-manager-demo/pkg/secrets"
+)
+
+func TestRateLimiter(t *testing.T) {
+	type step struct {
+		advance         time.Duration
+		remoteAddr      string
+		apiKey          string
+		token           string
+		expectedAllowed bool
+		expectedWait    time.Duration
+	}
+
+	tests := []struct {
+		name  string
+		by    string
+		steps []step
+	}{
+		{
+			name: "bucket refills at the rate",
+			by:   rateLimitByIP,
+			steps: []step{
+				{remoteAddr: "10.0.0.1:1000", expectedAllowed: true},
+				{remoteAddr: "10.0.0.1:1000", expectedAllowed: true},
+				{remoteAddr: "10.0.0.1:1000", expectedWait: 500 * time.Millisecond},
+				{advance: 250 * time.Millisecond, remoteAddr: "10.0.0.1:1000", expectedWait: 250 * time.Millisecond},
+				{advance: 250 * time.Millisecond, remoteAddr: "10.0.0.1:1000", expectedAllowed: true},
+				{remoteAddr: "10.0.0.1:1000", expectedWait: 500 * time.Millisecond},
+			},
+		},
+		{
+			name: "bucket does not grow past the burst",
+			by:   rateLimitByIP,
+			steps: []step{
+				{advance: time.Hour, remoteAddr: "10.0.0.1:1000", expectedAllowed: true},
+				{remoteAddr: "10.0.0.1:1000", expectedAllowed: true},
+				{remoteAddr: "10.0.0.1:1000", expectedWait: 500 * time.Millisecond},
+			},
+		},
+		{
+			name: "by IP ignores the port and the key",
+			by:   rateLimitByIP,
+			steps: []step{
+				{remoteAddr: "10.0.0.1:1000", apiKey: "a", expectedAllowed: true},
+				{remoteAddr: "10.0.0.1:2000", apiKey: "b", expectedAllowed: true},
+				{remoteAddr: "10.0.0.1:3000", apiKey: "c", expectedWait: 500 * time.Millisecond},
+				{remoteAddr: "10.0.0.2:1000", apiKey: "a", expectedAllowed: true},
+			},
+		},
+		{
+			name: "by key has a bucket per key and token",
+			by:   rateLimitByKey,
+			steps: []step{
+				{remoteAddr: "10.0.0.1:1000", apiKey: "a", expectedAllowed: true},
+				{remoteAddr: "10.0.0.2:1000", apiKey: "a", expectedAllowed: true},
+				{remoteAddr: "10.0.0.3:1000", apiKey: "a", expectedWait: 500 * time.Millisecond},
+				{remoteAddr: "10.0.0.1:1000", apiKey: "b", expectedAllowed: true},
+				{remoteAddr: "10.0.0.1:1000", token: "t", expectedAllowed: true},
+				{remoteAddr: "10.0.0.1:1000", apiKey: "b", token: "t", expectedAllowed: true},
+				{remoteAddr: "10.0.0.1:1000", token: "t", expectedWait: 500 * time.Millisecond},
+			},
+		},
+		{
+			name: "by key limits requests without credentials by IP",
+			by:   rateLimitByKey,
+			steps: []step{
+				{remoteAddr: "10.0.0.1:1000", expectedAllowed: true},
+				{remoteAddr: "10.0.0.1:2000", expectedAllowed: true},
+				{remoteAddr: "10.0.0.1:3000", expectedWait: 500 * time.Millisecond},
+				{remoteAddr: "10.0.0.1:1000", apiKey: "a", expectedAllowed: true},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			clock := secrets.NewFakeClock(time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC))
+			limiter := NewRateLimiter(2, 2, test.by, clock)
+
+			for i, step := range test.steps {
+				clock.Advance(step.advance)
+				rq := httptest.NewRequest(http.MethodGet, "/get-secret", nil)
+				rq.RemoteAddr = step.remoteAddr
+				if step.apiKey != "" {
+					rq.Header.Set(apiKeyHeader, step.apiKey)
+				}
+				if step.token != "" {
+					rq.Header.Set("Authorization", "Bearer "+step.token)
+				}
+
+				allowed, wait := limiter.Allow(rq)
+				if allowed != step.expectedAllowed || wait != step.expectedWait {
+					t.Errorf("step %d: expected %t and %s, got %t and %s", i, step.expectedAllowed, step.expectedWait, allowed, wait)
+				}
+			}
+		})
+	}
+}
+
+func TestRateLimiterSweepsFullBuckets(t *testing.T) {
+	clock := secrets.NewFakeClock(time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC))
+	limiter := NewRateLimiter(1, 1, rateLimitByIP, clock)
+	allow := func(i int) {
+		rq := httptest.NewRequest(http.MethodGet, "/get-secret", nil)
+		rq.RemoteAddr = fmt.Sprintf("10.%d.%d.%d:1000", i>>16&255, i>>8&255, i&255)
+		limiter.Allow(rq)
+	}
+
+	for i := 0; i < rateLimiterSweepSize-1; i++ {
+		allow(i)
+	}
+	clock.Advance(time.Second)
+	allow(rateLimiterSweepSize - 1)
+	if len(limiter.buckets) != rateLimiterSweepSize {
+		t.Fatalf("expected %d buckets, got %d", rateLimiterSweepSize, len(limiter.buckets))
+	}
+
+	// The next client past the size drops the buckets that are full again, the one still refilling is kept
+	allow(rateLimiterSweepSize)
+	if len(limiter.buckets) != 2 {
+		t.Errorf("expected 2 buckets after the sweep, got %d", len(limiter.buckets))
+	}
+}
+
+func TestRoutesAreRateLimited(t *testing.T) {
+	secretGetter := secrets.SecretGetter{Provider: secrets.NewMemoryProvider(nil), Metrics: secrets.NewMetrics()}
+	clock := secrets.NewFakeClock(time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC))
+
+	tests := []struct {
+		path            string
+		method          string
+		expectedLimited bool
+	}{
+		{path: "/get-secret", method: http.MethodGet, expectedLimited: true},
+		{path: "/served", method: http.MethodGet, expectedLimited: true},
+		{path: "/stats", method: http.MethodGet, expectedLimited: true},
+		{path: "/cache/refresh", method: http.MethodPost, expectedLimited: true},
+		{path: "/secrets/db-password", method: http.MethodPut, expectedLimited: true},
+		{path: "/secrets/db-password/versions/1", method: http.MethodDelete, expectedLimited: true},
+		{path: "/healthz", method: http.MethodGet},
+		{path: "/readyz", method: http.MethodGet},
+		{path: "/metrics", method: http.MethodGet},
+	}
+
+	for _, test := range tests {
+		t.Run(test.method+" "+test.path, func(t *testing.T) {
+			options := handlerOptions{WriteKeys: NewAllowlist(apiKeys{}), RateLimiter: NewRateLimiter(1, 1, rateLimitByIP, clock)}
+			mux := newServeMux(serverRoutes(secretGetter, options))
+
+			// The first request takes the only token, whatever it is answered
+			mux.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(test.method, test.path, nil))
+			recorder := httptest.NewRecorder()
+			mux.ServeHTTP(recorder, httptest.NewRequest(test.method, test.path, nil))
+			if limited := recorder.Code == http.StatusTooManyRequests; limited != test.expectedLimited {
+				t.Errorf("expected limited %t, got status %d", test.expectedLimited, recorder.Code)
+			}
+		})
+	}
+}
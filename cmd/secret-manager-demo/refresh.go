@@ -0,0 +1,43 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"secret-manager-demo/pkg/secrets"
+)
+
+// refreshCacheHandler refreshes every cached secret and reports which ones failed
+// It makes a call to the provider per cached secret, so it needs a write key, and it is only served without write
+// keys when no credentials are configured at all; the failed names are the ones the key may write
+func refreshCacheHandler(secretGetter secrets.SecretGetter, options handlerOptions) http.HandlerFunc {
+	return func(w http.ResponseWriter, rq *http.Request) {
+		if options.WriteKeys == nil && (options.APIKeys != nil || options.JWT != nil) {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		if _, ok := options.WriteKeys.lookup(rq.Header.Get(apiKeyHeader)); options.WriteKeys != nil && !ok {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		report := secretGetter.RefreshCache(rq.Context(), options.RefreshConcurrency)
+		failedNames := []string{}
+		for _, name := range report.FailedNames {
+			if options.WriteKeys.authorize(rq, name) == http.StatusOK {
+				failedNames = append(failedNames, name)
+			}
+		}
+		report.FailedNames = failedNames
+
+		bytes, err := json.Marshal(report)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write(bytes)
+	}
+}
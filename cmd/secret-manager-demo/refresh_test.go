@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"secret-manager-demo/pkg/secrets"
+)
+
+func TestRefreshCacheHandler(t *testing.T) {
+	writeKeys := NewAllowlist(apiKeys{"writer": {"db-*"}})
+
+	tests := []struct {
+		name           string
+		options        handlerOptions
+		apiKey         string
+		expectedStatus int
+		expectedFailed []string
+	}{
+		{name: "without credentials configured", expectedStatus: http.StatusOK, expectedFailed: []string{"api-key", "db-password"}},
+		{
+			name:           "read key",
+			options:        handlerOptions{APIKeys: NewAllowlist(apiKeys{"reader": {"*"}}), WriteKeys: writeKeys},
+			apiKey:         "reader",
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "read key without write keys",
+			options:        handlerOptions{APIKeys: NewAllowlist(apiKeys{"reader": {"*"}})},
+			apiKey:         "reader",
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "write key only gets the names it may write",
+			options:        handlerOptions{APIKeys: NewAllowlist(apiKeys{"reader": {"*"}}), WriteKeys: writeKeys},
+			apiKey:         "writer",
+			expectedStatus: http.StatusOK,
+			expectedFailed: []string{"db-password"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cache := secrets.NewMemoryCache(time.Hour, nil)
+			cache.Set("db-password", "hunter2")
+			cache.Set("api-key", "abc")
+			secretGetter := secrets.SecretGetter{Provider: failingProvider{err: secrets.ErrSecretNotFound}, Cache: cache}
+
+			rq := httptest.NewRequest(http.MethodPost, "/cache/refresh", nil)
+			rq.Header.Set(apiKeyHeader, test.apiKey)
+			recorder := httptest.NewRecorder()
+			refreshCacheHandler(secretGetter, test.options)(recorder, rq)
+			if recorder.Code != test.expectedStatus {
+				t.Fatalf("expected %d, got %d", test.expectedStatus, recorder.Code)
+			}
+			if recorder.Code != http.StatusOK {
+				return
+			}
+
+			var report secrets.RefreshReport
+			if err := json.Unmarshal(recorder.Body.Bytes(), &report); err != nil {
+				t.Fatalf("decoding report: %s", err)
+			}
+			if report.Failed != 2 || !reflect.DeepEqual(report.FailedNames, test.expectedFailed) {
+				t.Errorf("expected 2 failed and %v, got %+v", test.expectedFailed, report)
+			}
+		})
+	}
+}
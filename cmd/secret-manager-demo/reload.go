@@ -0,0 +1,185 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"secret-manager-demo/pkg/secrets"
+)
+
+// Reloader applies the settings that can change without a restart: the API keys, the JWT subjects, the cache TTLs
+// and the TLS certificate, reading the config file again first
+// Every setting is swapped atomically, so requests in flight finish with the ones they started with
+type Reloader struct {
+	// ConfigPath is the config file read again on every reload, if any
+	ConfigPath   string
+	APIKeys      *Allowlist
+	WriteKeys    *Allowlist
+	JWT          *jwtVerifier
+	Certificate  *Certificate
+	SecretGetter secrets.SecretGetter
+
+	mu       sync.Mutex
+	modTimes map[string]time.Time
+}
+
+// Reload reads every setting, and only applies them when all of them are valid, keeping the previous ones otherwise
+// Settings that were disabled on start, like API keys without any configured, are not enabled until a restart
+func (r *Reloader) Reload() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.ConfigPath != "" {
+		values, err := secrets.LoadConfigFile(r.ConfigPath)
+		if err != nil {
+			return fmt.Errorf("config file %s: %w", r.ConfigPath, err)
+		}
+		previous := secrets.SetConfigValues(values)
+		err = r.reload()
+		if err != nil {
+			secrets.SetConfigValues(previous)
+		}
+		return err
+	}
+	return r.reload()
+}
+
+// reload reads and applies the settings from the current environment and config file values
+func (r *Reloader) reload() error {
+	readKeys, err := loadAPIKeysFromEnv()
+	if err != nil {
+		return err
+	}
+
+	var writeKeys apiKeys
+	if r.WriteKeys != nil {
+		writeKeys, err = loadAPIKeys(secrets.GetEnv("WRITE_API_KEYS_FILE", ""))
+		if err != nil {
+			return fmt.Errorf("WRITE_API_KEYS_FILE: %w", err)
+		}
+	}
+
+	var subjects apiKeys
+	if r.JWT != nil {
+		subjects, err = loadJWTSubjectsFromEnv()
+		if err != nil {
+			return err
+		}
+	}
+
+	secretCacheTTL, err := secrets.GetEnvDuration("SECRET_CACHE_TTL", 0)
+	if err != nil {
+		return err
+	}
+	versionMetadataTTL, err := secrets.GetEnvDuration("VERSION_METADATA_TTL", 0)
+	if err != nil {
+		return err
+	}
+	staleWindow, err := secrets.GetEnvDuration("STALE_WINDOW", 0)
+	if err != nil {
+		return err
+	}
+
+	// The certificate is swapped by its own reload, so it goes last to keep everything else unchanged if it fails
+	if r.Certificate != nil {
+		err = r.Certificate.reload()
+		if err != nil {
+			return fmt.Errorf("TLS certificate: %w", err)
+		}
+	}
+
+	if r.APIKeys != nil {
+		r.APIKeys.Set(readKeys)
+	} else if readKeys != nil {
+		slog.Warn("API keys are not enabled until a restart")
+	}
+	if r.WriteKeys != nil {
+		r.WriteKeys.Set(writeKeys)
+	}
+	if r.JWT != nil {
+		r.JWT.Subjects.Set(subjects)
+	}
+
+	// Caches disabled on start are not created, the server and its handlers share the ones it started with
+	if cache, ok := r.SecretGetter.Cache.(secrets.TTLSetter); ok {
+		cache.SetTTL(secretCacheTTL)
+	} else if r.SecretGetter.Cache == nil && secretCacheTTL > 0 {
+		slog.Warn("SECRET_CACHE_TTL is not enabled until a restart")
+	}
+	if r.SecretGetter.VersionMetadataCache != nil {
+		r.SecretGetter.VersionMetadataCache.SetTTL(versionMetadataTTL)
+	} else if versionMetadataTTL > 0 {
+		slog.Warn("VERSION_METADATA_TTL is not enabled until a restart")
+	}
+	if r.SecretGetter.StaleCache != nil {
+		r.SecretGetter.StaleCache.SetTTL(staleWindow)
+	} else if staleWindow > 0 {
+		slog.Warn("STALE_WINDOW is not enabled until a restart")
+	}
+	return nil
+}
+
+// ReloadOnSignal reloads on every signal received, until the channel is closed
+func (r *Reloader) ReloadOnSignal(signals <-chan os.Signal) {
+	for signal := range signals {
+		r.reloadAndLog(signal.String())
+	}
+}
+
+// Watch polls the config file and the key files on the given interval and reloads when any changes, until stop is closed
+func (r *Reloader) Watch(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	r.filesChanged()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if r.filesChanged() {
+				r.reloadAndLog("file change")
+			}
+		}
+	}
+}
+
+// filesChanged tells if any watched file was modified since the last call, files that cannot be read are skipped
+// as the reload reports them
+func (r *Reloader) filesChanged() bool {
+	paths := []string{r.ConfigPath, secrets.GetEnv("API_KEYS_FILE", ""), secrets.GetEnv("WRITE_API_KEYS_FILE", ""), secrets.GetEnv("JWT_SUBJECTS_FILE", "")}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.modTimes == nil {
+		r.modTimes = map[string]time.Time{}
+	}
+
+	changed := false
+	for _, path := range paths {
+		if path == "" {
+			continue
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		if modTime, ok := r.modTimes[path]; ok && !modTime.Equal(info.ModTime()) {
+			changed = true
+		}
+		r.modTimes[path] = info.ModTime()
+	}
+	return changed
+}
+
+func (r *Reloader) reloadAndLog(trigger string) {
+	err := r.Reload()
+	if err != nil {
+		slog.Error("reloading configuration, keeping the previous one", "trigger", trigger, "error", err)
+		return
+	}
+	slog.Info("reloaded configuration", "trigger", trigger)
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"text/template"
+
+	"secret-manager-demo/pkg/secrets"
+)
+
+// maxRenderRequestSize bounds the body of render requests
+const maxRenderRequestSize = 1 << 20
+
+// maxRenderOutputSize bounds the rendered output, so a template ranging over large numbers cannot grow it without end
+const maxRenderOutputSize = 1 << 20
+
+// errRenderOutputTooLarge is returned by the writes past maxRenderOutputSize, stopping the template
+var errRenderOutputTooLarge = fmt.Errorf("the output is larger than %d bytes", maxRenderOutputSize)
+
+// renderWriter buffers the output of a template, failing the writes past its size and the ones after the context is
+// done, which stops the execution so it is bounded by the request timeout too
+type renderWriter struct {
+	ctx    context.Context
+	size   int
+	buffer bytes.Buffer
+}
+
+func (w *renderWriter) Write(p []byte) (int, error) {
+	if err := w.ctx.Err(); err != nil {
+		return 0, err
+	}
+	if w.buffer.Len()+len(p) > w.size {
+		return 0, errRenderOutputTooLarge
+	}
+	return w.buffer.Write(p)
+}
+
+// renderRequest is a Go template together with the secrets it may use, keyed by template variable
+type renderRequest struct {
+	Template string            `json:"template"`
+	Secrets  map[string]string `json:"secrets"`
+}
+
+// renderHandler renders the template on the body with the values of the given secrets
+// The template only gets the secrets it was given, as a map, and no functions to reach anything else
+func renderHandler(secretGetter secrets.SecretGetter, options handlerOptions) http.HandlerFunc {
+	return func(w http.ResponseWriter, rq *http.Request) {
+		var renderRq renderRequest
+		err := json.NewDecoder(http.MaxBytesReader(w, rq.Body, maxRenderRequestSize)).Decode(&renderRq)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid request: %s", err), http.StatusBadRequest)
+			return
+		}
+
+		tmpl, err := template.New("render").Option("missingkey=error").Parse(renderRq.Template)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid template: %s", err), http.StatusBadRequest)
+			return
+		}
+
+		ctx, cancel := options.requestContext(rq)
+		defer cancel()
+
+		data := map[string]string{}
+		for variable, secretName := range renderRq.Secrets {
+			if !secretNamePattern.MatchString(secretName) {
+				http.Error(w, fmt.Sprintf("invalid secret name %q", secretName), http.StatusBadRequest)
+				return
+			}
+
+			// Make sure the caller is allowed to read the secret
+			lookupName := options.NameCase.normalize(secretName)
+			if status := options.authorize(rq, lookupName); status != http.StatusOK {
+				w.WriteHeader(status)
+				return
+			}
+
+			// Fallbacks would render a plausible looking but wrong output, so they count as missing
+			resolution, err := secretGetter.ResolveContext(ctx, lookupName, "")
+			options.recordAccess(rq, secretGetter.Now(), secretName, "", resolutionResult(resolution, err))
+			switch {
+			case errors.Is(err, secrets.ErrSecretNotFound), err == nil && resolution.IsFallback():
+				http.Error(w, fmt.Sprintf("secret %s not found", secretName), http.StatusNotFound)
+				return
+			case err != nil:
+				http.Error(w, fmt.Sprintf("secret %s unavailable", secretName), http.StatusBadGateway)
+				return
+			}
+			data[variable] = resolution.Value
+		}
+
+		rendered := &renderWriter{ctx: ctx, size: maxRenderOutputSize}
+		err = tmpl.Execute(rendered, data)
+		if ctx.Err() != nil {
+			http.Error(w, "rendering template: timed out", http.StatusServiceUnavailable)
+			return
+		}
+		if err != nil {
+			http.Error(w, fmt.Sprintf("rendering template: %s", err), http.StatusBadRequest)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write(rendered.buffer.Bytes())
+	}
+}
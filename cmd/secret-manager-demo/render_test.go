@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"secret-manager-demo/pkg/secrets"
+)
+
+func TestRenderHandlerBoundsTheOutput(t *testing.T) {
+	secretGetter := secrets.SecretGetter{Provider: secrets.NewMemoryProvider(map[string]string{"db-password": "hunter2"}), RequireFallback: true}
+
+	tests := []struct {
+		name             string
+		body             string
+		canceled         bool
+		expectedStatus   int
+		expectedResponse string
+	}{
+		{
+			name:             "rendered",
+			body:             `{"template": "password={{.password}}", "secrets": {"password": "db-password"}}`,
+			expectedStatus:   http.StatusOK,
+			expectedResponse: "password=hunter2",
+		},
+		{
+			name:             "output too large",
+			body:             `{"template": "{{range 2000000}}x{{end}}"}`,
+			expectedStatus:   http.StatusBadRequest,
+			expectedResponse: errRenderOutputTooLarge.Error(),
+		},
+		{
+			name:             "canceled",
+			body:             `{"template": "{{range 1000}}x{{end}}"}`,
+			canceled:         true,
+			expectedStatus:   http.StatusServiceUnavailable,
+			expectedResponse: "timed out",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			handler := renderHandler(secretGetter, handlerOptions{})
+			rq := httptest.NewRequest(http.MethodPost, "/render", strings.NewReader(test.body))
+			// A request whose client went away is canceled like one past its timeout
+			if test.canceled {
+				ctx, cancel := context.WithCancel(rq.Context())
+				cancel()
+				rq = rq.WithContext(ctx)
+			}
+			recorder := httptest.NewRecorder()
+			handler(recorder, rq)
+			if recorder.Code != test.expectedStatus {
+				t.Errorf("expected %d, got %d", test.expectedStatus, recorder.Code)
+			}
+			if !strings.Contains(recorder.Body.String(), test.expectedResponse) {
+				t.Errorf("expected %q in the response, got %q", test.expectedResponse, recorder.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+
+	"secret-manager-demo/pkg/secrets"
+)
+
+// renderTarget is a template file and the path its output is written to
+type renderTarget struct {
+	source string
+	dest   string
+	// rendered is the last output written, so unchanged outputs are not written again
+	rendered []byte
+}
+
+// runRender renders every template given with -template SOURCE:DEST, writing the output to DEST
+// Templates reference secrets with {{ secret "NAME" }}, resolved like /render does, so a fallback fails the render
+// With -watch the templates are rendered again on the interval and written only when a secret rotated
+func runRender(secretGetter secrets.SecretGetter, options handlerOptions, args []string) error {
+	flags := flag.NewFlagSet("render", flag.ContinueOnError)
+	var templates repeatedFlag
+	flags.Var(&templates, "template", "template to render, as SOURCE:DEST, can be repeated")
+	watch := flags.Duration("watch", 0, "interval to render the templates again on, zero renders them once")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	if len(templates) == 0 || flags.NArg() != 0 {
+		return errors.New("usage: render -template SOURCE:DEST [-template SOURCE:DEST]... [-watch INTERVAL]")
+	}
+
+	targets := make([]*renderTarget, 0, len(templates))
+	for _, t := range templates {
+		source, dest, ok := strings.Cut(t, ":")
+		if !ok || source == "" || dest == "" {
+			return fmt.Errorf("invalid template %q, expected SOURCE:DEST", t)
+		}
+		targets = append(targets, &renderTarget{source: source, dest: dest})
+	}
+
+	// The first render must succeed, so a process waiting on the files does not start without them
+	for _, target := range targets {
+		err = target.render(secretGetter, options)
+		if err != nil {
+			return err
+		}
+	}
+	if *watch <= 0 {
+		return nil
+	}
+
+	ticker := time.NewTicker(*watch)
+	defer ticker.Stop()
+	for range ticker.C {
+		for _, target := range targets {
+			err = target.render(secretGetter, options)
+			if err != nil {
+				slog.Error("rendering template, keeping the previous output", "path", target.source, "error", err)
+			}
+		}
+	}
+	return nil
+}
+
+// render renders the template and writes the output when it changed, atomically and only readable by the owner
+func (t *renderTarget) render(secretGetter secrets.SecretGetter, options handlerOptions) error {
+	content, err := ioutil.ReadFile(t.source)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := options.commandContext()
+	defer cancel()
+
+	funcs := template.FuncMap{
+		"secret": func(name string) (string, error) {
+			if !secretNamePattern.MatchString(name) {
+				return "", fmt.Errorf("invalid secret name %q", name)
+			}
+			resolution, err := secretGetter.ResolveContext(ctx, options.NameCase.normalize(name), "")
+			if err != nil {
+				return "", fmt.Errorf("secret %s: %w", name, err)
+			}
+			if resolution.IsFallback() {
+				return "", fmt.Errorf("secret %s is not available", name)
+			}
+			return resolution.Value, nil
+		},
+	}
+	tmpl, err := template.New(filepath.Base(t.source)).Funcs(funcs).Option("missingkey=error").Parse(string(content))
+	if err != nil {
+		return err
+	}
+
+	var rendered bytes.Buffer
+	err = tmpl.Execute(&rendered, nil)
+	if err != nil {
+		return err
+	}
+	if t.rendered != nil && bytes.Equal(rendered.Bytes(), t.rendered) {
+		return nil
+	}
+
+	err = writeFileAtomically(t.dest, rendered.Bytes(), 0600)
+	if err != nil {
+		return err
+	}
+	t.rendered = rendered.Bytes()
+	slog.Info("rendered template", "path", t.source, "dest", t.dest)
+	return nil
+}
+
+// writeFileAtomically writes the content on a temporary file next to the path and renames it, so readers never
+// see a partial file, the temporary file is only readable by the owner until it gets the permissions
+func writeFileAtomically(path string, content []byte, perm os.FileMode) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(content)
+	if err == nil {
+		err = tmp.Chmod(perm)
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
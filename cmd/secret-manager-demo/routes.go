@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"secret-manager-demo/pkg/secrets"
+)
+
+// route is an endpoint registered on the server together with the methods it allows
+type route struct {
+	Path    string
+	Methods []string
+	Handler http.HandlerFunc
+}
+
+// serverRoutes returns every route the server registers for the given configuration
+func serverRoutes(secretGetter secrets.SecretGetter, options handlerOptions) []route {
+	// The endpoints doing work are rate limited, as they call the provider or walk what the server tracks
+	// The probes and metrics are not, so a busy client cannot make the pod look unhealthy
+	routes := []route{
+		{Path: "/get-secret", Methods: []string{http.MethodGet}, Handler: rateLimited(options.RateLimiter, getSecretHandler(secretGetter, options))},
+		{Path: "/get-secrets", Methods: []string{http.MethodPost}, Handler: rateLimited(options.RateLimiter, getSecretsHandler(secretGetter, options))},
+		{Path: "/secrets", Methods: []string{http.MethodGet}, Handler: rateLimited(options.RateLimiter, listSecretsHandler(secretGetter, options))},
+		{Path: "/get-secret-versions", Methods: []string{http.MethodGet}, Handler: rateLimited(options.RateLimiter, getSecretVersionsHandler(secretGetter, options))},
+		{Path: "/get-secret-metadata", Methods: []string{http.MethodGet}, Handler: rateLimited(options.RateLimiter, getSecretMetadataHandler(secretGetter, options))},
+		{Path: "/served", Methods: []string{http.MethodGet}, Handler: rateLimited(options.RateLimiter, servedHandler(secretGetter, options))},
+		{Path: "/stats", Methods: []string{http.MethodGet}, Handler: rateLimited(options.RateLimiter, statsHandler(secretGetter, options))},
+		{Path: "/cache/refresh", Methods: []string{http.MethodPost}, Handler: rateLimited(options.RateLimiter, refreshCacheHandler(secretGetter, options))},
+		{Path: "/render", Methods: []string{http.MethodPost}, Handler: rateLimited(options.RateLimiter, renderHandler(secretGetter, options))},
+		{Path: "/watch", Methods: []string{http.MethodGet}, Handler: rateLimited(options.RateLimiter, watchHandler(options))},
+		{Path: "/healthz", Methods: []string{http.MethodGet}, Handler: healthzHandler()},
+		{Path: "/readyz", Methods: []string{http.MethodGet}, Handler: readyzHandler(secretGetter, options)},
+	}
+
+	// Writes are only served when there are keys allowed to write
+	if options.WriteKeys != nil {
+		routes = append(routes, route{Path: "/secrets/", Methods: []string{http.MethodPut, http.MethodDelete}, Handler: rateLimited(options.RateLimiter, secretWritesHandler(secretGetter, options))})
+	}
+
+	// Profiles are only served when some are configured
+	if len(options.Profiles) > 0 {
+		routes = append(routes, route{Path: "/profile/", Methods: []string{http.MethodGet}, Handler: rateLimited(options.RateLimiter, getProfileHandler(secretGetter, options))})
+	}
+
+	// The admission webhook is only served when there is an image to inject
+	if options.Injector != nil {
+		routes = append(routes, route{Path: "/mutate", Methods: []string{http.MethodPost}, Handler: injectorHandler(options.Injector)})
+	}
+
+	// Every route is traced when there is a tracer
+	for i := range routes {
+		routes[i].Handler = traced(options.Tracer, routes[i].Path, routes[i].Handler)
+	}
+
+	// Every route is measured when there are metrics, which are then served too
+	if secretGetter.Metrics != nil {
+		routes = append(routes, route{Path: "/metrics", Methods: []string{http.MethodGet}, Handler: metricsHandler(secretGetter.Metrics)})
+		for i := range routes {
+			routes[i].Handler = measured(secretGetter.Metrics, secretGetter.Clock, routes[i].Path, routes[i].Handler)
+		}
+	}
+	return routes
+}
+
+// newServeMux registers the routes on a new mux, enforcing the allowed methods of every route
+func newServeMux(routes []route) *http.ServeMux {
+	mux := http.NewServeMux()
+	for _, r := range routes {
+		mux.HandleFunc(r.Path, allowMethods(r.Methods, r.Handler))
+	}
+	return mux
+}
+
+// withProtocolChecks makes the handling of old or minimal clients explicit
+// HTTP/1.1 requests without a Host header never get here, net/http answers them with 400
+// HTTP/1.0 requests are served even without a Host header, as it is optional there, and their
+// connection is closed after the response; anything older is answered with 505
+func withProtocolChecks(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, rq *http.Request) {
+		if !rq.ProtoAtLeast(1, 0) {
+			w.WriteHeader(http.StatusHTTPVersionNotSupported)
+			return
+		}
+		if !rq.ProtoAtLeast(1, 1) {
+			w.Header().Set("Connection", "close")
+		}
+		handler.ServeHTTP(w, rq)
+	})
+}
+
+// allowMethods answers 405 with the Allow header for requests with any other method
+func allowMethods(methods []string, handler http.HandlerFunc) http.HandlerFunc {
+	allow := strings.Join(methods, ", ")
+	return func(w http.ResponseWriter, rq *http.Request) {
+		for _, method := range methods {
+			if rq.Method == method {
+				handler(w, rq)
+				return
+			}
+		}
+
+		w.Header().Set("Allow", allow)
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// printRoutes writes a line per route with its path and allowed methods
+func printRoutes(w io.Writer, routes []route) error {
+	for _, r := range routes {
+		_, err := fmt.Fprintf(w, "%s\t%s\n", r.Path, strings.Join(r.Methods, ","))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bufio"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"secret-manager-demo/pkg/secrets"
+)
+
+func TestRoutesAnswerAllowHeader(t *testing.T) {
+	secretGetter := secrets.SecretGetter{Provider: secrets.NewMemoryProvider(nil), Metrics: secrets.NewMetrics()}
+	options := handlerOptions{
+		WriteKeys: NewAllowlist(apiKeys{}),
+		Profiles:  profiles{"app": nil},
+		Injector:  &Injector{},
+	}
+	mux := newServeMux(serverRoutes(secretGetter, options))
+
+	tests := []struct {
+		path          string
+		method        string
+		expectedAllow string
+	}{
+		{path: "/get-secret", method: http.MethodPost, expectedAllow: "GET"},
+		{path: "/get-secrets", method: http.MethodGet, expectedAllow: "POST"},
+		{path: "/secrets", method: http.MethodPost, expectedAllow: "GET"},
+		{path: "/get-secret-versions", method: http.MethodPost, expectedAllow: "GET"},
+		{path: "/get-secret-metadata", method: http.MethodDelete, expectedAllow: "GET"},
+		{path: "/served", method: http.MethodPost, expectedAllow: "GET"},
+		{path: "/stats", method: http.MethodPut, expectedAllow: "GET"},
+		{path: "/cache/refresh", method: http.MethodGet, expectedAllow: "POST"},
+		{path: "/render", method: http.MethodGet, expectedAllow: "POST"},
+		{path: "/watch", method: http.MethodPost, expectedAllow: "GET"},
+		{path: "/healthz", method: http.MethodPost, expectedAllow: "GET"},
+		{path: "/readyz", method: http.MethodPost, expectedAllow: "GET"},
+		{path: "/secrets/db-password", method: http.MethodGet, expectedAllow: "PUT, DELETE"},
+		{path: "/profile/app", method: http.MethodPost, expectedAllow: "GET"},
+		{path: "/mutate", method: http.MethodGet, expectedAllow: "POST"},
+		{path: "/metrics", method: http.MethodPost, expectedAllow: "GET"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.method+" "+test.path, func(t *testing.T) {
+			rs := httptest.NewRecorder()
+			mux.ServeHTTP(rs, httptest.NewRequest(test.method, test.path, nil))
+
+			if rs.Code != http.StatusMethodNotAllowed {
+				t.Errorf("expected status 405, got %d", rs.Code)
+			}
+			if allow := rs.Header().Get("Allow"); allow != test.expectedAllow {
+				t.Errorf("expected Allow %q, got %q", test.expectedAllow, allow)
+			}
+		})
+	}
+}
+
+func TestAllowMethods(t *testing.T) {
+	handler := allowMethods([]string{http.MethodGet, http.MethodHead}, func(w http.ResponseWriter, rq *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})
+
+	tests := []struct {
+		method         string
+		expectedStatus int
+		expectedAllow  string
+	}{
+		{method: http.MethodGet, expectedStatus: http.StatusTeapot},
+		{method: http.MethodHead, expectedStatus: http.StatusTeapot},
+		{method: http.MethodPost, expectedStatus: http.StatusMethodNotAllowed, expectedAllow: "GET, HEAD"},
+		{method: http.MethodOptions, expectedStatus: http.StatusMethodNotAllowed, expectedAllow: "GET, HEAD"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.method, func(t *testing.T) {
+			rs := httptest.NewRecorder()
+			handler(rs, httptest.NewRequest(test.method, "/", nil))
+
+			if rs.Code != test.expectedStatus {
+				t.Errorf("expected status %d, got %d", test.expectedStatus, rs.Code)
+			}
+			if allow := rs.Header().Get("Allow"); allow != test.expectedAllow {
+				t.Errorf("expected Allow %q, got %q", test.expectedAllow, allow)
+			}
+		})
+	}
+}
+
+func TestProtocolHandling(t *testing.T) {
+	secretGetter := secrets.SecretGetter{Provider: secrets.NewMemoryProvider(map[string]string{"db-password": "hunter2"})}
+	server := httptest.NewServer(withProtocolChecks(newServeMux(serverRoutes(secretGetter, handlerOptions{}))))
+	defer server.Close()
+
+	tests := []struct {
+		name            string
+		request         string
+		expectedStatus  int
+		expectedClose   bool
+		expectedContent string
+	}{
+		{
+			name:            "HTTP/1.1 with Host",
+			request:         "GET /get-secret HTTP/1.1\r\nHost: localhost\r\nsecret: db-password\r\n\r\n",
+			expectedStatus:  http.StatusOK,
+			expectedContent: "hunter2",
+		},
+		{
+			name:           "HTTP/1.1 without Host",
+			request:        "GET /get-secret HTTP/1.1\r\nsecret: db-password\r\n\r\n",
+			expectedStatus: http.StatusBadRequest,
+			expectedClose:  true,
+		},
+		{
+			name:            "HTTP/1.0 without Host",
+			request:         "GET /get-secret HTTP/1.0\r\nsecret: db-password\r\n\r\n",
+			expectedStatus:  http.StatusOK,
+			expectedClose:   true,
+			expectedContent: "hunter2",
+		},
+		{
+			name:            "HTTP/1.0 with Host",
+			request:         "GET /get-secret HTTP/1.0\r\nHost: localhost\r\nsecret: db-password\r\n\r\n",
+			expectedStatus:  http.StatusOK,
+			expectedClose:   true,
+			expectedContent: "hunter2",
+		},
+		{
+			name:            "HTTP/1.1 with an odd Host",
+			request:         "GET /get-secret HTTP/1.1\r\nHost: internal-client.local:1234\r\nsecret: db-password\r\n\r\n",
+			expectedStatus:  http.StatusOK,
+			expectedContent: "hunter2",
+		},
+		{
+			name:           "HTTP/1.1 with an invalid Host",
+			request:        "GET /get-secret HTTP/1.1\r\nHost: a b\r\nsecret: db-password\r\n\r\n",
+			expectedStatus: http.StatusBadRequest,
+			expectedClose:  true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			conn, err := net.Dial("tcp", server.Listener.Addr().String())
+			if err != nil {
+				t.Fatalf("connecting: %s", err)
+			}
+			defer conn.Close()
+			_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+			_, err = conn.Write([]byte(test.request))
+			if err != nil {
+				t.Fatalf("writing request: %s", err)
+			}
+			rs, err := http.ReadResponse(bufio.NewReader(conn), nil)
+			if err != nil {
+				t.Fatalf("reading response: %s", err)
+			}
+			defer rs.Body.Close()
+			body, _ := ioutil.ReadAll(rs.Body)
+
+			if rs.StatusCode != test.expectedStatus {
+				t.Errorf("expected status %d, got %d", test.expectedStatus, rs.StatusCode)
+			}
+			if rs.Close != test.expectedClose {
+				t.Errorf("expected connection close %t, got %t", test.expectedClose, rs.Close)
+			}
+			if !strings.Contains(string(body), test.expectedContent) {
+				t.Errorf("expected body with %q, got %q", test.expectedContent, body)
+			}
+		})
+	}
+}
+
+func TestWithProtocolChecksRejectsOldProtocols(t *testing.T) {
+	handler := withProtocolChecks(http.HandlerFunc(func(w http.ResponseWriter, rq *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	rq := httptest.NewRequest(http.MethodGet, "/get-secret", nil)
+	rq.Proto, rq.ProtoMajor, rq.ProtoMinor = "HTTP/0.9", 0, 9
+	rs := httptest.NewRecorder()
+	handler.ServeHTTP(rs, rq)
+
+	if rs.Code != http.StatusHTTPVersionNotSupported {
+		t.Errorf("expected status 505, got %d", rs.Code)
+	}
+}
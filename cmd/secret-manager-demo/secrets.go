@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"secret-manager-demo/pkg/secrets"
+)
+
+// listSecretsHandler lists the secrets the provider can see, passing the pagination through
+// Only names are listed unless ?metadata=true, and with API keys only the secrets the key may read are listed
+func listSecretsHandler(secretGetter secrets.SecretGetter, options handlerOptions) http.HandlerFunc {
+	return func(w http.ResponseWriter, rq *http.Request) {
+		// An unknown key is rejected, a known one only sees what it may read
+		if status := options.authorize(rq, ""); status == http.StatusUnauthorized {
+			w.WriteHeader(status)
+			return
+		}
+
+		var pageSize int
+		if value := rq.URL.Query().Get("pageSize"); value != "" {
+			var err error
+			pageSize, err = strconv.Atoi(value)
+			if err != nil || pageSize < 1 || pageSize > secrets.MaxListPageSize {
+				http.Error(w, fmt.Sprintf("pageSize must be between 1 and %d", secrets.MaxListPageSize), http.StatusBadRequest)
+				return
+			}
+		}
+
+		ctx, cancel := options.requestContext(rq)
+		defer cancel()
+
+		withMetadata := rq.URL.Query().Get("metadata") == "true"
+		page, status := listVisibleSecrets(ctx, secretGetter, options, rq, pageSize, rq.URL.Query().Get("pageToken"), withMetadata)
+		if status != http.StatusOK {
+			w.WriteHeader(status)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, page)
+	}
+}
+
+// listVisibleSecrets lists a page of the secrets the API key of the request may read, without metadata unless asked for
+// The returned status is the one the handler must answer with, the page is only set on 200
+func listVisibleSecrets(ctx context.Context, secretGetter secrets.SecretGetter, options handlerOptions, rq *http.Request, pageSize int, pageToken string, withMetadata bool) (secrets.SecretPage, int) {
+	page, err := secretGetter.ListSecrets(ctx, pageSize, pageToken)
+	switch {
+	case errors.Is(err, secrets.ErrListUnavailable):
+		return secrets.SecretPage{}, http.StatusNotImplemented
+	case err != nil:
+		// Denied listings mean the service account is misconfigured, which is not the client's fault either
+		return secrets.SecretPage{}, http.StatusBadGateway
+	}
+
+	visible := make([]secrets.SecretInfo, 0, len(page.Secrets))
+	for _, secret := range page.Secrets {
+		if options.authorize(rq, secret.Name) != http.StatusOK {
+			continue
+		}
+		if !withMetadata {
+			secret = secrets.SecretInfo{Name: secret.Name}
+		}
+		visible = append(visible, secret)
+	}
+	page.Secrets = visible
+	return page, http.StatusOK
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"net/http"
+
+	"secret-manager-demo/pkg/secrets"
+)
+
+// servedHandler returns the distinct secrets served so far and their sources
+// Only the secrets the caller may read are listed, so names do not leak to callers of other services
+func servedHandler(secretGetter secrets.SecretGetter, options handlerOptions) http.HandlerFunc {
+	return func(w http.ResponseWriter, rq *http.Request) {
+		snapshot := secretGetter.Served.Snapshot()
+		for name := range snapshot {
+			if options.authorize(rq, name) != http.StatusOK {
+				delete(snapshot, name)
+			}
+		}
+
+		writeJSON(w, http.StatusOK, struct {
+			Count   int                         `json:"count"`
+			Secrets map[string][]secrets.Source `json:"secrets"`
+		}{
+			Count:   len(snapshot),
+			Secrets: snapshot,
+		})
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"testing"
+
+	"secret-manager-demo/pkg/secrets"
+)
+
+func TestServedHandlerListsReadableSecrets(t *testing.T) {
+	served := secrets.NewServedSecrets()
+	served.Record("db-password", secrets.SourceCache)
+	served.Record("db-user", secrets.SourceCache)
+	served.Record("api-key", secrets.SourceCache)
+	secretGetter := secrets.SecretGetter{Served: served}
+
+	tests := []struct {
+		name          string
+		options       handlerOptions
+		apiKey        string
+		expectedNames []string
+	}{
+		{name: "without API keys", expectedNames: []string{"api-key", "db-password", "db-user"}},
+		{name: "prefix", options: handlerOptions{APIKeys: NewAllowlist(apiKeys{"key": {"db-*"}})}, apiKey: "key", expectedNames: []string{"db-password", "db-user"}},
+		{name: "unknown key", options: handlerOptions{APIKeys: NewAllowlist(apiKeys{"key": {"db-*"}})}, apiKey: "other", expectedNames: []string{}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rq := httptest.NewRequest(http.MethodGet, "/served", nil)
+			rq.Header.Set(apiKeyHeader, test.apiKey)
+			recorder := httptest.NewRecorder()
+			servedHandler(secretGetter, test.options)(recorder, rq)
+
+			var response struct {
+				Count   int                         `json:"count"`
+				Secrets map[string][]secrets.Source `json:"secrets"`
+			}
+			if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+				t.Fatalf("decoding response: %s", err)
+			}
+			names := []string{}
+			for name := range response.Secrets {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			if !reflect.DeepEqual(names, test.expectedNames) || response.Count != len(test.expectedNames) {
+				t.Errorf("expected %v, got %d: %v", test.expectedNames, response.Count, names)
+			}
+		})
+	}
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"secret-manager-demo/pkg/secrets"
+)
+
+// statsHandler returns the current state of the server, it never includes secret values
+func statsHandler(secretGetter secrets.SecretGetter, options handlerOptions) http.HandlerFunc {
+	return func(w http.ResponseWriter, rq *http.Request) {
+		bytes, err := json.Marshal(struct {
+			Shedding            bool   `json:"shedding"`
+			AverageLatency      string `json:"averageLatency"`
+			BreakerOpen         bool   `json:"breakerOpen"`
+			DroppedAccessEvents uint64 `json:"droppedAccessEvents"`
+			DroppedSpans        uint64 `json:"droppedSpans"`
+		}{
+			Shedding:            secretGetter.Shedder.Shedding(),
+			AverageLatency:      secretGetter.Shedder.Average().String(),
+			BreakerOpen:         secretGetter.Breaker.Open(),
+			DroppedAccessEvents: options.AccessEvents.Dropped(),
+			DroppedSpans:        options.Tracer.Dropped(),
+		})
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write(bytes)
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// Certificate holds the certificate the server presents, which can be reloaded when the files rotate
+type Certificate struct {
+	certFile string
+	keyFile  string
+	mu       sync.RWMutex
+	cert     *tls.Certificate
+	modTime  time.Time
+}
+
+// LoadCertificate reads the certificate and key on the given paths
+func LoadCertificate(certFile string, keyFile string) (*Certificate, error) {
+	c := &Certificate{certFile: certFile, keyFile: keyFile}
+	err := c.reload()
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// GetCertificate returns the current certificate, it is set on tls.Config so every handshake uses the latest one
+func (c *Certificate) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.cert, nil
+}
+
+// Watch polls the files on the given interval and reloads them when either changes, until stop is closed
+// A pair that does not load keeps the previous certificate, as the files are rarely replaced at the same time
+func (c *Certificate) Watch(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			modTime, err := c.lastModified()
+			if err != nil {
+				slog.Error("watching TLS certificate", "path", c.certFile, "error", err)
+				continue
+			}
+
+			c.mu.RLock()
+			changed := !modTime.Equal(c.modTime)
+			c.mu.RUnlock()
+			if !changed {
+				continue
+			}
+
+			err = c.reload()
+			if err != nil {
+				slog.Error("reloading TLS certificate, keeping the previous one", "path", c.certFile, "error", err)
+				continue
+			}
+			slog.Info("reloaded TLS certificate", "path", c.certFile)
+		}
+	}
+}
+
+// lastModified returns the latest modification time of the certificate and the key
+func (c *Certificate) lastModified() (time.Time, error) {
+	var latest time.Time
+	for _, path := range []string{c.certFile, c.keyFile} {
+		info, err := os.Stat(path)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+// reload reads the pair and swaps the certificate, only if the key matches the certificate
+func (c *Certificate) reload() error {
+	modTime, err := c.lastModified()
+	if err != nil {
+		return err
+	}
+
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.cert = &cert
+	c.modTime = modTime
+	return nil
+}
+
+// loadClientCAs reads the PEM bundle of the CAs client certificates must be signed by
+func loadClientCAs(path string) (*x509.CertPool, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(content) {
+		return nil, fmt.Errorf("no certificates found on %s", path)
+	}
+	return pool, nil
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"secret-manager-demo/pkg/secrets"
+)
+
+// versionSecret is the outcome of one of the versions of a multi-version request, the value is only set on 200
+type versionSecret struct {
+	Version string `json:"version"`
+	Status  int    `json:"status"`
+	Value   string `json:"value,omitempty"`
+}
+
+// getSecretVersionsHandler answers several versions of a secret at once, listed comma separated on ?versions=
+// The secret is named like on /get-secret, a request asking for more than MaxVersions versions is a 400
+func getSecretVersionsHandler(secretGetter secrets.SecretGetter, options handlerOptions) http.HandlerFunc {
+	return func(w http.ResponseWriter, rq *http.Request) {
+		secretName, status := requestedSecretName(rq, options)
+		if status != http.StatusOK {
+			w.WriteHeader(status)
+			return
+		}
+
+		versions := strings.Split(rq.URL.Query().Get("versions"), ",")
+		if len(versions) > options.maxVersions() {
+			http.Error(w, fmt.Sprintf("at most %d versions can be asked for at once", options.maxVersions()), http.StatusBadRequest)
+			return
+		}
+		for _, version := range versions {
+			if !secretVersionPattern.MatchString(version) {
+				http.Error(w, fmt.Sprintf("invalid version %q", version), http.StatusBadRequest)
+				return
+			}
+		}
+
+		ctx, cancel := options.requestContext(rq)
+		defer cancel()
+
+		results, statuses := resolveVersions(ctx, secretGetter, options, rq, secretName, versions)
+		answers := make([]versionSecret, len(versions))
+		for i, version := range versions {
+			answers[i] = versionSecret{Version: version, Status: statuses[i]}
+			if statuses[i] == http.StatusOK {
+				answers[i].Value = results[i].Value
+			}
+		}
+
+		writeJSON(w, http.StatusOK, struct {
+			Name     string          `json:"name"`
+			Versions []versionSecret `json:"versions"`
+		}{
+			Name:     secretName,
+			Versions: answers,
+		})
+	}
+}
+
+// resolveVersions resolves every version with up to VersionConcurrency at a time, the results keep the order of the
+// versions, which must be already validated
+func resolveVersions(ctx context.Context, secretGetter secrets.SecretGetter, options handlerOptions, rq *http.Request, secretName string, versions []string) ([]secretResult, []int) {
+	concurrency := options.VersionConcurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	// Every goroutine writes its own slot, so nothing else needs locking
+	results := make([]secretResult, len(versions))
+	statuses := make([]int, len(versions))
+	var wg sync.WaitGroup
+	semaphore := make(chan struct{}, concurrency)
+	for i, version := range versions {
+		wg.Add(1)
+		semaphore <- struct{}{}
+		go func(i int, version string) {
+			defer wg.Done()
+			defer func() { <-semaphore }()
+
+			results[i], statuses[i] = resolveSecretVersion(ctx, secretGetter, options, rq, secretName, version)
+			options.recordAccess(rq, secretGetter.Now(), secretName, version, accessResult(results[i], statuses[i]))
+		}(i, version)
+	}
+	wg.Wait()
+	return results, statuses
+}
+
+// maxVersions returns how many versions a request can ask for, which is defaultMaxVersions unless configured
+func (o handlerOptions) maxVersions() int {
+	if o.MaxVersions < 1 {
+		return defaultMaxVersions
+	}
+	return o.MaxVersions
+}
+
+// defaultMaxVersions is how many versions a request can ask for when MaxVersions is not set
+const defaultMaxVersions = 10
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"secret-manager-demo/pkg/secrets"
+)
+
+// concurrencyProvider serves every version as its number, recording how many versions were fetched at once
+type concurrencyProvider struct {
+	mu      sync.Mutex
+	active  int
+	maximum int
+	calls   atomic.Int32
+}
+
+func (p *concurrencyProvider) GetSecret(ctx context.Context, name string) (string, error) {
+	return p.GetSecretVersion(ctx, name, "latest")
+}
+
+func (p *concurrencyProvider) GetSecretVersion(ctx context.Context, name string, version string) (string, error) {
+	p.calls.Add(1)
+	p.mu.Lock()
+	p.active++
+	p.maximum = max(p.maximum, p.active)
+	p.mu.Unlock()
+
+	time.Sleep(5 * time.Millisecond)
+
+	p.mu.Lock()
+	p.active--
+	p.mu.Unlock()
+	return "value-" + version, nil
+}
+
+func TestGetSecretVersionsHandler(t *testing.T) {
+	tests := []struct {
+		name             string
+		query            string
+		maxVersions      int
+		expectedStatus   int
+		expectedVersions []versionSecret
+	}{
+		{
+			name:           "every version",
+			query:          "versions=1,2,latest",
+			expectedStatus: http.StatusOK,
+			expectedVersions: []versionSecret{
+				{Version: "1", Status: http.StatusOK, Value: "first"},
+				{Version: "2", Status: http.StatusOK, Value: "second"},
+				{Version: "latest", Status: http.StatusOK, Value: "second"},
+			},
+		},
+		{
+			name:           "missing version",
+			query:          "versions=1,7",
+			expectedStatus: http.StatusOK,
+			expectedVersions: []versionSecret{
+				{Version: "1", Status: http.StatusOK, Value: "first"},
+				{Version: "7", Status: http.StatusNotFound},
+			},
+		},
+		{name: "at the cap", query: "versions=1,2", maxVersions: 2, expectedStatus: http.StatusOK, expectedVersions: []versionSecret{
+			{Version: "1", Status: http.StatusOK, Value: "first"},
+			{Version: "2", Status: http.StatusOK, Value: "second"},
+		}},
+		{name: "above the cap", query: "versions=1,2,latest", maxVersions: 2, expectedStatus: http.StatusBadRequest},
+		{name: "above the default cap", query: "versions=" + strings.Repeat("1,", defaultMaxVersions) + "1", expectedStatus: http.StatusBadRequest},
+		{name: "no versions", query: "", expectedStatus: http.StatusBadRequest},
+		{name: "empty version", query: "versions=1,,2", expectedStatus: http.StatusBadRequest},
+		{name: "invalid version", query: "versions=1,a/b", expectedStatus: http.StatusBadRequest},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			provider := secrets.NewMemoryProvider(map[string]string{"db-password": "first"})
+			_, _, err := provider.PutSecret(context.Background(), "db-password", "second")
+			if err != nil {
+				t.Fatalf("adding version: %s", err)
+			}
+			handler := getSecretVersionsHandler(secrets.SecretGetter{Provider: provider}, handlerOptions{MaxVersions: test.maxVersions})
+
+			rq := httptest.NewRequest(http.MethodGet, "/get-secret-versions?"+test.query, nil)
+			rq.Header.Set("secret", "db-password")
+			rs := httptest.NewRecorder()
+			handler(rs, rq)
+
+			if rs.Code != test.expectedStatus {
+				t.Fatalf("expected status %d, got %d", test.expectedStatus, rs.Code)
+			}
+			if rs.Code != http.StatusOK {
+				return
+			}
+			var response struct {
+				Name     string          `json:"name"`
+				Versions []versionSecret `json:"versions"`
+			}
+			if err := json.Unmarshal(rs.Body.Bytes(), &response); err != nil {
+				t.Fatalf("decoding response: %s", err)
+			}
+			if len(response.Versions) != len(test.expectedVersions) {
+				t.Fatalf("expected %d versions, got %d", len(test.expectedVersions), len(response.Versions))
+			}
+			for i, version := range response.Versions {
+				if version != test.expectedVersions[i] {
+					t.Errorf("expected %+v, got %+v", test.expectedVersions[i], version)
+				}
+			}
+		})
+	}
+}
+
+func TestGetSecretVersionsHandlerBoundsConcurrency(t *testing.T) {
+	provider := &concurrencyProvider{}
+	options := handlerOptions{MaxVersions: 8, VersionConcurrency: 3}
+	handler := getSecretVersionsHandler(secrets.SecretGetter{Provider: provider}, options)
+
+	rq := httptest.NewRequest(http.MethodGet, "/get-secret-versions?versions=1,2,3,4,5,6,7,8", nil)
+	rq.Header.Set("secret", "db-password")
+	rs := httptest.NewRecorder()
+	handler(rs, rq)
+
+	if rs.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", rs.Code)
+	}
+	if provider.calls.Load() != 8 {
+		t.Errorf("expected 8 calls to the provider, got %d", provider.calls.Load())
+	}
+	if provider.maximum > options.VersionConcurrency {
+		t.Errorf("expected at most %d versions fetched at once, got %d", options.VersionConcurrency, provider.maximum)
+	}
+}
+
+func TestGetSecretVersionsHandlerWithoutVersions(t *testing.T) {
+	handler := getSecretVersionsHandler(secrets.SecretGetter{Provider: &countingProvider{}}, handlerOptions{})
+
+	rq := httptest.NewRequest(http.MethodGet, "/get-secret-versions?versions=1", nil)
+	rq.Header.Set("secret", "db-password")
+	rs := httptest.NewRecorder()
+	handler(rs, rq)
+
+	var response struct {
+		Versions []versionSecret `json:"versions"`
+	}
+	if err := json.Unmarshal(rs.Body.Bytes(), &response); err != nil {
+		t.Fatalf("decoding response: %s", err)
+	}
+	if len(response.Versions) != 1 || response.Versions[0].Status != http.StatusNotImplemented {
+		t.Errorf("expected a 501 for the version, got %+v", response.Versions)
+	}
+}
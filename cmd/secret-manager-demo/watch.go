@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"secret-manager-demo/pkg/secrets"
+)
+
+// watchHandler streams the changes of the secret named on the query as server-sent events, so clients reload
+// their credentials when it rotates instead of polling its value
+// Every change is a "change" event with the name and the new version, never the value, and keepalive comments
+// are sent while idle so proxies do not close the stream and clients can tell a dead connection
+func watchHandler(options handlerOptions) http.HandlerFunc {
+	return func(w http.ResponseWriter, rq *http.Request) {
+		secretName := rq.URL.Query().Get("secret")
+		if !secretNamePattern.MatchString(secretName) {
+			http.Error(w, "invalid secret name", http.StatusBadRequest)
+			return
+		}
+
+		// Make sure the caller is allowed to read the secret, as rotations tell when it changes
+		lookupName := options.NameCase.normalize(secretName)
+		if status := options.authorize(rq, lookupName); status != http.StatusOK {
+			w.WriteHeader(status)
+			return
+		}
+
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+			return
+		}
+
+		subscribeCtx, cancel := options.requestContext(rq)
+		changes, unsubscribe, err := options.Rotations.Subscribe(subscribeCtx, lookupName)
+		cancel()
+		if errors.Is(err, secrets.ErrTooManyWatched) {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		defer unsubscribe()
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		// Buffering proxies like nginx would hold the events back
+		w.Header().Set("X-Accel-Buffering", "no")
+		w.WriteHeader(http.StatusOK)
+		_, _ = fmt.Fprintf(w, ": watching %s\n\n", secretName)
+		flusher.Flush()
+
+		keepalive := options.WatchKeepalive
+		if keepalive <= 0 {
+			keepalive = 15 * time.Second
+		}
+		ticker := time.NewTicker(keepalive)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-rq.Context().Done():
+				return
+			case <-ticker.C:
+				_, err = fmt.Fprint(w, ":keepalive\n\n")
+			case change := <-changes:
+				change.Name = secretName
+				var data []byte
+				data, err = json.Marshal(change)
+				if err == nil {
+					_, err = fmt.Fprintf(w, "event: change\ndata: %s\n\n", data)
+				}
+			}
+			if err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
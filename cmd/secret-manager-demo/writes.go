@@ -0,0 +1,136 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"secret-manager-demo/pkg/secrets"
+)
+
+// secretWritesHandler serves the writes under /secrets/, which share the path
+func secretWritesHandler(secretGetter secrets.SecretGetter, options handlerOptions) http.HandlerFunc {
+	put := putSecretHandler(secretGetter, options)
+	revoke := revokeVersionHandler(secretGetter, options)
+	return func(w http.ResponseWriter, rq *http.Request) {
+		if rq.Method == http.MethodDelete {
+			revoke(w, rq)
+			return
+		}
+		put(w, rq)
+	}
+}
+
+// putSecretHandler adds a version with the body to the secret on the path, creating the secret when missing
+// Every write needs an API key allowed to write the secret, the handler is only registered when there are write keys
+// Every write of a valid name goes to the audit log, denied ones too
+func putSecretHandler(secretGetter secrets.SecretGetter, options handlerOptions) http.HandlerFunc {
+	return func(w http.ResponseWriter, rq *http.Request) {
+		secretName := strings.TrimPrefix(rq.URL.Path, "/secrets/")
+		if !secretNamePattern.MatchString(secretName) {
+			http.Error(w, fmt.Sprintf("invalid secret name %q", secretName), http.StatusBadRequest)
+			return
+		}
+
+		// Make sure the caller is allowed to write the secret
+		lookupName := options.NameCase.normalize(secretName)
+		if status := options.WriteKeys.authorize(rq, lookupName); status != http.StatusOK {
+			options.recordWrite(rq, secretGetter.Now(), "put", secretName, "", status)
+			w.WriteHeader(status)
+			return
+		}
+
+		value, err := ioutil.ReadAll(http.MaxBytesReader(w, rq.Body, secrets.MaxSecretSize))
+		if err != nil {
+			options.recordWrite(rq, secretGetter.Now(), "put", secretName, "", http.StatusRequestEntityTooLarge)
+			http.Error(w, fmt.Sprintf("the value must be at most %d bytes", secrets.MaxSecretSize), http.StatusRequestEntityTooLarge)
+			return
+		}
+
+		ctx, cancel := options.requestContext(rq)
+		defer cancel()
+
+		version, created, err := secretGetter.PutSecret(ctx, lookupName, string(value))
+		switch {
+		case errors.Is(err, secrets.ErrWritesUnavailable):
+			options.recordWrite(rq, secretGetter.Now(), "put", secretName, "", http.StatusNotImplemented)
+			w.WriteHeader(http.StatusNotImplemented)
+			return
+		case err != nil:
+			// Denied writes mean the service account is misconfigured, which is not the client's fault
+			slog.Error("writing secret", "name", lookupName, "error", err)
+			options.recordWrite(rq, secretGetter.Now(), "put", secretName, "", http.StatusBadGateway)
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+
+		status := http.StatusOK
+		if created {
+			status = http.StatusCreated
+		}
+		options.recordWrite(rq, secretGetter.Now(), "put", secretName, version, status)
+		writeJSON(w, status, struct {
+			Name    string `json:"name"`
+			Version string `json:"version"`
+		}{
+			Name:    secretName,
+			Version: version,
+		})
+	}
+}
+
+// revokeVersionHandler disables the version on /secrets/{name}/versions/{version}, or destroys it with ?action=destroy
+// Disabling is the default as it can be undone, a leaked version can then be destroyed once nothing reads it
+func revokeVersionHandler(secretGetter secrets.SecretGetter, options handlerOptions) http.HandlerFunc {
+	return func(w http.ResponseWriter, rq *http.Request) {
+		parts := strings.Split(strings.TrimPrefix(rq.URL.Path, "/secrets/"), "/")
+		if len(parts) != 3 || parts[1] != "versions" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		secretName, version := parts[0], parts[2]
+		if !secretNamePattern.MatchString(secretName) || !secretVersionPattern.MatchString(version) || version == "latest" {
+			http.Error(w, "expected a secret name and a version number", http.StatusBadRequest)
+			return
+		}
+
+		action := rq.URL.Query().Get("action")
+		switch action {
+		case "":
+			action = "disable"
+		case "disable", "destroy":
+		default:
+			http.Error(w, fmt.Sprintf("invalid action %q, expected disable or destroy", action), http.StatusBadRequest)
+			return
+		}
+
+		// Make sure the caller is allowed to write the secret
+		lookupName := options.NameCase.normalize(secretName)
+		if status := options.WriteKeys.authorize(rq, lookupName); status != http.StatusOK {
+			options.recordWrite(rq, secretGetter.Now(), action, secretName, version, status)
+			w.WriteHeader(status)
+			return
+		}
+
+		ctx, cancel := options.requestContext(rq)
+		defer cancel()
+
+		status := http.StatusNoContent
+		err := secretGetter.RevokeVersion(ctx, lookupName, version, action == "destroy")
+		switch {
+		case errors.Is(err, secrets.ErrWritesUnavailable):
+			status = http.StatusNotImplemented
+		case errors.Is(err, secrets.ErrSecretNotFound):
+			status = http.StatusNotFound
+		case err != nil:
+			slog.Error("revoking secret version", "name", lookupName, "version", version, "error", err)
+			status = http.StatusBadGateway
+		}
+
+		options.recordWrite(rq, secretGetter.Now(), action, secretName, version, status)
+		w.WriteHeader(status)
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"secret-manager-demo/pkg/secrets"
+)
+
+func TestSecretWritesAreAudited(t *testing.T) {
+	tests := []struct {
+		name            string
+		method          string
+		path            string
+		apiKey          string
+		expectedStatus  int
+		expectedRecord  AuditRecord
+		expectedNoAudit bool
+	}{
+		{
+			name:           "put",
+			method:         http.MethodPut,
+			path:           "/secrets/db-password",
+			apiKey:         "writer",
+			expectedStatus: http.StatusOK,
+			expectedRecord: AuditRecord{Name: "db-password", Version: "2", Action: "put", Result: "ok"},
+		},
+		{
+			name:           "denied put",
+			method:         http.MethodPut,
+			path:           "/secrets/api-key",
+			apiKey:         "writer",
+			expectedStatus: http.StatusForbidden,
+			expectedRecord: AuditRecord{Name: "api-key", Action: "put", Result: "forbidden"},
+		},
+		{
+			name:           "disable",
+			method:         http.MethodDelete,
+			path:           "/secrets/db-password/versions/1",
+			apiKey:         "writer",
+			expectedStatus: http.StatusNoContent,
+			expectedRecord: AuditRecord{Name: "db-password", Version: "1", Action: "disable", Result: "ok"},
+		},
+		{
+			name:           "destroy of a missing version",
+			method:         http.MethodDelete,
+			path:           "/secrets/db-missing/versions/1?action=destroy",
+			apiKey:         "writer",
+			expectedStatus: http.StatusNotFound,
+			expectedRecord: AuditRecord{Name: "db-missing", Version: "1", Action: "destroy", Result: "not-found"},
+		},
+		{
+			name:            "invalid name",
+			method:          http.MethodPut,
+			path:            "/secrets/db password",
+			apiKey:          "writer",
+			expectedStatus:  http.StatusBadRequest,
+			expectedNoAudit: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var audit bytes.Buffer
+			secretGetter := secrets.SecretGetter{Provider: secrets.NewMemoryProvider(map[string]string{"db-password": "hunter2"})}
+			options := handlerOptions{WriteKeys: NewAllowlist(apiKeys{"writer": {"db-*"}}), Audit: &AuditLog{w: &audit}}
+
+			rq := httptest.NewRequest(test.method, "/", strings.NewReader("rotated"))
+			rq.URL.Path, rq.URL.RawQuery, _ = strings.Cut(test.path, "?")
+			rq.Header.Set(apiKeyHeader, test.apiKey)
+			recorder := httptest.NewRecorder()
+			secretWritesHandler(secretGetter, options)(recorder, rq)
+			if recorder.Code != test.expectedStatus {
+				t.Errorf("expected %d, got %d", test.expectedStatus, recorder.Code)
+			}
+
+			if test.expectedNoAudit {
+				if audit.Len() > 0 {
+					t.Errorf("expected no audit record, got %s", audit.String())
+				}
+				return
+			}
+			var record AuditRecord
+			if err := json.Unmarshal(audit.Bytes(), &record); err != nil {
+				t.Fatalf("decoding audit record %q: %s", audit.String(), err)
+			}
+			if record.Caller == "" || record.Caller == "anonymous" {
+				t.Errorf("expected the caller, got %q", record.Caller)
+			}
+			record.Time, record.Caller, record.Client = test.expectedRecord.Time, "", ""
+			if record != test.expectedRecord {
+				t.Errorf("expected %+v, got %+v", test.expectedRecord, record)
+			}
+		})
+	}
+}
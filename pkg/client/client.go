@@ -0,0 +1,291 @@
+// Package client calls the HTTP API of the server, so Go services get secrets without building the requests themselves
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MaxBatchSize is how many secrets the server takes on a single batch request, larger batches are split
+const MaxBatchSize = 100
+
+// maxResponseSize bounds the bodies read from the server
+const maxResponseSize = 1 << 20
+
+// Errors the answers of the server are mapped to, which can be told apart with errors.Is
+var (
+	// ErrNotFound is returned when the backend does not have the secret and the server has no fallback for it
+	ErrNotFound = errors.New("secret not found")
+	// ErrNotConfigured is returned when the server runs without a backend and the secret is not set on it
+	ErrNotConfigured = errors.New("secret not configured")
+	// ErrUnauthorized is returned when the request has no valid API key or token
+	ErrUnauthorized = errors.New("unauthorized")
+	// ErrForbidden is returned when the API key or token may not read the secret
+	ErrForbidden = errors.New("forbidden")
+	// ErrUnavailable is returned when the server or its backend could not answer, after the retries
+	ErrUnavailable = errors.New("secret unavailable")
+)
+
+// StatusError is the answer of the server to a secret that was not served, it wraps the error of its status code
+type StatusError struct {
+	Name       string
+	StatusCode int
+	err        error
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("secret %s: %s (%d)", e.Name, e.err, e.StatusCode)
+}
+
+func (e *StatusError) Unwrap() error {
+	return e.err
+}
+
+// newStatusError maps the status code the server answered for the secret
+func newStatusError(name string, statusCode int) *StatusError {
+	var err error
+	switch statusCode {
+	case http.StatusNotFound:
+		err = ErrNotFound
+	case http.StatusUnauthorized:
+		err = ErrUnauthorized
+	case http.StatusForbidden:
+		err = ErrForbidden
+	case http.StatusTooManyRequests, http.StatusServiceUnavailable, http.StatusGatewayTimeout, http.StatusBadGateway:
+		err = ErrUnavailable
+	default:
+		err = errors.New(strings.ToLower(http.StatusText(statusCode)))
+	}
+	return &StatusError{Name: name, StatusCode: statusCode, err: err}
+}
+
+// Secret is a secret served by the server
+// IsFallback is only told by servers answering the v2 responses, it is false otherwise
+type Secret struct {
+	Name       string
+	Value      string
+	Version    string
+	IsFallback bool
+	// Err is set instead of the value for the secrets of a batch that were not served
+	Err error
+}
+
+// Client calls the server on BaseURL, retrying the requests the server could not answer for a while
+type Client struct {
+	BaseURL string
+	// HTTPClient sends the requests, http.DefaultClient is used when nil
+	HTTPClient *http.Client
+	// APIKey is sent on the X-API-Key header, Token as a bearer token, both are optional
+	APIKey string
+	Token  string
+	// Attempts is how many times a request is sent, at least once
+	Attempts int
+	// BaseDelay is the delay before the first retry, doubled on every retry up to MaxDelay, which also bounds the
+	// waits the server asks for with Retry-After
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+}
+
+// New returns a client of the server on the base URL, like http://localhost:8080, retrying 3 times
+func New(baseURL string) *Client {
+	return &Client{
+		BaseURL:   strings.TrimSuffix(baseURL, "/"),
+		Attempts:  3,
+		BaseDelay: 100 * time.Millisecond,
+		MaxDelay:  2 * time.Second,
+	}
+}
+
+// Get gets the value of the secret
+func (c *Client) Get(ctx context.Context, name string) (string, error) {
+	secret, err := c.GetSecret(ctx, name, "")
+	if err != nil {
+		return "", err
+	}
+	return secret.Value, nil
+}
+
+// GetSecret gets a version of the secret, the latest one when version is empty
+func (c *Client) GetSecret(ctx context.Context, name string, version string) (Secret, error) {
+	query := url.Values{}
+	query.Set("name", name)
+	if version != "" {
+		query.Set("version", version)
+	}
+
+	secretResponse := struct {
+		Name       string `json:"name"`
+		Value      string `json:"value"`
+		Version    string `json:"version"`
+		IsFallback bool   `json:"isFallback"`
+		Configured *bool  `json:"configured"`
+	}{}
+	err := c.do(ctx, name, http.MethodGet, "/get-secret?"+query.Encode(), nil, &secretResponse)
+	if err != nil {
+		return Secret{}, err
+	}
+	// Servers running without a backend may answer unconfigured secrets with 200, telling them apart on the body
+	if secretResponse.Configured != nil && !*secretResponse.Configured {
+		return Secret{}, &StatusError{Name: name, StatusCode: http.StatusOK, err: ErrNotConfigured}
+	}
+	return Secret{Name: name, Value: secretResponse.Value, Version: secretResponse.Version, IsFallback: secretResponse.IsFallback}, nil
+}
+
+// GetMany gets the secrets in batches of MaxBatchSize, keeping the order of the names
+// Secrets that were not served have their Err set, the error is only returned when a batch could not be sent
+func (c *Client) GetMany(ctx context.Context, names []string) ([]Secret, error) {
+	secrets := make([]Secret, 0, len(names))
+	for start := 0; start < len(names); start += MaxBatchSize {
+		batch := names[start:min(start+MaxBatchSize, len(names))]
+
+		batchResponse := struct {
+			Secrets []struct {
+				Name       string `json:"name"`
+				Status     int    `json:"status"`
+				Value      string `json:"value"`
+				Version    string `json:"version"`
+				IsFallback *bool  `json:"isFallback"`
+				Configured *bool  `json:"configured"`
+			} `json:"secrets"`
+		}{}
+		err := c.do(ctx, "", http.MethodPost, "/get-secrets", batch, &batchResponse)
+		if err != nil {
+			return nil, err
+		}
+		if len(batchResponse.Secrets) != len(batch) {
+			return nil, fmt.Errorf("the server answered %d secrets for a batch of %d", len(batchResponse.Secrets), len(batch))
+		}
+
+		for _, answer := range batchResponse.Secrets {
+			secret := Secret{Name: answer.Name, Value: answer.Value, Version: answer.Version, IsFallback: answer.IsFallback != nil && *answer.IsFallback}
+			switch {
+			case answer.Configured != nil && !*answer.Configured:
+				secret.Err = &StatusError{Name: answer.Name, StatusCode: answer.Status, err: ErrNotConfigured}
+			case answer.Status != http.StatusOK:
+				secret.Err = newStatusError(answer.Name, answer.Status)
+			}
+			secrets = append(secrets, secret)
+		}
+	}
+	return secrets, nil
+}
+
+// do sends the request, retrying it while the server answers it is unavailable or cannot be reached, and decodes the
+// answer into the value
+func (c *Client) do(ctx context.Context, name string, method string, path string, body interface{}, value interface{}) error {
+	var content []byte
+	if body != nil {
+		var err error
+		content, err = json.Marshal(body)
+		if err != nil {
+			return err
+		}
+	}
+
+	attempts := max(c.Attempts, 1)
+	var err error
+	for attempt := 0; attempt < attempts; attempt++ {
+		var retryAfter time.Duration
+		retryAfter, err = c.send(ctx, name, method, path, content, value)
+		if err == nil || !retryable(err) || attempt == attempts-1 {
+			return err
+		}
+
+		// The wait the server asked for is bounded by MaxDelay too, and there is no point waiting past the deadline
+		delay := max(c.delay(attempt), retryAfter)
+		if c.MaxDelay > 0 {
+			delay = min(delay, c.MaxDelay)
+		}
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < delay {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+	return err
+}
+
+// send sends the request once, returning the wait the server asked for with Retry-After when it answered 429 or 503
+func (c *Client) send(ctx context.Context, name string, method string, path string, content []byte, value interface{}) (time.Duration, error) {
+	rq, err := http.NewRequestWithContext(ctx, method, c.BaseURL+path, bytes.NewReader(content))
+	if err != nil {
+		return 0, err
+	}
+	if content != nil {
+		rq.Header.Set("Content-Type", "application/json")
+	}
+	if c.APIKey != "" {
+		rq.Header.Set("X-API-Key", c.APIKey)
+	}
+	if c.Token != "" {
+		rq.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	rs, err := httpClient.Do(rq)
+	if err != nil {
+		return 0, err
+	}
+	defer rs.Body.Close()
+	responseBody, err := io.ReadAll(io.LimitReader(rs.Body, maxResponseSize))
+	if err != nil {
+		return 0, err
+	}
+
+	// Unconfigured secrets may come with an error status and the body telling them apart
+	if rs.StatusCode != http.StatusOK && !notConfigured(responseBody) {
+		seconds, _ := strconv.Atoi(rs.Header.Get("Retry-After"))
+		return time.Duration(seconds) * time.Second, newStatusError(name, rs.StatusCode)
+	}
+	return 0, json.Unmarshal(responseBody, value)
+}
+
+// notConfigured tells if the body of an error answer says the secret is not configured on the server
+func notConfigured(body []byte) bool {
+	errorResponse := struct {
+		Configured *bool `json:"configured"`
+	}{}
+	err := json.Unmarshal(body, &errorResponse)
+	return err == nil && errorResponse.Configured != nil && !*errorResponse.Configured
+}
+
+// retryable tells if the request may succeed if sent again, which is when the server could not be reached or said
+// it was unavailable
+func retryable(err error) bool {
+	var statusError *StatusError
+	if errors.As(err, &statusError) {
+		return statusError.StatusCode == http.StatusTooManyRequests || statusError.StatusCode == http.StatusServiceUnavailable ||
+			statusError.StatusCode == http.StatusGatewayTimeout
+	}
+	var urlError *url.Error
+	return errors.As(err, &urlError) && !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded)
+}
+
+// delay returns the wait before the retry after the attempt, doubling the base delay up to MaxDelay with jitter so
+// clients restarted together do not retry together
+func (c *Client) delay(attempt int) time.Duration {
+	backoff := c.BaseDelay << attempt
+	if c.MaxDelay > 0 && (backoff > c.MaxDelay || backoff <= 0) {
+		backoff = c.MaxDelay
+	}
+	if backoff <= 0 {
+		return 0
+	}
+	return backoff/2 + rand.N(backoff/2+1)
+}
@@ -0,0 +1,221 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newTestClient returns a client of a server answering with the handler, retrying without waiting long
+func newTestClient(t *testing.T, handler http.HandlerFunc) *Client {
+	t.Helper()
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	c := New(server.URL)
+	c.BaseDelay = time.Millisecond
+	c.MaxDelay = 10 * time.Millisecond
+	return c
+}
+
+func TestGetMapsAnswers(t *testing.T) {
+	tests := []struct {
+		name          string
+		status        int
+		body          string
+		expectedValue string
+		expectedErr   error
+	}{
+		{name: "found", status: http.StatusOK, body: `{"name":"db-password","value":"hunter2"}`, expectedValue: "hunter2"},
+		{name: "not found", status: http.StatusNotFound, expectedErr: ErrNotFound},
+		{name: "unauthorized", status: http.StatusUnauthorized, expectedErr: ErrUnauthorized},
+		{name: "forbidden", status: http.StatusForbidden, expectedErr: ErrForbidden},
+		{name: "bad gateway", status: http.StatusBadGateway, expectedErr: ErrUnavailable},
+		{name: "not configured", status: http.StatusOK, body: `{"name":"db-password","configured":false}`, expectedErr: ErrNotConfigured},
+		{name: "not configured with a status", status: http.StatusNotFound, body: `{"name":"db-password","configured":false}`, expectedErr: ErrNotConfigured},
+		{name: "configured elsewhere in the body", status: http.StatusNotFound, body: `{"detail":{"configured":false}}`, expectedErr: ErrNotFound},
+		{name: "configured as text", status: http.StatusNotFound, body: `"configured":false`, expectedErr: ErrNotFound},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := newTestClient(t, func(w http.ResponseWriter, rq *http.Request) {
+				w.WriteHeader(test.status)
+				_, _ = w.Write([]byte(test.body))
+			})
+
+			value, err := c.Get(context.Background(), "db-password")
+			if test.expectedErr != nil {
+				if !errors.Is(err, test.expectedErr) {
+					t.Errorf("expected %v, got %q (%v)", test.expectedErr, value, err)
+				}
+				return
+			}
+			if err != nil || value != test.expectedValue {
+				t.Errorf("expected %q, got %q (%v)", test.expectedValue, value, err)
+			}
+		})
+	}
+}
+
+func TestGetSendsTheRequest(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, rq *http.Request) {
+		if rq.URL.Path != "/get-secret" || rq.URL.Query().Get("name") != "db-password" || rq.URL.Query().Get("version") != "2" {
+			t.Errorf("unexpected request %s", rq.URL)
+		}
+		if rq.Header.Get("X-API-Key") != "key" || rq.Header.Get("Authorization") != "Bearer token" {
+			t.Errorf("expected the credentials, got %v", rq.Header)
+		}
+		_, _ = w.Write([]byte(`{"name":"db-password","value":"hunter2","version":"2","isFallback":true}`))
+	})
+	c.APIKey = "key"
+	c.Token = "token"
+
+	secret, err := c.GetSecret(context.Background(), "db-password", "2")
+	if err != nil {
+		t.Fatalf("getting secret: %s", err)
+	}
+	if secret.Value != "hunter2" || secret.Version != "2" || !secret.IsFallback {
+		t.Errorf("expected version 2 of the fallback, got %+v", secret)
+	}
+}
+
+func TestRetries(t *testing.T) {
+	tests := []struct {
+		name          string
+		statuses      []int
+		attempts      int
+		expectedCalls int32
+		expectedErr   error
+	}{
+		{name: "unavailable then served", statuses: []int{http.StatusServiceUnavailable, http.StatusTooManyRequests, http.StatusOK}, attempts: 3, expectedCalls: 3},
+		{name: "unavailable every time", statuses: []int{http.StatusServiceUnavailable, http.StatusServiceUnavailable}, attempts: 2, expectedCalls: 2, expectedErr: ErrUnavailable},
+		{name: "not found is not retried", statuses: []int{http.StatusNotFound, http.StatusOK}, attempts: 3, expectedCalls: 1, expectedErr: ErrNotFound},
+		{name: "bad gateway is not retried", statuses: []int{http.StatusBadGateway, http.StatusOK}, attempts: 3, expectedCalls: 1, expectedErr: ErrUnavailable},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var calls atomic.Int32
+			c := newTestClient(t, func(w http.ResponseWriter, rq *http.Request) {
+				call := calls.Add(1)
+				w.WriteHeader(test.statuses[min(int(call), len(test.statuses))-1])
+				_, _ = w.Write([]byte(`{"name":"db-password","value":"hunter2"}`))
+			})
+			c.Attempts = test.attempts
+
+			_, err := c.Get(context.Background(), "db-password")
+			if test.expectedErr == nil && err != nil {
+				t.Errorf("expected the secret, got %v", err)
+			}
+			if test.expectedErr != nil && !errors.Is(err, test.expectedErr) {
+				t.Errorf("expected %v, got %v", test.expectedErr, err)
+			}
+			if calls.Load() != test.expectedCalls {
+				t.Errorf("expected %d calls, got %d", test.expectedCalls, calls.Load())
+			}
+		})
+	}
+}
+
+func TestRetryAfterIsBounded(t *testing.T) {
+	tests := []struct {
+		name        string
+		maxDelay    time.Duration
+		timeout     time.Duration
+		expectedErr error
+	}{
+		{name: "by MaxDelay", maxDelay: 10 * time.Millisecond, timeout: time.Minute},
+		{name: "by the deadline", timeout: 100 * time.Millisecond, expectedErr: ErrUnavailable},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var calls atomic.Int32
+			c := newTestClient(t, func(w http.ResponseWriter, rq *http.Request) {
+				if calls.Add(1) == 1 {
+					w.Header().Set("Retry-After", "3600")
+					w.WriteHeader(http.StatusServiceUnavailable)
+					return
+				}
+				_, _ = w.Write([]byte(`{"name":"db-password","value":"hunter2"}`))
+			})
+			c.MaxDelay = test.maxDelay
+
+			ctx, cancel := context.WithTimeout(context.Background(), test.timeout)
+			defer cancel()
+			start := time.Now()
+			_, err := c.Get(ctx, "db-password")
+			if elapsed := time.Since(start); elapsed > 5*time.Second {
+				t.Errorf("expected the wait to be bounded, took %s", elapsed)
+			}
+			if test.expectedErr == nil && err != nil {
+				t.Errorf("expected the secret, got %v", err)
+			}
+			// Giving up before the deadline returns the answer of the server, not the deadline
+			if test.expectedErr != nil && !errors.Is(err, test.expectedErr) {
+				t.Errorf("expected %v, got %v", test.expectedErr, err)
+			}
+		})
+	}
+}
+
+func TestGetManySplitsBatches(t *testing.T) {
+	var batches atomic.Int32
+	c := newTestClient(t, func(w http.ResponseWriter, rq *http.Request) {
+		batches.Add(1)
+		var names []string
+		if err := json.NewDecoder(rq.Body).Decode(&names); err != nil || len(names) > MaxBatchSize {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		type answer struct {
+			Name       string `json:"name"`
+			Status     int    `json:"status"`
+			Value      string `json:"value,omitempty"`
+			Configured *bool  `json:"configured,omitempty"`
+		}
+		answers := make([]answer, len(names))
+		for i, name := range names {
+			switch name {
+			case "missing":
+				answers[i] = answer{Name: name, Status: http.StatusNotFound}
+			case "unset":
+				configured := false
+				answers[i] = answer{Name: name, Status: http.StatusOK, Configured: &configured}
+			default:
+				answers[i] = answer{Name: name, Status: http.StatusOK, Value: "value-of-" + name}
+			}
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"secrets": answers})
+	})
+
+	names := []string{"missing", "unset"}
+	for i := 0; i < MaxBatchSize; i++ {
+		names = append(names, fmt.Sprintf("secret-%d", i))
+	}
+	secrets, err := c.GetMany(context.Background(), names)
+	if err != nil {
+		t.Fatalf("getting secrets: %s", err)
+	}
+	if batches.Load() != 2 {
+		t.Errorf("expected 2 batches, got %d", batches.Load())
+	}
+	if len(secrets) != len(names) {
+		t.Fatalf("expected %d secrets, got %d", len(names), len(secrets))
+	}
+	if !errors.Is(secrets[0].Err, ErrNotFound) || !errors.Is(secrets[1].Err, ErrNotConfigured) {
+		t.Errorf("expected not found and not configured, got %v and %v", secrets[0].Err, secrets[1].Err)
+	}
+	for i, secret := range secrets[2:] {
+		if secret.Name != names[i+2] || secret.Value != "value-of-"+names[i+2] || secret.Err != nil {
+			t.Errorf("expected %s in order, got %+v", names[i+2], secret)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package secrets
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+)
+
+// ageIdentitiesFromEnv reads the X25519 identities of SOPS_AGE_KEY and the file of SOPS_AGE_KEY_FILE, as SOPS does
+func ageIdentitiesFromEnv() ([]age.Identity, error) {
+	keys := GetEnv("SOPS_AGE_KEY", "")
+	if path := GetEnv("SOPS_AGE_KEY_FILE", ""); path != "" {
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("reading age keys: %w", err)
+		}
+		keys += "\n" + string(content)
+	}
+
+	identities, err := parseAgeIdentities(keys)
+	if err != nil {
+		return nil, err
+	}
+	if len(identities) == 0 {
+		return nil, errors.New("SOPS_AGE_KEY or SOPS_AGE_KEY_FILE is required for age keys")
+	}
+	return identities, nil
+}
+
+// parseAgeIdentities parses the AGE-SECRET-KEY-1 lines of an identity file, skipping blank lines and comments
+// Keys with nothing but comments are no identities rather than an error, so the caller tells what is missing
+func parseAgeIdentities(keys string) ([]age.Identity, error) {
+	for _, line := range strings.Split(keys, "\n") {
+		if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "#") {
+			identities, err := age.ParseIdentities(strings.NewReader(keys))
+			if err != nil {
+				return nil, fmt.Errorf("parsing age keys: %w", err)
+			}
+			return identities, nil
+		}
+	}
+	return nil, nil
+}
+
+// ageDecrypt decrypts an armored or binary age file with the first of the identities it was encrypted to
+func ageDecrypt(identities []age.Identity, file []byte) ([]byte, error) {
+	var reader io.Reader = bytes.NewReader(file)
+	if trimmed := bytes.TrimSpace(file); bytes.HasPrefix(trimmed, []byte(armor.Header)) {
+		reader = armor.NewReader(bytes.NewReader(trimmed))
+	}
+
+	decrypted, err := age.Decrypt(reader, identities...)
+	if err != nil {
+		return nil, fmt.Errorf("decrypting age file: %w", err)
+	}
+	plaintext, err := ioutil.ReadAll(decrypted)
+	if err != nil {
+		return nil, fmt.Errorf("decrypting age file: %w", err)
+	}
+	return plaintext, nil
+}
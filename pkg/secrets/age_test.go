@@ -0,0 +1,236 @@
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// sequence returns n bytes counting up from start, to tell data keys apart
+func sequence(start byte, n int) []byte {
+	sequence := make([]byte, n)
+	for i := range sequence {
+		sequence[i] = start + byte(i)
+	}
+	return sequence
+}
+
+// ageChunkSize is the size of the chunks of plaintext age seals on their own
+const ageChunkSize = 64 * 1024
+
+// newAgeIdentity returns a new X25519 identity and its AGE-SECRET-KEY-1 encoding
+func newAgeIdentity(t *testing.T) (*age.X25519Identity, string) {
+	t.Helper()
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatalf("generating key: %s", err)
+	}
+	return identity, identity.String()
+}
+
+// ageEncrypt encrypts the plaintext to the recipient as the age tool does, armored when asked
+func ageEncrypt(t *testing.T, recipient age.Recipient, plaintext []byte, armored bool) []byte {
+	t.Helper()
+	var file bytes.Buffer
+	var output io.WriteCloser = nopWriteCloser{&file}
+	if armored {
+		output = armor.NewWriter(&file)
+	}
+	writer, err := age.Encrypt(output, recipient)
+	if err != nil {
+		t.Fatalf("encrypting: %s", err)
+	}
+	_, err = writer.Write(plaintext)
+	if err == nil {
+		err = writer.Close()
+	}
+	if err == nil {
+		err = output.Close()
+	}
+	if err != nil {
+		t.Fatalf("encrypting: %s", err)
+	}
+	return file.Bytes()
+}
+
+// nopWriteCloser is a writer with nothing to close
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+func TestParseAgeIdentities(t *testing.T) {
+	identity, key := newAgeIdentity(t)
+	changed := "Q"
+	if key[30] == 'Q' {
+		changed = "P"
+	}
+
+	tests := []struct {
+		name        string
+		keys        string
+		expectedErr bool
+	}{
+		{name: "key file", keys: "# created: 2024-01-02T03:04:05Z\n# public key: age1...\n" + key + "\n"},
+		{name: "lowercase", keys: strings.ToLower(key), expectedErr: true},
+		{name: "mixed case", keys: key[:20] + strings.ToLower(key[20:]), expectedErr: true},
+		{name: "changed character", keys: key[:30] + changed + key[31:], expectedErr: true},
+		{name: "recipient", keys: identity.Recipient().String(), expectedErr: true},
+		{name: "cut key", keys: key[:len(key)-1], expectedErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			identities, err := parseAgeIdentities(test.keys)
+			if test.expectedErr {
+				if err == nil {
+					t.Errorf("expected an error, got %d identities", len(identities))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parsing identities: %s", err)
+			}
+			if len(identities) != 1 || identities[0].(*age.X25519Identity).String() != key {
+				t.Errorf("expected the identity, got %d identities", len(identities))
+			}
+		})
+	}
+}
+
+func TestAgeDecrypt(t *testing.T) {
+	identity, _ := newAgeIdentity(t)
+	other, _ := newAgeIdentity(t)
+	dataKey := sequence(0, 32)
+	large := bytes.Repeat([]byte("0123456789abcdef"), ageChunkSize/8+3)
+
+	tests := []struct {
+		name        string
+		identities  []age.Identity
+		file        []byte
+		expected    []byte
+		expectedErr bool
+	}{
+		{name: "armored", identities: []age.Identity{identity}, file: ageEncrypt(t, identity.Recipient(), dataKey, true), expected: dataKey},
+		{name: "binary", identities: []age.Identity{identity}, file: ageEncrypt(t, identity.Recipient(), dataKey, false), expected: dataKey},
+		{name: "second identity", identities: []age.Identity{other, identity}, file: ageEncrypt(t, identity.Recipient(), dataKey, true), expected: dataKey},
+		{name: "several chunks", identities: []age.Identity{identity}, file: ageEncrypt(t, identity.Recipient(), large, false), expected: large},
+		{name: "full chunk", identities: []age.Identity{identity}, file: ageEncrypt(t, identity.Recipient(), large[:ageChunkSize], false), expected: large[:ageChunkSize]},
+		{name: "empty", identities: []age.Identity{identity}, file: ageEncrypt(t, identity.Recipient(), nil, false), expected: []byte{}},
+		{name: "other identity", identities: []age.Identity{other}, file: ageEncrypt(t, identity.Recipient(), dataKey, true), expectedErr: true},
+		{name: "not age", identities: []age.Identity{identity}, file: []byte("age-encryption.org/v2\n"), expectedErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			plaintext, err := ageDecrypt(test.identities, test.file)
+			if test.expectedErr {
+				if err == nil {
+					t.Errorf("expected an error, got %x", plaintext)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("decrypting: %s", err)
+			}
+			if !bytes.Equal(plaintext, test.expected) {
+				t.Errorf("expected %d bytes back, got %d", len(test.expected), len(plaintext))
+			}
+		})
+	}
+}
+
+func TestAgeDecryptRejectsChanges(t *testing.T) {
+	identity, _ := newAgeIdentity(t)
+	file := ageEncrypt(t, identity.Recipient(), bytes.Repeat([]byte("x"), ageChunkSize+10), false)
+	headerEnd := bytes.Index(file, []byte("\n---")) + 1
+
+	tests := []struct {
+		name   string
+		change func(file []byte) []byte
+	}{
+		{name: "header", change: func(file []byte) []byte {
+			return bytes.Replace(file, []byte("\n---"), []byte("\n-> other\n\n---"), 1)
+		}},
+		{name: "payload", change: func(file []byte) []byte {
+			file[len(file)-1] ^= 1
+			return file
+		}},
+		{name: "dropped last chunk", change: func(file []byte) []byte {
+			return file[:len(file)-(10+chacha20poly1305.Overhead)]
+		}},
+		{name: "truncated header", change: func(file []byte) []byte {
+			return file[:headerEnd]
+		}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := ageDecrypt([]age.Identity{identity}, test.change(append([]byte{}, file...))); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
+
+func TestSOPSDataKeyWithAge(t *testing.T) {
+	identity, key := newAgeIdentity(t)
+	dataKey := sequence(0, 32)
+	enc := ageEncrypt(t, identity.Recipient(), dataKey, true)
+
+	// SOPS writes the armored data key as a block scalar
+	lines := strings.Split(strings.TrimSpace(string(enc)), "\n")
+	metadata, err := parseSOPSYAML([]byte("age:\n  - recipient: age1recipient\n    enc: |\n      " + strings.Join(lines, "\n      ") + "\n"))
+	if err != nil {
+		t.Fatalf("parsing metadata: %s", err)
+	}
+	keyFile := filepath.Join(t.TempDir(), "keys.txt")
+	if err := ioutil.WriteFile(keyFile, []byte("# created: 2024-01-02T03:04:05Z\n"+key+"\n"), 0600); err != nil {
+		t.Fatalf("writing keys: %s", err)
+	}
+	_, otherKey := newAgeIdentity(t)
+
+	tests := []struct {
+		name        string
+		key         string
+		keyFile     string
+		expectedErr bool
+	}{
+		{name: "key", key: key},
+		{name: "key file", keyFile: keyFile},
+		{name: "key among others", key: otherKey + "\n" + key},
+		{name: "other key", key: otherKey, expectedErr: true},
+		{name: "no keys", expectedErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Setenv("SOPS_AGE_KEY", test.key)
+			t.Setenv("SOPS_AGE_KEY_FILE", test.keyFile)
+			decrypted, err := sopsDataKey(context.Background(), metadata)
+			if test.expectedErr {
+				if err == nil {
+					t.Errorf("expected an error, got %x", decrypted)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("decrypting data key: %s", err)
+			}
+			if !bytes.Equal(decrypted, dataKey) {
+				t.Errorf("expected %x, got %x", dataKey, decrypted)
+			}
+		})
+	}
+}
@@ -0,0 +1,168 @@
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// SourceAWSSecretsManager is the source of values coming from AWS Secrets Manager
+const SourceAWSSecretsManager Source = "aws-secrets-manager"
+
+// awsVersionIdPattern matches version ids, which are UUIDs, to tell them apart from staging labels
+var awsVersionIdPattern = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+func init() {
+	RegisterProvider("aws", newAWSProviderFromEnv)
+}
+
+// AWSProvider gets secrets from AWS Secrets Manager, authenticating with the credentials chain of the workload
+type AWSProvider struct {
+	Region string
+	// Endpoint replaces the regional endpoint, for VPC endpoints or local emulators
+	Endpoint string
+	// Retry applies to every secret fetch
+	Retry       RetryPolicy
+	credentials *awsCredentialsChain
+}
+
+// Source tells the values come from AWS Secrets Manager
+func (p AWSProvider) Source() Source {
+	return SourceAWSSecretsManager
+}
+
+// GetSecret gets the current version of the secret
+func (p AWSProvider) GetSecret(ctx context.Context, name string) (string, error) {
+	return p.GetSecretVersion(ctx, name, "")
+}
+
+// GetSecretVersion gets a version of the secret, retried according to Retry
+func (p AWSProvider) GetSecretVersion(ctx context.Context, name string, version string) (string, error) {
+	var value string
+	err := p.Retry.do(ctx, func(ctx context.Context) error {
+		var err error
+		value, err = p.fetchSecret(ctx, name, version)
+		return err
+	})
+	return value, err
+}
+
+// fetchSecret calls GetSecretValue, secrets stored as binary are returned as they are
+// Versions are either version ids or staging labels like AWSPREVIOUS, the current version is used when empty
+func (p AWSProvider) fetchSecret(ctx context.Context, name string, version string) (string, error) {
+	credentials, err := p.credentials.get(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	secretRq := struct {
+		SecretId     string `json:"SecretId"`
+		VersionId    string `json:"VersionId,omitempty"`
+		VersionStage string `json:"VersionStage,omitempty"`
+	}{SecretId: name}
+	if awsVersionIdPattern.MatchString(version) {
+		secretRq.VersionId = version
+	} else {
+		secretRq.VersionStage = version
+	}
+	body, err := json.Marshal(secretRq)
+	if err != nil {
+		return "", err
+	}
+
+	rq, err := http.NewRequestWithContext(ctx, http.MethodPost, p.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+
+	rq.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	rq.Header.Set("X-Amz-Target", "secretsmanager.GetSecretValue")
+	err = signAWSRequest(rq, credentials, p.Region, "secretsmanager", clockFromContext(ctx).Now())
+	if err != nil {
+		return "", err
+	}
+	rs, err := UpstreamClient.Do(rq)
+	if err != nil {
+		return "", err
+	}
+
+	bytes, err := ReadBody(rs)
+	if err != nil {
+		return "", err
+	}
+
+	if rs.StatusCode != http.StatusOK {
+		return "", awsError(rs.StatusCode, bytes)
+	}
+
+	secretResponse := struct {
+		SecretString *string `json:"SecretString"`
+		SecretBinary string  `json:"SecretBinary"`
+	}{}
+	err = json.Unmarshal(bytes, &secretResponse)
+	if err != nil {
+		return "", err
+	}
+
+	if secretResponse.SecretString != nil {
+		return *secretResponse.SecretString, nil
+	}
+	data, err := base64.StdEncoding.DecodeString(secretResponse.SecretBinary)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// awsError maps the error body of AWS JSON APIs, not found and permission denied can be told apart with errors.Is
+func awsError(statusCode int, body []byte) error {
+	errorResponse := struct {
+		Type    string `json:"__type"`
+		Message string `json:"message"`
+	}{}
+	// The body is only used to tell the error apart, the status code is enough otherwise
+	_ = json.Unmarshal(body, &errorResponse)
+
+	// The type may come qualified, like com.amazonaws.secretsmanager#ResourceNotFoundException
+	errorType := errorResponse.Type
+	if separator := strings.LastIndex(errorType, "#"); separator >= 0 {
+		errorType = errorType[separator+1:]
+	}
+
+	switch errorType {
+	case "ResourceNotFoundException", "ParameterNotFound", "ParameterVersionNotFound":
+		return fmt.Errorf("%w: error %d - %s %s", ErrSecretNotFound, statusCode, errorType, errorResponse.Message)
+	case "AccessDeniedException", "AccessDenied", "DecryptionFailure", "KMSAccessDeniedException":
+		return fmt.Errorf("%w: error %d - %s %s", ErrPermissionDenied, statusCode, errorType, errorResponse.Message)
+	}
+	if statusCode == http.StatusForbidden {
+		return fmt.Errorf("%w: error %d - %s %s", ErrPermissionDenied, statusCode, errorType, errorResponse.Message)
+	}
+	return withStatus(statusCode, fmt.Errorf("error %d - %s %s", statusCode, errorType, errorResponse.Message))
+}
+
+// newAWSProviderFromEnv builds the AWS provider from the standard AWS environment values
+func newAWSProviderFromEnv() (SecretProvider, error) {
+	region := GetEnv("AWS_REGION", GetEnv("AWS_DEFAULT_REGION", ""))
+	if region == "" {
+		return nil, errors.New("AWS_REGION is required for the aws backend")
+	}
+
+	retry, err := getRetryPolicy("AWS_SECRETS_MANAGER", RetryPolicy{Attempts: 1})
+	if err != nil {
+		return nil, err
+	}
+
+	return AWSProvider{
+		Region:      region,
+		Endpoint:    GetEnv("AWS_ENDPOINT_URL_SECRETS_MANAGER", fmt.Sprintf("https://secretsmanager.%s.amazonaws.com/", region)),
+		Retry:       retry,
+		credentials: newAWSCredentialsChain(region),
+	}, nil
+}
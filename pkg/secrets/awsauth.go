@@ -0,0 +1,317 @@
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// awsCredentials are the keys requests to AWS are signed with
+// Expiration is zero for static keys, which never expire
+type awsCredentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+	Expiration      time.Time
+}
+
+// awsCredentialsRefreshWindow is how long before expiring credentials are fetched again
+const awsCredentialsRefreshWindow = 5 * time.Minute
+
+// awsCredentialsChain gets credentials the way the AWS SDKs do, and keeps them until they are about to expire
+// The first source configured is used: environment keys, IRSA web identity, container credentials and then the instance role
+type awsCredentialsChain struct {
+	region string
+	fetch  func(ctx context.Context) (awsCredentials, error)
+
+	mu      sync.Mutex
+	current awsCredentials
+}
+
+// newAWSCredentialsChain picks the source of credentials from the environment
+func newAWSCredentialsChain(region string) *awsCredentialsChain {
+	chain := &awsCredentialsChain{region: region}
+
+	switch {
+	case GetEnv("AWS_ACCESS_KEY_ID", "") != "" && GetEnv("AWS_SECRET_ACCESS_KEY", "") != "":
+		static := awsCredentials{
+			AccessKeyID:     GetEnv("AWS_ACCESS_KEY_ID", ""),
+			SecretAccessKey: GetEnv("AWS_SECRET_ACCESS_KEY", ""),
+			SessionToken:    GetEnv("AWS_SESSION_TOKEN", ""),
+		}
+		chain.fetch = func(ctx context.Context) (awsCredentials, error) {
+			return static, nil
+		}
+	case GetEnv("AWS_WEB_IDENTITY_TOKEN_FILE", "") != "" && GetEnv("AWS_ROLE_ARN", "") != "":
+		chain.fetch = chain.fetchWebIdentity
+	case GetEnv("AWS_CONTAINER_CREDENTIALS_FULL_URI", "") != "" || GetEnv("AWS_CONTAINER_CREDENTIALS_RELATIVE_URI", "") != "":
+		chain.fetch = fetchContainerCredentials
+	default:
+		chain.fetch = fetchInstanceCredentials
+	}
+	return chain
+}
+
+// get returns the current credentials, fetching them again when they are about to expire
+func (c *awsCredentialsChain) get(ctx context.Context) (awsCredentials, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.current.AccessKeyID != "" && (c.current.Expiration.IsZero() || clockFromContext(ctx).Now().Before(c.current.Expiration.Add(-awsCredentialsRefreshWindow))) {
+		return c.current, nil
+	}
+
+	ctx, span := StartSpan(ctx, "token fetch")
+	credentials, err := c.fetch(ctx)
+	span.End(err)
+	if err != nil {
+		return awsCredentials{}, fmt.Errorf("getting AWS credentials: %w", err)
+	}
+	if credentials.AccessKeyID == "" || credentials.SecretAccessKey == "" {
+		return awsCredentials{}, errors.New("getting AWS credentials: answer without keys")
+	}
+	c.current = credentials
+	return credentials, nil
+}
+
+// fetchWebIdentity exchanges the service account token projected by IRSA for credentials of the role
+// The token file is read on every exchange since it is rotated by the kubelet
+func (c *awsCredentialsChain) fetchWebIdentity(ctx context.Context) (awsCredentials, error) {
+	token, err := ioutil.ReadFile(GetEnv("AWS_WEB_IDENTITY_TOKEN_FILE", ""))
+	if err != nil {
+		return awsCredentials{}, err
+	}
+
+	query := url.Values{}
+	query.Set("Action", "AssumeRoleWithWebIdentity")
+	query.Set("Version", "2011-06-15")
+	query.Set("RoleArn", GetEnv("AWS_ROLE_ARN", ""))
+	query.Set("RoleSessionName", GetEnv("AWS_ROLE_SESSION_NAME", "secret-manager-demo"))
+	query.Set("WebIdentityToken", strings.TrimSpace(string(token)))
+
+	stsUrl := fmt.Sprintf("https://sts.%s.amazonaws.com/", c.region)
+	rq, err := http.NewRequestWithContext(ctx, http.MethodPost, stsUrl, strings.NewReader(query.Encode()))
+	if err != nil {
+		return awsCredentials{}, err
+	}
+
+	rq.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rs, err := UpstreamClient.Do(rq)
+	if err != nil {
+		return awsCredentials{}, err
+	}
+	body, err := ReadBody(rs)
+	if err != nil {
+		return awsCredentials{}, err
+	}
+	if rs.StatusCode != http.StatusOK {
+		return awsCredentials{}, fmt.Errorf("STS answered %d: %s", rs.StatusCode, body)
+	}
+
+	stsResponse := struct {
+		Credentials struct {
+			AccessKeyID     string    `xml:"AccessKeyId"`
+			SecretAccessKey string    `xml:"SecretAccessKey"`
+			SessionToken    string    `xml:"SessionToken"`
+			Expiration      time.Time `xml:"Expiration"`
+		} `xml:"AssumeRoleWithWebIdentityResult>Credentials"`
+	}{}
+	err = xml.Unmarshal(body, &stsResponse)
+	if err != nil {
+		return awsCredentials{}, err
+	}
+	return awsCredentials(stsResponse.Credentials), nil
+}
+
+// fetchContainerCredentials gets credentials from the endpoint of ECS tasks or EKS Pod Identity
+func fetchContainerCredentials(ctx context.Context) (awsCredentials, error) {
+	credentialsUrl := GetEnv("AWS_CONTAINER_CREDENTIALS_FULL_URI", "")
+	if relative := GetEnv("AWS_CONTAINER_CREDENTIALS_RELATIVE_URI", ""); relative != "" {
+		credentialsUrl = "http://169.254.170.2" + relative
+	}
+
+	rq, err := http.NewRequestWithContext(ctx, http.MethodGet, credentialsUrl, nil)
+	if err != nil {
+		return awsCredentials{}, err
+	}
+
+	// Pod Identity mounts the authorization token on a file, which is rotated
+	authorization := GetEnv("AWS_CONTAINER_AUTHORIZATION_TOKEN", "")
+	if tokenFile := GetEnv("AWS_CONTAINER_AUTHORIZATION_TOKEN_FILE", ""); tokenFile != "" {
+		token, err := ioutil.ReadFile(tokenFile)
+		if err != nil {
+			return awsCredentials{}, err
+		}
+		authorization = strings.TrimSpace(string(token))
+	}
+	if authorization != "" {
+		rq.Header.Set("Authorization", authorization)
+	}
+
+	return fetchCredentialsDocument(rq)
+}
+
+// awsInstanceMetadataUrl is the base URL of the instance metadata service available on EC2 instances
+const awsInstanceMetadataUrl = "http://169.254.169.254/latest"
+
+// fetchInstanceCredentials gets credentials of the instance role, using a session token as IMDSv2 requires
+func fetchInstanceCredentials(ctx context.Context) (awsCredentials, error) {
+	rq, err := http.NewRequestWithContext(ctx, http.MethodPut, awsInstanceMetadataUrl+"/api/token", nil)
+	if err != nil {
+		return awsCredentials{}, err
+	}
+	rq.Header.Set("X-aws-ec2-metadata-token-ttl-seconds", "21600")
+	rs, err := UpstreamClient.Do(rq)
+	if err != nil {
+		return awsCredentials{}, err
+	}
+	token, err := ReadBody(rs)
+	if err != nil {
+		return awsCredentials{}, err
+	}
+	if rs.StatusCode != http.StatusOK {
+		return awsCredentials{}, fmt.Errorf("instance metadata answered %d for the session token", rs.StatusCode)
+	}
+
+	roleUrl := awsInstanceMetadataUrl + "/meta-data/iam/security-credentials/"
+	rq, err = http.NewRequestWithContext(ctx, http.MethodGet, roleUrl, nil)
+	if err != nil {
+		return awsCredentials{}, err
+	}
+	rq.Header.Set("X-aws-ec2-metadata-token", string(token))
+	rs, err = UpstreamClient.Do(rq)
+	if err != nil {
+		return awsCredentials{}, err
+	}
+	role, err := ReadBody(rs)
+	if err != nil {
+		return awsCredentials{}, err
+	}
+	if rs.StatusCode != http.StatusOK {
+		return awsCredentials{}, fmt.Errorf("instance metadata answered %d for the role, is there an instance profile", rs.StatusCode)
+	}
+
+	rq, err = http.NewRequestWithContext(ctx, http.MethodGet, roleUrl+strings.TrimSpace(string(role)), nil)
+	if err != nil {
+		return awsCredentials{}, err
+	}
+	rq.Header.Set("X-aws-ec2-metadata-token", string(token))
+	return fetchCredentialsDocument(rq)
+}
+
+// fetchCredentialsDocument gets the JSON credentials document served by the container and instance endpoints
+func fetchCredentialsDocument(rq *http.Request) (awsCredentials, error) {
+	rs, err := UpstreamClient.Do(rq)
+	if err != nil {
+		return awsCredentials{}, err
+	}
+	body, err := ReadBody(rs)
+	if err != nil {
+		return awsCredentials{}, err
+	}
+	if rs.StatusCode != http.StatusOK {
+		return awsCredentials{}, fmt.Errorf("credentials endpoint answered %d", rs.StatusCode)
+	}
+
+	document := struct {
+		AccessKeyID     string    `json:"AccessKeyId"`
+		SecretAccessKey string    `json:"SecretAccessKey"`
+		Token           string    `json:"Token"`
+		Expiration      time.Time `json:"Expiration"`
+	}{}
+	err = json.Unmarshal(body, &document)
+	if err != nil {
+		return awsCredentials{}, err
+	}
+	return awsCredentials{
+		AccessKeyID:     document.AccessKeyID,
+		SecretAccessKey: document.SecretAccessKey,
+		SessionToken:    document.Token,
+		Expiration:      document.Expiration,
+	}, nil
+}
+
+// signAWSRequest signs the request with Signature Version 4, the body is read and put back
+func signAWSRequest(rq *http.Request, credentials awsCredentials, region string, service string, now time.Time) error {
+	var body []byte
+	if rq.Body != nil {
+		var err error
+		body, err = ioutil.ReadAll(rq.Body)
+		if err != nil {
+			return err
+		}
+		rq.Body.Close()
+		rq.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+	rq.Header.Set("X-Amz-Date", amzDate)
+	if credentials.SessionToken != "" {
+		rq.Header.Set("X-Amz-Security-Token", credentials.SessionToken)
+	}
+
+	// Every header set so far is signed, together with the host
+	headers := map[string]string{"host": rq.URL.Host}
+	for name, values := range rq.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalUri := rq.URL.EscapedPath()
+	if canonicalUri == "" {
+		canonicalUri = "/"
+	}
+	// Values are sorted by Encode, but AWS expects spaces as %20
+	canonicalQuery := strings.Replace(rq.URL.Query().Encode(), "+", "%20", -1)
+
+	canonicalRequest := strings.Join([]string{
+		rq.Method, canonicalUri, canonicalQuery, canonicalHeaders.String(), signedHeaders, sha256Hex(body),
+	}, "\n")
+
+	scope := fmt.Sprintf("%s/%s/%s/aws4_request", date, region, service)
+	stringToSign := strings.Join([]string{"AWS4-HMAC-SHA256", amzDate, scope, sha256Hex([]byte(canonicalRequest))}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+credentials.SecretAccessKey), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, service)
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	rq.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		credentials.AccessKeyID, scope, signedHeaders, signature))
+	return nil
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
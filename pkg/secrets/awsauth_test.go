@@ -0,0 +1,297 @@
+package secrets
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// awsExampleCredentials are the example keys of the AWS Signature Version 4 documentation and test suite
+var awsExampleCredentials = awsCredentials{AccessKeyID: "AKIDEXAMPLE", SecretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"}
+
+func TestSignAWSRequest(t *testing.T) {
+	now := time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC)
+
+	tests := []struct {
+		name                  string
+		method                string
+		url                   string
+		headers               map[string]string
+		body                  string
+		region                string
+		service               string
+		expectedAuthorization string
+	}{
+		{
+			name:    "documentation example",
+			method:  http.MethodGet,
+			url:     "https://iam.amazonaws.com/?Action=ListUsers&Version=2010-05-08",
+			headers: map[string]string{"Content-Type": "application/x-www-form-urlencoded; charset=utf-8"},
+			region:  "us-east-1",
+			service: "iam",
+			expectedAuthorization: "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/iam/aws4_request, " +
+				"SignedHeaders=content-type;host;x-amz-date, Signature=5d672d79c15b13162d9279b0855cfba6789a8edb4c82c400e06b5924a6f2b5d7",
+		},
+		{
+			name:    "get-vanilla",
+			method:  http.MethodGet,
+			url:     "https://example.amazonaws.com/",
+			region:  "us-east-1",
+			service: "service",
+			expectedAuthorization: "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, " +
+				"SignedHeaders=host;x-amz-date, Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31",
+		},
+		{
+			name:    "get-vanilla-query-order-key-case",
+			method:  http.MethodGet,
+			url:     "https://example.amazonaws.com/?Param2=value2&Param1=value1",
+			region:  "us-east-1",
+			service: "service",
+			expectedAuthorization: "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, " +
+				"SignedHeaders=host;x-amz-date, Signature=b97d918cfa904a5beff61c982a1b6f458b799221646efd99d3219ec94cdf2500",
+		},
+		{
+			name:    "post-x-www-form-urlencoded",
+			method:  http.MethodPost,
+			url:     "https://example.amazonaws.com/",
+			headers: map[string]string{"Content-Type": "application/x-www-form-urlencoded"},
+			body:    "Param1=value1",
+			region:  "us-east-1",
+			service: "service",
+			expectedAuthorization: "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, " +
+				"SignedHeaders=content-type;host;x-amz-date, Signature=ff11897932ad3f4e8b18135d722051e5ac45fc38421b1da7b9d196a0fe09473a",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rq, err := http.NewRequest(test.method, test.url, strings.NewReader(test.body))
+			if err != nil {
+				t.Fatalf("creating request: %s", err)
+			}
+			for name, value := range test.headers {
+				rq.Header.Set(name, value)
+			}
+
+			err = signAWSRequest(rq, awsExampleCredentials, test.region, test.service, now)
+			if err != nil {
+				t.Fatalf("signing: %s", err)
+			}
+			if authorization := rq.Header.Get("Authorization"); authorization != test.expectedAuthorization {
+				t.Errorf("expected %s, got %s", test.expectedAuthorization, authorization)
+			}
+			if date := rq.Header.Get("X-Amz-Date"); date != "20150830T123600Z" {
+				t.Errorf("expected the date header, got %q", date)
+			}
+
+			// The body is still there to be sent
+			sent, _ := ioutil.ReadAll(rq.Body)
+			if string(sent) != test.body {
+				t.Errorf("expected the body %q, got %q", test.body, sent)
+			}
+		})
+	}
+}
+
+func TestSignAWSRequestWithSessionToken(t *testing.T) {
+	credentials := awsExampleCredentials
+	credentials.SessionToken = "session"
+	rq, _ := http.NewRequest(http.MethodGet, "https://example.amazonaws.com/", nil)
+
+	err := signAWSRequest(rq, credentials, "us-east-1", "service", time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatalf("signing: %s", err)
+	}
+	if rq.Header.Get("X-Amz-Security-Token") != "session" {
+		t.Error("expected the session token header")
+	}
+	if !strings.Contains(rq.Header.Get("Authorization"), "SignedHeaders=host;x-amz-date;x-amz-security-token,") {
+		t.Errorf("expected the session token to be signed, got %s", rq.Header.Get("Authorization"))
+	}
+}
+
+func TestSHA256HexAndHMACSHA256(t *testing.T) {
+	if sum := sha256Hex(nil); sum != "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855" {
+		t.Errorf("unexpected hash of nothing %s", sum)
+	}
+	if sum := sha256Hex([]byte("abc")); sum != "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad" {
+		t.Errorf("unexpected hash of abc %s", sum)
+	}
+
+	// Test case 2 of RFC 4231
+	mac := fmt.Sprintf("%x", hmacSHA256([]byte("Jefe"), "what do ya want for nothing?"))
+	if mac != "5bdcc146bf60754e6a042426089575c75a003f089d2739839dec58b964ec3843" {
+		t.Errorf("unexpected HMAC %s", mac)
+	}
+}
+
+// clearAWSEnv unsets the variables that pick the source of AWS credentials for the rest of the test
+func clearAWSEnv(t *testing.T) {
+	t.Helper()
+	for _, name := range []string{
+		"AWS_ACCESS_KEY_ID", "AWS_SECRET_ACCESS_KEY", "AWS_SESSION_TOKEN",
+		"AWS_WEB_IDENTITY_TOKEN_FILE", "AWS_ROLE_ARN", "AWS_ROLE_SESSION_NAME",
+		"AWS_CONTAINER_CREDENTIALS_FULL_URI", "AWS_CONTAINER_CREDENTIALS_RELATIVE_URI",
+		"AWS_CONTAINER_AUTHORIZATION_TOKEN", "AWS_CONTAINER_AUTHORIZATION_TOKEN_FILE",
+	} {
+		t.Setenv(name, "")
+	}
+}
+
+func TestAWSCredentialsChain(t *testing.T) {
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	if err := ioutil.WriteFile(tokenFile, []byte("projected-token\n"), 0600); err != nil {
+		t.Fatalf("writing token: %s", err)
+	}
+	expiration := "2024-01-02T04:04:05Z"
+	document := `{"AccessKeyId": "AKIDDOCUMENT", "SecretAccessKey": "secret", "Token": "session", "Expiration": "` + expiration + `"}`
+
+	tests := []struct {
+		name     string
+		env      map[string]string
+		handler  http.HandlerFunc
+		expected awsCredentials
+	}{
+		{
+			name:     "static keys",
+			env:      map[string]string{"AWS_ACCESS_KEY_ID": "AKIDSTATIC", "AWS_SECRET_ACCESS_KEY": "secret", "AWS_SESSION_TOKEN": "session"},
+			expected: awsCredentials{AccessKeyID: "AKIDSTATIC", SecretAccessKey: "secret", SessionToken: "session"},
+		},
+		{
+			name: "web identity",
+			env:  map[string]string{"AWS_WEB_IDENTITY_TOKEN_FILE": tokenFile, "AWS_ROLE_ARN": "arn:aws:iam::123456789012:role/app", "AWS_ROLE_SESSION_NAME": "app"},
+			handler: func(w http.ResponseWriter, rq *http.Request) {
+				rq.ParseForm()
+				if rq.Method != http.MethodPost || rq.URL.String() != "https://sts.eu-west-1.amazonaws.com/" ||
+					rq.PostForm.Get("Action") != "AssumeRoleWithWebIdentity" || rq.PostForm.Get("WebIdentityToken") != "projected-token" ||
+					rq.PostForm.Get("RoleArn") != "arn:aws:iam::123456789012:role/app" || rq.PostForm.Get("RoleSessionName") != "app" {
+					http.Error(w, "unexpected exchange "+rq.URL.String(), http.StatusBadRequest)
+					return
+				}
+				fmt.Fprint(w, `<AssumeRoleWithWebIdentityResponse><AssumeRoleWithWebIdentityResult><Credentials>`+
+					`<AccessKeyId>AKIDSTS</AccessKeyId><SecretAccessKey>secret</SecretAccessKey><SessionToken>session</SessionToken>`+
+					`<Expiration>`+expiration+`</Expiration></Credentials></AssumeRoleWithWebIdentityResult></AssumeRoleWithWebIdentityResponse>`)
+			},
+			expected: awsCredentials{AccessKeyID: "AKIDSTS", SecretAccessKey: "secret", SessionToken: "session", Expiration: time.Date(2024, 1, 2, 4, 4, 5, 0, time.UTC)},
+		},
+		{
+			name: "container full URI with a token file",
+			env:  map[string]string{"AWS_CONTAINER_CREDENTIALS_FULL_URI": "http://169.254.170.23/v1/credentials", "AWS_CONTAINER_AUTHORIZATION_TOKEN_FILE": tokenFile},
+			handler: func(w http.ResponseWriter, rq *http.Request) {
+				if rq.URL.String() != "http://169.254.170.23/v1/credentials" || rq.Header.Get("Authorization") != "projected-token" {
+					http.Error(w, "unexpected request", http.StatusUnauthorized)
+					return
+				}
+				fmt.Fprint(w, document)
+			},
+			expected: awsCredentials{AccessKeyID: "AKIDDOCUMENT", SecretAccessKey: "secret", SessionToken: "session", Expiration: time.Date(2024, 1, 2, 4, 4, 5, 0, time.UTC)},
+		},
+		{
+			name: "container relative URI",
+			env:  map[string]string{"AWS_CONTAINER_CREDENTIALS_RELATIVE_URI": "/v2/credentials/id", "AWS_CONTAINER_AUTHORIZATION_TOKEN": "static-token"},
+			handler: func(w http.ResponseWriter, rq *http.Request) {
+				if rq.URL.String() != "http://169.254.170.2/v2/credentials/id" || rq.Header.Get("Authorization") != "static-token" {
+					http.Error(w, "unexpected request", http.StatusUnauthorized)
+					return
+				}
+				fmt.Fprint(w, document)
+			},
+			expected: awsCredentials{AccessKeyID: "AKIDDOCUMENT", SecretAccessKey: "secret", SessionToken: "session", Expiration: time.Date(2024, 1, 2, 4, 4, 5, 0, time.UTC)},
+		},
+		{
+			name: "instance role",
+			handler: func(w http.ResponseWriter, rq *http.Request) {
+				switch {
+				case rq.Method == http.MethodPut && rq.URL.Path == "/latest/api/token" && rq.Header.Get("X-aws-ec2-metadata-token-ttl-seconds") != "":
+					fmt.Fprint(w, "imds-token")
+				case rq.Header.Get("X-aws-ec2-metadata-token") != "imds-token":
+					http.Error(w, "missing session token", http.StatusUnauthorized)
+				case rq.URL.Path == "/latest/meta-data/iam/security-credentials/":
+					fmt.Fprint(w, "app-role\n")
+				case rq.URL.Path == "/latest/meta-data/iam/security-credentials/app-role":
+					fmt.Fprint(w, document)
+				default:
+					http.NotFound(w, rq)
+				}
+			},
+			expected: awsCredentials{AccessKeyID: "AKIDDOCUMENT", SecretAccessKey: "secret", SessionToken: "session", Expiration: time.Date(2024, 1, 2, 4, 4, 5, 0, time.UTC)},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			clearAWSEnv(t)
+			for name, value := range test.env {
+				t.Setenv(name, value)
+			}
+			stubUpstream(t, func(w http.ResponseWriter, rq *http.Request) {
+				if test.handler == nil {
+					t.Errorf("unexpected call to %s", rq.URL)
+					http.Error(w, "unexpected", http.StatusInternalServerError)
+					return
+				}
+				test.handler(w, rq)
+			})
+
+			credentials, err := newAWSCredentialsChain("eu-west-1").get(context.Background())
+			if err != nil {
+				t.Fatalf("getting credentials: %s", err)
+			}
+			if !credentials.Expiration.Equal(test.expected.Expiration) {
+				t.Errorf("expected expiration %s, got %s", test.expected.Expiration, credentials.Expiration)
+			}
+			credentials.Expiration, test.expected.Expiration = time.Time{}, time.Time{}
+			if credentials != test.expected {
+				t.Errorf("expected %+v, got %+v", test.expected, credentials)
+			}
+		})
+	}
+}
+
+func TestAWSCredentialsChainRefreshesBeforeExpiring(t *testing.T) {
+	clock := NewFakeClock(time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC))
+	ctx := WithClock(context.Background(), clock)
+	fetches := 0
+	chain := &awsCredentialsChain{region: "eu-west-1", fetch: func(ctx context.Context) (awsCredentials, error) {
+		fetches++
+		return awsCredentials{
+			AccessKeyID:     fmt.Sprintf("AKID%d", fetches),
+			SecretAccessKey: "secret",
+			Expiration:      clock.Now().Add(time.Hour),
+		}, nil
+	}}
+
+	steps := []struct {
+		advance    time.Duration
+		expectedID string
+	}{
+		{expectedID: "AKID1"},
+		{advance: time.Hour - awsCredentialsRefreshWindow - time.Second, expectedID: "AKID1"},
+		{advance: time.Second, expectedID: "AKID2"},
+		{advance: time.Minute, expectedID: "AKID2"},
+	}
+	for i, step := range steps {
+		clock.Advance(step.advance)
+		credentials, err := chain.get(ctx)
+		if err != nil {
+			t.Fatalf("step %d: getting credentials: %s", i, err)
+		}
+		if credentials.AccessKeyID != step.expectedID {
+			t.Errorf("step %d: expected %s, got %s", i, step.expectedID, credentials.AccessKeyID)
+		}
+	}
+}
+
+func TestAWSCredentialsChainRejectsAnswersWithoutKeys(t *testing.T) {
+	chain := &awsCredentialsChain{fetch: func(ctx context.Context) (awsCredentials, error) {
+		return awsCredentials{AccessKeyID: "AKID"}, nil
+	}}
+	if _, err := chain.get(context.Background()); err == nil {
+		t.Error("expected an error for credentials without a secret key")
+	}
+}
@@ -0,0 +1,223 @@
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SourceAzureKeyVault is the source of values coming from Azure Key Vault
+const SourceAzureKeyVault Source = "azure-key-vault"
+
+// azureKeyVaultResource is the audience of the tokens Key Vault accepts
+const azureKeyVaultResource = "https://vault.azure.net"
+
+func init() {
+	RegisterProvider("azure", newAzureProviderFromEnv)
+}
+
+// AzureProvider gets secrets from Azure Key Vault, authenticating with the managed identity of the workload
+type AzureProvider struct {
+	// VaultURI is the base URI of the vault, like https://my-vault.vault.azure.net
+	VaultURI string
+	// Retry applies to every secret fetch
+	Retry RetryPolicy
+	token *azureManagedIdentity
+}
+
+// Source tells the values come from Azure Key Vault
+func (p AzureProvider) Source() Source {
+	return SourceAzureKeyVault
+}
+
+// GetSecret gets the current version of the secret
+func (p AzureProvider) GetSecret(ctx context.Context, name string) (string, error) {
+	return p.GetSecretVersion(ctx, name, "")
+}
+
+// GetSecretVersion gets a version of the secret, retried according to Retry
+func (p AzureProvider) GetSecretVersion(ctx context.Context, name string, version string) (string, error) {
+	var value string
+	err := p.Retry.do(ctx, func(ctx context.Context) error {
+		var err error
+		value, err = p.fetchSecret(ctx, name, version)
+		return err
+	})
+	return value, err
+}
+
+// fetchSecret gets the secret value from Key Vault, the current version when version is empty
+func (p AzureProvider) fetchSecret(ctx context.Context, name string, version string) (string, error) {
+	token, err := p.token.get(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	secretPath := "/secrets/" + url.PathEscape(name)
+	if version != "" {
+		secretPath += "/" + url.PathEscape(version)
+	}
+	secretUrl := fmt.Sprintf("%s%s?api-version=7.4", p.VaultURI, secretPath)
+	rq, err := http.NewRequestWithContext(ctx, http.MethodGet, secretUrl, nil)
+	if err != nil {
+		return "", err
+	}
+
+	rq.Header.Add("Authorization", fmt.Sprintf("Bearer %s", token))
+	rs, err := UpstreamClient.Do(rq)
+	if err != nil {
+		return "", err
+	}
+
+	secretResponse := struct {
+		Error struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+		Value string `json:"value"`
+	}{}
+
+	bytes, err := ReadBody(rs)
+	if err != nil {
+		return "", err
+	}
+
+	// Errors coming from the gateway in front of the vault may not be JSON, the status code is enough for those
+	err = json.Unmarshal(bytes, &secretResponse)
+	if err != nil && rs.StatusCode == http.StatusOK {
+		return "", err
+	}
+
+	switch rs.StatusCode {
+	case http.StatusOK:
+		return secretResponse.Value, nil
+	case http.StatusNotFound:
+		return "", fmt.Errorf("%w: error %d - %s", ErrSecretNotFound, rs.StatusCode, secretResponse.Error.Code)
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return "", fmt.Errorf("%w: error %d - %s", ErrPermissionDenied, rs.StatusCode, secretResponse.Error.Code)
+	default:
+		return "", withStatus(rs.StatusCode, fmt.Errorf("error %d - %s %s", rs.StatusCode, secretResponse.Error.Code, secretResponse.Error.Message))
+	}
+}
+
+// azureTokenRefreshWindow is how long before expiring the token is fetched again
+const azureTokenRefreshWindow = 5 * time.Minute
+
+// azureManagedIdentity gets tokens for the managed identity, and keeps them until they are about to expire
+// App Service and Functions expose their own endpoint, everything else uses the instance metadata service
+type azureManagedIdentity struct {
+	// clientID selects a user assigned identity, the system assigned one is used when empty
+	clientID string
+
+	mu        sync.Mutex
+	token     string
+	expiresOn time.Time
+}
+
+// get returns the current token, fetching it again when it is about to expire
+func (m *azureManagedIdentity) get(ctx context.Context) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.token != "" && clockFromContext(ctx).Now().Before(m.expiresOn.Add(-azureTokenRefreshWindow)) {
+		return m.token, nil
+	}
+
+	ctx, span := StartSpan(ctx, "token fetch")
+	token, expiresOn, err := m.fetch(ctx)
+	span.End(err)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrTokenUnavailable, err)
+	}
+	m.token, m.expiresOn = token, expiresOn
+	return token, nil
+}
+
+// fetch gets a token for Key Vault from the managed identity endpoint
+func (m *azureManagedIdentity) fetch(ctx context.Context) (string, time.Time, error) {
+	query := url.Values{}
+	query.Set("resource", azureKeyVaultResource)
+	if m.clientID != "" {
+		query.Set("client_id", m.clientID)
+	}
+
+	var tokenUrl string
+	headers := http.Header{}
+	if endpoint := GetEnv("IDENTITY_ENDPOINT", ""); endpoint != "" {
+		query.Set("api-version", "2019-08-01")
+		tokenUrl = endpoint + "?" + query.Encode()
+		headers.Set("X-IDENTITY-HEADER", GetEnv("IDENTITY_HEADER", ""))
+	} else {
+		query.Set("api-version", "2018-02-01")
+		tokenUrl = "http://169.254.169.254/metadata/identity/oauth2/token?" + query.Encode()
+		headers.Set("Metadata", "true")
+	}
+
+	rq, err := http.NewRequestWithContext(ctx, http.MethodGet, tokenUrl, nil)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	rq.Header = headers
+	rs, err := UpstreamClient.Do(rq)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	bytes, err := ReadBody(rs)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	if rs.StatusCode != http.StatusOK {
+		return "", time.Time{}, fmt.Errorf("managed identity endpoint answered %d: %s", rs.StatusCode, bytes)
+	}
+
+	// Both endpoints serialize the expiry as a string of seconds since the epoch
+	tokenResponse := struct {
+		AccessToken string      `json:"access_token"`
+		ExpiresOn   json.Number `json:"expires_on"`
+	}{}
+	err = json.Unmarshal(bytes, &tokenResponse)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	if tokenResponse.AccessToken == "" {
+		return "", time.Time{}, errors.New("managed identity endpoint answered without an access token")
+	}
+
+	expiresOn, err := strconv.ParseInt(tokenResponse.ExpiresOn.String(), 10, 64)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("expires_on %q: %w", tokenResponse.ExpiresOn, err)
+	}
+	return tokenResponse.AccessToken, time.Unix(expiresOn, 0), nil
+}
+
+// newAzureProviderFromEnv builds the Azure provider for the vault on AZURE_KEY_VAULT_URI
+func newAzureProviderFromEnv() (SecretProvider, error) {
+	vaultURI := strings.TrimSuffix(GetEnv("AZURE_KEY_VAULT_URI", ""), "/")
+	if vaultURI == "" {
+		return nil, errors.New("AZURE_KEY_VAULT_URI is required for the azure backend")
+	}
+	_, err := url.ParseRequestURI(vaultURI)
+	if err != nil {
+		return nil, fmt.Errorf("AZURE_KEY_VAULT_URI: %w", err)
+	}
+
+	retry, err := getRetryPolicy("AZURE_KEY_VAULT", RetryPolicy{Attempts: 1})
+	if err != nil {
+		return nil, err
+	}
+
+	return AzureProvider{
+		VaultURI: vaultURI,
+		Retry:    retry,
+		token:    &azureManagedIdentity{clientID: GetEnv("AZURE_CLIENT_ID", "")},
+	}, nil
+}
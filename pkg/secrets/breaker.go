@@ -0,0 +1,111 @@
+package secrets
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// CircuitBreaker stops calling the backend after consecutive failures, so an outage is answered right away instead
+// of stalling every request for the whole timeout and hammering the backend while it recovers
+// Once the cooldown passes a single request goes through as a probe, closing the breaker when it succeeds
+// A nil CircuitBreaker never opens
+type CircuitBreaker struct {
+	threshold int
+	cooldown  time.Duration
+	clock     Clock
+	mu        sync.Mutex
+	failures  int
+	open      bool
+	openedAt  time.Time
+	probing   bool
+}
+
+// NewCircuitBreaker returns a breaker opening after threshold consecutive failures for the cooldown,
+// a zero threshold disables it
+func NewCircuitBreaker(threshold int, cooldown time.Duration, clock Clock) *CircuitBreaker {
+	if threshold <= 0 {
+		return nil
+	}
+	if clock == nil {
+		clock = RealClock{}
+	}
+	return &CircuitBreaker{threshold: threshold, cooldown: cooldown, clock: clock}
+}
+
+// Allow tells if the backend can be called, which is always true unless open and a probe is not due or in flight
+func (b *CircuitBreaker) Allow() bool {
+	if b == nil {
+		return true
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.open {
+		return true
+	}
+	if b.probing || b.clock.Now().Sub(b.openedAt) < b.cooldown {
+		return false
+	}
+	b.probing = true
+	return true
+}
+
+// Observe records the outcome of a call to the backend
+// Only transient errors count as failures, as not found and the like mean the backend is answering
+func (b *CircuitBreaker) Observe(err error) {
+	if b == nil {
+		return
+	}
+	// Callers going away say nothing about the backend
+	if errors.Is(err, context.Canceled) {
+		b.Abandon()
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+	if err == nil || !isRetryable(err) {
+		if b.open {
+			slog.Info("secret provider recovered, closing the circuit breaker")
+		}
+		b.failures = 0
+		b.open = false
+		return
+	}
+
+	b.failures++
+	if b.open || b.failures >= b.threshold {
+		if !b.open {
+			slog.Warn("secret provider is failing, opening the circuit breaker", "failures", b.failures, "cooldown", b.cooldown)
+		}
+		b.open = true
+		b.openedAt = b.clock.Now()
+	}
+}
+
+// Abandon records a call to the backend that ended without an outcome, as when the caller gave up or ran out of
+// time, which neither counts as a failure nor closes the breaker
+func (b *CircuitBreaker) Abandon() {
+	if b == nil {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+}
+
+// Open tells if calls to the backend are being stopped
+func (b *CircuitBreaker) Open() bool {
+	if b == nil {
+		return false
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.open
+}
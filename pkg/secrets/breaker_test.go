@@ -0,0 +1,123 @@
+package secrets
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	transient := statusError{StatusCode: http.StatusServiceUnavailable}
+
+	// Every step advances the clock, asks to call the backend and, when allowed, records the outcome of the call
+	type step struct {
+		advance         time.Duration
+		err             error
+		abandon         bool
+		expectedAllowed bool
+		expectedOpen    bool
+	}
+
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{
+			name: "opens after the threshold",
+			steps: []step{
+				{err: transient, expectedAllowed: true},
+				{err: transient, expectedAllowed: true, expectedOpen: true},
+				{expectedOpen: true},
+				{advance: 59 * time.Second, expectedOpen: true},
+			},
+		},
+		{
+			name: "successes reset the failures",
+			steps: []step{
+				{err: transient, expectedAllowed: true},
+				{expectedAllowed: true},
+				{err: transient, expectedAllowed: true},
+			},
+		},
+		{
+			name: "answers of the backend are not failures",
+			steps: []step{
+				{err: ErrSecretNotFound, expectedAllowed: true},
+				{err: ErrPermissionDenied, expectedAllowed: true},
+				{err: statusError{StatusCode: http.StatusBadRequest}, expectedAllowed: true},
+			},
+		},
+		{
+			name: "probe closes the breaker when it succeeds",
+			steps: []step{
+				{err: transient, expectedAllowed: true},
+				{err: transient, expectedAllowed: true, expectedOpen: true},
+				{advance: time.Minute, expectedAllowed: true},
+				{err: transient, expectedAllowed: true},
+			},
+		},
+		{
+			name: "probe opens the breaker again when it fails",
+			steps: []step{
+				{err: transient, expectedAllowed: true},
+				{err: transient, expectedAllowed: true, expectedOpen: true},
+				{advance: time.Minute, err: transient, expectedAllowed: true, expectedOpen: true},
+				{advance: 59 * time.Second, expectedOpen: true},
+				{advance: time.Second, expectedAllowed: true},
+			},
+		},
+		{
+			name: "abandoned probe lets another one through",
+			steps: []step{
+				{err: transient, expectedAllowed: true},
+				{err: transient, expectedAllowed: true, expectedOpen: true},
+				{advance: time.Minute, abandon: true, expectedAllowed: true, expectedOpen: true},
+				{expectedAllowed: true},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			clock := NewFakeClock(time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC))
+			breaker := NewCircuitBreaker(2, time.Minute, clock)
+
+			for i, step := range test.steps {
+				clock.Advance(step.advance)
+				allowed := breaker.Allow()
+				if allowed != step.expectedAllowed {
+					t.Fatalf("step %d: expected allowed %t, got %t", i, step.expectedAllowed, allowed)
+				}
+				if allowed && step.abandon {
+					breaker.Abandon()
+				} else if allowed {
+					breaker.Observe(step.err)
+				}
+				if open := breaker.Open(); open != step.expectedOpen {
+					t.Fatalf("step %d: expected open %t, got %t", i, step.expectedOpen, open)
+				}
+			}
+		})
+	}
+}
+
+func TestCircuitBreakerAllowsOneProbe(t *testing.T) {
+	clock := NewFakeClock(time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC))
+	breaker := NewCircuitBreaker(1, time.Minute, clock)
+	breaker.Allow()
+	breaker.Observe(statusError{StatusCode: http.StatusServiceUnavailable})
+
+	// Callers arriving while the probe is in flight are stopped, even past the cooldown
+	clock.Advance(time.Minute)
+	if !breaker.Allow() {
+		t.Fatal("expected the probe to be allowed")
+	}
+	clock.Advance(time.Minute)
+	if breaker.Allow() {
+		t.Error("expected a single probe in flight")
+	}
+	breaker.Observe(nil)
+	if !breaker.Allow() || breaker.Open() {
+		t.Error("expected the breaker to close after the probe")
+	}
+}
@@ -0,0 +1,316 @@
+package secrets
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Cache keeps resolved secret values for a while, so repeated lookups do not hit the backend
+type Cache interface {
+	// Get returns the value for the secret, if present and not expired
+	Get(name string) (string, bool)
+	// Set stores the value for the secret
+	Set(name string, value string)
+	// Delete removes the value for the secret
+	Delete(name string)
+	// Names returns the keys of the secrets with a value that has not expired, the keys of secrets of other projects
+	// asked for by requests carry the project too
+	Names() []string
+}
+
+// CacheLocker is implemented by caches shared with other processes, so only one of them fetches a missing secret
+// while the others wait for it and then read the value it cached
+type CacheLocker interface {
+	// Lock holds the lock of the secret until the returned function is called, it fails when the context is done
+	// before the lock is free
+	Lock(ctx context.Context, name string) (func(), error)
+}
+
+// TTLSetter is implemented by caches whose TTL can change while they are used, on a configuration reload
+type TTLSetter interface {
+	SetTTL(ttl time.Duration)
+}
+
+// memoryCache is the default Cache, private to the process
+type memoryCache struct {
+	entries *TTLCache
+}
+
+// NewMemoryCache returns a Cache that keeps values in memory for the given time
+func NewMemoryCache(ttl time.Duration, clock Clock) Cache {
+	return memoryCache{entries: NewTTLCache(ttl, clock)}
+}
+
+func (c memoryCache) Get(name string) (string, bool) {
+	value, ok := c.entries.Get(name)
+	if !ok {
+		return "", false
+	}
+	return value.(string), true
+}
+
+func (c memoryCache) Set(name string, value string) {
+	c.entries.Set(name, value)
+}
+
+func (c memoryCache) Delete(name string) {
+	c.entries.Delete(name)
+}
+
+func (c memoryCache) Names() []string {
+	return c.entries.Keys()
+}
+
+func (c memoryCache) SetTTL(ttl time.Duration) {
+	c.entries.SetTTL(ttl)
+}
+
+// EvictIdle removes the values that were not accessed within the idle window
+func (c memoryCache) EvictIdle(idle time.Duration) int {
+	return c.entries.EvictIdle(idle)
+}
+
+// sharedCacheLockInterval is how often a process waiting for the lock of a secret checks if it is free
+const sharedCacheLockInterval = 20 * time.Millisecond
+
+// sharedCache is a Cache backed by a local directory, so processes on the same node share a warm cache
+// Every secret is a file holding its expiry time, its name and its value, written atomically with 0600
+// The modification time of a file is when it was last accessed, which EvictIdle goes by
+// Fetches of a secret are coordinated with a lock file per secret, which are kept as removing them could let two
+// processes hold the lock of the same secret
+type sharedCache struct {
+	dir string
+	// ttl is shared by the copies of the cache, so it can be changed on all of them
+	ttl   *atomic.Int64
+	clock Clock
+}
+
+// NewSharedCache returns a Cache that keeps values on the given directory for the given time
+func NewSharedCache(dir string, ttl time.Duration, clock Clock) (Cache, error) {
+	if clock == nil {
+		clock = RealClock{}
+	}
+
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return nil, err
+	}
+
+	// An existing directory is left as it is by MkdirAll, but it holds plaintext secrets, so it has to be ours and
+	// only ours
+	info, err := os.Lstat(dir)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("shared cache %s is not a directory", dir)
+	}
+	if ownedByAnotherUser(info) {
+		return nil, fmt.Errorf("shared cache %s is owned by another user", dir)
+	}
+	if info.Mode().Perm() != 0700 {
+		err = os.Chmod(dir, 0700)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	c := sharedCache{dir: dir, ttl: new(atomic.Int64), clock: clock}
+	c.SetTTL(ttl)
+	return c, nil
+}
+
+func (c sharedCache) Get(name string) (string, bool) {
+	path := c.path(name)
+	entryName, value, ok := c.read(path)
+	if !ok || entryName != name {
+		return "", false
+	}
+
+	now := c.clock.Now()
+	err := os.Chtimes(path, now, now)
+	if err != nil && !os.IsNotExist(err) {
+		slog.Error("touching shared cache", "error", err)
+	}
+	return value, true
+}
+
+func (c sharedCache) Set(name string, value string) {
+	content := make([]byte, 10, 10+len(name)+len(value))
+	binary.BigEndian.PutUint64(content, uint64(c.clock.Now().Add(time.Duration(c.ttl.Load())).UnixNano()))
+	binary.BigEndian.PutUint16(content[8:], uint16(len(name)))
+	content = append(content, name...)
+	content = append(content, value...)
+
+	tmp, err := ioutil.TempFile(c.dir, ".tmp-*")
+	if err != nil {
+		slog.Error("writing shared cache", "error", err)
+		return
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(content)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		now := c.clock.Now()
+		err = os.Chtimes(tmp.Name(), now, now)
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), c.path(name))
+	}
+	if err != nil {
+		slog.Error("writing shared cache", "error", err)
+	}
+}
+
+func (c sharedCache) SetTTL(ttl time.Duration) {
+	c.ttl.Store(int64(ttl))
+}
+
+func (c sharedCache) Names() []string {
+	files, err := ioutil.ReadDir(c.dir)
+	if err != nil {
+		slog.Error("listing shared cache", "error", err)
+		return nil
+	}
+
+	var names []string
+	for _, file := range files {
+		if file.IsDir() || strings.HasPrefix(file.Name(), ".") {
+			continue
+		}
+		if name, _, ok := c.read(filepath.Join(c.dir, file.Name())); ok {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// read returns the name and value on the file, only if it has not expired
+// Expired files are removed, another process writing the secret again at the same time only makes it miss once
+func (c sharedCache) read(path string) (string, string, bool) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			slog.Error("reading shared cache", "error", err)
+		}
+		return "", "", false
+	}
+
+	// A file shorter than its header was not written by us, so it is ignored
+	if len(content) < 10 {
+		return "", "", false
+	}
+	nameLength := int(binary.BigEndian.Uint16(content[8:10]))
+	if len(content) < 10+nameLength {
+		return "", "", false
+	}
+
+	expiresAt := time.Unix(0, int64(binary.BigEndian.Uint64(content[:8])))
+	if !c.clock.Now().Before(expiresAt) {
+		err = os.Remove(path)
+		if err != nil && !os.IsNotExist(err) {
+			slog.Error("deleting from shared cache", "error", err)
+		}
+		return "", "", false
+	}
+	return string(content[10 : 10+nameLength]), string(content[10+nameLength:]), true
+}
+
+func (c sharedCache) Delete(name string) {
+	err := os.Remove(c.path(name))
+	if err != nil && !os.IsNotExist(err) {
+		slog.Error("deleting from shared cache", "error", err)
+	}
+}
+
+// EvictIdle removes the files that were not accessed within the idle window and the expired ones, and the temporary
+// files left behind by processes that stopped while writing
+// It returns how many secrets were removed
+func (c sharedCache) EvictIdle(idle time.Duration) int {
+	files, err := ioutil.ReadDir(c.dir)
+	if err != nil {
+		slog.Error("listing shared cache", "error", err)
+		return 0
+	}
+
+	now := c.clock.Now()
+	evicted := 0
+	for _, file := range files {
+		if file.IsDir() {
+			continue
+		}
+		path := filepath.Join(c.dir, file.Name())
+		idleFor := now.Sub(file.ModTime())
+		if strings.HasPrefix(file.Name(), ".tmp-") {
+			if idleFor >= idle {
+				_ = os.Remove(path)
+			}
+			continue
+		}
+		if strings.HasPrefix(file.Name(), ".lock-") {
+			continue
+		}
+		if _, _, ok := c.read(path); ok && idleFor < idle {
+			continue
+		}
+
+		// Expired files were already removed when read
+		err := os.Remove(path)
+		if err != nil && !os.IsNotExist(err) {
+			slog.Error("deleting from shared cache", "error", err)
+			continue
+		}
+		evicted++
+	}
+	return evicted
+}
+
+// Lock takes the lock file of the secret, waiting for the process holding it to fetch the secret
+// Locks are released by the system when the process holding them stops, so a crashed process does not keep them
+func (c sharedCache) Lock(ctx context.Context, name string) (func(), error) {
+	path := c.path(name)
+	file, err := os.OpenFile(filepath.Join(filepath.Dir(path), ".lock-"+filepath.Base(path)), os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		locked, err := tryLockFile(file)
+		if err != nil {
+			_ = file.Close()
+			return nil, err
+		}
+		if locked {
+			return func() {
+				unlockFile(file)
+				_ = file.Close()
+			}, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			_ = file.Close()
+			return nil, ctx.Err()
+		case <-time.After(sharedCacheLockInterval):
+		}
+	}
+}
+
+// path returns the file for the secret, hashing the name so it is always a valid file name
+func (c sharedCache) path(name string) string {
+	sum := sha256.Sum256([]byte(name))
+	return filepath.Join(c.dir, hex.EncodeToString(sum[:]))
+}
@@ -0,0 +1,221 @@
+package secrets
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSharedCacheEvictsIdleAndExpiredFiles(t *testing.T) {
+	dir := t.TempDir()
+	clock := NewFakeClock(time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC))
+	cache, err := NewSharedCache(dir, time.Hour, clock)
+	if err != nil {
+		t.Fatalf("creating cache: %s", err)
+	}
+	evictor := cache.(interface{ EvictIdle(time.Duration) int })
+
+	cache.Set("db-password", "hunter2")
+	cache.Set("api-key", "abc")
+	// A temporary file of a writer that stopped halfway
+	if err := ioutil.WriteFile(filepath.Join(dir, ".tmp-123"), []byte("partial"), 0600); err != nil {
+		t.Fatalf("writing file: %s", err)
+	}
+	if err := os.Chtimes(filepath.Join(dir, ".tmp-123"), clock.Now(), clock.Now()); err != nil {
+		t.Fatalf("touching file: %s", err)
+	}
+
+	// Reading a secret counts as accessing it, the other one goes idle
+	clock.Advance(20 * time.Minute)
+	if _, ok := cache.Get("db-password"); !ok {
+		t.Fatal("expected db-password to be cached")
+	}
+	clock.Advance(20 * time.Minute)
+	if evicted := evictor.EvictIdle(30 * time.Minute); evicted != 1 {
+		t.Errorf("expected 1 idle file evicted, got %d", evicted)
+	}
+	assertCacheFiles(t, dir, 1)
+	if _, ok := cache.Get("db-password"); !ok {
+		t.Error("expected db-password to stay cached")
+	}
+
+	// Expired files are removed even when they were accessed recently
+	clock.Advance(30 * time.Minute)
+	cache.Set("api-key", "abc")
+	if evicted := evictor.EvictIdle(time.Hour); evicted != 1 {
+		t.Errorf("expected 1 expired file evicted, got %d", evicted)
+	}
+	assertCacheFiles(t, dir, 1)
+
+	// Reading an expired file removes it too
+	clock.Advance(time.Hour)
+	if _, ok := cache.Get("api-key"); ok {
+		t.Error("expected api-key to have expired")
+	}
+	assertCacheFiles(t, dir, 0)
+}
+
+// assertCacheFiles checks how many files are left on the shared cache directory
+func assertCacheFiles(t *testing.T, dir string, expected int) {
+	t.Helper()
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("listing cache directory: %s", err)
+	}
+	if len(files) != expected {
+		var names []string
+		for _, file := range files {
+			names = append(names, file.Name())
+		}
+		t.Errorf("expected %d files, got %v", expected, names)
+	}
+}
+
+func TestNewSharedCacheRestrictsTheDirectory(t *testing.T) {
+	tmp := t.TempDir()
+	open := filepath.Join(tmp, "open")
+	if err := os.Mkdir(open, 0755); err != nil {
+		t.Fatalf("creating directory: %s", err)
+	}
+	file := filepath.Join(tmp, "file")
+	if err := ioutil.WriteFile(file, nil, 0600); err != nil {
+		t.Fatalf("writing file: %s", err)
+	}
+	link := filepath.Join(tmp, "link")
+	if err := os.Symlink(open, link); err != nil {
+		t.Fatalf("creating link: %s", err)
+	}
+
+	tests := []struct {
+		name        string
+		dir         string
+		expectedErr bool
+	}{
+		{name: "new", dir: filepath.Join(tmp, "new", "cache")},
+		{name: "readable by others", dir: open},
+		{name: "file", dir: file, expectedErr: true},
+		{name: "link", dir: link, expectedErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := NewSharedCache(test.dir, time.Hour, nil)
+			if (err != nil) != test.expectedErr {
+				t.Fatalf("expected error %t, got %v", test.expectedErr, err)
+			}
+			if err != nil {
+				return
+			}
+
+			info, err := os.Stat(test.dir)
+			if err != nil {
+				t.Fatalf("stating directory: %s", err)
+			}
+			if info.Mode().Perm() != 0700 {
+				t.Errorf("expected mode 0700, got %o", info.Mode().Perm())
+			}
+		})
+	}
+}
+
+// gatedProvider holds fetches until it is released, counting them
+type gatedProvider struct {
+	calls   *atomic.Int32
+	started chan struct{}
+	release chan struct{}
+}
+
+func (p gatedProvider) GetSecret(ctx context.Context, name string) (string, error) {
+	p.calls.Add(1)
+	p.started <- struct{}{}
+	<-p.release
+	return "hunter2", nil
+}
+
+func TestSharedCacheFetchesOnceAcrossProcesses(t *testing.T) {
+	dir := t.TempDir()
+	var calls atomic.Int32
+	provider := gatedProvider{calls: &calls, started: make(chan struct{}, 2), release: make(chan struct{})}
+
+	// Every getter has its own cache on the directory, as processes on the same node do
+	results := make(chan string, 2)
+	resolve := func() {
+		cache, err := NewSharedCache(dir, time.Hour, nil)
+		if err != nil {
+			t.Errorf("creating cache: %s", err)
+			results <- ""
+			return
+		}
+		sg := SecretGetter{Provider: provider, Cache: cache, RequireFallback: true}
+		resolution, err := sg.Resolve("db-password", "")
+		if err != nil {
+			t.Errorf("resolving: %s", err)
+		}
+		results <- resolution.Value
+	}
+
+	go resolve()
+	<-provider.started
+	go resolve()
+
+	// The second process waits on the lock instead of fetching, then reads what the first one cached
+	select {
+	case <-provider.started:
+		t.Fatal("expected the second process to wait for the first one")
+	case <-time.After(10 * sharedCacheLockInterval):
+	}
+	close(provider.release)
+	for i := 0; i < 2; i++ {
+		if value := <-results; value != "hunter2" {
+			t.Errorf("expected hunter2, got %q", value)
+		}
+	}
+	if calls.Load() != 1 {
+		t.Errorf("expected 1 fetch, got %d", calls.Load())
+	}
+}
+
+func TestSharedCacheLockGivesUpWithTheContext(t *testing.T) {
+	dir := t.TempDir()
+	first, err := NewSharedCache(dir, time.Hour, nil)
+	if err != nil {
+		t.Fatalf("creating cache: %s", err)
+	}
+	second, err := NewSharedCache(dir, time.Hour, nil)
+	if err != nil {
+		t.Fatalf("creating cache: %s", err)
+	}
+
+	unlock, err := first.(CacheLocker).Lock(context.Background(), "db-password")
+	if err != nil {
+		t.Fatalf("locking: %s", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*sharedCacheLockInterval)
+	defer cancel()
+	if _, err := second.(CacheLocker).Lock(ctx, "db-password"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected the deadline, got %v", err)
+	}
+
+	// Other secrets are not held by the lock, and the lock is free once released
+	otherUnlock, err := second.(CacheLocker).Lock(context.Background(), "api-key")
+	if err != nil {
+		t.Fatalf("locking other secret: %s", err)
+	}
+	otherUnlock()
+	unlock()
+	secondUnlock, err := second.(CacheLocker).Lock(context.Background(), "db-password")
+	if err != nil {
+		t.Fatalf("locking after release: %s", err)
+	}
+	secondUnlock()
+
+	// Lock files are not secrets
+	if names := first.Names(); len(names) != 0 {
+		t.Errorf("expected no secrets, got %v", names)
+	}
+}
@@ -0,0 +1,29 @@
+//go:build unix
+
+package secrets
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// ownedByAnotherUser tells if the file belongs to a user other than the one running the process
+func ownedByAnotherUser(info os.FileInfo) bool {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	return ok && int(stat.Uid) != os.Getuid()
+}
+
+// tryLockFile takes an exclusive lock on the file without waiting, telling if another process holds it
+func tryLockFile(file *os.File) (bool, error) {
+	err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if errors.Is(err, syscall.EWOULDBLOCK) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// unlockFile releases the lock taken by tryLockFile
+func unlockFile(file *os.File) {
+	_ = syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+}
@@ -0,0 +1,115 @@
+package secrets
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"syscall"
+)
+
+// SourceChain is the source of values coming from a chain of providers
+const SourceChain Source = "chain"
+
+func init() {
+	RegisterProvider("chain", newChainProviderFromEnv)
+}
+
+// chainStep is a provider of the chain, and whether its errors end the resolution or move on to the next step
+type chainStep struct {
+	Name        string
+	Provider    SecretProvider
+	SkipOnError bool
+}
+
+// ChainProvider tries its providers in order, moving on to the next one when a secret is not found
+// What is not found anywhere ends on the fallback, which is the literal default at the end of every chain
+type ChainProvider struct {
+	Steps []chainStep
+}
+
+// Source tells the values come from a chain of providers
+func (p ChainProvider) Source() Source {
+	return SourceChain
+}
+
+// GetSecret gets the secret from the first step that has it
+// Errors of steps that fail end the resolution, and the ones of steps that skip on error are returned when no later
+// step has the secret, as a secret skipped that way may exist and should not be taken as missing
+func (p ChainProvider) GetSecret(ctx context.Context, name string) (string, error) {
+	var skipped []error
+	for _, step := range p.Steps {
+		value, err := step.Provider.GetSecret(ctx, name)
+		switch {
+		case err == nil:
+			slog.Debug("resolved secret on the chain", "name", name, "provider", step.Name)
+			return value, nil
+		case errors.Is(err, ErrSecretNotFound):
+			continue
+		case step.SkipOnError:
+			slog.Warn("skipping failing provider of the chain", "name", name, "provider", step.Name, "error", err)
+			skipped = append(skipped, fmt.Errorf("%s: %w", step.Name, err))
+		default:
+			return "", fmt.Errorf("%s: %w", step.Name, err)
+		}
+	}
+
+	if len(skipped) > 0 {
+		return "", errors.Join(skipped...)
+	}
+	return "", fmt.Errorf("%w: on every provider of the chain", ErrSecretNotFound)
+}
+
+// envProvider gets secrets from the environment variables, for chains falling back to them
+type envProvider struct{}
+
+// Source tells the values come from the environment
+func (envProvider) Source() Source {
+	return SourceEnv
+}
+
+func (envProvider) GetSecret(ctx context.Context, name string) (string, error) {
+	value, ok := syscall.Getenv(name)
+	if !ok {
+		return "", ErrSecretNotFound
+	}
+	return value, nil
+}
+
+// newChainProviderFromEnv builds the chain on SECRET_CHAIN, a comma separated list of backends tried in order
+// Every backend can be followed by :skip to move on when it fails, or :fail, the default, to end the resolution
+func newChainProviderFromEnv() (SecretProvider, error) {
+	chain := GetEnv("SECRET_CHAIN", "")
+	if chain == "" {
+		return nil, errors.New("SECRET_CHAIN is required for the chain backend")
+	}
+
+	var steps []chainStep
+	for _, entry := range strings.Split(chain, ",") {
+		name, behavior, _ := strings.Cut(strings.TrimSpace(entry), ":")
+		step := chainStep{Name: name}
+		switch behavior {
+		case "", "fail":
+		case "skip":
+			step.SkipOnError = true
+		default:
+			return nil, fmt.Errorf("SECRET_CHAIN: unknown behavior %q of %s, expected skip or fail", behavior, name)
+		}
+
+		switch name {
+		case "env":
+			step.Provider = envProvider{}
+		case "chain":
+			return nil, errors.New("SECRET_CHAIN: a chain cannot contain another chain")
+		default:
+			provider, err := NewProvider(name)
+			if err != nil {
+				return nil, fmt.Errorf("SECRET_CHAIN: %w", err)
+			}
+			step.Provider = provider
+		}
+		steps = append(steps, step)
+	}
+	return ChainProvider{Steps: steps}, nil
+}
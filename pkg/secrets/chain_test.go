@@ -0,0 +1,116 @@
+package secrets
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+)
+
+func TestChainProvider(t *testing.T) {
+	unavailable := withStatus(http.StatusServiceUnavailable, errors.New("unavailable"))
+	missing := failingProvider{err: ErrSecretNotFound}
+	failing := failingProvider{err: unavailable}
+
+	tests := []struct {
+		name          string
+		steps         []chainStep
+		expectedValue string
+		expectedErr   error
+	}{
+		{
+			name:          "first step that has it",
+			steps:         []chainStep{{Name: "first", Provider: missing}, {Name: "second", Provider: staticProvider{}}},
+			expectedValue: "static",
+		},
+		{
+			name:        "missing everywhere",
+			steps:       []chainStep{{Name: "first", Provider: missing}, {Name: "second", Provider: missing}},
+			expectedErr: ErrSecretNotFound,
+		},
+		{
+			name:        "failing step ends the resolution",
+			steps:       []chainStep{{Name: "first", Provider: failing}, {Name: "second", Provider: staticProvider{}}},
+			expectedErr: unavailable,
+		},
+		{
+			name:          "skipped step moves on",
+			steps:         []chainStep{{Name: "first", Provider: failing, SkipOnError: true}, {Name: "second", Provider: staticProvider{}}},
+			expectedValue: "static",
+		},
+		{
+			name:        "skipped step is not taken as missing",
+			steps:       []chainStep{{Name: "first", Provider: failing, SkipOnError: true}, {Name: "second", Provider: missing}},
+			expectedErr: unavailable,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			value, err := ChainProvider{Steps: test.steps}.GetSecret(context.Background(), "db-password")
+			if value != test.expectedValue {
+				t.Errorf("expected %q, got %q", test.expectedValue, value)
+			}
+			if test.expectedErr == nil && err != nil {
+				t.Errorf("expected no error, got %s", err)
+			}
+			if test.expectedErr != nil && !errors.Is(err, test.expectedErr) {
+				t.Errorf("expected %v, got %v", test.expectedErr, err)
+			}
+			if errors.Is(err, unavailable) && errors.Is(err, ErrSecretNotFound) {
+				t.Errorf("expected a failure not to be taken as missing, got %v", err)
+			}
+		})
+	}
+}
+
+func TestNewChainProviderFromEnv(t *testing.T) {
+	tests := []struct {
+		name          string
+		chain         string
+		expectedSteps []chainStep
+		expectedErr   bool
+	}{
+		{
+			name:          "behaviors",
+			chain:         "memory:skip, env:fail,memory",
+			expectedSteps: []chainStep{{Name: "memory", SkipOnError: true}, {Name: "env"}, {Name: "memory"}},
+		},
+		{name: "empty", chain: "", expectedErr: true},
+		{name: "unknown behavior", chain: "env:retry", expectedErr: true},
+		{name: "unknown backend", chain: "env,nowhere", expectedErr: true},
+		{name: "nested chain", chain: "env,chain", expectedErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Setenv("SECRET_CHAIN", test.chain)
+			provider, err := newChainProviderFromEnv()
+			if (err != nil) != test.expectedErr {
+				t.Fatalf("expected error %t, got %v", test.expectedErr, err)
+			}
+			if err != nil {
+				return
+			}
+
+			steps := provider.(ChainProvider).Steps
+			if len(steps) != len(test.expectedSteps) {
+				t.Fatalf("expected %d steps, got %d", len(test.expectedSteps), len(steps))
+			}
+			for i, step := range steps {
+				if step.Name != test.expectedSteps[i].Name || step.SkipOnError != test.expectedSteps[i].SkipOnError || step.Provider == nil {
+					t.Errorf("step %d: expected %+v, got %+v", i, test.expectedSteps[i], step)
+				}
+			}
+		})
+	}
+}
+
+func TestChainProviderFallsBackToEnv(t *testing.T) {
+	t.Setenv("CHAIN_TEST_SECRET", "from-env")
+	chain := ChainProvider{Steps: []chainStep{{Name: "memory", Provider: NewMemoryProvider(nil)}, {Name: "env", Provider: envProvider{}}}}
+	value, err := chain.GetSecret(context.Background(), "CHAIN_TEST_SECRET")
+	if err != nil || value != "from-env" {
+		t.Errorf("expected from-env, got %q and %v", value, err)
+	}
+}
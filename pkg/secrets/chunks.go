@@ -0,0 +1,72 @@
+package secrets
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// maxChunks bounds how many parts are read for a single secret
+const maxChunks = 1000
+
+// validateChunkNameFormat checks the format takes the base name and then the part number, and gives every part its own
+// name, so a typo fails on start instead of reading every secret as a regular one
+func validateChunkNameFormat(format string) error {
+	first, second := fmt.Sprintf(format, "name", 0), fmt.Sprintf(format, "name", 1)
+	if strings.Contains(first, "%!") || !strings.Contains(first, "name") || first == second {
+		return fmt.Errorf("invalid format %q, expected a %%s for the name followed by a %%d for the part", format)
+	}
+	return nil
+}
+
+// fetchChunkedSecret gets a version of a secret stored on sequential parts and concatenates them
+// Parts are rotated together, every rotation adding a version to each of them, so they share version numbers; the
+// version of the first part is read on every other one, so a read during a rotation never mixes two of them
+// Parts are read until one is missing, a secret without a first part is read as a regular one
+func (p GCPProvider) fetchChunkedSecret(ctx context.Context, policy RetryPolicy, name string, version string, token string) (string, error) {
+	first, pinned, err := p.accessSecretWithRetry(ctx, policy, p.chunkName(name, 0), version, token)
+	if errors.Is(err, ErrSecretNotFound) {
+		value, _, err := p.accessSecretWithRetry(ctx, policy, name, version, token)
+		return value, err
+	}
+	if err != nil {
+		return "", err
+	}
+
+	var value strings.Builder
+	value.WriteString(first)
+	for part := 1; part < maxChunks; part++ {
+		partValue, _, err := p.accessSecretWithRetry(ctx, policy, p.chunkName(name, part), pinned, token)
+		if err == nil {
+			value.WriteString(partValue)
+			continue
+		}
+		if !errors.Is(err, ErrSecretNotFound) {
+			return "", err
+		}
+
+		// The part either does not exist, which ends the secret, or lacks the version as it was not rotated yet
+		_, latest, err := p.accessSecretWithRetry(ctx, policy, p.chunkName(name, part), "latest", token)
+		if err == nil {
+			return "", fmt.Errorf("secret %s has version %s on part 0 and %s on part %d, the parts were not rotated together", name, pinned, latest, part)
+		}
+		if !errors.Is(err, ErrSecretNotFound) {
+			return "", err
+		}
+
+		// A missing part followed by an existing one means the secret is incomplete
+		_, _, nextErr := p.accessSecretWithRetry(ctx, policy, p.chunkName(name, part+1), pinned, token)
+		if nextErr == nil {
+			return "", fmt.Errorf("secret %s is missing part %d", name, part)
+		}
+		return value.String(), nil
+	}
+
+	return "", fmt.Errorf("secret %s has more than %d parts", name, maxChunks)
+}
+
+// chunkName returns the name of the part of the secret
+func (p GCPProvider) chunkName(name string, part int) string {
+	return fmt.Sprintf(p.ChunkNameFormat, name, part)
+}
@@ -0,0 +1,130 @@
+package secrets
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// versionedUpstream answers the accesses of Secret Manager from the versions of every secret, latest being the last one
+func versionedUpstream(versions map[string][]string) http.HandlerFunc {
+	return func(w http.ResponseWriter, rq *http.Request) {
+		// The path is /v1/projects/<project>/secrets/<secret>/versions/<version>:access
+		parts := strings.Split(strings.TrimSuffix(rq.URL.Path, ":access"), "/")
+		name, version := parts[len(parts)-3], parts[len(parts)-1]
+		values, ok := versions[name]
+		number, err := strconv.Atoi(version)
+		if version == "latest" {
+			number, err = len(values), nil
+		}
+		if !ok || err != nil || number < 1 || number > len(values) {
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"error": {"code": 404, "status": "NOT_FOUND"}}`))
+			return
+		}
+
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"name":    "projects/my-project/secrets/" + name + "/versions/" + strconv.Itoa(number),
+			"payload": map[string]string{"data": base64.StdEncoding.EncodeToString([]byte(values[number-1]))},
+		})
+	}
+}
+
+func TestFetchChunkedSecret(t *testing.T) {
+	tests := []struct {
+		name          string
+		versions      map[string][]string
+		version       string
+		expectedValue string
+		expectedErr   string
+	}{
+		{
+			name:          "reassembled",
+			versions:      map[string][]string{"cert-part-0": {"a1", "a2"}, "cert-part-1": {"b1", "b2"}, "cert-part-2": {"c1", "c2"}},
+			version:       "latest",
+			expectedValue: "a2b2c2",
+		},
+		{
+			name:          "older version",
+			versions:      map[string][]string{"cert-part-0": {"a1", "a2"}, "cert-part-1": {"b1", "b2"}},
+			version:       "1",
+			expectedValue: "a1b1",
+		},
+		{
+			name:          "regular secret",
+			versions:      map[string][]string{"cert": {"whole"}},
+			version:       "latest",
+			expectedValue: "whole",
+		},
+		{
+			name:        "missing part",
+			versions:    map[string][]string{"cert-part-0": {"a1"}, "cert-part-2": {"c1"}},
+			version:     "latest",
+			expectedErr: "missing part 1",
+		},
+		{
+			name:        "first part rotated alone",
+			versions:    map[string][]string{"cert-part-0": {"a1", "a2"}, "cert-part-1": {"b1"}},
+			version:     "latest",
+			expectedErr: "version 2 on part 0 and 1 on part 1",
+		},
+		{
+			name:          "last part rotated first",
+			versions:      map[string][]string{"cert-part-0": {"a1"}, "cert-part-1": {"b1", "b2"}},
+			version:       "latest",
+			expectedValue: "a1b1",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			stubUpstream(t, versionedUpstream(test.versions))
+			provider := GCPProvider{
+				Project: "my-project",
+				Credentials: credentialsFunc(func(ctx context.Context) (GCPToken, error) {
+					return GCPToken{AccessToken: "token", Expiry: time.Now().Add(time.Hour)}, nil
+				}),
+				ChunkNameFormat: "%s-part-%d",
+			}
+
+			value, err := provider.GetSecretVersion(context.Background(), "cert", test.version)
+			if test.expectedErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.expectedErr) {
+					t.Errorf("expected %q, got %v", test.expectedErr, err)
+				}
+				return
+			}
+			if err != nil || value != test.expectedValue {
+				t.Errorf("expected %q, got %q and %v", test.expectedValue, value, err)
+			}
+		})
+	}
+}
+
+func TestValidateChunkNameFormat(t *testing.T) {
+	tests := []struct {
+		format      string
+		expectedErr bool
+	}{
+		{format: "%s-part-%d"},
+		{format: "%s_%02d"},
+		{format: "%s-part", expectedErr: true},
+		{format: "part-%d", expectedErr: true},
+		{format: "%d-%s", expectedErr: true},
+		{format: "%s-%d-%d", expectedErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.format, func(t *testing.T) {
+			err := validateChunkNameFormat(test.format)
+			if (err != nil) != test.expectedErr {
+				t.Errorf("expected error %t, got %v", test.expectedErr, err)
+			}
+		})
+	}
+}
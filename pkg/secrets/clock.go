@@ -0,0 +1,68 @@
+package secrets
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Clock tells the current time, it allows time-based logic to be controlled on tests
+type Clock interface {
+	Now() time.Time
+}
+
+// RealClock is the default Clock, it relies on time.Now
+type RealClock struct{}
+
+func (RealClock) Now() time.Time {
+	return time.Now()
+}
+
+// clockKey is the context key of the clock the calls to the provider tell the time with
+type clockKey struct{}
+
+// WithClock returns a context whose calls to the provider tell the time with the clock, like when checking if a
+// token expired or signing a request, so tests can move the time the providers see
+func WithClock(ctx context.Context, clock Clock) context.Context {
+	return context.WithValue(ctx, clockKey{}, clock)
+}
+
+// clockFromContext returns the clock of the context, the real one when there is none
+func clockFromContext(ctx context.Context) Clock {
+	if clock, ok := ctx.Value(clockKey{}).(Clock); ok && clock != nil {
+		return clock
+	}
+	return RealClock{}
+}
+
+// FakeClock is a deterministic Clock that only moves when it is told to
+type FakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+// NewFakeClock returns a FakeClock stopped at the given time
+func NewFakeClock(now time.Time) *FakeClock {
+	return &FakeClock{now: now}
+}
+
+// Now returns the time the clock is stopped at
+func (c *FakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// Advance moves the clock forward by the given duration
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// Set stops the clock at the given time
+func (c *FakeClock) Set(now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = now
+}
@@ -0,0 +1,149 @@
+package secrets
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestTokenCachesFollowTheClock(t *testing.T) {
+	clock := NewFakeClock(time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC))
+	var calls atomic.Int32
+
+	tests := []struct {
+		name string
+		// answer is the body of the token endpoint, which can tell the expiry from the clock
+		answer func() string
+		// get gets a token through the cache
+		get func(ctx context.Context) error
+		// lifetime is how long the token is valid, and window how long before expiring it is fetched again
+		lifetime time.Duration
+		window   time.Duration
+	}{
+		{
+			name:   "gcp",
+			answer: func() string { return `{"access_token":"token","expires_in":3600}` },
+			get: func() func(ctx context.Context) error {
+				credentials := &CachedCredentials{Credentials: metadataCredentials{}}
+				return func(ctx context.Context) error {
+					_, err := credentials.Token(ctx)
+					return err
+				}
+			}(),
+			lifetime: time.Hour,
+			window:   tokenRefreshWindow,
+		},
+		{
+			name:   "vault",
+			answer: func() string { return `{"auth":{"client_token":"token","lease_duration":3600}}` },
+			get: func() func(ctx context.Context) error {
+				auth := &vaultAuth{method: "approle", mount: "approle", login: func() (map[string]string, error) {
+					return map[string]string{"role_id": "role"}, nil
+				}}
+				return func(ctx context.Context) error {
+					_, err := auth.get(ctx, VaultProvider{Address: "http://vault:8200"})
+					return err
+				}
+			}(),
+			lifetime: time.Hour,
+			window:   vaultTokenRefreshWindow,
+		},
+		{
+			name:   "conjur",
+			answer: func() string { return `{"protected":"token"}` },
+			get: func() func(ctx context.Context) error {
+				auth := &conjurAuth{login: "host/app", apiKey: func() (string, error) { return "key", nil }}
+				return func(ctx context.Context) error {
+					_, err := auth.get(ctx, ConjurProvider{Address: "http://conjur", Account: "acme"})
+					return err
+				}
+			}(),
+			lifetime: conjurTokenLifetime,
+		},
+		{
+			name: "azure",
+			answer: func() string {
+				expiresOn := clock.Now().Add(time.Hour).Unix()
+				return fmt.Sprintf(`{"access_token":"token","expires_on":"%s"}`, strconv.FormatInt(expiresOn, 10))
+			},
+			get: func() func(ctx context.Context) error {
+				identity := &azureManagedIdentity{}
+				return func(ctx context.Context) error {
+					_, err := identity.get(ctx)
+					return err
+				}
+			}(),
+			lifetime: time.Hour,
+			window:   azureTokenRefreshWindow,
+		},
+		{
+			name:   "aws",
+			answer: func() string { return "" },
+			get: func() func(ctx context.Context) error {
+				chain := &awsCredentialsChain{fetch: func(ctx context.Context) (awsCredentials, error) {
+					calls.Add(1)
+					return awsCredentials{AccessKeyID: "id", SecretAccessKey: "secret", Expiration: clock.Now().Add(time.Hour)}, nil
+				}}
+				return func(ctx context.Context) error {
+					_, err := chain.get(ctx)
+					return err
+				}
+			}(),
+			lifetime: time.Hour,
+			window:   awsCredentialsRefreshWindow,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Setenv("IDENTITY_ENDPOINT", "")
+			calls.Store(0)
+			stubUpstream(t, func(w http.ResponseWriter, rq *http.Request) {
+				calls.Add(1)
+				_, _ = w.Write([]byte(test.answer()))
+			})
+			ctx := WithClock(context.Background(), clock)
+
+			get := func(expectedCalls int32) {
+				t.Helper()
+				if err := test.get(ctx); err != nil {
+					t.Fatalf("getting token: %s", err)
+				}
+				if calls.Load() != expectedCalls {
+					t.Errorf("expected %d fetches at %s, got %d", expectedCalls, clock.Now(), calls.Load())
+				}
+			}
+
+			get(1)
+			get(1)
+
+			// Right before the refresh window the token is still used, and right after it is fetched again
+			clock.Advance(test.lifetime - test.window - time.Second)
+			get(1)
+			clock.Advance(2 * time.Second)
+			get(2)
+			get(2)
+		})
+	}
+}
+
+func TestClockFromContext(t *testing.T) {
+	if _, ok := clockFromContext(context.Background()).(RealClock); !ok {
+		t.Error("expected the real clock without one on the context")
+	}
+
+	clock := NewFakeClock(time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC))
+	if clockFromContext(WithClock(context.Background(), clock)) != clock {
+		t.Error("expected the clock of the context")
+	}
+
+	// The getter passes its clock to the calls to the provider
+	sg := SecretGetter{Clock: clock}
+	if clockFromContext(sg.providerContext(context.Background(), "db-password")) != clock {
+		t.Error("expected the clock of the getter")
+	}
+}
@@ -0,0 +1,89 @@
+package secrets
+
+import (
+	"fmt"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// LookupEnv returns the value for an environment value, or the value on the config file if not set
+func LookupEnv(name string) (string, bool) {
+	if value, ok := syscall.Getenv(name); ok {
+		return value, true
+	}
+	return configValue(name)
+}
+
+// GetEnv returns the value for an environment value, or a fallback if not found
+func GetEnv(name string, fallback string) string {
+	value, ok := LookupEnv(name)
+	if !ok {
+		return fallback
+	}
+	return value
+}
+
+// GetEnvBool returns the boolean value for an environment value, or a fallback if not found
+func GetEnvBool(name string, fallback bool) (bool, error) {
+	value, ok := LookupEnv(name)
+	if !ok || value == "" {
+		return fallback, nil
+	}
+
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", name, err)
+	}
+	return parsed, nil
+}
+
+// GetEnvInt returns the integer value for an environment value, or a fallback if not found
+func GetEnvInt(name string, fallback int) (int, error) {
+	value, ok := LookupEnv(name)
+	if !ok || value == "" {
+		return fallback, nil
+	}
+
+	parsed, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", name, err)
+	}
+	return parsed, nil
+}
+
+// GetEnvDuration returns the duration value for an environment value, or a fallback if not found
+func GetEnvDuration(name string, fallback time.Duration) (time.Duration, error) {
+	value, ok := LookupEnv(name)
+	if !ok || value == "" {
+		return fallback, nil
+	}
+
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", name, err)
+	}
+	return parsed, nil
+}
+
+// getRetryPolicy returns the retry policy from the <prefix>_RETRY_ATTEMPTS, <prefix>_RETRY_BASE_DELAY,
+// <prefix>_RETRY_MAX_DELAY and <prefix>_TIMEOUT environment values, the ones missing are taken from the defaults
+func getRetryPolicy(prefix string, defaults RetryPolicy) (RetryPolicy, error) {
+	attempts, err := GetEnvInt(prefix+"_RETRY_ATTEMPTS", defaults.Attempts)
+	if err != nil {
+		return RetryPolicy{}, err
+	}
+	baseDelay, err := GetEnvDuration(prefix+"_RETRY_BASE_DELAY", defaults.BaseDelay)
+	if err != nil {
+		return RetryPolicy{}, err
+	}
+	maxDelay, err := GetEnvDuration(prefix+"_RETRY_MAX_DELAY", defaults.MaxDelay)
+	if err != nil {
+		return RetryPolicy{}, err
+	}
+	timeout, err := GetEnvDuration(prefix+"_TIMEOUT", defaults.Timeout)
+	if err != nil {
+		return RetryPolicy{}, err
+	}
+	return RetryPolicy{Attempts: attempts, BaseDelay: baseDelay, MaxDelay: maxDelay, Timeout: timeout}, nil
+}
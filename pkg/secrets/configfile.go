@@ -0,0 +1,225 @@
+package secrets
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var (
+	configMu sync.RWMutex
+	// configValues are the values of the config file by environment variable name, environment variables override them
+	configValues = map[string]string{}
+)
+
+// configValue returns the value of the config file for the environment variable, if it has one
+func configValue(name string) (string, bool) {
+	configMu.RLock()
+	defer configMu.RUnlock()
+	value, ok := configValues[name]
+	return value, ok
+}
+
+// SetConfigValues replaces the values of the config file, returning the previous ones
+func SetConfigValues(values map[string]string) map[string]string {
+	configMu.Lock()
+	defer configMu.Unlock()
+	previous := configValues
+	configValues = values
+	return previous
+}
+
+// LoadConfigFile reads the YAML or TOML config file, telling them apart by the extension
+// Keys are the environment variables in lowercase, nested sections are joined with an underscore,
+// so tls.cert_file sets TLS_CERT_FILE, and lists are joined with commas like the variables expect
+func LoadConfigFile(path string) (map[string]string, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return parseYAMLConfig(content)
+	case ".toml":
+		return parseTOMLConfig(content)
+	default:
+		return nil, fmt.Errorf("unknown config format %q, expected .yaml, .yml or .toml", filepath.Ext(path))
+	}
+}
+
+// configKey returns the environment variable name for the key on the section
+func configKey(section string, key string) string {
+	name := strings.ToUpper(strings.ReplaceAll(key, "-", "_"))
+	if section == "" {
+		return name
+	}
+	return section + "_" + name
+}
+
+// yamlSection is a mapping of the YAML file that is still open, with the indentation of its keys
+type yamlSection struct {
+	indent int
+	name   string
+}
+
+// parseYAMLConfig parses the subset of YAML config files use: nested mappings of scalars, and lists of scalars
+// either inline or one item per line
+func parseYAMLConfig(content []byte) (map[string]string, error) {
+	values := map[string]string{}
+	sections := []yamlSection{{indent: -1}}
+	// list is the key whose items are being read, it is empty when the last key was not a list
+	var list string
+
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		text := scanner.Text()
+		line := strings.TrimSpace(stripConfigComment(text))
+		if line == "" || line == "---" {
+			continue
+		}
+		indent := len(text) - len(strings.TrimLeft(text, " "))
+		if strings.HasPrefix(strings.TrimLeft(text, " "), "\t") {
+			return nil, fmt.Errorf("line %d: tabs are not allowed for indentation", lineNumber)
+		}
+
+		// Items belong to the last key, which must have been left without a value
+		if line == "-" || strings.HasPrefix(line, "- ") {
+			if list == "" {
+				return nil, fmt.Errorf("line %d: list item without a key", lineNumber)
+			}
+			item, err := parseConfigScalar(strings.TrimSpace(strings.TrimPrefix(line, "-")))
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", lineNumber, err)
+			}
+			if values[list] != "" {
+				item = values[list] + "," + item
+			}
+			values[list] = item
+			continue
+		}
+
+		key, value, ok := strings.Cut(line, ":")
+		if !ok || strings.TrimSpace(key) == "" {
+			return nil, fmt.Errorf("line %d: expected key: value", lineNumber)
+		}
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+
+		for indent <= sections[len(sections)-1].indent {
+			sections = sections[:len(sections)-1]
+		}
+		name := configKey(sections[len(sections)-1].name, key)
+
+		// A key without a value opens a section, or a list when its next line is an item
+		if value == "" {
+			sections = append(sections, yamlSection{indent: indent, name: name})
+			list = name
+			continue
+		}
+		list = ""
+
+		parsed, err := parseConfigValue(value)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNumber, err)
+		}
+		values[name] = parsed
+	}
+	return values, scanner.Err()
+}
+
+// parseTOMLConfig parses the subset of TOML config files use: tables of key = value pairs with scalars and inline arrays
+func parseTOMLConfig(content []byte) (map[string]string, error) {
+	values := map[string]string{}
+	var section string
+
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(stripConfigComment(scanner.Text()))
+		if line == "" {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section = ""
+			for _, name := range strings.Split(strings.Trim(line, "[]"), ".") {
+				section = configKey(section, strings.TrimSpace(name))
+			}
+			continue
+		}
+
+		key, value, ok := strings.Cut(line, "=")
+		if !ok || strings.TrimSpace(key) == "" {
+			return nil, fmt.Errorf("line %d: expected key = value", lineNumber)
+		}
+
+		parsed, err := parseConfigValue(strings.TrimSpace(value))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNumber, err)
+		}
+		values[configKey(section, strings.TrimSpace(key))] = parsed
+	}
+	return values, scanner.Err()
+}
+
+// parseConfigValue parses a scalar or an inline list, which is joined with commas
+func parseConfigValue(value string) (string, error) {
+	if !strings.HasPrefix(value, "[") {
+		return parseConfigScalar(value)
+	}
+	if !strings.HasSuffix(value, "]") {
+		return "", fmt.Errorf("unterminated list %s", value)
+	}
+
+	items := []string{}
+	for _, item := range strings.Split(strings.TrimSuffix(strings.TrimPrefix(value, "["), "]"), ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		parsed, err := parseConfigScalar(item)
+		if err != nil {
+			return "", err
+		}
+		items = append(items, parsed)
+	}
+	return strings.Join(items, ","), nil
+}
+
+// parseConfigScalar unquotes double quoted strings with their escapes and single quoted ones, others are kept as is
+func parseConfigScalar(value string) (string, error) {
+	switch {
+	case len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"':
+		return strconv.Unquote(value)
+	case len(value) >= 2 && value[0] == '\'' && value[len(value)-1] == '\'':
+		return strings.ReplaceAll(value[1:len(value)-1], "''", "'"), nil
+	case strings.HasPrefix(value, "\"") || strings.HasPrefix(value, "'"):
+		return "", fmt.Errorf("unterminated string %s", value)
+	default:
+		return value, nil
+	}
+}
+
+// stripConfigComment removes a # comment from the line, unless the # is quoted or part of a value
+func stripConfigComment(line string) string {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		switch c := line[i]; {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#' && (i == 0 || line[i-1] == ' ' || line[i-1] == '\t'):
+			return line[:i]
+		}
+	}
+	return line
+}
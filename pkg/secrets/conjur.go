@@ -0,0 +1,197 @@
+package secrets
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SourceConjur is the source of values coming from CyberArk Conjur
+const SourceConjur Source = "conjur"
+
+// conjurTokenLifetime is how long access tokens are used, Conjur issues them for 8 minutes
+const conjurTokenLifetime = 6 * time.Minute
+
+func init() {
+	RegisterProvider("conjur", newConjurProviderFromEnv)
+}
+
+// ConjurProvider gets secrets from the variables of CyberArk Conjur, authenticating as a host or user with its API key
+type ConjurProvider struct {
+	// Address is the base URL of the Conjur appliance, like https://conjur.example.com
+	Address string
+	Account string
+	// Prefix is the policy path the variables are under, like myapp/prod/, as names cannot have slashes
+	Prefix string
+	// Retry applies to every secret fetch
+	Retry RetryPolicy
+	auth  *conjurAuth
+}
+
+// Source tells the values come from Conjur
+func (p ConjurProvider) Source() Source {
+	return SourceConjur
+}
+
+// GetSecret gets the value of the variable, retried according to Retry
+func (p ConjurProvider) GetSecret(ctx context.Context, name string) (string, error) {
+	var value string
+	err := p.Retry.do(ctx, func(ctx context.Context) error {
+		var err error
+		value, err = p.fetchSecret(ctx, name)
+		// Tokens are short lived and are refused once expired, authenticating again tells it apart from a real denial
+		if errors.Is(err, ErrPermissionDenied) && p.auth.invalidate() {
+			value, err = p.fetchSecret(ctx, name)
+		}
+		return err
+	})
+	return value, err
+}
+
+// fetchSecret reads the current value of the variable
+func (p ConjurProvider) fetchSecret(ctx context.Context, name string) (string, error) {
+	token, err := p.auth.get(ctx, p)
+	if err != nil {
+		return "", err
+	}
+
+	secretUrl := fmt.Sprintf("%s/secrets/%s/variable/%s", p.Address, url.PathEscape(p.Account), url.PathEscape(p.Prefix+name))
+	rq, err := http.NewRequestWithContext(ctx, http.MethodGet, secretUrl, nil)
+	if err != nil {
+		return "", err
+	}
+	rq.Header.Set("Authorization", fmt.Sprintf(`Token token="%s"`, token))
+	rs, err := UpstreamClient.Do(rq)
+	if err != nil {
+		return "", err
+	}
+
+	bytes, err := ReadBody(rs)
+	if err != nil {
+		return "", err
+	}
+	if rs.StatusCode != http.StatusOK {
+		return "", conjurError(rs.StatusCode, bytes)
+	}
+	return string(bytes), nil
+}
+
+// conjurError maps the answers of Conjur, not found and permission denied can be told apart with errors.Is
+func conjurError(statusCode int, body []byte) error {
+	message := strings.TrimSpace(string(body))
+	switch statusCode {
+	case http.StatusNotFound:
+		return fmt.Errorf("%w: error %d - %s", ErrSecretNotFound, statusCode, message)
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return fmt.Errorf("%w: error %d - %s", ErrPermissionDenied, statusCode, message)
+	default:
+		return withStatus(statusCode, fmt.Errorf("error %d - %s", statusCode, message))
+	}
+}
+
+// conjurAuth gets the access token requests are sent with, authenticating again before it expires
+type conjurAuth struct {
+	login string
+	// apiKey reads the API key, on every authentication so rotated files are read again
+	apiKey func() (string, error)
+
+	mu        sync.Mutex
+	token     string
+	expiresAt time.Time
+}
+
+// get returns the current token, authenticating when there is none or it is about to expire
+// The token is returned base64 encoded, the way the Authorization header takes it
+func (a *conjurAuth) get(ctx context.Context, p ConjurProvider) (string, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.token != "" && clockFromContext(ctx).Now().Before(a.expiresAt) {
+		return a.token, nil
+	}
+
+	apiKey, err := a.apiKey()
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrTokenUnavailable, err)
+	}
+	authnUrl := fmt.Sprintf("%s/authn/%s/%s/authenticate", p.Address, url.PathEscape(p.Account), url.PathEscape(a.login))
+	ctx, span := StartSpan(ctx, "token fetch")
+	rq, err := http.NewRequestWithContext(ctx, http.MethodPost, authnUrl, strings.NewReader(apiKey))
+	if err != nil {
+		span.End(err)
+		return "", fmt.Errorf("%w: %v", ErrTokenUnavailable, err)
+	}
+	rq.Header.Set("Content-Type", "text/plain")
+	rs, err := UpstreamClient.Do(rq)
+	span.End(err)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrTokenUnavailable, err)
+	}
+	bytes, err := ReadBody(rs)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrTokenUnavailable, err)
+	}
+	if rs.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%w: authenticating as %s: %v", ErrTokenUnavailable, a.login, conjurError(rs.StatusCode, bytes))
+	}
+
+	a.token = base64.StdEncoding.EncodeToString(bytes)
+	a.expiresAt = clockFromContext(ctx).Now().Add(conjurTokenLifetime)
+	return a.token, nil
+}
+
+// invalidate drops the token so the next request authenticates again, it tells if there was a token to drop
+func (a *conjurAuth) invalidate() bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	dropped := a.token != ""
+	a.token = ""
+	return dropped
+}
+
+// newConjurProviderFromEnv builds the Conjur provider from the environment values the Conjur clients use
+func newConjurProviderFromEnv() (SecretProvider, error) {
+	address := strings.TrimSuffix(GetEnv("CONJUR_APPLIANCE_URL", ""), "/")
+	account := GetEnv("CONJUR_ACCOUNT", "")
+	login := GetEnv("CONJUR_AUTHN_LOGIN", "")
+	if address == "" || account == "" || login == "" {
+		return nil, errors.New("CONJUR_APPLIANCE_URL, CONJUR_ACCOUNT and CONJUR_AUTHN_LOGIN are required for the conjur backend")
+	}
+
+	auth := &conjurAuth{login: login}
+	if apiKeyFile := GetEnv("CONJUR_AUTHN_API_KEY_FILE", ""); apiKeyFile != "" {
+		auth.apiKey = func() (string, error) {
+			return readSecretFile(apiKeyFile)
+		}
+	} else if apiKey := GetEnv("CONJUR_AUTHN_API_KEY", ""); apiKey != "" {
+		auth.apiKey = func() (string, error) {
+			return apiKey, nil
+		}
+	} else {
+		return nil, errors.New("CONJUR_AUTHN_API_KEY or CONJUR_AUTHN_API_KEY_FILE is required for the conjur backend")
+	}
+
+	prefix := GetEnv("CONJUR_VARIABLE_PREFIX", "")
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+
+	retry, err := getRetryPolicy("CONJUR", upstreamRetryPolicy)
+	if err != nil {
+		return nil, err
+	}
+
+	return ConjurProvider{
+		Address: address,
+		Account: account,
+		Prefix:  prefix,
+		Retry:   retry,
+		auth:    auth,
+	}, nil
+}
@@ -0,0 +1,139 @@
+package secrets
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// SourceConsul is the source of values coming from Consul KV
+const SourceConsul Source = "consul"
+
+func init() {
+	RegisterProvider("consul", newConsulProviderFromEnv)
+}
+
+// ConsulProvider gets secrets from the KV store of Consul, every secret is a key under the prefix
+type ConsulProvider struct {
+	// Address is the base URL of the Consul agent, like http://127.0.0.1:8500
+	Address string
+	// Token is the ACL token sent on every request, it is optional when ACLs are disabled
+	Token string
+	// Prefix is the folder the keys are under, like myapp/prod/, as names cannot have slashes
+	Prefix string
+	// Datacenter and Namespace are only sent when set, the ones of the agent are used otherwise
+	Datacenter string
+	Namespace  string
+	// Retry applies to every secret fetch
+	Retry RetryPolicy
+}
+
+// Source tells the values come from Consul KV
+func (p ConsulProvider) Source() Source {
+	return SourceConsul
+}
+
+// GetSecret gets the value of the key, retried according to Retry
+func (p ConsulProvider) GetSecret(ctx context.Context, name string) (string, error) {
+	var value string
+	err := p.Retry.do(ctx, func(ctx context.Context) error {
+		var err error
+		value, err = p.fetchSecret(ctx, name)
+		return err
+	})
+	return value, err
+}
+
+// fetchSecret reads the raw value of the key, so it is not base64 encoded as on the JSON answer
+func (p ConsulProvider) fetchSecret(ctx context.Context, name string) (string, error) {
+	query := url.Values{}
+	query.Set("raw", "true")
+	if p.Datacenter != "" {
+		query.Set("dc", p.Datacenter)
+	}
+	if p.Namespace != "" {
+		query.Set("ns", p.Namespace)
+	}
+
+	rq, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("%s/v1/kv/%s?%s", p.Address, p.Prefix+name, query.Encode()), nil)
+	if err != nil {
+		return "", err
+	}
+	if p.Token != "" {
+		rq.Header.Set("X-Consul-Token", p.Token)
+	}
+	rs, err := UpstreamClient.Do(rq)
+	if err != nil {
+		return "", err
+	}
+
+	bytes, err := ReadBody(rs)
+	if err != nil {
+		return "", err
+	}
+	if rs.StatusCode != http.StatusOK {
+		return "", consulError(rs.StatusCode, bytes)
+	}
+	return string(bytes), nil
+}
+
+// consulError maps the answers of Consul, not found and permission denied can be told apart with errors.Is
+// Consul answers errors as text, and missing keys with an empty body
+func consulError(statusCode int, body []byte) error {
+	message := strings.TrimSpace(string(body))
+	switch statusCode {
+	case http.StatusNotFound:
+		return fmt.Errorf("%w: error %d - %s", ErrSecretNotFound, statusCode, message)
+	case http.StatusForbidden:
+		return fmt.Errorf("%w: error %d - %s", ErrPermissionDenied, statusCode, message)
+	default:
+		return withStatus(statusCode, fmt.Errorf("error %d - %s", statusCode, message))
+	}
+}
+
+// newConsulProviderFromEnv builds the Consul provider from the environment values the Consul CLI uses
+func newConsulProviderFromEnv() (SecretProvider, error) {
+	// The address may come without a scheme, which is then told by CONSUL_HTTP_SSL
+	address := strings.TrimSuffix(GetEnv("CONSUL_HTTP_ADDR", "127.0.0.1:8500"), "/")
+	if !strings.Contains(address, "://") {
+		ssl, err := GetEnvBool("CONSUL_HTTP_SSL", false)
+		if err != nil {
+			return nil, err
+		}
+		scheme := "http"
+		if ssl {
+			scheme = "https"
+		}
+		address = scheme + "://" + address
+	}
+
+	token := GetEnv("CONSUL_HTTP_TOKEN", "")
+	if tokenFile := GetEnv("CONSUL_HTTP_TOKEN_FILE", ""); tokenFile != "" {
+		var err error
+		token, err = readSecretFile(tokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("CONSUL_HTTP_TOKEN_FILE: %w", err)
+		}
+	}
+
+	prefix := strings.TrimPrefix(GetEnv("CONSUL_KV_PREFIX", ""), "/")
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+
+	retry, err := getRetryPolicy("CONSUL", upstreamRetryPolicy)
+	if err != nil {
+		return nil, err
+	}
+
+	return ConsulProvider{
+		Address:    address,
+		Token:      token,
+		Prefix:     prefix,
+		Datacenter: GetEnv("CONSUL_DATACENTER", ""),
+		Namespace:  GetEnv("CONSUL_NAMESPACE", ""),
+		Retry:      retry,
+	}, nil
+}
@@ -0,0 +1,186 @@
+package secrets
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// DiskCache persists the last known good secret values so they can be served during outages
+// The file holds the SHA-256 checksum of the payload followed by the gzip compressed payload
+type DiskCache struct {
+	path   string
+	mu     sync.Mutex
+	values map[string]string
+}
+
+// LoadDiskCache loads the cache from the given path
+// A missing, truncated or corrupted file is ignored, so live fetches are used instead
+func LoadDiskCache(path string) *DiskCache {
+	c := &DiskCache{path: path, values: map[string]string{}}
+
+	values, err := readDiskCache(path)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			slog.Warn("ignoring disk cache", "path", path, "error", err)
+		}
+		return c
+	}
+
+	c.values = values
+	return c
+}
+
+// Get returns the cached value for the secret, if any
+func (c *DiskCache) Get(name string) (string, bool) {
+	if c == nil {
+		return "", false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	value, ok := c.values[name]
+	return value, ok
+}
+
+// Names returns the names of every cached secret
+func (c *DiskCache) Names() []string {
+	if c == nil {
+		return nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	names := make([]string, 0, len(c.values))
+	for name := range c.values {
+		names = append(names, name)
+	}
+	return names
+}
+
+// Set stores the value for the secret and persists the whole cache to disk
+func (c *DiskCache) Set(name string, value string) error {
+	if c == nil {
+		return nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if current, ok := c.values[name]; ok && current == value {
+		return nil
+	}
+
+	c.values[name] = value
+	return writeDiskCache(c.path, c.values)
+}
+
+// Delete removes the value for the secret and persists the whole cache to disk
+func (c *DiskCache) Delete(name string) error {
+	if c == nil {
+		return nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.values[name]; !ok {
+		return nil
+	}
+
+	delete(c.values, name)
+	return writeDiskCache(c.path, c.values)
+}
+
+// readDiskCache reads and verifies the cache file
+func readDiskCache(path string) (map[string]string, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(content) < sha256.Size {
+		return nil, errors.New("file is truncated")
+	}
+
+	checksum, payload := content[:sha256.Size], content[sha256.Size:]
+	sum := sha256.Sum256(payload)
+	if !bytes.Equal(checksum, sum[:]) {
+		return nil, errors.New("checksum mismatch")
+	}
+
+	reader, err := gzip.NewReader(bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+
+	decompressed, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	values := map[string]string{}
+	err = json.Unmarshal(decompressed, &values)
+	if err != nil {
+		return nil, err
+	}
+
+	return values, nil
+}
+
+// writeDiskCache writes the cache file atomically, so readers never see a partial write
+func writeDiskCache(path string, values map[string]string) error {
+	encoded, err := json.Marshal(values)
+	if err != nil {
+		return err
+	}
+
+	var payload bytes.Buffer
+	writer := gzip.NewWriter(&payload)
+	_, err = writer.Write(encoded)
+	if err != nil {
+		return err
+	}
+	err = writer.Close()
+	if err != nil {
+		return err
+	}
+
+	sum := sha256.Sum256(payload.Bytes())
+
+	// The temporary file is created with 0600 and lives next to the target so rename is atomic
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(append(sum[:], payload.Bytes()...))
+	if err != nil {
+		_ = tmp.Close()
+		return err
+	}
+
+	err = tmp.Sync()
+	if err != nil {
+		_ = tmp.Close()
+		return err
+	}
+
+	err = tmp.Close()
+	if err != nil {
+		return err
+	}
+
+	err = os.Chmod(tmp.Name(), 0600)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,116 @@
+package secrets
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDiskCacheRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache")
+
+	cache := LoadDiskCache(path)
+	if err := cache.Set("db-password", "hunter2"); err != nil {
+		t.Fatalf("setting value: %s", err)
+	}
+	if err := cache.Set("api-key", "abc"); err != nil {
+		t.Fatalf("setting value: %s", err)
+	}
+	if err := cache.Delete("api-key"); err != nil {
+		t.Fatalf("deleting value: %s", err)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("stating cache file: %s", err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("expected mode 0600, got %o", info.Mode().Perm())
+	}
+
+	loaded := LoadDiskCache(path)
+	if value, ok := loaded.Get("db-password"); !ok || value != "hunter2" {
+		t.Errorf("expected hunter2, got %q (found %t)", value, ok)
+	}
+	if _, ok := loaded.Get("api-key"); ok {
+		t.Error("expected deleted value to be gone")
+	}
+
+	// No temporary files are left behind
+	entries, err := ioutil.ReadDir(filepath.Dir(path))
+	if err != nil {
+		t.Fatalf("listing cache directory: %s", err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("expected only the cache file, got %d entries", len(entries))
+	}
+}
+
+func TestLoadDiskCacheIgnoresDamagedFiles(t *testing.T) {
+	// Write a valid file to damage it in different ways
+	validPath := filepath.Join(t.TempDir(), "cache")
+	err := LoadDiskCache(validPath).Set("db-password", "hunter2")
+	if err != nil {
+		t.Fatalf("setting value: %s", err)
+	}
+	valid, err := ioutil.ReadFile(validPath)
+	if err != nil {
+		t.Fatalf("reading cache file: %s", err)
+	}
+
+	flipped := append([]byte{}, valid...)
+	flipped[len(flipped)-1] ^= 0xff
+
+	tests := []struct {
+		name    string
+		content []byte
+	}{
+		{name: "empty", content: []byte{}},
+		{name: "truncated checksum", content: valid[:10]},
+		{name: "checksum only", content: valid[:32]},
+		{name: "truncated payload", content: valid[:len(valid)-5]},
+		{name: "corrupted payload", content: flipped},
+		{name: "not a cache file", content: []byte(`{"db-password":"hunter2"}`)},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "cache")
+			err := ioutil.WriteFile(path, test.content, 0600)
+			if err != nil {
+				t.Fatalf("writing cache file: %s", err)
+			}
+
+			cache := LoadDiskCache(path)
+			if value, ok := cache.Get("db-password"); ok {
+				t.Errorf("expected damaged file to be ignored, got %q", value)
+			}
+
+			// The cache is still usable, replacing the damaged file
+			if err := cache.Set("db-password", "hunter3"); err != nil {
+				t.Fatalf("setting value: %s", err)
+			}
+			if value, ok := LoadDiskCache(path).Get("db-password"); !ok || value != "hunter3" {
+				t.Errorf("expected hunter3, got %q (found %t)", value, ok)
+			}
+		})
+	}
+}
+
+func TestResolveFallsThroughDamagedDiskCache(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache")
+	err := ioutil.WriteFile(path, []byte("truncated"), 0600)
+	if err != nil {
+		t.Fatalf("writing cache file: %s", err)
+	}
+
+	sg := SecretGetter{Provider: NewMemoryProvider(map[string]string{"db-password": "live"}), DiskCache: LoadDiskCache(path)}
+	resolution, err := sg.Resolve("db-password", "")
+	if err != nil {
+		t.Fatalf("resolving secret: %s", err)
+	}
+	if resolution.Value != "live" || resolution.Source != SourceMemory {
+		t.Errorf("expected the live value from memory, got %q from %s", resolution.Value, resolution.Source)
+	}
+}
@@ -0,0 +1,144 @@
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// SourceDoppler is the source of values coming from Doppler
+const SourceDoppler Source = "doppler"
+
+func init() {
+	RegisterProvider("doppler", newDopplerProviderFromEnv)
+}
+
+// DopplerProvider gets secrets from a config of a Doppler project
+// Service tokens are scoped to one config, so the project and config are only needed with personal or CLI tokens
+type DopplerProvider struct {
+	// Address is the base URL of the Doppler API
+	Address string
+	Token   string
+	Project string
+	Config  string
+	// Retry applies to every secret fetch
+	Retry RetryPolicy
+}
+
+// Source tells the values come from Doppler
+func (p DopplerProvider) Source() Source {
+	return SourceDoppler
+}
+
+// GetSecret gets the secret, retried according to Retry as Doppler rate limits by token
+func (p DopplerProvider) GetSecret(ctx context.Context, name string) (string, error) {
+	var value string
+	err := p.Retry.do(ctx, func(ctx context.Context) error {
+		var err error
+		value, err = p.fetchSecret(ctx, name)
+		return err
+	})
+	return value, err
+}
+
+// fetchSecret reads the computed value of the secret, with the references to other secrets already expanded
+func (p DopplerProvider) fetchSecret(ctx context.Context, name string) (string, error) {
+	query := url.Values{}
+	query.Set("name", name)
+	if p.Project != "" {
+		query.Set("project", p.Project)
+	}
+	if p.Config != "" {
+		query.Set("config", p.Config)
+	}
+
+	rq, err := http.NewRequestWithContext(ctx, http.MethodGet, p.Address+"/v3/configs/config/secret?"+query.Encode(), nil)
+	if err != nil {
+		return "", err
+	}
+	rq.Header.Set("Authorization", "Bearer "+p.Token)
+	rq.Header.Set("Accept", "application/json")
+	rs, err := UpstreamClient.Do(rq)
+	if err != nil {
+		return "", err
+	}
+
+	bytes, err := ReadBody(rs)
+	if err != nil {
+		return "", err
+	}
+	if rs.StatusCode != http.StatusOK {
+		return "", dopplerError(rs.StatusCode, bytes)
+	}
+
+	secretResponse := struct {
+		Value struct {
+			Computed *string `json:"computed"`
+		} `json:"value"`
+	}{}
+	err = json.Unmarshal(bytes, &secretResponse)
+	if err != nil {
+		return "", withStatus(rs.StatusCode, err)
+	}
+	// Secrets are answered with a null value when they were added to another config only
+	if secretResponse.Value.Computed == nil {
+		return "", fmt.Errorf("%w: %s has no value on the config", ErrSecretNotFound, name)
+	}
+	return *secretResponse.Value.Computed, nil
+}
+
+// dopplerError maps the error body of Doppler, not found and permission denied can be told apart with errors.Is
+func dopplerError(statusCode int, body []byte) error {
+	errorResponse := struct {
+		Messages []string `json:"messages"`
+	}{}
+	// The body is only used for the message, the status code tells the error apart
+	_ = json.Unmarshal(body, &errorResponse)
+	message := strings.Join(errorResponse.Messages, "; ")
+
+	switch statusCode {
+	case http.StatusNotFound:
+		return fmt.Errorf("%w: error %d - %s", ErrSecretNotFound, statusCode, message)
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return fmt.Errorf("%w: error %d - %s", ErrPermissionDenied, statusCode, message)
+	default:
+		return withStatus(statusCode, fmt.Errorf("error %d - %s", statusCode, message))
+	}
+}
+
+// newDopplerProviderFromEnv builds the Doppler provider from the environment values the Doppler CLI uses
+func newDopplerProviderFromEnv() (SecretProvider, error) {
+	token := GetEnv("DOPPLER_TOKEN", "")
+	if tokenFile := GetEnv("DOPPLER_TOKEN_FILE", ""); tokenFile != "" {
+		var err error
+		token, err = readSecretFile(tokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("DOPPLER_TOKEN_FILE: %w", err)
+		}
+	}
+	if token == "" {
+		return nil, errors.New("DOPPLER_TOKEN or DOPPLER_TOKEN_FILE is required for the doppler backend")
+	}
+
+	project, config := GetEnv("DOPPLER_PROJECT", ""), GetEnv("DOPPLER_CONFIG", "")
+	if (project == "") != (config == "") {
+		return nil, errors.New("DOPPLER_PROJECT and DOPPLER_CONFIG go together")
+	}
+
+	retry, err := getRetryPolicy("DOPPLER", upstreamRetryPolicy)
+	if err != nil {
+		return nil, err
+	}
+
+	return DopplerProvider{
+		Address: strings.TrimSuffix(GetEnv("DOPPLER_API_HOST", "https://api.doppler.com"), "/"),
+		Token:   token,
+		Project: project,
+		Config:  config,
+		Retry:   retry,
+	}, nil
+}
@@ -0,0 +1,241 @@
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// SourceEncryptedFile is the source of values coming from a local encrypted file
+const SourceEncryptedFile Source = "encrypted-file"
+
+func init() {
+	RegisterProvider("encrypted-file", newEncryptedFileProviderFromEnv)
+}
+
+// EncryptedFileProvider gets secrets from a local file encrypted with AES-256-GCM, for air-gapped environments and
+// local development where no secret manager is reachable
+// The file is the nonce followed by the sealed JSON object of names and values, and is read again when it changes
+type EncryptedFileProvider struct {
+	path string
+	aead cipher.AEAD
+
+	mu      sync.Mutex
+	values  map[string]string
+	modTime time.Time
+	size    int64
+}
+
+// NewEncryptedFileProvider returns a provider of the file sealed with the 32 bytes key, which is read right away so
+// a wrong key fails on start
+func NewEncryptedFileProvider(path string, key []byte) (*EncryptedFileProvider, error) {
+	aead, err := NewFileAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &EncryptedFileProvider{path: path, aead: aead}
+	_, err = p.current()
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Source tells the values come from an encrypted file
+func (p *EncryptedFileProvider) Source() Source {
+	return SourceEncryptedFile
+}
+
+// GetSecret gets the secret from the file, a file that cannot be read or opened keeps its previous values
+func (p *EncryptedFileProvider) GetSecret(ctx context.Context, name string) (string, error) {
+	values, err := p.current()
+	if err != nil {
+		return "", err
+	}
+
+	value, ok := values[name]
+	if !ok {
+		return "", ErrSecretNotFound
+	}
+	return value, nil
+}
+
+// current returns the values of the file, opening it again when it changed since it was last opened
+func (p *EncryptedFileProvider) current() (map[string]string, error) {
+	info, err := os.Stat(p.path)
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.values != nil && info.ModTime().Equal(p.modTime) && info.Size() == p.size {
+		return p.values, nil
+	}
+
+	content, err := ioutil.ReadFile(p.path)
+	var values map[string]string
+	if err == nil {
+		values, err = openSecretsFile(p.aead, content)
+	}
+	if err != nil {
+		// The file may be half written, so the previous values are kept until it can be opened
+		if p.values != nil {
+			slog.Error("reading encrypted file, keeping previous values", "path", p.path, "error", err)
+			return p.values, nil
+		}
+		return nil, fmt.Errorf("opening %s: %w", p.path, err)
+	}
+	p.values, p.modTime, p.size = values, info.ModTime(), info.Size()
+	return values, nil
+}
+
+// NewFileAEAD returns AES-256-GCM with the key
+func NewFileAEAD(key []byte) (cipher.AEAD, error) {
+	if len(key) != 32 {
+		return nil, fmt.Errorf("the key must be 32 bytes, got %d", len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// openSecretsFile decrypts the content of an encrypted file into its names and values
+func openSecretsFile(aead cipher.AEAD, content []byte) (map[string]string, error) {
+	if len(content) < aead.NonceSize() {
+		return nil, errors.New("the file is too short")
+	}
+
+	plaintext, err := aead.Open(nil, content[:aead.NonceSize()], content[aead.NonceSize():], nil)
+	if err != nil {
+		return nil, errors.New("the file cannot be decrypted with the key")
+	}
+	values := map[string]string{}
+	err = json.Unmarshal(plaintext, &values)
+	if err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+// SealSecretsFile encrypts the names and values with a random nonce, into the content of an encrypted file
+func SealSecretsFile(aead cipher.AEAD, values map[string]string) ([]byte, error) {
+	plaintext, err := json.Marshal(values)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+// EncryptedFileKeyFromEnv gets the key of the file, as base64 on ENCRYPTED_FILE_KEY or on the file of
+// ENCRYPTED_FILE_KEY_FILE, or wrapped by Cloud KMS on the file of ENCRYPTED_FILE_WRAPPED_KEY_FILE and unwrapped with
+// the key of ENCRYPTED_FILE_KMS_KEY
+func EncryptedFileKeyFromEnv() ([]byte, error) {
+	encoded := GetEnv("ENCRYPTED_FILE_KEY", "")
+	if keyFile := GetEnv("ENCRYPTED_FILE_KEY_FILE", ""); keyFile != "" {
+		var err error
+		encoded, err = readSecretFile(keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("ENCRYPTED_FILE_KEY_FILE: %w", err)
+		}
+	}
+	if encoded != "" {
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("the key is not base64: %w", err)
+		}
+		return key, nil
+	}
+
+	kmsKey := GetEnv("ENCRYPTED_FILE_KMS_KEY", "")
+	wrappedKeyFile := GetEnv("ENCRYPTED_FILE_WRAPPED_KEY_FILE", "")
+	if kmsKey == "" || wrappedKeyFile == "" {
+		return nil, errors.New("ENCRYPTED_FILE_KEY, ENCRYPTED_FILE_KEY_FILE or ENCRYPTED_FILE_KMS_KEY and ENCRYPTED_FILE_WRAPPED_KEY_FILE are required")
+	}
+	wrapped, err := ioutil.ReadFile(wrappedKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("ENCRYPTED_FILE_WRAPPED_KEY_FILE: %w", err)
+	}
+	credentials, err := FindDefaultCredentials()
+	if err != nil {
+		return nil, err
+	}
+	return kmsDecrypt(context.Background(), credentials, kmsKey, wrapped)
+}
+
+// kmsDecrypt decrypts the ciphertext with the Cloud KMS key, named as projects/<project>/locations/<location>/keyRings/
+// <ring>/cryptoKeys/<key>
+func kmsDecrypt(ctx context.Context, credentials GCPCredentials, kmsKey string, ciphertext []byte) ([]byte, error) {
+	token, err := credentials.Token(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := json.Marshal(map[string]string{"ciphertext": base64.StdEncoding.EncodeToString(ciphertext)})
+	if err != nil {
+		return nil, err
+	}
+	rq, err := http.NewRequestWithContext(ctx, http.MethodPost, fmt.Sprintf("https://cloudkms.googleapis.com/v1/%s:decrypt", kmsKey), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	rq.Header.Set("Authorization", "Bearer "+token.AccessToken)
+	rq.Header.Set("Content-Type", "application/json")
+	rs, err := UpstreamClient.Do(rq)
+	if err != nil {
+		return nil, err
+	}
+
+	content, err := ReadBody(rs)
+	if err != nil {
+		return nil, err
+	}
+	decryptResponse := struct {
+		Plaintext string   `json:"plaintext"`
+		Error     apiError `json:"error"`
+	}{}
+	err = json.Unmarshal(content, &decryptResponse)
+	if err != nil {
+		return nil, withStatus(rs.StatusCode, err)
+	}
+	err = decryptResponse.Error.err(rs.StatusCode)
+	if err != nil {
+		return nil, fmt.Errorf("decrypting with %s: %w", kmsKey, err)
+	}
+	return base64.StdEncoding.DecodeString(decryptResponse.Plaintext)
+}
+
+// newEncryptedFileProviderFromEnv builds the provider of the file on ENCRYPTED_FILE
+func newEncryptedFileProviderFromEnv() (SecretProvider, error) {
+	path := GetEnv("ENCRYPTED_FILE", "")
+	if path == "" {
+		return nil, errors.New("ENCRYPTED_FILE is required for the encrypted-file backend")
+	}
+
+	key, err := EncryptedFileKeyFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	return NewEncryptedFileProvider(path, key)
+}
@@ -0,0 +1,134 @@
+package secrets
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// EnvFile holds the values of a dotenv file, used as a source for secrets in env-only mode
+type EnvFile struct {
+	path    string
+	mu      sync.RWMutex
+	values  map[string]string
+	modTime time.Time
+	size    int64
+}
+
+// LoadEnvFile reads and parses the dotenv file on the given path
+func LoadEnvFile(path string) (*EnvFile, error) {
+	f := &EnvFile{path: path}
+	err := f.reload()
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Lookup returns the value for the given name, if present on the file
+func (f *EnvFile) Lookup(name string) (string, bool) {
+	if f == nil {
+		return "", false
+	}
+
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	value, ok := f.values[name]
+	return value, ok
+}
+
+// Watch polls the file on the given interval and reloads it when it changes, until stop is closed
+// Read or parse errors keep the previous values, as editors may leave the file half written while saving
+func (f *EnvFile) Watch(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			info, err := os.Stat(f.path)
+			if err != nil {
+				slog.Error("watching env file", "path", f.path, "error", err)
+				continue
+			}
+
+			f.mu.RLock()
+			changed := !info.ModTime().Equal(f.modTime) || info.Size() != f.size
+			f.mu.RUnlock()
+			if !changed {
+				continue
+			}
+
+			err = f.reload()
+			if err != nil {
+				slog.Error("reloading env file, keeping previous values", "path", f.path, "error", err)
+				continue
+			}
+			slog.Info("reloaded env file", "path", f.path)
+		}
+	}
+}
+
+// reload reads the file and swaps the values, only if the whole file could be parsed
+func (f *EnvFile) reload() error {
+	info, err := os.Stat(f.path)
+	if err != nil {
+		return err
+	}
+
+	content, err := ioutil.ReadFile(f.path)
+	if err != nil {
+		return err
+	}
+
+	values, err := parseDotenv(content)
+	if err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.values = values
+	f.modTime = info.ModTime()
+	f.size = info.Size()
+	return nil
+}
+
+// parseDotenv parses KEY=VALUE lines, ignoring blank lines, comments and the export keyword
+func parseDotenv(content []byte) (map[string]string, error) {
+	values := map[string]string{}
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		line = strings.TrimPrefix(line, "export ")
+		separator := strings.Index(line, "=")
+		if separator <= 0 {
+			return nil, fmt.Errorf("line %d: expected KEY=VALUE", lineNumber)
+		}
+
+		key := strings.TrimSpace(line[:separator])
+		value := strings.TrimSpace(line[separator+1:])
+
+		// Quotes are optional, but they must match when present
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		}
+
+		values[key] = value
+	}
+
+	return values, scanner.Err()
+}
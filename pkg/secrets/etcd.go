@@ -0,0 +1,267 @@
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// SourceEtcd is the source of values coming from etcd
+const SourceEtcd Source = "etcd"
+
+func init() {
+	RegisterProvider("etcd", newEtcdProviderFromEnv)
+}
+
+// EtcdProvider gets secrets from the keys of an etcd v3 cluster through its JSON gateway, every secret is a key
+// under the prefix
+type EtcdProvider struct {
+	// Endpoints are the base URLs of the members, like https://etcd-0:2379, tried in order until one answers
+	Endpoints []string
+	// Prefix is prepended to the names, like /secrets/myapp/, as names cannot have slashes
+	Prefix string
+	// Retry applies to every secret fetch
+	Retry RetryPolicy
+	// client trusts the CA of the cluster and presents the client certificate, when there are
+	client *http.Client
+	auth   *etcdAuth
+}
+
+// Source tells the values come from etcd
+func (p EtcdProvider) Source() Source {
+	return SourceEtcd
+}
+
+// GetSecret gets the value of the key, retried according to Retry
+func (p EtcdProvider) GetSecret(ctx context.Context, name string) (string, error) {
+	var value string
+	err := p.Retry.do(ctx, func(ctx context.Context) error {
+		var err error
+		value, err = p.fetchSecret(ctx, name)
+		// Tokens of simple auth expire after some minutes, authenticating again tells it apart from a real denial
+		if errors.Is(err, ErrPermissionDenied) && p.auth.invalidate() {
+			value, err = p.fetchSecret(ctx, name)
+		}
+		return err
+	})
+	return value, err
+}
+
+// fetchSecret reads the key with a range request, which answers no keys when it does not exist
+func (p EtcdProvider) fetchSecret(ctx context.Context, name string) (string, error) {
+	token, err := p.auth.get(ctx, p)
+	if err != nil {
+		return "", err
+	}
+
+	rangeResponse := struct {
+		Kvs []struct {
+			Value string `json:"value"`
+		} `json:"kvs"`
+	}{}
+	body := map[string]string{"key": base64.StdEncoding.EncodeToString([]byte(p.Prefix + name))}
+	err = p.post(ctx, "/v3/kv/range", token, body, &rangeResponse)
+	if err != nil {
+		return "", err
+	}
+	if len(rangeResponse.Kvs) == 0 {
+		return "", fmt.Errorf("%w: no key %s%s", ErrSecretNotFound, p.Prefix, name)
+	}
+	value, err := base64.StdEncoding.DecodeString(rangeResponse.Kvs[0].Value)
+	if err != nil {
+		return "", err
+	}
+	return string(value), nil
+}
+
+// post sends the request to the first member that answers and decodes the answer into the value
+// Members that cannot be reached are skipped, the error of the last one is returned when none answers
+func (p EtcdProvider) post(ctx context.Context, path string, token string, body interface{}, value interface{}) error {
+	content, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	var rs *http.Response
+	for _, endpoint := range p.Endpoints {
+		var rq *http.Request
+		rq, err = http.NewRequestWithContext(ctx, http.MethodPost, endpoint+path, bytes.NewReader(content))
+		if err != nil {
+			return err
+		}
+		rq.Header.Set("Content-Type", "application/json")
+		if token != "" {
+			rq.Header.Set("Authorization", token)
+		}
+		rs, err = p.client.Do(rq)
+		if err == nil || ctx.Err() != nil {
+			break
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	bytes, err := ReadBody(rs)
+	if err != nil {
+		return err
+	}
+	if rs.StatusCode != http.StatusOK {
+		return etcdError(rs.StatusCode, bytes)
+	}
+	err = json.Unmarshal(bytes, value)
+	if err != nil {
+		return withStatus(rs.StatusCode, err)
+	}
+	return nil
+}
+
+// etcdError maps the error body of the gateway, permission denied can be told apart with errors.Is
+// The gateway answers the gRPC code of the error, missing keys are not errors
+func etcdError(statusCode int, body []byte) error {
+	errorResponse := struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	}{}
+	// The body is only used for the message and the code, the status code is enough otherwise
+	_ = json.Unmarshal(body, &errorResponse)
+
+	// 7 is PERMISSION_DENIED and 16 UNAUTHENTICATED, which is answered for expired tokens
+	if errorResponse.Code == 7 || errorResponse.Code == 16 || statusCode == http.StatusUnauthorized || statusCode == http.StatusForbidden {
+		return fmt.Errorf("%w: error %d - %s", ErrPermissionDenied, statusCode, errorResponse.Message)
+	}
+	return withStatus(statusCode, fmt.Errorf("error %d - %s", statusCode, errorResponse.Message))
+}
+
+// etcdAuth gets the token of simple auth requests are sent with, when the cluster has auth enabled with users
+// Clusters authenticating with the common name of client certificates need no token
+type etcdAuth struct {
+	user     string
+	password string
+
+	mu    sync.Mutex
+	token string
+}
+
+// get returns the current token, authenticating when there is none
+func (a *etcdAuth) get(ctx context.Context, p EtcdProvider) (string, error) {
+	if a.user == "" {
+		return "", nil
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.token != "" {
+		return a.token, nil
+	}
+
+	authenticateResponse := struct {
+		Token string `json:"token"`
+	}{}
+	ctx, span := StartSpan(ctx, "token fetch")
+	err := p.post(ctx, "/v3/auth/authenticate", "", map[string]string{"name": a.user, "password": a.password}, &authenticateResponse)
+	span.End(err)
+	if err != nil {
+		return "", fmt.Errorf("%w: authenticating as %s: %v", ErrTokenUnavailable, a.user, err)
+	}
+	if authenticateResponse.Token == "" {
+		return "", fmt.Errorf("%w: authenticating as %s answered without a token", ErrTokenUnavailable, a.user)
+	}
+	a.token = authenticateResponse.Token
+	return a.token, nil
+}
+
+// invalidate drops the token so the next request authenticates again, it tells if there is an authentication to do
+func (a *etcdAuth) invalidate() bool {
+	if a.user == "" {
+		return false
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.token = ""
+	return true
+}
+
+// newEtcdClient returns a copy of the upstream client trusting the CA and presenting the certificate of the files,
+// either can be empty
+func newEtcdClient(caFile string, certFile string, keyFile string) (*http.Client, error) {
+	transport, ok := UpstreamClient.Transport.(*http.Transport)
+	if !ok {
+		return nil, errors.New("the upstream client has no transport to copy")
+	}
+	transport = transport.Clone()
+	if transport.TLSClientConfig == nil {
+		transport.TLSClientConfig = &tls.Config{}
+	}
+
+	if caFile != "" {
+		content, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(content) {
+			return nil, fmt.Errorf("no certificates found on %s", caFile)
+		}
+		transport.TLSClientConfig.RootCAs = pool
+	}
+	if certFile != "" || keyFile != "" {
+		certificate, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		transport.TLSClientConfig.Certificates = []tls.Certificate{certificate}
+	}
+
+	client := *UpstreamClient
+	client.Transport = transport
+	return &client, nil
+}
+
+// newEtcdProviderFromEnv builds the etcd provider from the environment values etcdctl uses
+func newEtcdProviderFromEnv() (SecretProvider, error) {
+	var endpoints []string
+	for _, endpoint := range strings.Split(GetEnv("ETCDCTL_ENDPOINTS", "http://127.0.0.1:2379"), ",") {
+		if endpoint = strings.TrimSuffix(strings.TrimSpace(endpoint), "/"); endpoint != "" {
+			endpoints = append(endpoints, endpoint)
+		}
+	}
+	if len(endpoints) == 0 {
+		return nil, errors.New("ETCDCTL_ENDPOINTS is required for the etcd backend")
+	}
+
+	client, err := newEtcdClient(GetEnv("ETCDCTL_CACERT", ""), GetEnv("ETCDCTL_CERT", ""), GetEnv("ETCDCTL_KEY", ""))
+	if err != nil {
+		return nil, fmt.Errorf("etcd client: %w", err)
+	}
+
+	// The user may come as user:password, as etcdctl takes it
+	auth := &etcdAuth{}
+	auth.user, auth.password, _ = strings.Cut(GetEnv("ETCDCTL_USER", ""), ":")
+	if password := GetEnv("ETCDCTL_PASSWORD", ""); password != "" {
+		auth.password = password
+	}
+
+	retry, err := getRetryPolicy("ETCD", upstreamRetryPolicy)
+	if err != nil {
+		return nil, err
+	}
+
+	return EtcdProvider{
+		Endpoints: endpoints,
+		Prefix:    GetEnv("ETCD_KEY_PREFIX", ""),
+		Retry:     retry,
+		client:    client,
+		auth:      auth,
+	}, nil
+}
@@ -0,0 +1,447 @@
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	secretmanager "cloud.google.com/go/secretmanager/apiv1"
+	"cloud.google.com/go/secretmanager/apiv1/secretmanagerpb"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/option"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// SourceSecretManager is the source of values coming from GCP Secret Manager
+const SourceSecretManager Source = "secret-manager"
+
+func init() {
+	RegisterProvider("gcp", newGCPProviderFromEnv)
+}
+
+// GCPProvider gets secrets from GCP Secret Manager, authenticating with Application Default Credentials
+type GCPProvider struct {
+	Project string
+	// ProjectRoutes send the secrets with some prefixes to other projects, like the shared ones of a central project
+	ProjectRoutes []ProjectRoute
+	// Location makes requests go to the regional endpoint of that location, the global one is used when empty
+	Location string
+	// Credentials get the access tokens, they are resolved as Application Default Credentials
+	Credentials GCPCredentials
+	// MetadataRetry applies to getting the access token, which is local and fast on the metadata server
+	MetadataRetry RetryPolicy
+	// SecretManagerRetry applies to the secret fetch from Secret Manager, which is remote
+	SecretManagerRetry RetryPolicy
+	// ChunkNameFormat enables reading secrets split on parts, it formats the base name and the part number
+	ChunkNameFormat string
+}
+
+// Source tells the values come from Secret Manager
+func (p GCPProvider) Source() Source {
+	return SourceSecretManager
+}
+
+// GetSecret gets the token and then the secret from GCP Secret Manager
+// Each call is retried and bounded according to its own policy
+func (p GCPProvider) GetSecret(ctx context.Context, name string) (string, error) {
+	var value string
+	err := p.withToken(ctx, func(token string) error {
+		var err error
+		if p.ChunkNameFormat != "" {
+			value, err = p.fetchChunkedSecret(ctx, p.SecretManagerRetry, name, "latest", token)
+		} else {
+			value, err = p.fetchSecretWithRetry(ctx, p.SecretManagerRetry, name, token)
+		}
+		return err
+	})
+	return value, err
+}
+
+// GetSecretVersion gets a version of the secret, reading that version of every part of chunked secrets
+func (p GCPProvider) GetSecretVersion(ctx context.Context, name string, version string) (string, error) {
+	var value string
+	err := p.withToken(ctx, func(token string) error {
+		var err error
+		if p.ChunkNameFormat != "" {
+			value, err = p.fetchChunkedSecret(ctx, p.SecretManagerRetry, name, version, token)
+		} else {
+			value, _, err = p.accessSecretWithRetry(ctx, p.SecretManagerRetry, name, version, token)
+		}
+		return err
+	})
+	return value, err
+}
+
+// withToken makes the call with a token, and when Secret Manager rejects it makes the call once more with a new one
+// A token can be revoked before it expires, so the rejected one is dropped from the cache instead of being used until then
+func (p GCPProvider) withToken(ctx context.Context, call func(token string) error) error {
+	token, err := p.getToken(ctx)
+	if err != nil {
+		return err
+	}
+	err = call(token)
+
+	invalidator, ok := p.Credentials.(tokenInvalidator)
+	var status statusError
+	if !ok || !errors.As(err, &status) || status.StatusCode != http.StatusUnauthorized {
+		return err
+	}
+	slog.Warn("access token was rejected, getting another one", "error", err)
+	invalidator.Invalidate(token)
+	token, err = p.getToken(ctx)
+	if err != nil {
+		return err
+	}
+	return call(token)
+}
+
+// getToken gets the token from the credentials, retried according to MetadataRetry
+// Retry overrides of the secret do not apply, as the token is shared by every secret
+func (p GCPProvider) getToken(ctx context.Context) (string, error) {
+	ctx, span := StartSpan(ctx, "token fetch")
+	var token GCPToken
+	err := p.MetadataRetry.do(withoutRetryOverride(ctx), func(ctx context.Context) error {
+		var err error
+		token, err = p.Credentials.Token(ctx)
+		return err
+	})
+	span.End(err)
+	return token.AccessToken, err
+}
+
+// fetchSecretWithRetry gets the secret, retried according to the policy
+func (p GCPProvider) fetchSecretWithRetry(ctx context.Context, policy RetryPolicy, name string, token string) (string, error) {
+	value, _, err := p.accessSecretWithRetry(ctx, policy, name, "latest", token)
+	return value, err
+}
+
+// accessSecretWithRetry gets the value of the version of the secret and the number of that version, retried according
+// to the policy
+func (p GCPProvider) accessSecretWithRetry(ctx context.Context, policy RetryPolicy, name string, version string, token string) (string, string, error) {
+	var value, number string
+	err := policy.do(ctx, func(ctx context.Context) error {
+		var err error
+		value, number, err = p.accessSecret(ctx, name, version, token)
+		return err
+	})
+	return value, number, err
+}
+
+// fetchSecret gets the secret value of the version from GCP Secret Manager using the given access token
+func (p GCPProvider) fetchSecret(ctx context.Context, name string, version string, token string) (string, error) {
+	value, _, err := p.accessSecret(ctx, name, version, token)
+	return value, err
+}
+
+// accessSecret gets the secret value of the version and the number of that version, which tells what an alias like
+// latest resolved to
+func (p GCPProvider) accessSecret(ctx context.Context, name string, version string, token string) (string, string, error) {
+	versionName, err := p.secretVersionName(p.projectFor(ctx, name), name, version)
+	if err != nil {
+		return "", "", err
+	}
+	client, err := p.secretManagerClient(ctx, token)
+	if err != nil {
+		return "", "", err
+	}
+	defer client.Close()
+
+	secretResponse, err := client.AccessSecretVersion(ctx, &secretmanagerpb.AccessSecretVersionRequest{Name: versionName})
+	if err != nil {
+		return "", "", secretManagerError(err)
+	}
+
+	// The name is projects/<project>/secrets/<secret>/versions/<version>
+	return string(secretResponse.GetPayload().GetData()), path.Base(secretResponse.GetName()), nil
+}
+
+// projectFor returns the project the secret is in, which is the one the request asked for, then the one of the longest
+// route whose prefix the name starts with, and then the configured one
+func (p GCPProvider) projectFor(ctx context.Context, name string) string {
+	if project := projectFromContext(ctx); project != "" {
+		return project
+	}
+	for _, route := range p.ProjectRoutes {
+		if strings.HasPrefix(name, route.Prefix) {
+			return route.Project
+		}
+	}
+	return p.Project
+}
+
+// endpoint returns the base URL of Secret Manager, the regional endpoint of the location when there is one
+func (p GCPProvider) endpoint() string {
+	if p.Location != "" {
+		// Regional secrets are only available on their own endpoint
+		return fmt.Sprintf("https://secretmanager.%s.rep.googleapis.com", url.PathEscape(p.Location))
+	}
+	return "https://secretmanager.googleapis.com"
+}
+
+// secretsParent returns the resource name the secrets of the project are under, the location when using regional endpoints
+// The client puts the names on the path as they are, so a slash on any part would make them point somewhere else
+func (p GCPProvider) secretsParent(project string) (string, error) {
+	if err := validResourceIDs(project, p.Location); err != nil {
+		return "", err
+	}
+	if p.Location != "" {
+		return fmt.Sprintf("projects/%s/locations/%s", project, p.Location), nil
+	}
+	return "projects/" + project, nil
+}
+
+// secretName returns the resource name of the secret
+func (p GCPProvider) secretName(project string, name string) (string, error) {
+	parent, err := p.secretsParent(project)
+	if err != nil {
+		return "", err
+	}
+	if err := validResourceIDs(name); err != nil {
+		return "", err
+	}
+	return parent + "/secrets/" + name, nil
+}
+
+// secretVersionName returns the resource name of the version of the secret, for accessing its value or its metadata
+func (p GCPProvider) secretVersionName(project string, name string, version string) (string, error) {
+	secretName, err := p.secretName(project, name)
+	if err != nil {
+		return "", err
+	}
+	if err := validResourceIDs(version); err != nil {
+		return "", err
+	}
+	return secretName + "/versions/" + version, nil
+}
+
+// validResourceIDs checks the IDs can be put on a resource name, empty ones are left to the caller
+func validResourceIDs(ids ...string) error {
+	for _, id := range ids {
+		if strings.ContainsAny(id, "/?#") {
+			return fmt.Errorf("%w: %q is not a valid resource ID", ErrSecretNotFound, id)
+		}
+	}
+	return nil
+}
+
+// secretManagerClient returns a client of Secret Manager that calls the endpoint with the token
+// The calls are not retried by the client, as the retry policies of the provider do that
+func (p GCPProvider) secretManagerClient(ctx context.Context, token string) (*secretmanager.Client, error) {
+	client, err := secretmanager.NewRESTClient(ctx,
+		option.WithEndpoint(p.endpoint()),
+		option.WithHTTPClient(&http.Client{Transport: secretManagerTransport{token: token}}))
+	if err != nil {
+		return nil, err
+	}
+	*client.CallOptions = secretmanager.CallOptions{}
+	return client, nil
+}
+
+// secretManagerTransport sends the calls of the Secret Manager client through UpstreamClient with the access token,
+// reading the bodies with ReadBody so they are bounded as every other response
+type secretManagerTransport struct {
+	token string
+}
+
+func (t secretManagerTransport) RoundTrip(rq *http.Request) (*http.Response, error) {
+	rq = rq.Clone(rq.Context())
+	rq.Header.Set("Authorization", fmt.Sprintf("Bearer %s", t.token))
+	rs, err := UpstreamClient.Do(rq)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := ReadBody(rs)
+	if err != nil {
+		return nil, err
+	}
+	rs.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	// Proxies may answer errors with a success status, which the client would take for an empty response
+	envelope := struct {
+		Error apiError `json:"error"`
+	}{}
+	if rs.StatusCode == http.StatusOK && json.Unmarshal(body, &envelope) == nil {
+		rs.StatusCode = envelope.Error.code(rs.StatusCode)
+	}
+	return rs, nil
+}
+
+// secretManagerError maps the errors the client returns for the responses of Secret Manager to the error envelope
+func secretManagerError(err error) error {
+	var responseError *googleapi.Error
+	if !errors.As(err, &responseError) {
+		return err
+	}
+
+	// Errors coming from a proxy in front of the API may not be JSON, the status code tells if they are transient
+	envelope := struct {
+		Error apiError `json:"error"`
+	}{}
+	if json.Unmarshal([]byte(responseError.Body), &envelope) != nil {
+		envelope.Error = apiError{}
+	}
+	return envelope.Error.err(responseError.Code)
+}
+
+// formatTimestamp formats the times of Secret Manager as the API does on JSON, empty when there is none
+func formatTimestamp(timestamp *timestamppb.Timestamp) string {
+	if timestamp == nil {
+		return ""
+	}
+	return timestamp.AsTime().UTC().Format(time.RFC3339Nano)
+}
+
+// apiError is the error envelope returned by Google APIs
+type apiError struct {
+	Code    errorCode `json:"code"`
+	Message string    `json:"message"`
+	Status  string    `json:"status"`
+}
+
+// errorCode is the code of an error envelope, which some envelopes serialize as a string
+type errorCode int
+
+func (c *errorCode) UnmarshalJSON(data []byte) error {
+	// An empty code, either null or an empty string, leaves the status to tell the error
+	if string(data) == "null" || string(data) == `""` {
+		*c = 0
+		return nil
+	}
+
+	var number json.Number
+	err := json.Unmarshal(data, &number)
+	if err != nil {
+		return err
+	}
+
+	parsed, err := strconv.Atoi(number.String())
+	if err != nil {
+		return fmt.Errorf("error code %s: %w", data, err)
+	}
+	*c = errorCode(parsed)
+	return nil
+}
+
+// code returns the HTTP status the envelope stands for, using the status and then the HTTP status of the response in
+// case the envelope is missing the code
+func (e apiError) code(statusCode int) int {
+	code := int(e.Code)
+	if code == 0 {
+		switch e.Status {
+		case "NOT_FOUND":
+			code = http.StatusNotFound
+		case "PERMISSION_DENIED":
+			code = http.StatusForbidden
+		}
+	}
+	if code == 0 {
+		code = statusCode
+	}
+	return code
+}
+
+// err maps the envelope to an error, not found and permission denied can be told apart with errors.Is
+func (e apiError) err(statusCode int) error {
+	code := e.code(statusCode)
+	switch code {
+	case 0, http.StatusOK:
+		return nil
+	case http.StatusNotFound:
+		return fmt.Errorf("%w: error %d - status %s", ErrSecretNotFound, code, e.Status)
+	case http.StatusForbidden:
+		return fmt.Errorf("%w: error %d - status %s", ErrPermissionDenied, code, e.Status)
+	default:
+		return withStatus(code, fmt.Errorf("error %d - status %s", code, e.Status))
+	}
+}
+
+// newGCPProviderFromEnv builds the GCP provider, checking the service account and discovering the region when asked to
+func newGCPProviderFromEnv() (SecretProvider, error) {
+	project := GetEnv("GCP_PROJECT", "")
+	if project == "" {
+		return nil, errors.New("GCP_PROJECT is required for the gcp backend")
+	}
+
+	// Check which service account this runs as, catching deployments bound to the wrong one
+	verify, err := GetEnvBool("VERIFY_SERVICE_ACCOUNT", false)
+	if err != nil {
+		return nil, err
+	}
+	expectedServiceAccount := GetEnv("EXPECTED_SERVICE_ACCOUNT", "")
+	if verify || expectedServiceAccount != "" {
+		err = verifyServiceAccount(context.Background(), expectedServiceAccount)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Get the location for regional endpoints, which can be discovered from the metadata server
+	location := GetEnv("SECRET_MANAGER_LOCATION", "")
+	regional, err := GetEnvBool("SECRET_MANAGER_REGIONAL", false)
+	if err != nil {
+		return nil, err
+	}
+	if regional && location == "" {
+		location, err = discoverRegion(context.Background())
+		if err != nil {
+			slog.Warn("discovering region, using the global endpoint", "error", err)
+		}
+	}
+
+	// Get the retry policies, metadata is local so it can fail fast while Secret Manager is remote
+	metadataRetry, err := getRetryPolicy("METADATA", upstreamRetryPolicy)
+	if err != nil {
+		return nil, err
+	}
+	secretManagerRetry, err := getRetryPolicy("SECRET_MANAGER", upstreamRetryPolicy)
+	if err != nil {
+		return nil, err
+	}
+
+	// Get the format of part names when secrets that exceed the size limit are split on parts
+	var chunkNameFormat string
+	chunkedSecrets, err := GetEnvBool("CHUNKED_SECRETS", false)
+	if err != nil {
+		return nil, err
+	}
+	if chunkedSecrets {
+		chunkNameFormat = GetEnv("CHUNK_NAME_FORMAT", "%s-part-%d")
+		err = validateChunkNameFormat(chunkNameFormat)
+		if err != nil {
+			return nil, fmt.Errorf("CHUNK_NAME_FORMAT: %w", err)
+		}
+	}
+
+	// Get the routes of the secrets kept on other projects, by the prefix of their names
+	projectRoutes, err := parseProjectRoutes(GetEnv("GCP_PROJECT_ROUTES", ""))
+	if err != nil {
+		return nil, fmt.Errorf("GCP_PROJECT_ROUTES: %w", err)
+	}
+
+	// Resolve the credentials, the metadata server is only used when there are no credential files
+	credentials, err := FindDefaultCredentials()
+	if err != nil {
+		return nil, err
+	}
+
+	return GCPProvider{
+		Project:            project,
+		ProjectRoutes:      projectRoutes,
+		Credentials:        &CachedCredentials{Credentials: credentials},
+		Location:           location,
+		ChunkNameFormat:    chunkNameFormat,
+		MetadataRetry:      metadataRetry,
+		SecretManagerRetry: secretManagerRetry,
+	}, nil
+}
@@ -0,0 +1,318 @@
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSecretVersionName(t *testing.T) {
+	tests := []struct {
+		name             string
+		provider         GCPProvider
+		project          string
+		secret           string
+		version          string
+		expected         string
+		expectedEndpoint string
+		expectedErr      bool
+	}{
+		{
+			name:             "global endpoint",
+			project:          "my-project",
+			secret:           "db-password",
+			version:          "latest",
+			expected:         "projects/my-project/secrets/db-password/versions/latest",
+			expectedEndpoint: "https://secretmanager.googleapis.com",
+		},
+		{
+			name:             "regional endpoint",
+			provider:         GCPProvider{Location: "europe-west1"},
+			project:          "my-project",
+			secret:           "db-password",
+			version:          "3",
+			expected:         "projects/my-project/locations/europe-west1/secrets/db-password/versions/3",
+			expectedEndpoint: "https://secretmanager.europe-west1.rep.googleapis.com",
+		},
+		{
+			name:             "hyphens and underscores only",
+			project:          "p",
+			secret:           "-_-",
+			version:          "_",
+			expected:         "projects/p/secrets/-_-/versions/_",
+			expectedEndpoint: "https://secretmanager.googleapis.com",
+		},
+		{name: "version with a slash", project: "my-project", secret: "db-password", version: "a/b", expectedErr: true},
+		{name: "version with a query", project: "my-project", secret: "db-password", version: "1?alt=media", expectedErr: true},
+		{name: "secret with a fragment", project: "my-project", secret: "db#password", version: "latest", expectedErr: true},
+		{name: "project with a slash", project: "x/y", secret: "db-password", version: "latest", expectedErr: true},
+		{name: "location with a slash", provider: GCPProvider{Location: "a/b"}, project: "my-project", secret: "db-password", version: "latest", expectedErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			actual, err := test.provider.secretVersionName(test.project, test.secret, test.version)
+			if test.expectedErr {
+				if !errors.Is(err, ErrSecretNotFound) {
+					t.Errorf("expected not found, got %q and %v", actual, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected a name, got %s", err)
+			}
+			if actual != test.expected {
+				t.Errorf("expected %s, got %s", test.expected, actual)
+			}
+			if endpoint := test.provider.endpoint(); endpoint != test.expectedEndpoint {
+				t.Errorf("expected endpoint %s, got %s", test.expectedEndpoint, endpoint)
+			}
+		})
+	}
+}
+
+func TestSecretManagerClientCalls(t *testing.T) {
+	type call struct {
+		method string
+		url    string
+		body   string
+	}
+	var calls []call
+	stubUpstream(t, func(w http.ResponseWriter, rq *http.Request) {
+		if rq.Header.Get("Authorization") != "Bearer token" {
+			t.Errorf("expected the token, got %q", rq.Header.Get("Authorization"))
+		}
+		var body []byte
+		if rq.Body != nil {
+			body, _ = ioutil.ReadAll(rq.Body)
+		}
+		calls = append(calls, call{method: rq.Method, url: rq.URL.Scheme + "://" + rq.URL.Host + rq.URL.Path, body: string(body)})
+
+		switch {
+		case strings.HasSuffix(rq.URL.Path, "/db-password:addVersion"):
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"error":{"code":404,"status":"NOT_FOUND"}}`))
+		case strings.HasSuffix(rq.URL.Path, ":addVersion"):
+			_, _ = w.Write([]byte(`{"name":"projects/my-project/secrets/api-key/versions/7"}`))
+		case strings.HasSuffix(rq.URL.Path, ":access"):
+			_, _ = w.Write([]byte(`{"name":"projects/my-project/secrets/api-key/versions/7","payload":{"data":"aHVudGVyMg=="}}`))
+		default:
+			_, _ = w.Write([]byte(`{"name":"projects/my-project/secrets/api-key/versions/7","createTime":"2024-01-02T03:04:05.5Z"}`))
+		}
+	})
+	provider := GCPProvider{Project: "my-project", Location: "europe-west1"}
+	ctx := context.Background()
+
+	value, version, err := provider.accessSecret(ctx, "api-key", "latest", "token")
+	if err != nil || value != "hunter2" || version != "7" {
+		t.Errorf("expected hunter2 at version 7, got %q at %q and %v", value, version, err)
+	}
+	metadata, err := provider.fetchVersionMetadata(ctx, "api-key", "token")
+	if err != nil || metadata != (VersionMetadata{Version: "7", CreateTime: "2024-01-02T03:04:05.5Z"}) {
+		t.Errorf("expected version 7, got %+v and %v", metadata, err)
+	}
+	version, err = provider.addVersion(ctx, "api-key", "hunter2", "token")
+	if err != nil || version != "7" {
+		t.Errorf("expected version 7, got %q and %v", version, err)
+	}
+	if _, err := provider.addVersion(ctx, "db-password", "hunter2", "token"); !errors.Is(err, ErrSecretNotFound) {
+		t.Errorf("expected not found, got %v", err)
+	}
+
+	base := "https://secretmanager.europe-west1.rep.googleapis.com/v1/projects/my-project/locations/europe-west1/secrets/"
+	expected := []call{
+		{method: http.MethodGet, url: base + "api-key/versions/latest:access"},
+		{method: http.MethodGet, url: base + "api-key/versions/latest"},
+		{method: http.MethodPost, url: base + "api-key:addVersion", body: `{"parent":"projects/my-project/locations/europe-west1/secrets/api-key","payload":{"data":"aHVudGVyMg=="}}`},
+		{method: http.MethodPost, url: base + "db-password:addVersion", body: `{"parent":"projects/my-project/locations/europe-west1/secrets/db-password","payload":{"data":"aHVudGVyMg=="}}`},
+	}
+	if len(calls) != len(expected) {
+		t.Fatalf("expected %d calls, got %+v", len(expected), calls)
+	}
+	for i := range expected {
+		// The client may space the JSON it sends, so the bodies are compared compacted
+		var compacted bytes.Buffer
+		if calls[i].body != "" && json.Compact(&compacted, []byte(calls[i].body)) == nil {
+			calls[i].body = compacted.String()
+		}
+		if calls[i] != expected[i] {
+			t.Errorf("expected %+v, got %+v", expected[i], calls[i])
+		}
+	}
+}
+
+func TestSecretManagerErrors(t *testing.T) {
+	tests := []struct {
+		name              string
+		status            int
+		body              string
+		expectedErr       error
+		expectedRetryable bool
+	}{
+		{name: "not found", status: http.StatusNotFound, body: `{"error":{"code":404,"status":"NOT_FOUND"}}`, expectedErr: ErrSecretNotFound},
+		{name: "permission denied on a proxied 200", status: http.StatusOK, body: `{"error":{"status":"PERMISSION_DENIED"}}`, expectedErr: ErrPermissionDenied},
+		{name: "unavailable", status: http.StatusServiceUnavailable, body: `{"error":{"code":503,"status":"UNAVAILABLE"}}`, expectedErr: errAny, expectedRetryable: true},
+		{name: "proxy page", status: http.StatusBadGateway, body: `<html>bad gateway</html>`, expectedErr: errAny, expectedRetryable: true},
+		{name: "bad request", status: http.StatusBadRequest, body: `{"error":{"code":400,"status":"INVALID_ARGUMENT"}}`, expectedErr: errAny},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			stubUpstream(t, func(w http.ResponseWriter, rq *http.Request) {
+				w.WriteHeader(test.status)
+				_, _ = w.Write([]byte(test.body))
+			})
+
+			_, err := GCPProvider{Project: "my-project"}.fetchSecret(context.Background(), "db-password", "latest", "token")
+			if err == nil || (test.expectedErr != errAny && !errors.Is(err, test.expectedErr)) {
+				t.Fatalf("expected %v, got %v", test.expectedErr, err)
+			}
+			if isRetryable(err) != test.expectedRetryable {
+				t.Errorf("expected retryable %t, got %s", test.expectedRetryable, err)
+			}
+		})
+	}
+}
+
+func TestAPIErrorEnvelope(t *testing.T) {
+	tests := []struct {
+		name              string
+		statusCode        int
+		body              string
+		expectedErr       error
+		expectedRetryable bool
+	}{
+		{name: "numeric code", statusCode: http.StatusForbidden, body: `{"error":{"code":403,"status":"PERMISSION_DENIED"}}`, expectedErr: ErrPermissionDenied},
+		{name: "string code", statusCode: http.StatusForbidden, body: `{"error":{"code":"403","status":"PERMISSION_DENIED"}}`, expectedErr: ErrPermissionDenied},
+		{name: "numeric not found", statusCode: http.StatusNotFound, body: `{"error":{"code":404}}`, expectedErr: ErrSecretNotFound},
+		{name: "string not found", statusCode: http.StatusNotFound, body: `{"error":{"code":"404"}}`, expectedErr: ErrSecretNotFound},
+		{name: "status only", statusCode: http.StatusNotFound, body: `{"error":{"status":"NOT_FOUND"}}`, expectedErr: ErrSecretNotFound},
+		{name: "status only on a proxied 200", statusCode: http.StatusOK, body: `{"error":{"status":"PERMISSION_DENIED"}}`, expectedErr: ErrPermissionDenied},
+		{name: "empty string code uses the status", statusCode: http.StatusForbidden, body: `{"error":{"code":"","status":"PERMISSION_DENIED"}}`, expectedErr: ErrPermissionDenied},
+		{name: "no envelope uses the HTTP status", statusCode: http.StatusNotFound, body: `{}`, expectedErr: ErrSecretNotFound},
+		{name: "numeric server error", statusCode: http.StatusServiceUnavailable, body: `{"error":{"code":503,"status":"UNAVAILABLE"}}`, expectedErr: errAny, expectedRetryable: true},
+		{name: "string server error", statusCode: http.StatusServiceUnavailable, body: `{"error":{"code":"503","status":"UNAVAILABLE"}}`, expectedErr: errAny, expectedRetryable: true},
+		{name: "string throttling", statusCode: http.StatusTooManyRequests, body: `{"error":{"code":"429","status":"RESOURCE_EXHAUSTED"}}`, expectedErr: errAny, expectedRetryable: true},
+		{name: "no error", statusCode: http.StatusOK, body: `{"payload":{"data":""}}`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			envelope := struct {
+				Error apiError `json:"error"`
+			}{}
+			err := json.Unmarshal([]byte(test.body), &envelope)
+			if err != nil {
+				t.Fatalf("decoding envelope: %s", err)
+			}
+
+			err = envelope.Error.err(test.statusCode)
+			switch {
+			case test.expectedErr == nil:
+				if err != nil {
+					t.Errorf("expected no error, got %s", err)
+				}
+				return
+			case err == nil:
+				t.Fatalf("expected %v, got no error", test.expectedErr)
+			case test.expectedErr != errAny && !errors.Is(err, test.expectedErr):
+				t.Errorf("expected %v, got %s", test.expectedErr, err)
+			}
+			if isRetryable(err) != test.expectedRetryable {
+				t.Errorf("expected retryable %t, got %s", test.expectedRetryable, err)
+			}
+		})
+	}
+}
+
+func TestErrorCodeRejectsInvalidCodes(t *testing.T) {
+	for _, body := range []string{`{"code":"forbidden"}`, `{"code":4.03}`, `{"code":true}`} {
+		t.Run(body, func(t *testing.T) {
+			envelope := apiError{}
+			if err := json.Unmarshal([]byte(body), &envelope); err == nil {
+				t.Errorf("expected error, got code %d", envelope.Code)
+			}
+		})
+	}
+}
+
+func TestRejectedTokensAreFetchedAgain(t *testing.T) {
+	tests := []struct {
+		name string
+		// revoked are the tokens Secret Manager rejects
+		revoked             map[string]bool
+		expectedTokenCalls  int
+		expectedSecretCalls int
+		expectedErr         bool
+	}{
+		{name: "valid token", revoked: map[string]bool{}, expectedTokenCalls: 1, expectedSecretCalls: 1},
+		{name: "revoked token", revoked: map[string]bool{"token-1": true}, expectedTokenCalls: 2, expectedSecretCalls: 2},
+		{name: "every token rejected", revoked: map[string]bool{"token-1": true, "token-2": true}, expectedTokenCalls: 2, expectedSecretCalls: 2, expectedErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var tokenCalls, secretCalls int
+			stubUpstream(t, func(w http.ResponseWriter, rq *http.Request) {
+				secretCalls++
+				if test.revoked[strings.TrimPrefix(rq.Header.Get("Authorization"), "Bearer ")] {
+					w.WriteHeader(http.StatusUnauthorized)
+					_, _ = w.Write([]byte(`{"error":{"code":401,"status":"UNAUTHENTICATED"}}`))
+					return
+				}
+				_, _ = w.Write([]byte(`{"name":"projects/my-project/secrets/db-password/versions/1","payload":{"data":"aHVudGVyMg=="}}`))
+			})
+			provider := GCPProvider{
+				Project: "my-project",
+				Credentials: &CachedCredentials{Credentials: credentialsFunc(func(ctx context.Context) (GCPToken, error) {
+					tokenCalls++
+					return GCPToken{AccessToken: fmt.Sprintf("token-%d", tokenCalls), Expiry: time.Now().Add(time.Hour)}, nil
+				})},
+				SecretManagerRetry: RetryPolicy{Attempts: 3},
+			}
+
+			value, err := provider.GetSecret(context.Background(), "db-password")
+			if test.expectedErr != (err != nil) {
+				t.Errorf("expected error %t, got %v", test.expectedErr, err)
+			}
+			if !test.expectedErr && value != "hunter2" {
+				t.Errorf("expected hunter2, got %q", value)
+			}
+			if tokenCalls != test.expectedTokenCalls {
+				t.Errorf("expected %d token calls, got %d", test.expectedTokenCalls, tokenCalls)
+			}
+			if secretCalls != test.expectedSecretCalls {
+				t.Errorf("expected %d secret calls, got %d", test.expectedSecretCalls, secretCalls)
+			}
+		})
+	}
+}
+
+func TestCachedCredentialsInvalidate(t *testing.T) {
+	calls := 0
+	credentials := &CachedCredentials{Credentials: credentialsFunc(func(ctx context.Context) (GCPToken, error) {
+		calls++
+		return GCPToken{AccessToken: fmt.Sprintf("token-%d", calls), Expiry: time.Now().Add(time.Hour)}, nil
+	})}
+	ctx := context.Background()
+
+	first, _ := credentials.Token(ctx)
+	credentials.Invalidate(first.AccessToken)
+	second, _ := credentials.Token(ctx)
+	if second.AccessToken != "token-2" {
+		t.Errorf("expected a new token, got %s", second.AccessToken)
+	}
+
+	// Dropping a token that was already replaced keeps the new one
+	credentials.Invalidate(first.AccessToken)
+	if current, _ := credentials.Token(ctx); current.AccessToken != "token-2" {
+		t.Errorf("expected the newer token to be kept, got %s", current.AccessToken)
+	}
+}
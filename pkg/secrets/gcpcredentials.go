@@ -0,0 +1,246 @@
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+	"google.golang.org/api/impersonate"
+	"google.golang.org/api/option"
+)
+
+// cloudPlatformScope is the OAuth scope tokens are requested with
+const cloudPlatformScope = "https://www.googleapis.com/auth/cloud-platform"
+
+// GCPToken is an access token together with when it expires
+type GCPToken struct {
+	AccessToken string
+	Expiry      time.Time
+}
+
+// GCPCredentials get access tokens for Google APIs
+type GCPCredentials interface {
+	Token(ctx context.Context) (GCPToken, error)
+}
+
+// tokenRefreshWindow is how long before expiring a cached token is fetched again
+const tokenRefreshWindow = time.Minute
+
+// CachedCredentials keep the token of other credentials until it is about to expire
+// Concurrent callers wait on the same fetch instead of all fetching a token
+type CachedCredentials struct {
+	Credentials GCPCredentials
+
+	mu      sync.Mutex
+	current GCPToken
+}
+
+// Token returns the cached token, fetching another one when it is about to expire
+func (c *CachedCredentials) Token(ctx context.Context) (GCPToken, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.current.AccessToken != "" && clockFromContext(ctx).Now().Before(c.current.Expiry.Add(-tokenRefreshWindow)) {
+		return c.current, nil
+	}
+
+	token, err := c.Credentials.Token(ctx)
+	if err != nil {
+		return GCPToken{}, err
+	}
+	c.current = token
+	return token, nil
+}
+
+// tokenInvalidator is implemented by credentials that keep tokens, so one an API rejected is not used again
+type tokenInvalidator interface {
+	Invalidate(token string)
+}
+
+// Invalidate drops the token when it is the cached one, a newer token another caller already got is kept
+func (c *CachedCredentials) Invalidate(token string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.current.AccessToken == token {
+		c.current = GCPToken{}
+	}
+}
+
+// FindDefaultCredentials resolves Application Default Credentials the way Google client libraries do
+// GOOGLE_APPLICATION_CREDENTIALS is used first, then the file written by gcloud and then the metadata server
+// With IMPERSONATE_SERVICE_ACCOUNT, those credentials only get tokens of the service account named on it
+func FindDefaultCredentials() (GCPCredentials, error) {
+	credentials, err := findApplicationCredentials()
+	if err != nil {
+		return nil, err
+	}
+	if target := GetEnv("IMPERSONATE_SERVICE_ACCOUNT", ""); target != "" {
+		return impersonatedCredentials(credentials, target)
+	}
+	return credentials, nil
+}
+
+// findApplicationCredentials resolves the credentials of the workload itself, before any impersonation
+func findApplicationCredentials() (GCPCredentials, error) {
+	if path := GetEnv("GOOGLE_APPLICATION_CREDENTIALS", ""); path != "" {
+		credentials, err := loadCredentialsFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("GOOGLE_APPLICATION_CREDENTIALS: %w", err)
+		}
+		return credentials, nil
+	}
+
+	if path := wellKnownCredentialsFile(); path != "" {
+		if _, err := os.Stat(path); err == nil {
+			return loadCredentialsFile(path)
+		}
+	}
+
+	return metadataCredentials{}, nil
+}
+
+// wellKnownCredentialsFile is where gcloud auth application-default login writes the credentials
+func wellKnownCredentialsFile() string {
+	if dir := GetEnv("CLOUDSDK_CONFIG", ""); dir != "" {
+		return filepath.Join(dir, "application_default_credentials.json")
+	}
+	home := GetEnv("HOME", "")
+	if home == "" {
+		return ""
+	}
+	return filepath.Join(home, ".config", "gcloud", "application_default_credentials.json")
+}
+
+// loadCredentialsFile reads a credentials file of a service account, of a user, of a federated workload or of an
+// impersonated service account, which the oauth2 library parses and gets tokens for
+func loadCredentialsFile(path string) (GCPCredentials, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	credentials, err := google.CredentialsFromJSON(upstreamContext(), content, cloudPlatformScope)
+	if err != nil {
+		return nil, err
+	}
+	return tokenSourceCredentials{source: credentials.TokenSource}, nil
+}
+
+// impersonatedCredentials get tokens of the target service account with the tokens of the credentials, which need the
+// Service Account Token Creator role on it
+// This keeps the identity of the workload minimal, and the one reading secrets explicit on the audit logs
+func impersonatedCredentials(credentials GCPCredentials, target string) (GCPCredentials, error) {
+	client := &http.Client{Transport: &oauth2.Transport{Source: credentialsTokenSource{credentials: credentials}, Base: upstreamTransport{}}}
+	source, err := impersonate.CredentialsTokenSource(upstreamContext(), impersonate.CredentialsConfig{
+		TargetPrincipal: target,
+		Scopes:          []string{cloudPlatformScope},
+	}, option.WithHTTPClient(client))
+	if err != nil {
+		return nil, fmt.Errorf("IMPERSONATE_SERVICE_ACCOUNT: %w", err)
+	}
+	return tokenSourceCredentials{source: source}, nil
+}
+
+// upstreamContext is the context the oauth2 library makes its calls with, which sends them through UpstreamClient
+// The library keeps it for every later token, so it has no deadline of its own
+func upstreamContext() context.Context {
+	return context.WithValue(context.Background(), oauth2.HTTPClient, &http.Client{Transport: upstreamTransport{}})
+}
+
+// tokenSourceCredentials get tokens from an oauth2 token source, which keeps them until they expire
+type tokenSourceCredentials struct {
+	source oauth2.TokenSource
+}
+
+// Token gets a token from the source, failures the token endpoint answered with keep its status so they are only
+// retried when transient
+func (c tokenSourceCredentials) Token(ctx context.Context) (GCPToken, error) {
+	token, err := c.source.Token()
+	if err != nil {
+		var retrieveError *oauth2.RetrieveError
+		if errors.As(err, &retrieveError) && retrieveError.Response != nil {
+			return GCPToken{}, withStatus(retrieveError.Response.StatusCode, fmt.Errorf("%w: %v", ErrTokenUnavailable, err))
+		}
+		return GCPToken{}, fmt.Errorf("%w: %v", ErrTokenUnavailable, err)
+	}
+	return GCPToken{AccessToken: token.AccessToken, Expiry: token.Expiry}, nil
+}
+
+// credentialsTokenSource is the oauth2 token source of credentials, for the libraries taking one
+type credentialsTokenSource struct {
+	credentials GCPCredentials
+}
+
+// Token gets a token of the credentials
+func (s credentialsTokenSource) Token() (*oauth2.Token, error) {
+	token, err := s.credentials.Token(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	return &oauth2.Token{AccessToken: token.AccessToken, TokenType: "Bearer", Expiry: token.Expiry}, nil
+}
+
+// metadataCredentials get tokens for the service account attached to the instance, or bound through Workload Identity on GKE
+// They are read here rather than by the oauth2 library, whose metadata client does not go through UpstreamClient
+type metadataCredentials struct{}
+
+// Token gets the token from the metadata server
+func (metadataCredentials) Token(ctx context.Context) (GCPToken, error) {
+	tokenUrl := metadataUrl + "/instance/service-accounts/default/token"
+	rq, err := http.NewRequestWithContext(ctx, http.MethodGet, tokenUrl, nil)
+	if err != nil {
+		return GCPToken{}, err
+	}
+
+	rq.Header.Add("Metadata-Flavor", "Google")
+	rs, err := UpstreamClient.Do(rq)
+	if err != nil {
+		return GCPToken{}, err
+	}
+
+	return readTokenResponse(ctx, rs, "metadata server")
+}
+
+// readTokenResponse reads the access token and its lifetime the metadata server answers with
+// The lifetime starts at the time of the clock of the context
+func readTokenResponse(ctx context.Context, rs *http.Response, issuer string) (GCPToken, error) {
+	tokenResponse := struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+		Error       string `json:"error"`
+	}{}
+
+	bytes, err := ReadBody(rs)
+	if err != nil {
+		return GCPToken{}, err
+	}
+
+	// An empty body would fail to unmarshal with a confusing error, or end up on an empty bearer token
+	if len(bytes) == 0 {
+		return GCPToken{}, withStatus(rs.StatusCode, fmt.Errorf("%w: %s answered %d with an empty body", ErrTokenUnavailable, issuer, rs.StatusCode))
+	}
+
+	err = json.Unmarshal(bytes, &tokenResponse)
+	if err != nil {
+		return GCPToken{}, withStatus(rs.StatusCode, err)
+	}
+
+	if tokenResponse.AccessToken == "" {
+		return GCPToken{}, withStatus(rs.StatusCode, fmt.Errorf("%w: %s answered %d without an access token %s", ErrTokenUnavailable, issuer, rs.StatusCode, tokenResponse.Error))
+	}
+
+	return GCPToken{
+		AccessToken: tokenResponse.AccessToken,
+		Expiry:      clockFromContext(ctx).Now().Add(time.Duration(tokenResponse.ExpiresIn) * time.Second),
+	}, nil
+}
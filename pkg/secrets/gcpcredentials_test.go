@@ -0,0 +1,182 @@
+package secrets
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"golang.org/x/oauth2"
+)
+
+func TestReadTokenResponse(t *testing.T) {
+	tests := []struct {
+		name              string
+		status            int
+		body              string
+		expectedToken     string
+		tokenUnavailable  bool
+		expectedRetryable bool
+	}{
+		{name: "token", status: http.StatusOK, body: `{"access_token":"abc","expires_in":3600}`, expectedToken: "abc"},
+		{name: "empty body", status: http.StatusOK, body: "", tokenUnavailable: true, expectedRetryable: true},
+		{name: "empty body on server error", status: http.StatusServiceUnavailable, body: "", tokenUnavailable: true, expectedRetryable: true},
+		{name: "empty object", status: http.StatusOK, body: "{}", tokenUnavailable: true, expectedRetryable: true},
+		{name: "denied", status: http.StatusBadRequest, body: `{"error":"invalid_grant"}`, tokenUnavailable: true},
+		{name: "not JSON", status: http.StatusOK, body: "<html>", expectedRetryable: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rs := &http.Response{StatusCode: test.status, Body: ioutil.NopCloser(strings.NewReader(test.body))}
+			token, err := readTokenResponse(context.Background(), rs, "test")
+
+			if test.expectedToken != "" {
+				if err != nil {
+					t.Fatalf("expected token, got %s", err)
+				}
+				if token.AccessToken != test.expectedToken {
+					t.Errorf("expected token %s, got %s", test.expectedToken, token.AccessToken)
+				}
+				return
+			}
+
+			if err == nil {
+				t.Fatalf("expected error, got token %q", token.AccessToken)
+			}
+			if errors.Is(err, ErrTokenUnavailable) != test.tokenUnavailable {
+				t.Errorf("expected token unavailable %t, got %s", test.tokenUnavailable, err)
+			}
+			if isRetryable(err) != test.expectedRetryable {
+				t.Errorf("expected retryable %t, got %s", test.expectedRetryable, err)
+			}
+		})
+	}
+}
+
+func TestGCPProviderEmptyTokenBody(t *testing.T) {
+	var tokenCalls, secretCalls atomic.Int32
+	stubUpstream(t, func(w http.ResponseWriter, rq *http.Request) {
+		if rq.URL.Host == "metadata.google.internal" {
+			tokenCalls.Add(1)
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		secretCalls.Add(1)
+		w.WriteHeader(http.StatusUnauthorized)
+	})
+
+	provider := GCPProvider{
+		Project:            "my-project",
+		Credentials:        metadataCredentials{},
+		MetadataRetry:      RetryPolicy{Attempts: 3},
+		SecretManagerRetry: RetryPolicy{Attempts: 1},
+	}
+	_, err := provider.GetSecret(context.Background(), "db-password")
+
+	if !errors.Is(err, ErrTokenUnavailable) {
+		t.Errorf("expected token unavailable, got %v", err)
+	}
+	// The empty body is retried as a transient failure of the metadata server
+	if tokenCalls.Load() != 3 {
+		t.Errorf("expected 3 token calls, got %d", tokenCalls.Load())
+	}
+	// The secret is never asked for with an empty bearer token
+	if secretCalls.Load() != 0 {
+		t.Errorf("expected no secret calls, got %d", secretCalls.Load())
+	}
+}
+
+func TestFindDefaultCredentialsFromFile(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generating key: %s", err)
+	}
+	encoded, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatalf("encoding key: %s", err)
+	}
+	file, err := json.Marshal(map[string]string{
+		"type":           "service_account",
+		"client_email":   "app@my-project.iam.gserviceaccount.com",
+		"private_key_id": "key-1",
+		"private_key":    string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: encoded})),
+		"token_uri":      "https://oauth2.example.com/token",
+	})
+	if err != nil {
+		t.Fatalf("encoding file: %s", err)
+	}
+	path := filepath.Join(t.TempDir(), "credentials.json")
+	if err := ioutil.WriteFile(path, file, 0600); err != nil {
+		t.Fatalf("writing file: %s", err)
+	}
+
+	tests := []struct {
+		name          string
+		impersonate   string
+		expectedToken string
+	}{
+		{name: "service account", expectedToken: "service-account-token"},
+		{name: "impersonated", impersonate: "reader@my-project.iam.gserviceaccount.com", expectedToken: "impersonated-token"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Setenv("GOOGLE_APPLICATION_CREDENTIALS", path)
+			t.Setenv("IMPERSONATE_SERVICE_ACCOUNT", test.impersonate)
+			// The token endpoints are only reached through UpstreamClient
+			stubUpstream(t, func(w http.ResponseWriter, rq *http.Request) {
+				switch rq.URL.String() {
+				case "https://oauth2.example.com/token":
+					if rq.FormValue("grant_type") != "urn:ietf:params:oauth:grant-type:jwt-bearer" || rq.FormValue("assertion") == "" {
+						http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+						return
+					}
+					_, _ = w.Write([]byte(`{"access_token":"service-account-token","token_type":"Bearer","expires_in":3600}`))
+				case "https://iamcredentials.googleapis.com/v1/projects/-/serviceAccounts/reader@my-project.iam.gserviceaccount.com:generateAccessToken":
+					if rq.Header.Get("Authorization") != "Bearer service-account-token" {
+						http.Error(w, `{"error":{"code":401}}`, http.StatusUnauthorized)
+						return
+					}
+					_, _ = w.Write([]byte(`{"accessToken":"impersonated-token","expireTime":"2099-01-02T03:04:05Z"}`))
+				default:
+					http.NotFound(w, rq)
+				}
+			})
+
+			credentials, err := FindDefaultCredentials()
+			if err != nil {
+				t.Fatalf("finding credentials: %s", err)
+			}
+			token, err := credentials.Token(context.Background())
+			if err != nil {
+				t.Fatalf("getting token: %s", err)
+			}
+			if token.AccessToken != test.expectedToken {
+				t.Errorf("expected %s, got %s", test.expectedToken, token.AccessToken)
+			}
+		})
+	}
+}
+
+func TestTokenSourceCredentialsKeepTheStatus(t *testing.T) {
+	stubUpstream(t, func(w http.ResponseWriter, rq *http.Request) {
+		http.Error(w, `{"error":"unavailable"}`, http.StatusServiceUnavailable)
+	})
+	config := &oauth2.Config{Endpoint: oauth2.Endpoint{TokenURL: "https://oauth2.example.com/token"}}
+	credentials := tokenSourceCredentials{source: config.TokenSource(upstreamContext(), &oauth2.Token{RefreshToken: "refresh"})}
+
+	_, err := credentials.Token(context.Background())
+	if !errors.Is(err, ErrTokenUnavailable) || !isRetryable(err) {
+		t.Errorf("expected a retryable unavailable token, got %v", err)
+	}
+}
@@ -0,0 +1,473 @@
+package secrets
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// Policy tells what to do when the provider answers with an authoritative error
+type Policy string
+
+const (
+	// PolicyFallback returns the fallback value as if the secret had been found
+	PolicyFallback Policy = "fallback"
+	// PolicyError surfaces the error to the caller
+	PolicyError Policy = "error"
+)
+
+var (
+	// ErrSecretNotFound is returned when the secret does not exist and OnNotFound is PolicyError
+	ErrSecretNotFound = errors.New("secret not found")
+	// ErrPermissionDenied is returned when access to the secret is denied and OnForbidden is PolicyError
+	ErrPermissionDenied = errors.New("permission denied")
+	// ErrOverloaded is returned when the provider is being shed and OnShed is PolicyError
+	ErrOverloaded = errors.New("secret provider is overloaded")
+	// ErrUnavailable is returned when the provider fails and there is no value to serve instead
+	ErrUnavailable = errors.New("secret provider is unavailable")
+	// ErrTokenUnavailable is a transient failure getting the access token for the provider
+	ErrTokenUnavailable = errors.New("access token unavailable")
+)
+
+// ParsePolicy parses a Policy, defaulting to PolicyFallback when the value is empty
+func ParsePolicy(value string) (Policy, error) {
+	switch Policy(value) {
+	case "", PolicyFallback:
+		return PolicyFallback, nil
+	case PolicyError:
+		return PolicyError, nil
+	default:
+		return "", fmt.Errorf("unknown policy %q, expected %q or %q", value, PolicyFallback, PolicyError)
+	}
+}
+
+type SecretGetter struct {
+	// Provider is the backend secrets come from, secrets come from the environment when nil
+	Provider SecretProvider
+	// Prefix is prepended to every name, both for the provider and for environment variables
+	Prefix string
+	// OnForbidden tells what to do when the provider denies access to a secret
+	OnForbidden Policy
+	// OnNotFound tells what to do when the provider does not have a secret
+	OnNotFound Policy
+	// VersionMetadataCache keeps version metadata apart from values, with its own TTL
+	VersionMetadataCache *TTLCache
+	// EnvFile is looked up after the environment variables when there is no provider, it is optional
+	EnvFile *EnvFile
+	// RequireFallback makes an empty fallback mean there is none, so failures are errors instead of empty values
+	// This also stops the handlers from making up default-for-<name> fallbacks
+	RequireFallback bool
+	// StrictEnv makes a secret missing from the environment an error rather than the fallback
+	StrictEnv bool
+	// Cache keeps the values fetched from the provider for a while, it is optional
+	Cache Cache
+	// Shedder stops calling the provider on cache misses while its latency is too high, it is optional
+	Shedder *LoadShedder
+	// OnShed tells what to do on a cache miss while shedding, when there is no last known good value
+	OnShed Policy
+	// Breaker stops calling the provider on cache misses while it keeps failing, it is optional
+	Breaker *CircuitBreaker
+	// Flights collapses the concurrent misses of a secret into a single fetch, it is optional
+	Flights *SingleFlight
+	// StaleCache keeps the last known good values for the stale window, to be served on transient errors
+	StaleCache *TTLCache
+	// DiskCache keeps the last known good values to be served during outages, it is optional
+	DiskCache *DiskCache
+	// Served tracks the distinct secrets served and their sources, it is optional
+	Served *ServedSecrets
+	// Metrics counts the sources tried for every secret and times the calls to the provider, it is optional
+	Metrics *Metrics
+	// Redactor is given every value resolved, so they are scrubbed from the logs, it is optional
+	Redactor *Redactor
+	// OnResolve is called after every resolution with where the value came from, it is never given the value
+	OnResolve func(name, source string, err error)
+	// Clock is used for every time-based decision, defaults to the real clock when nil
+	Clock Clock
+	// RetryOverrides replace settings of the retry policy of the provider for some secrets, by their names on the
+	// backend, which include the prefix
+	RetryOverrides map[string]RetryOverride
+}
+
+// Now returns the current time according to the configured clock
+func (sg SecretGetter) Now() time.Time {
+	if sg.Clock == nil {
+		return RealClock{}.Now()
+	}
+	return sg.Clock.Now()
+}
+
+// GetSecret gets a secret either from environment variable or from the provider
+// ErrSecretNotFound and ErrPermissionDenied are returned according to the OnNotFound and OnForbidden policies
+// instead of the fallback, and ErrUnavailable when the provider fails with no value to serve instead
+// With RequireFallback and an empty fallback, any failure is returned as an error
+func (sg SecretGetter) GetSecret(name string, fallback string) (string, error) {
+	resolution, err := sg.ResolveContext(context.Background(), name, fallback)
+	if err != nil {
+		return "", err
+	}
+	return resolution.Value, nil
+}
+
+// Source tells where the value of a secret came from
+type Source string
+
+const (
+	SourceEnv       Source = "env"
+	SourceEnvFile   Source = "env-file"
+	SourceCache     Source = "cache"
+	SourceStale     Source = "stale"
+	SourceDiskCache Source = "disk-cache"
+	SourceFallback  Source = "fallback"
+)
+
+// Resolution is the value of a secret together with where it came from
+type Resolution struct {
+	Value  string
+	Source Source
+	// Attempts are the sources tried, in order, to get to the value
+	Attempts []Attempt
+}
+
+// IsFallback tells if the value is the fallback rather than a real one
+func (r Resolution) IsFallback() bool {
+	return r.Source == SourceFallback
+}
+
+// Outcomes of trying a source
+const (
+	OutcomeHit   = "hit"
+	OutcomeMiss  = "miss"
+	OutcomeError = "error"
+)
+
+// Attempt is a source tried while resolving a secret and its outcome, it never includes the value
+type Attempt struct {
+	Source  Source `json:"source"`
+	Outcome string `json:"outcome"`
+}
+
+// trace collects the attempts of a resolution
+type trace []Attempt
+
+func (t *trace) add(source Source, outcome string) {
+	*t = append(*t, Attempt{Source: source, Outcome: outcome})
+}
+
+// Resolve is like GetSecret, but also tells where the value came from
+func (sg SecretGetter) Resolve(name string, fallback string) (Resolution, error) {
+	return sg.ResolveContext(context.Background(), name, fallback)
+}
+
+// ResolveContext is like Resolve, but the calls to the provider are bounded by the context
+func (sg SecretGetter) ResolveContext(ctx context.Context, name string, fallback string) (Resolution, error) {
+	var t trace
+	resolution, err := sg.resolve(ctx, name, fallback, &t)
+	resolution.Attempts = t
+	sg.Served.Record(name, resolution.Source)
+	sg.Metrics.ObserveResolution(name, t, resolution.Source, err)
+	if err == nil && !resolution.IsFallback() {
+		sg.Redactor.Add(resolution.Value)
+	}
+	slog.Debug("resolved secret", "name", name, "source", resolution.Source, "error", err)
+
+	if sg.OnResolve != nil {
+		sg.OnResolve(name, string(resolution.Source), err)
+	}
+	return resolution, err
+}
+
+// resolve gets the secret from the configured sources, adding every source tried to the trace
+func (sg SecretGetter) resolve(ctx context.Context, name string, fallback string, t *trace) (Resolution, error) {
+	// The prefix applies the same on both modes, so switching modes does not change which keys resolve
+	name = sg.Prefix + name
+
+	// If there is no provider, get value from environment variables
+	if sg.Provider == nil {
+		return sg.lookupEnv(name, fallback, t)
+	}
+
+	// Secrets of other projects asked for by the request are cached apart
+	key := cacheKey(projectFromContext(ctx), name)
+
+	if sg.Cache != nil {
+		_, span := StartSpan(ctx, "cache lookup")
+		value, ok := sg.Cache.Get(key)
+		span.SetAttribute("secret.name", name)
+		span.SetAttribute("cache.hit", strconv.FormatBool(ok))
+		span.End(nil)
+		if ok {
+			t.add(SourceCache, OutcomeHit)
+			return Resolution{Value: value, Source: SourceCache}, nil
+		}
+		t.add(SourceCache, OutcomeMiss)
+	}
+
+	// While the backend is slow, serve what we have instead of queueing more requests on it
+	if !sg.Shedder.Allow() {
+		if resolution, ok := sg.lastKnownGood(key, t); ok {
+			return resolution, nil
+		}
+		if sg.OnShed == PolicyError {
+			return Resolution{}, ErrOverloaded
+		}
+		return sg.fallback(fallback, ErrOverloaded, t)
+	}
+
+	// While the backend is failing, answer right away with what we have instead of waiting for it to fail again
+	if !sg.Breaker.Allow() {
+		if resolution, ok := sg.lastKnownGood(key, t); ok {
+			return resolution, nil
+		}
+		return sg.fallback(fallback, ErrUnavailable, t)
+	}
+
+	// Concurrent misses of the same secret share a single fetch, which is the one observed and remembered
+	source := ProviderSource(sg.Provider)
+	value, err := sg.Flights.Do(ctx, key, func(ctx context.Context) (string, error) {
+		// Caches shared with other processes are locked around the fetch, so a miss on all of them fetches once
+		if locker, ok := sg.Cache.(CacheLocker); ok {
+			unlock, err := locker.Lock(ctx, key)
+			if err != nil && ctx.Err() != nil {
+				return "", err
+			}
+			if err != nil {
+				slog.Warn("locking shared cache, fetching without the lock", "error", err)
+			} else {
+				defer unlock()
+				// Another process may have fetched the secret while this one waited for the lock
+				if value, ok := sg.Cache.Get(key); ok {
+					return value, nil
+				}
+			}
+		}
+
+		start := sg.Now()
+		fetchCtx, span := StartSpan(ctx, "secret fetch")
+		span.SetAttribute("secret.name", name)
+		span.SetAttribute("secret.source", string(source))
+		value, err := sg.fetchSecretValue(fetchCtx, name)
+		span.End(err)
+		latency := sg.Now().Sub(start)
+		sg.Shedder.Observe(latency)
+		if ctx.Err() != nil {
+			// The deadline of the caller passing says nothing about the backend
+			sg.Breaker.Abandon()
+		} else {
+			sg.Breaker.Observe(err)
+		}
+		sg.Metrics.ObserveUpstream(source, latency, err)
+		if err == nil {
+			sg.remember(key, value)
+		}
+		return value, err
+	})
+	switch {
+	case err == nil:
+		t.add(source, OutcomeHit)
+		return Resolution{Value: value, Source: source}, nil
+	case errors.Is(err, ErrSecretNotFound):
+		// Not found and permission denied are authoritative, so they are handled by the policies
+		slog.Warn("secret not found", "name", name, "error", err)
+		t.add(source, OutcomeMiss)
+		sg.forget(key)
+		if sg.OnNotFound == PolicyError {
+			return Resolution{}, ErrSecretNotFound
+		}
+		return sg.fallback(fallback, ErrSecretNotFound, t)
+	case errors.Is(err, ErrPermissionDenied):
+		slog.Warn("secret access denied", "name", name, "error", err)
+		t.add(source, OutcomeError)
+		sg.forget(key)
+		if sg.OnForbidden == PolicyError {
+			return Resolution{}, ErrPermissionDenied
+		}
+		return sg.fallback(fallback, ErrPermissionDenied, t)
+	default:
+		// In case there is any other error, prefer the last known good value over the fallback
+		slog.Error("fetching secret", "name", name, "error", err)
+		t.add(source, OutcomeError)
+		if resolution, ok := sg.lastKnownGood(key, t); ok {
+			return resolution, nil
+		}
+		return sg.fallback(fallback, ErrUnavailable, t)
+	}
+}
+
+// fallback returns the fallback as the resolution
+// When RequireFallback is set and there is no fallback, the cause of needing one is returned instead
+func (sg SecretGetter) fallback(fallback string, cause error, t *trace) (Resolution, error) {
+	if fallback == "" && sg.RequireFallback {
+		t.add(SourceFallback, OutcomeMiss)
+		return Resolution{}, cause
+	}
+
+	t.add(SourceFallback, OutcomeHit)
+	return Resolution{Value: fallback, Source: SourceFallback}, nil
+}
+
+// lastKnownGood returns the last value fetched from the provider, if still within the stale window or on disk
+func (sg SecretGetter) lastKnownGood(name string, t *trace) (Resolution, bool) {
+	if stale, ok := sg.StaleCache.Get(name); ok {
+		slog.Warn("serving stale value", "name", name)
+		t.add(SourceStale, OutcomeHit)
+		return Resolution{Value: stale.(string), Source: SourceStale}, true
+	}
+	if sg.StaleCache != nil {
+		t.add(SourceStale, OutcomeMiss)
+	}
+
+	if cached, ok := sg.DiskCache.Get(name); ok {
+		t.add(SourceDiskCache, OutcomeHit)
+		return Resolution{Value: cached, Source: SourceDiskCache}, true
+	}
+	if sg.DiskCache != nil {
+		t.add(SourceDiskCache, OutcomeMiss)
+	}
+	return Resolution{}, false
+}
+
+// SweepIdle removes the cached values that were not accessed within the idle window, in memory or on the shared
+// cache directory, so plaintext secrets that are no longer used are not kept around, it returns how many were removed
+func (sg SecretGetter) SweepIdle(idle time.Duration) int {
+	evicted := sg.StaleCache.EvictIdle(idle)
+	if cache, ok := sg.Cache.(interface{ EvictIdle(time.Duration) int }); ok {
+		evicted += cache.EvictIdle(idle)
+	}
+	return evicted
+}
+
+// remember caches the value fetched from the provider
+func (sg SecretGetter) remember(name string, value string) {
+	if sg.Cache != nil {
+		sg.Cache.Set(name, value)
+	}
+	sg.StaleCache.Set(name, value)
+
+	// Keep the last known good value in case the provider becomes unavailable
+	if err := sg.DiskCache.Set(name, value); err != nil {
+		slog.Error("writing disk cache", "name", name, "error", err)
+	}
+}
+
+// forget removes every cached value of the secret, used when the provider says it is gone or denied
+func (sg SecretGetter) forget(name string) {
+	if sg.Cache != nil {
+		sg.Cache.Delete(name)
+	}
+	sg.StaleCache.Delete(name)
+	if err := sg.DiskCache.Delete(name); err != nil {
+		slog.Error("deleting from disk cache", "name", name, "error", err)
+	}
+}
+
+// forgetEveryProject removes every cached value and version metadata of the secret, for every project it was
+// cached for, used when it is written so no layer serves a value the write replaced or revoked
+func (sg SecretGetter) forgetEveryProject(name string) {
+	sameName := func(key string) bool {
+		_, keyName := splitCacheKey(key)
+		return keyName == name
+	}
+
+	if sg.Cache != nil {
+		for _, key := range sg.Cache.Names() {
+			if sameName(key) {
+				sg.Cache.Delete(key)
+			}
+		}
+	}
+	for _, key := range sg.StaleCache.Keys() {
+		if sameName(key) {
+			sg.StaleCache.Delete(key)
+		}
+	}
+	for _, key := range sg.VersionMetadataCache.Keys() {
+		if sameName(key) {
+			sg.VersionMetadataCache.Delete(key)
+		}
+	}
+	for _, key := range sg.DiskCache.Names() {
+		if !sameName(key) {
+			continue
+		}
+		if err := sg.DiskCache.Delete(key); err != nil {
+			slog.Error("deleting from disk cache", "name", displayKey(key), "error", err)
+		}
+	}
+}
+
+// Invalidate removes the cached value and version metadata of the secret, so the next read gets its latest version
+// The entries of the secret cached for other projects asked for by requests are removed too
+// The last known good values are kept, as they are only served when the provider fails
+func (sg SecretGetter) Invalidate(name string) {
+	name = sg.Prefix + name
+	if sg.Cache != nil {
+		for _, key := range sg.Cache.Names() {
+			if _, keyName := splitCacheKey(key); keyName == name {
+				sg.Cache.Delete(key)
+			}
+		}
+	}
+	for _, key := range sg.VersionMetadataCache.Keys() {
+		if _, keyName := splitCacheKey(key); keyName == name {
+			sg.VersionMetadataCache.Delete(key)
+		}
+	}
+}
+
+// lookupEnv gets the secret from the environment variables, then from the env file, then the fallback
+// When StrictEnv is set, a missing secret is ErrSecretNotFound instead of the fallback
+func (sg SecretGetter) lookupEnv(name string, fallback string, t *trace) (Resolution, error) {
+	if value, ok := syscall.Getenv(name); ok {
+		t.add(SourceEnv, OutcomeHit)
+		return Resolution{Value: value, Source: SourceEnv}, nil
+	}
+	t.add(SourceEnv, OutcomeMiss)
+
+	if value, ok := sg.EnvFile.Lookup(name); ok {
+		t.add(SourceEnvFile, OutcomeHit)
+		return Resolution{Value: value, Source: SourceEnvFile}, nil
+	}
+	if sg.EnvFile != nil {
+		t.add(SourceEnvFile, OutcomeMiss)
+	}
+
+	if sg.StrictEnv {
+		return Resolution{}, ErrSecretNotFound
+	}
+	return sg.fallback(fallback, ErrSecretNotFound, t)
+}
+
+// fetchSecretValue gets the secret from the provider
+func (sg SecretGetter) fetchSecretValue(ctx context.Context, name string) (string, error) {
+	return sg.Provider.GetSecret(sg.providerContext(ctx, name), name)
+}
+
+// providerContext returns the context for the calls to the provider about the secret, carrying the clock and the
+// retry override of the secret if any
+func (sg SecretGetter) providerContext(ctx context.Context, name string) context.Context {
+	ctx = sg.clockContext(ctx)
+	if override, ok := sg.RetryOverrides[name]; ok {
+		return withRetryOverride(ctx, override)
+	}
+	return ctx
+}
+
+// clockContext returns the context for the calls to the provider, which tell the time with the configured clock
+func (sg SecretGetter) clockContext(ctx context.Context) context.Context {
+	if sg.Clock == nil {
+		return ctx
+	}
+	return WithClock(ctx, sg.Clock)
+}
+
+// DefaultFallback returns the made up fallback the handlers use, which is empty with RequireFallback
+func (sg SecretGetter) DefaultFallback(name string) string {
+	if sg.RequireFallback {
+		return ""
+	}
+	return fmt.Sprintf("default-for-%s", name)
+}
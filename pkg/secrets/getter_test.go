@@ -0,0 +1,109 @@
+package secrets
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestPrefixAppliesOnBothModes(t *testing.T) {
+	values := map[string]string{"APP_DB_PASSWORD": "prefixed", "DB_PASSWORD": "unprefixed"}
+
+	tests := []struct {
+		name          string
+		prefix        string
+		requested     string
+		expectedValue string
+		expectedFound bool
+	}{
+		{name: "prefixed", prefix: "APP_", requested: "DB_PASSWORD", expectedValue: "prefixed", expectedFound: true},
+		{name: "no prefix", prefix: "", requested: "DB_PASSWORD", expectedValue: "unprefixed", expectedFound: true},
+		{name: "prefix is not stripped from the request", prefix: "APP_", requested: "APP_DB_PASSWORD", expectedFound: false},
+		{name: "missing with prefix", prefix: "APP_", requested: "API_KEY", expectedFound: false},
+	}
+
+	for _, test := range tests {
+		t.Run("env "+test.name, func(t *testing.T) {
+			for name, value := range values {
+				t.Setenv(name, value)
+			}
+
+			sg := SecretGetter{Prefix: test.prefix, RequireFallback: true}
+			assertResolution(t, sg, test.requested, test.expectedValue, test.expectedFound)
+		})
+
+		t.Run("provider "+test.name, func(t *testing.T) {
+			sg := SecretGetter{Provider: NewMemoryProvider(values), Prefix: test.prefix, RequireFallback: true}
+			assertResolution(t, sg, test.requested, test.expectedValue, test.expectedFound)
+		})
+	}
+}
+
+// assertResolution checks the secret resolves to the value, or that it is not found
+func assertResolution(t *testing.T, sg SecretGetter, name string, expectedValue string, expectedFound bool) {
+	t.Helper()
+	resolution, err := sg.Resolve(name, "")
+	if !expectedFound {
+		if err == nil {
+			t.Errorf("expected %s not to be found, got %q from %s", name, resolution.Value, resolution.Source)
+		}
+		return
+	}
+	if err != nil {
+		t.Fatalf("resolving %s: %s", name, err)
+	}
+	if resolution.Value != expectedValue {
+		t.Errorf("expected %q, got %q", expectedValue, resolution.Value)
+	}
+}
+
+// failingProvider fails every fetch with its error
+type failingProvider struct {
+	err error
+}
+
+func (p failingProvider) GetSecret(ctx context.Context, name string) (string, error) {
+	return "", p.err
+}
+
+func TestGetSecretReturnsErrors(t *testing.T) {
+	denied := failingProvider{err: fmt.Errorf("%w: db-password", ErrPermissionDenied)}
+	missing := failingProvider{err: fmt.Errorf("%w: db-password", ErrSecretNotFound)}
+	broken := failingProvider{err: errors.New("connection reset")}
+
+	tests := []struct {
+		name          string
+		sg            SecretGetter
+		fallback      string
+		expectedValue string
+		expectedErr   error
+	}{
+		{name: "found", sg: SecretGetter{Provider: NewMemoryProvider(map[string]string{"db-password": "live"})}, fallback: "default", expectedValue: "live"},
+		{name: "denied with error policy", sg: SecretGetter{Provider: denied, OnForbidden: PolicyError}, fallback: "default", expectedErr: ErrPermissionDenied},
+		{name: "denied with fallback policy", sg: SecretGetter{Provider: denied, OnForbidden: PolicyFallback}, fallback: "default", expectedValue: "default"},
+		{name: "denied without a fallback", sg: SecretGetter{Provider: denied, RequireFallback: true}, expectedErr: ErrPermissionDenied},
+		{name: "missing with error policy", sg: SecretGetter{Provider: missing, OnNotFound: PolicyError}, fallback: "default", expectedErr: ErrSecretNotFound},
+		{name: "missing with fallback policy", sg: SecretGetter{Provider: missing}, fallback: "default", expectedValue: "default"},
+		{name: "upstream failure without a fallback", sg: SecretGetter{Provider: broken, RequireFallback: true}, expectedErr: ErrUnavailable},
+		{name: "upstream failure with a fallback", sg: SecretGetter{Provider: broken}, fallback: "default", expectedValue: "default"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			value, err := test.sg.GetSecret("db-password", test.fallback)
+			if test.expectedErr != nil {
+				if !errors.Is(err, test.expectedErr) {
+					t.Errorf("expected %v, got %q (%v)", test.expectedErr, value, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("getting secret: %s", err)
+			}
+			if value != test.expectedValue {
+				t.Errorf("expected %q, got %q", test.expectedValue, value)
+			}
+		})
+	}
+}
@@ -0,0 +1,73 @@
+package secrets
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+)
+
+// ReadinessChecker is implemented by providers that can tell if they are able to serve secrets,
+// which usually means their backend is reachable and credentials for it can be obtained
+type ReadinessChecker interface {
+	CheckReady(ctx context.Context) error
+}
+
+// CheckReady tells if secrets can be served, env-only mode and providers that cannot check are always ready
+func (sg SecretGetter) CheckReady(ctx context.Context) error {
+	checker, ok := sg.Provider.(ReadinessChecker)
+	if !ok {
+		return nil
+	}
+	return checker.CheckReady(sg.clockContext(ctx))
+}
+
+// CheckReady gets an access token, from the metadata server or the credentials file
+func (p GCPProvider) CheckReady(ctx context.Context) error {
+	_, err := p.getToken(ctx)
+	return err
+}
+
+// CheckReady gets the credentials of the chain
+func (p AWSProvider) CheckReady(ctx context.Context) error {
+	_, err := p.credentials.get(ctx)
+	return err
+}
+
+// CheckReady gets a token from the managed identity endpoint
+func (p AzureProvider) CheckReady(ctx context.Context) error {
+	_, err := p.token.get(ctx)
+	return err
+}
+
+// CheckReady logs in to Vault, or keeps the static token
+func (p VaultProvider) CheckReady(ctx context.Context) error {
+	_, err := p.auth.get(ctx, p)
+	return err
+}
+
+// CheckReady asks the API server for its version with the service account token
+func (p KubernetesProvider) CheckReady(ctx context.Context) error {
+	token, err := readSecretFile(p.TokenFile)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrTokenUnavailable, err)
+	}
+
+	rq, err := http.NewRequestWithContext(ctx, http.MethodGet, p.Server+"/version", nil)
+	if err != nil {
+		return err
+	}
+
+	rq.Header.Add("Authorization", fmt.Sprintf("Bearer %s", token))
+	rs, err := p.client.Do(rq)
+	if err != nil {
+		return err
+	}
+	_, err = ReadBody(rs)
+	if err != nil {
+		return err
+	}
+	if rs.StatusCode != http.StatusOK {
+		return fmt.Errorf("API server answered %d", rs.StatusCode)
+	}
+	return nil
+}
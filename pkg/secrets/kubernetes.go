@@ -0,0 +1,156 @@
+package secrets
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+)
+
+// SourceKubernetes is the source of values coming from Kubernetes Secrets
+const SourceKubernetes Source = "kubernetes"
+
+// serviceAccountDir is where Kubernetes mounts the token, CA and namespace of the pod service account
+const serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+func init() {
+	RegisterProvider("kubernetes", newKubernetesProviderFromEnv)
+}
+
+// KubernetesProvider gets secrets from the Secrets of a namespace, talking to the API server as the pod service account
+// Every secret is a Secret object, and its value is one key of its data
+type KubernetesProvider struct {
+	// Server is the base URL of the API server
+	Server    string
+	Namespace string
+	// Key is the key of the data that holds the value
+	Key string
+	// TokenFile is read on every request since the kubelet rotates the token
+	TokenFile string
+	// Retry applies to every secret fetch
+	Retry  RetryPolicy
+	client *http.Client
+}
+
+// Source tells the values come from Kubernetes Secrets
+func (p KubernetesProvider) Source() Source {
+	return SourceKubernetes
+}
+
+// GetSecret gets the secret object, retried according to Retry
+func (p KubernetesProvider) GetSecret(ctx context.Context, name string) (string, error) {
+	var value string
+	err := p.Retry.do(ctx, func(ctx context.Context) error {
+		var err error
+		value, err = p.fetchSecret(ctx, name)
+		return err
+	})
+	return value, err
+}
+
+// fetchSecret reads the secret object from the API server
+func (p KubernetesProvider) fetchSecret(ctx context.Context, name string) (string, error) {
+	token, err := readSecretFile(p.TokenFile)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrTokenUnavailable, err)
+	}
+
+	secretUrl := fmt.Sprintf("%s/api/v1/namespaces/%s/secrets/%s", p.Server, url.PathEscape(p.Namespace), url.PathEscape(name))
+	rq, err := http.NewRequestWithContext(ctx, http.MethodGet, secretUrl, nil)
+	if err != nil {
+		return "", err
+	}
+
+	rq.Header.Add("Authorization", fmt.Sprintf("Bearer %s", token))
+	rq.Header.Add("Accept", "application/json")
+	rs, err := p.client.Do(rq)
+	if err != nil {
+		return "", err
+	}
+
+	// Errors come as a Status object, data is base64 encoded
+	secretResponse := struct {
+		Message string            `json:"message"`
+		Reason  string            `json:"reason"`
+		Data    map[string]string `json:"data"`
+	}{}
+
+	bytes, err := ReadBody(rs)
+	if err != nil {
+		return "", err
+	}
+
+	err = json.Unmarshal(bytes, &secretResponse)
+	if err != nil && rs.StatusCode == http.StatusOK {
+		return "", err
+	}
+
+	switch rs.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return "", fmt.Errorf("%w: error %d - %s", ErrSecretNotFound, rs.StatusCode, secretResponse.Reason)
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return "", fmt.Errorf("%w: error %d - %s", ErrPermissionDenied, rs.StatusCode, secretResponse.Message)
+	default:
+		return "", withStatus(rs.StatusCode, fmt.Errorf("error %d - %s %s", rs.StatusCode, secretResponse.Reason, secretResponse.Message))
+	}
+
+	encoded, ok := secretResponse.Data[p.Key]
+	if !ok {
+		return "", fmt.Errorf("%w: key %s not found on %s", ErrSecretNotFound, p.Key, name)
+	}
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// newKubernetesProviderFromEnv builds the Kubernetes provider from the in-cluster configuration of the pod
+func newKubernetesProviderFromEnv() (SecretProvider, error) {
+	host, port := GetEnv("KUBERNETES_SERVICE_HOST", ""), GetEnv("KUBERNETES_SERVICE_PORT", "")
+	if host == "" || port == "" {
+		return nil, errors.New("KUBERNETES_SERVICE_HOST and KUBERNETES_SERVICE_PORT are required for the kubernetes backend, is this running on a pod")
+	}
+
+	ca, err := ioutil.ReadFile(serviceAccountDir + "/ca.crt")
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, errors.New("no certificates found on the service account CA")
+	}
+
+	// The namespace of the pod is used unless another one is given
+	namespace := GetEnv("KUBERNETES_NAMESPACE", "")
+	if namespace == "" {
+		namespace, err = readSecretFile(serviceAccountDir + "/namespace")
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	retry, err := getRetryPolicy("KUBERNETES", RetryPolicy{Attempts: 1})
+	if err != nil {
+		return nil, err
+	}
+
+	return KubernetesProvider{
+		Server:    "https://" + net.JoinHostPort(host, port),
+		Namespace: namespace,
+		Key:       GetEnv("KUBERNETES_SECRET_KEY", "value"),
+		TokenFile: serviceAccountDir + "/token",
+		Retry:     retry,
+		client: &http.Client{
+			Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}},
+		},
+	}, nil
+}
@@ -0,0 +1,191 @@
+package secrets
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// redactedValue replaces secret values and sensitive attributes on logs
+const redactedValue = "[REDACTED]"
+
+// maxRedactedValues bounds how many values are scrubbed from logs, the oldest are forgotten first
+const maxRedactedValues = 10000
+
+// sensitiveLogKeys are the attribute keys whose values are always redacted, whatever they hold
+var sensitiveLogKeys = map[string]bool{
+	"value":         true,
+	"secret":        true,
+	"token":         true,
+	"password":      true,
+	"authorization": true,
+	"api_key":       true,
+}
+
+// Redactor keeps the secret values served, so they are scrubbed from every log line even when an error quotes them
+// A nil Redactor keeps nothing
+type Redactor struct {
+	mu     sync.RWMutex
+	values map[string]bool
+	order  []string
+	// replacer replaces every value in a single pass, it is dropped when a value is added and built again on the next
+	// Redact, so values added together are built once
+	replacer *strings.Replacer
+}
+
+// NewRedactor returns a redactor without values
+func NewRedactor() *Redactor {
+	return &Redactor{values: map[string]bool{}}
+}
+
+// Add remembers a value to be scrubbed from logs
+func (r *Redactor) Add(value string) {
+	if r == nil || value == "" {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.values[value] {
+		return
+	}
+	if len(r.order) >= maxRedactedValues {
+		delete(r.values, r.order[0])
+		r.order = r.order[1:]
+	}
+	r.values[value] = true
+	r.order = append(r.order, value)
+	r.replacer = nil
+}
+
+// Redact replaces every known value found on the text
+func (r *Redactor) Redact(text string) string {
+	if r == nil {
+		return text
+	}
+
+	r.mu.RLock()
+	replacer := r.replacer
+	r.mu.RUnlock()
+	if replacer == nil {
+		replacer = r.buildReplacer()
+	}
+	return replacer.Replace(text)
+}
+
+// buildReplacer builds the replacer of the current values
+func (r *Redactor) buildReplacer() *strings.Replacer {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.replacer != nil {
+		return r.replacer
+	}
+
+	// At every position the replacer takes the first value that matches, so longer values go first and a value
+	// containing another one is redacted whole
+	sorted := append([]string{}, r.order...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return len(sorted[i]) > len(sorted[j])
+	})
+	pairs := make([]string, 0, 2*len(sorted))
+	for _, value := range sorted {
+		pairs = append(pairs, value, redactedValue)
+	}
+	r.replacer = strings.NewReplacer(pairs...)
+	return r.replacer
+}
+
+// redactingHandler scrubs secret values from the message and attributes of every record before the wrapped handler
+// gets them, so no level, not even debug, can log a value
+type redactingHandler struct {
+	handler  slog.Handler
+	redactor *Redactor
+}
+
+func (h redactingHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.handler.Enabled(ctx, level)
+}
+
+func (h redactingHandler) Handle(ctx context.Context, record slog.Record) error {
+	redacted := slog.NewRecord(record.Time, record.Level, h.redactor.Redact(record.Message), record.PC)
+	record.Attrs(func(attr slog.Attr) bool {
+		redacted.AddAttrs(h.redactAttr(attr))
+		return true
+	})
+	return h.handler.Handle(ctx, redacted)
+}
+
+func (h redactingHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	redacted := make([]slog.Attr, 0, len(attrs))
+	for _, attr := range attrs {
+		redacted = append(redacted, h.redactAttr(attr))
+	}
+	return redactingHandler{handler: h.handler.WithAttrs(redacted), redactor: h.redactor}
+}
+
+func (h redactingHandler) WithGroup(name string) slog.Handler {
+	return redactingHandler{handler: h.handler.WithGroup(name), redactor: h.redactor}
+}
+
+// redactAttr redacts sensitive keys entirely, and scrubs known values from the rest, errors included
+func (h redactingHandler) redactAttr(attr slog.Attr) slog.Attr {
+	if sensitiveLogKeys[strings.ToLower(attr.Key)] {
+		return slog.String(attr.Key, redactedValue)
+	}
+
+	value := attr.Value.Resolve()
+	switch value.Kind() {
+	case slog.KindString:
+		return slog.String(attr.Key, h.redactor.Redact(value.String()))
+	case slog.KindGroup:
+		group := value.Group()
+		redacted := make([]any, 0, len(group))
+		for _, member := range group {
+			redacted = append(redacted, h.redactAttr(member))
+		}
+		return slog.Group(attr.Key, redacted...)
+	case slog.KindAny:
+		if value.Any() == nil {
+			return attr
+		}
+		// Errors and other values are logged as their text, which may quote a value
+		return slog.String(attr.Key, h.redactor.Redact(fmt.Sprint(value.Any())))
+	default:
+		return slog.Attr{Key: attr.Key, Value: value}
+	}
+}
+
+// logLevels are the levels that can be configured
+var logLevels = map[string]slog.Level{
+	"debug": slog.LevelDebug,
+	"info":  slog.LevelInfo,
+	"warn":  slog.LevelWarn,
+	"error": slog.LevelError,
+}
+
+// NewLogger returns a logger writing text or JSON records at or above the level, scrubbing the values of the redactor
+func NewLogger(w io.Writer, level string, format string, redactor *Redactor) (*slog.Logger, error) {
+	if level == "" {
+		level = "info"
+	}
+	minLevel, ok := logLevels[strings.ToLower(level)]
+	if !ok {
+		return nil, fmt.Errorf("unknown log level %q, expected debug, info, warn or error", level)
+	}
+
+	options := &slog.HandlerOptions{Level: minLevel}
+	var handler slog.Handler
+	switch format {
+	case "", "text":
+		handler = slog.NewTextHandler(w, options)
+	case "json":
+		handler = slog.NewJSONHandler(w, options)
+	default:
+		return nil, fmt.Errorf("unknown log format %q, expected text or json", format)
+	}
+	return slog.New(redactingHandler{handler: handler, redactor: redactor}), nil
+}
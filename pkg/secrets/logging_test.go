@@ -0,0 +1,74 @@
+package secrets
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestRedactorRedacts(t *testing.T) {
+	redactor := NewRedactor()
+	for _, value := range []string{"hunt", "hunter2", "x9", ""} {
+		redactor.Add(value)
+	}
+
+	tests := []struct {
+		name     string
+		text     string
+		expected string
+	}{
+		{name: "value", text: "password is hunter2", expected: "password is [REDACTED]"},
+		{name: "value containing another one", text: "hunter2 and hunt", expected: "[REDACTED] and [REDACTED]"},
+		{name: "short value", text: "code x9 failed", expected: "code [REDACTED] failed"},
+		{name: "every occurrence", text: "x9x9", expected: "[REDACTED][REDACTED]"},
+		{name: "no value", text: "nothing to see", expected: "nothing to see"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if redacted := redactor.Redact(test.text); redacted != test.expected {
+				t.Errorf("expected %q, got %q", test.expected, redacted)
+			}
+		})
+	}
+
+	var nilRedactor *Redactor
+	if redacted := nilRedactor.Redact("hunter2"); redacted != "hunter2" {
+		t.Errorf("expected a nil redactor to keep the text, got %q", redacted)
+	}
+}
+
+func TestRedactorForgetsTheOldestValues(t *testing.T) {
+	redactor := NewRedactor()
+	redactor.Add("first-value")
+	for i := 0; i < maxRedactedValues; i++ {
+		redactor.Add(strings.Repeat("v", 12) + string(rune('a'+i%26)) + strings.Repeat("w", i/26))
+	}
+
+	if redacted := redactor.Redact("first-value"); redacted != "first-value" {
+		t.Errorf("expected the oldest value to be forgotten, got %q", redacted)
+	}
+	if redacted := redactor.Redact("vvvvvvvvvvvvb"); redacted != redactedValue {
+		t.Errorf("expected a recent value to be redacted, got %q", redacted)
+	}
+}
+
+func TestLoggerRedacts(t *testing.T) {
+	redactor := NewRedactor()
+	redactor.Add("hunter2")
+	var output bytes.Buffer
+	logger, err := NewLogger(&output, "debug", "json", redactor)
+	if err != nil {
+		t.Fatalf("creating logger: %s", err)
+	}
+
+	logger.Debug("fetched hunter2", "error", errors.New("bad value hunter2"), "token", "abc", "name", "db-password")
+	logged := output.String()
+	if strings.Contains(logged, "hunter2") || strings.Contains(logged, "abc") {
+		t.Errorf("expected the values redacted, got %s", logged)
+	}
+	if !strings.Contains(logged, "db-password") {
+		t.Errorf("expected the rest of the record, got %s", logged)
+	}
+}
@@ -0,0 +1,215 @@
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// SourceMemory is the source of values coming from the in-memory provider
+const SourceMemory Source = "memory"
+
+func init() {
+	RegisterProvider("memory", newMemoryProviderFromEnv)
+}
+
+// MemoryProvider keeps secrets in memory, with versions that can be added, disabled and destroyed like on Secret
+// Manager, so applications can be tested against the server without any cloud credentials
+type MemoryProvider struct {
+	clock Clock
+
+	mu      sync.RWMutex
+	secrets map[string]*memorySecret
+}
+
+// memorySecret is a secret of the in-memory provider, its versions are numbered from 1 in the order they were added
+type memorySecret struct {
+	createTime time.Time
+	versions   []memoryVersion
+}
+
+// memoryVersion is a version of a secret, destroyed versions keep no value
+type memoryVersion struct {
+	value      string
+	createTime time.Time
+	disabled   bool
+	destroyed  bool
+}
+
+// NewMemoryProvider returns a provider with the values as the first version of their secrets
+func NewMemoryProvider(values map[string]string) *MemoryProvider {
+	p := &MemoryProvider{clock: RealClock{}, secrets: map[string]*memorySecret{}}
+	for name, value := range values {
+		p.Set(name, value)
+	}
+	return p
+}
+
+// Source tells the values come from memory
+func (p *MemoryProvider) Source() Source {
+	return SourceMemory
+}
+
+// GetSecret gets the latest enabled version of the secret
+func (p *MemoryProvider) GetSecret(ctx context.Context, name string) (string, error) {
+	return p.GetSecretVersion(ctx, name, "latest")
+}
+
+// GetSecretVersion gets a version of the secret by its number, or the latest enabled one for latest or empty
+// Disabled and destroyed versions cannot be accessed, as on Secret Manager
+func (p *MemoryProvider) GetSecretVersion(ctx context.Context, name string, version string) (string, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	secret, ok := p.secrets[name]
+	if !ok {
+		return "", fmt.Errorf("%w: %s", ErrSecretNotFound, name)
+	}
+	if version == "" || version == "latest" {
+		index, err := secret.latestIndex()
+		if err != nil {
+			return "", fmt.Errorf("%s: %w", name, err)
+		}
+		return secret.versions[index].value, nil
+	}
+
+	index, err := secret.versionIndex(version)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", name, err)
+	}
+	if secret.versions[index].disabled || secret.versions[index].destroyed {
+		return "", withStatus(http.StatusBadRequest, fmt.Errorf("version %s of %s is disabled or destroyed", version, name))
+	}
+	return secret.versions[index].value, nil
+}
+
+// GetVersionMetadata gets the number and create time of the latest enabled version of the secret
+func (p *MemoryProvider) GetVersionMetadata(ctx context.Context, name string) (VersionMetadata, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	secret, ok := p.secrets[name]
+	if !ok {
+		return VersionMetadata{}, fmt.Errorf("%w: %s", ErrSecretNotFound, name)
+	}
+	index, err := secret.latestIndex()
+	if err != nil {
+		return VersionMetadata{}, fmt.Errorf("%s: %w", name, err)
+	}
+	return VersionMetadata{
+		Version:    strconv.Itoa(index + 1),
+		CreateTime: secret.versions[index].createTime.UTC().Format(time.RFC3339Nano),
+	}, nil
+}
+
+// PutSecret adds a version with the value, creating the secret when it does not exist yet
+func (p *MemoryProvider) PutSecret(ctx context.Context, name string, value string) (string, bool, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	secret, ok := p.secrets[name]
+	if !ok {
+		secret = &memorySecret{createTime: p.clock.Now()}
+		p.secrets[name] = secret
+	}
+	secret.versions = append(secret.versions, memoryVersion{value: value, createTime: p.clock.Now()})
+	return strconv.Itoa(len(secret.versions)), !ok, nil
+}
+
+// RevokeVersion disables or destroys the version, destroying drops its value for good
+func (p *MemoryProvider) RevokeVersion(ctx context.Context, name string, version string, destroy bool) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	secret, ok := p.secrets[name]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrSecretNotFound, name)
+	}
+	index, err := secret.versionIndex(version)
+	if err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	secret.versions[index].disabled = true
+	if destroy {
+		secret.versions[index] = memoryVersion{createTime: secret.versions[index].createTime, disabled: true, destroyed: true}
+	}
+	return nil
+}
+
+// ListSecrets lists the secrets in the order of their names, the page token is the last name of the previous page
+func (p *MemoryProvider) ListSecrets(ctx context.Context, pageSize int, pageToken string) (SecretPage, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	names := make([]string, 0, len(p.secrets))
+	for name := range p.secrets {
+		if name > pageToken {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	page := SecretPage{Secrets: []SecretInfo{}}
+	if pageSize > 0 && len(names) > pageSize {
+		names = names[:pageSize]
+		page.NextPageToken = names[len(names)-1]
+	}
+	for _, name := range names {
+		page.Secrets = append(page.Secrets, SecretInfo{Name: name, CreateTime: p.secrets[name].createTime.UTC().Format(time.RFC3339Nano)})
+	}
+	return page, nil
+}
+
+// Set adds a version with the value, for tests changing secrets while the server runs
+func (p *MemoryProvider) Set(name string, value string) {
+	_, _, _ = p.PutSecret(context.Background(), name, value)
+}
+
+// Delete removes the secret with all its versions, for tests of secrets that go missing
+func (p *MemoryProvider) Delete(name string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.secrets, name)
+}
+
+// latestIndex returns the index of the latest version that is neither disabled nor destroyed
+func (s *memorySecret) latestIndex() (int, error) {
+	for i := len(s.versions) - 1; i >= 0; i-- {
+		if !s.versions[i].disabled && !s.versions[i].destroyed {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("%w: no enabled version", ErrSecretNotFound)
+}
+
+// versionIndex returns the index of the version number on the versions of the secret
+func (s *memorySecret) versionIndex(version string) (int, error) {
+	number, err := strconv.Atoi(version)
+	if err != nil || number < 1 || number > len(s.versions) {
+		return 0, fmt.Errorf("%w: version %s", ErrSecretNotFound, version)
+	}
+	return number - 1, nil
+}
+
+// newMemoryProviderFromEnv builds the in-memory provider, seeded from the JSON object of names and values on
+// MEMORY_SECRETS_FILE when there is one
+func newMemoryProviderFromEnv() (SecretProvider, error) {
+	values := map[string]string{}
+	if path := GetEnv("MEMORY_SECRETS_FILE", ""); path != "" {
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("MEMORY_SECRETS_FILE: %w", err)
+		}
+		err = json.Unmarshal(content, &values)
+		if err != nil {
+			return nil, fmt.Errorf("MEMORY_SECRETS_FILE: expected an object of string values: %w", err)
+		}
+	}
+	return NewMemoryProvider(values), nil
+}
@@ -0,0 +1,188 @@
+package secrets
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestMemoryProviderVersions(t *testing.T) {
+	ctx := context.Background()
+	provider := NewMemoryProvider(map[string]string{"db-password": "first"})
+	for _, value := range []string{"second", "third"} {
+		if _, _, err := provider.PutSecret(ctx, "db-password", value); err != nil {
+			t.Fatalf("adding version: %s", err)
+		}
+	}
+	if err := provider.RevokeVersion(ctx, "db-password", "3", false); err != nil {
+		t.Fatalf("disabling version: %s", err)
+	}
+	if err := provider.RevokeVersion(ctx, "db-password", "1", true); err != nil {
+		t.Fatalf("destroying version: %s", err)
+	}
+
+	tests := []struct {
+		name          string
+		secret        string
+		version       string
+		expectedValue string
+		expectedErr   error
+	}{
+		{name: "latest skips disabled versions", secret: "db-password", version: "latest", expectedValue: "second"},
+		{name: "empty is latest", secret: "db-password", version: "", expectedValue: "second"},
+		{name: "enabled version", secret: "db-password", version: "2", expectedValue: "second"},
+		{name: "disabled version", secret: "db-password", version: "3", expectedErr: errAny},
+		{name: "destroyed version", secret: "db-password", version: "1", expectedErr: errAny},
+		{name: "version out of range", secret: "db-password", version: "4", expectedErr: ErrSecretNotFound},
+		{name: "version zero", secret: "db-password", version: "0", expectedErr: ErrSecretNotFound},
+		{name: "version that is not a number", secret: "db-password", version: "first", expectedErr: ErrSecretNotFound},
+		{name: "missing secret", secret: "api-key", version: "latest", expectedErr: ErrSecretNotFound},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			value, err := provider.GetSecretVersion(ctx, test.secret, test.version)
+			if test.expectedErr != nil {
+				if err == nil || (test.expectedErr != errAny && !errors.Is(err, test.expectedErr)) {
+					t.Errorf("expected %v, got %q (%v)", test.expectedErr, value, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("getting version: %s", err)
+			}
+			if value != test.expectedValue {
+				t.Errorf("expected %q, got %q", test.expectedValue, value)
+			}
+		})
+	}
+}
+
+func TestMemoryProviderMetadata(t *testing.T) {
+	ctx := context.Background()
+	clock := NewFakeClock(time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC))
+	provider := NewMemoryProvider(nil)
+	provider.clock = clock
+
+	provider.Set("db-password", "first")
+	created := clock.Now()
+	clock.Advance(time.Hour)
+	provider.Set("db-password", "second")
+	if err := provider.RevokeVersion(ctx, "db-password", "2", false); err != nil {
+		t.Fatalf("disabling version: %s", err)
+	}
+
+	metadata, err := provider.GetVersionMetadata(ctx, "db-password")
+	if err != nil {
+		t.Fatalf("getting metadata: %s", err)
+	}
+	if metadata.Version != "1" || metadata.CreateTime != created.UTC().Format(time.RFC3339Nano) {
+		t.Errorf("expected version 1 created at %s, got %+v", created, metadata)
+	}
+
+	// A secret with no enabled version is as missing as one that does not exist
+	if err := provider.RevokeVersion(ctx, "db-password", "1", false); err != nil {
+		t.Fatalf("disabling version: %s", err)
+	}
+	for _, name := range []string{"db-password", "api-key"} {
+		if _, err := provider.GetVersionMetadata(ctx, name); !errors.Is(err, ErrSecretNotFound) {
+			t.Errorf("%s: expected not found, got %v", name, err)
+		}
+	}
+}
+
+func TestMemoryProviderPutSecret(t *testing.T) {
+	provider := NewMemoryProvider(nil)
+
+	version, created, err := provider.PutSecret(context.Background(), "db-password", "first")
+	if err != nil || version != "1" || !created {
+		t.Errorf("expected version 1 of a new secret, got %s (created %t, %v)", version, created, err)
+	}
+	version, created, err = provider.PutSecret(context.Background(), "db-password", "second")
+	if err != nil || version != "2" || created {
+		t.Errorf("expected version 2 of an existing secret, got %s (created %t, %v)", version, created, err)
+	}
+
+	provider.Delete("db-password")
+	if _, err := provider.GetSecret(context.Background(), "db-password"); !errors.Is(err, ErrSecretNotFound) {
+		t.Errorf("expected deleted secret to be not found, got %v", err)
+	}
+}
+
+func TestMemoryProviderListSecrets(t *testing.T) {
+	provider := NewMemoryProvider(map[string]string{"c": "3", "a": "1", "b": "2"})
+
+	var names []string
+	pageToken := ""
+	for pages := 0; ; pages++ {
+		if pages > 3 {
+			t.Fatal("expected paging to end")
+		}
+		page, err := provider.ListSecrets(context.Background(), 2, pageToken)
+		if err != nil {
+			t.Fatalf("listing secrets: %s", err)
+		}
+		for _, secret := range page.Secrets {
+			names = append(names, secret.Name)
+		}
+		if page.NextPageToken == "" {
+			break
+		}
+		pageToken = page.NextPageToken
+	}
+
+	if len(names) != 3 || names[0] != "a" || names[1] != "b" || names[2] != "c" {
+		t.Errorf("expected a, b and c, got %v", names)
+	}
+}
+
+func TestNewMemoryProviderFromEnv(t *testing.T) {
+	dir := t.TempDir()
+	valid := filepath.Join(dir, "valid.json")
+	if err := ioutil.WriteFile(valid, []byte(`{"db-password":"hunter2"}`), 0600); err != nil {
+		t.Fatalf("writing file: %s", err)
+	}
+	invalid := filepath.Join(dir, "invalid.json")
+	if err := ioutil.WriteFile(invalid, []byte(`{"db-password":1}`), 0600); err != nil {
+		t.Fatalf("writing file: %s", err)
+	}
+
+	tests := []struct {
+		name          string
+		path          string
+		expectedValue string
+		expectedErr   bool
+	}{
+		{name: "seeded", path: valid, expectedValue: "hunter2"},
+		{name: "empty", path: ""},
+		{name: "not string values", path: invalid, expectedErr: true},
+		{name: "missing file", path: filepath.Join(dir, "missing.json"), expectedErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Setenv("MEMORY_SECRETS_FILE", test.path)
+			provider, err := newMemoryProviderFromEnv()
+			if (err != nil) != test.expectedErr {
+				t.Fatalf("expected error %t, got %v", test.expectedErr, err)
+			}
+			if err != nil {
+				return
+			}
+
+			value, err := provider.GetSecret(context.Background(), "db-password")
+			if test.expectedValue == "" {
+				if !errors.Is(err, ErrSecretNotFound) {
+					t.Errorf("expected not found, got %q (%v)", value, err)
+				}
+				return
+			}
+			if value != test.expectedValue {
+				t.Errorf("expected %q, got %q (%v)", test.expectedValue, value, err)
+			}
+		})
+	}
+}
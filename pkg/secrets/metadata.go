@@ -0,0 +1,66 @@
+package secrets
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// metadataUrl is the base URL of the metadata server available on GCP instances
+const metadataUrl = "http://metadata.google.internal/computeMetadata/v1"
+
+// getMetadata gets a plain text value from the metadata server
+func getMetadata(ctx context.Context, path string) (string, error) {
+	rq, err := http.NewRequestWithContext(ctx, http.MethodGet, metadataUrl+path, nil)
+	if err != nil {
+		return "", err
+	}
+
+	rq.Header.Add("Metadata-Flavor", "Google")
+	rs, err := UpstreamClient.Do(rq)
+	if err != nil {
+		return "", err
+	}
+	bytes, err := ReadBody(rs)
+	if err != nil {
+		return "", err
+	}
+
+	if rs.StatusCode != http.StatusOK {
+		return "", withStatus(rs.StatusCode, fmt.Errorf("metadata server answered %d for %s", rs.StatusCode, path))
+	}
+	return strings.TrimSpace(string(bytes)), nil
+}
+
+// discoverRegion gets the region of the instance, from a zone like projects/123/zones/us-central1-a
+func discoverRegion(ctx context.Context) (string, error) {
+	zone, err := getMetadata(ctx, "/instance/zone")
+	if err != nil {
+		return "", err
+	}
+
+	zone = path.Base(zone)
+	separator := strings.LastIndex(zone, "-")
+	if separator <= 0 {
+		return "", fmt.Errorf("unexpected zone %q", zone)
+	}
+	return zone[:separator], nil
+}
+
+// verifyServiceAccount logs the email of the service account the instance runs as
+// When an expected email is given, a different one is an error
+func verifyServiceAccount(ctx context.Context, expected string) error {
+	email, err := getMetadata(ctx, "/instance/service-accounts/default/email")
+	if err != nil {
+		return fmt.Errorf("getting service account email: %w", err)
+	}
+
+	slog.Info("running as service account", "email", email)
+	if expected != "" && email != expected {
+		return fmt.Errorf("running as service account %s, expected %s", email, expected)
+	}
+	return nil
+}
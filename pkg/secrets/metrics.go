@@ -0,0 +1,239 @@
+package secrets
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxSecretLabels bounds the distinct secret names used as labels, names seen after that are counted as otherSecretLabel
+// Unknown names are answered too, so without a bound any caller could grow the metrics without limit
+const maxSecretLabels = 1000
+
+// otherSecretLabel is the secret label of the names over maxSecretLabels
+const otherSecretLabel = "_other"
+
+// latencyBuckets are the upper bounds in seconds of the latency histograms
+var latencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// Metrics keeps the counters and histograms served on /metrics in the Prometheus text format
+// A nil Metrics records nothing
+type Metrics struct {
+	requests         *counterVec
+	requestDuration  *histogramVec
+	lookups          *counterVec
+	fallbacks        *counterVec
+	resolveErrors    *counterVec
+	upstreamDuration *histogramVec
+
+	mu      sync.Mutex
+	secrets map[string]bool
+}
+
+// NewMetrics returns empty metrics
+func NewMetrics() *Metrics {
+	return &Metrics{
+		requests:         newCounterVec("secret_manager_http_requests_total", "HTTP requests by route and status code.", "route", "code"),
+		requestDuration:  newHistogramVec("secret_manager_http_request_duration_seconds", "Latency of HTTP requests by route.", "route"),
+		lookups:          newCounterVec("secret_manager_lookups_total", "Sources tried to resolve secrets, by outcome.", "secret", "source", "outcome"),
+		fallbacks:        newCounterVec("secret_manager_fallbacks_total", "Secrets answered with the fallback value.", "secret"),
+		resolveErrors:    newCounterVec("secret_manager_resolve_errors_total", "Secrets that could not be resolved.", "secret"),
+		upstreamDuration: newHistogramVec("secret_manager_upstream_duration_seconds", "Latency of the calls to the provider, by source and outcome.", "source", "outcome"),
+		secrets:          map[string]bool{},
+	}
+}
+
+// ObserveResolution counts every source tried to resolve the secret, and whether it ended on the fallback or an error
+func (m *Metrics) ObserveResolution(name string, attempts []Attempt, source Source, err error) {
+	if m == nil {
+		return
+	}
+
+	secret := m.secretLabel(name)
+	for _, attempt := range attempts {
+		m.lookups.inc(secret, string(attempt.Source), attempt.Outcome)
+	}
+	switch {
+	case err != nil:
+		m.resolveErrors.inc(secret)
+	case source == SourceFallback:
+		m.fallbacks.inc(secret)
+	}
+}
+
+// ObserveUpstream records the latency of a call to the provider
+func (m *Metrics) ObserveUpstream(source Source, latency time.Duration, err error) {
+	if m == nil {
+		return
+	}
+
+	outcome := OutcomeHit
+	if err != nil {
+		outcome = OutcomeError
+	}
+	m.upstreamDuration.observe(latency.Seconds(), string(source), outcome)
+}
+
+// ObserveRequest records a request served on the route
+func (m *Metrics) ObserveRequest(route string, code int, latency time.Duration) {
+	if m == nil {
+		return
+	}
+	m.requests.inc(route, strconv.Itoa(code))
+	m.requestDuration.observe(latency.Seconds(), route)
+}
+
+// secretLabel returns the label for the secret, which is its name until maxSecretLabels names were seen
+func (m *Metrics) secretLabel(name string) string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.secrets[name] {
+		return name
+	}
+	if len(m.secrets) >= maxSecretLabels {
+		return otherSecretLabel
+	}
+	m.secrets[name] = true
+	return name
+}
+
+// WriteText writes every metric in the Prometheus text format
+func (m *Metrics) WriteText(w io.Writer) error {
+	buffered := bufio.NewWriter(w)
+	m.write(buffered)
+	return buffered.Flush()
+}
+
+// write writes every metric in the Prometheus text format
+func (m *Metrics) write(w *bufio.Writer) {
+	m.requests.write(w)
+	m.requestDuration.write(w)
+	m.lookups.write(w)
+	m.fallbacks.write(w)
+	m.resolveErrors.write(w)
+	m.upstreamDuration.write(w)
+}
+
+// counterVec is a counter with labels, the values are keyed by the label values joined
+type counterVec struct {
+	name   string
+	help   string
+	labels []string
+
+	mu     sync.Mutex
+	values map[string]float64
+}
+
+func newCounterVec(name string, help string, labels ...string) *counterVec {
+	return &counterVec{name: name, help: help, labels: labels, values: map[string]float64{}}
+}
+
+func (c *counterVec) inc(labelValues ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.values[strings.Join(labelValues, "\xff")]++
+}
+
+func (c *counterVec) write(w *bufio.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", c.name, c.help, c.name)
+	for _, key := range sortedKeys(c.values) {
+		fmt.Fprintf(w, "%s%s %s\n", c.name, formatLabels(c.labels, key, ""), formatFloat(c.values[key]))
+	}
+}
+
+// histogramVec is a histogram with labels, using latencyBuckets
+type histogramVec struct {
+	name   string
+	help   string
+	labels []string
+
+	mu     sync.Mutex
+	values map[string]*histogram
+}
+
+// histogram keeps the count of observations per bucket, not cumulative, with their sum
+type histogram struct {
+	buckets []uint64
+	count   uint64
+	sum     float64
+}
+
+func newHistogramVec(name string, help string, labels ...string) *histogramVec {
+	return &histogramVec{name: name, help: help, labels: labels, values: map[string]*histogram{}}
+}
+
+func (h *histogramVec) observe(value float64, labelValues ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	key := strings.Join(labelValues, "\xff")
+	values, ok := h.values[key]
+	if !ok {
+		values = &histogram{buckets: make([]uint64, len(latencyBuckets))}
+		h.values[key] = values
+	}
+	for i, bound := range latencyBuckets {
+		if value <= bound {
+			values.buckets[i]++
+			break
+		}
+	}
+	values.count++
+	values.sum += value
+}
+
+func (h *histogramVec) write(w *bufio.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name)
+	for _, key := range sortedKeys(h.values) {
+		values := h.values[key]
+		var cumulative uint64
+		for i, bound := range latencyBuckets {
+			cumulative += values.buckets[i]
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(h.labels, key, formatFloat(bound)), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(h.labels, key, "+Inf"), values.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, formatLabels(h.labels, key, ""), formatFloat(values.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, formatLabels(h.labels, key, ""), values.count)
+	}
+}
+
+// formatLabels formats the joined label values, with the le label of histogram buckets when there is one
+func formatLabels(names []string, key string, le string) string {
+	values := strings.Split(key, "\xff")
+	pairs := make([]string, 0, len(names)+1)
+	for i, name := range names {
+		pairs = append(pairs, fmt.Sprintf("%s=%q", name, values[i]))
+	}
+	if le != "" {
+		pairs = append(pairs, fmt.Sprintf("le=%q", le))
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatFloat(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// sortedKeys returns the keys of the map sorted, so the output is stable between scrapes
+func sortedKeys[V any](values map[string]V) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
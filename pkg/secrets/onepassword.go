@@ -0,0 +1,228 @@
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// SourceOnePassword is the source of values coming from 1Password
+const SourceOnePassword Source = "1password"
+
+// onePasswordIdPattern matches the ids of vaults and items, to tell them apart from names and titles
+var onePasswordIdPattern = regexp.MustCompile(`^[a-z0-9]{26}$`)
+
+func init() {
+	RegisterProvider("1password", newOnePasswordProviderFromEnv)
+}
+
+// OnePasswordProvider gets secrets from the items of a vault through a 1Password Connect server
+// Every secret is an item, found by its title or id, and its value is one field of the item
+type OnePasswordProvider struct {
+	// Address is the base URL of the Connect server, like http://onepassword-connect:8080
+	Address string
+	Token   string
+	// Vault is the name or id of the vault the items are in
+	Vault string
+	// Field is the label or id of the field that holds the value
+	Field string
+	// Retry applies to every secret fetch
+	Retry RetryPolicy
+	vault *onePasswordVault
+}
+
+// onePasswordVault keeps the id of the vault once its name is looked up, as it does not change
+type onePasswordVault struct {
+	mu sync.Mutex
+	id string
+}
+
+// Source tells the values come from 1Password
+func (p OnePasswordProvider) Source() Source {
+	return SourceOnePassword
+}
+
+// GetSecret gets the field of the item, retried according to Retry
+func (p OnePasswordProvider) GetSecret(ctx context.Context, name string) (string, error) {
+	var value string
+	err := p.Retry.do(ctx, func(ctx context.Context) error {
+		var err error
+		value, err = p.fetchSecret(ctx, name)
+		return err
+	})
+	return value, err
+}
+
+// fetchSecret reads the item and returns the value of the field
+func (p OnePasswordProvider) fetchSecret(ctx context.Context, name string) (string, error) {
+	vaultId, err := p.vaultId(ctx)
+	if err != nil {
+		return "", err
+	}
+	itemId := name
+	if !onePasswordIdPattern.MatchString(name) {
+		itemId, err = p.lookup(ctx, fmt.Sprintf("/v1/vaults/%s/items", vaultId), name)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	item := struct {
+		Fields []struct {
+			Id      string  `json:"id"`
+			Label   string  `json:"label"`
+			Purpose string  `json:"purpose"`
+			Value   *string `json:"value"`
+		} `json:"fields"`
+	}{}
+	err = p.get(ctx, fmt.Sprintf("/v1/vaults/%s/items/%s", vaultId, itemId), &item)
+	if err != nil {
+		return "", err
+	}
+
+	for _, field := range item.Fields {
+		// The password field of logins is labeled password and has the PASSWORD purpose, whatever its label is
+		matches := field.Id == p.Field || strings.EqualFold(field.Label, p.Field) ||
+			(p.Field == "password" && field.Purpose == "PASSWORD")
+		if matches && field.Value != nil {
+			return *field.Value, nil
+		}
+	}
+	return "", fmt.Errorf("%w: field %s not found on %s", ErrSecretNotFound, p.Field, name)
+}
+
+// vaultId returns the id of the vault, looking it up by name the first time when it is not an id already
+func (p OnePasswordProvider) vaultId(ctx context.Context) (string, error) {
+	if onePasswordIdPattern.MatchString(p.Vault) {
+		return p.Vault, nil
+	}
+
+	p.vault.mu.Lock()
+	defer p.vault.mu.Unlock()
+	if p.vault.id != "" {
+		return p.vault.id, nil
+	}
+	id, err := p.lookup(ctx, "/v1/vaults", p.Vault)
+	// A missing vault is a configuration error, not a missing secret to fall back from
+	if errors.Is(err, ErrSecretNotFound) {
+		return "", fmt.Errorf("vault %s not found", p.Vault)
+	}
+	if err != nil {
+		return "", fmt.Errorf("vault %s: %w", p.Vault, err)
+	}
+	p.vault.id = id
+	return id, nil
+}
+
+// lookup returns the id of the vault or item of the list with the name, which has to be unique
+func (p OnePasswordProvider) lookup(ctx context.Context, path string, name string) (string, error) {
+	field := "title"
+	if path == "/v1/vaults" {
+		field = "name"
+	}
+	filter := fmt.Sprintf(`%s eq "%s"`, field, strings.ReplaceAll(name, `"`, `\"`))
+
+	var matches []struct {
+		Id string `json:"id"`
+	}
+	err := p.get(ctx, path+"?filter="+url.QueryEscape(filter), &matches)
+	if err != nil {
+		return "", err
+	}
+	switch len(matches) {
+	case 0:
+		return "", fmt.Errorf("%w: no %s %s", ErrSecretNotFound, field, name)
+	case 1:
+		return matches[0].Id, nil
+	default:
+		return "", fmt.Errorf("%d matches of %s %s, use the id instead", len(matches), field, name)
+	}
+}
+
+// get sends a request to the Connect server and decodes the answer into the value
+func (p OnePasswordProvider) get(ctx context.Context, path string, value interface{}) error {
+	rq, err := http.NewRequestWithContext(ctx, http.MethodGet, p.Address+path, nil)
+	if err != nil {
+		return err
+	}
+	rq.Header.Set("Authorization", "Bearer "+p.Token)
+	rs, err := UpstreamClient.Do(rq)
+	if err != nil {
+		return err
+	}
+
+	bytes, err := ReadBody(rs)
+	if err != nil {
+		return err
+	}
+	if rs.StatusCode != http.StatusOK {
+		return onePasswordError(rs.StatusCode, bytes)
+	}
+	err = json.Unmarshal(bytes, value)
+	if err != nil {
+		return withStatus(rs.StatusCode, err)
+	}
+	return nil
+}
+
+// onePasswordError maps the error body of the Connect server, not found and permission denied can be told apart
+// with errors.Is
+func onePasswordError(statusCode int, body []byte) error {
+	errorResponse := struct {
+		Message string `json:"message"`
+	}{}
+	// The body is only used for the message, the status code tells the error apart
+	_ = json.Unmarshal(body, &errorResponse)
+
+	switch statusCode {
+	case http.StatusNotFound:
+		return fmt.Errorf("%w: error %d - %s", ErrSecretNotFound, statusCode, errorResponse.Message)
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return fmt.Errorf("%w: error %d - %s", ErrPermissionDenied, statusCode, errorResponse.Message)
+	default:
+		return withStatus(statusCode, fmt.Errorf("error %d - %s", statusCode, errorResponse.Message))
+	}
+}
+
+// newOnePasswordProviderFromEnv builds the 1Password provider from the environment values the Connect SDKs use
+func newOnePasswordProviderFromEnv() (SecretProvider, error) {
+	address := strings.TrimSuffix(GetEnv("OP_CONNECT_HOST", ""), "/")
+	if address == "" {
+		return nil, errors.New("OP_CONNECT_HOST is required for the 1password backend")
+	}
+	token := GetEnv("OP_CONNECT_TOKEN", "")
+	if tokenFile := GetEnv("OP_CONNECT_TOKEN_FILE", ""); tokenFile != "" {
+		var err error
+		token, err = readSecretFile(tokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("OP_CONNECT_TOKEN_FILE: %w", err)
+		}
+	}
+	if token == "" {
+		return nil, errors.New("OP_CONNECT_TOKEN or OP_CONNECT_TOKEN_FILE is required for the 1password backend")
+	}
+	vault := GetEnv("OP_VAULT", "")
+	if vault == "" {
+		return nil, errors.New("OP_VAULT is required for the 1password backend")
+	}
+
+	retry, err := getRetryPolicy("OP_CONNECT", upstreamRetryPolicy)
+	if err != nil {
+		return nil, err
+	}
+
+	return OnePasswordProvider{
+		Address: address,
+		Token:   token,
+		Vault:   vault,
+		Field:   GetEnv("OP_FIELD", "password"),
+		Retry:   retry,
+		vault:   &onePasswordVault{},
+	}, nil
+}
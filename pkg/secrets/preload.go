@@ -0,0 +1,86 @@
+package secrets
+
+import (
+	"context"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Preload resolves the secrets ahead of the first requests, with up to concurrency at a time
+// The whole operation is bounded by the deadline, a zero deadline means no deadline
+// Fetches still in flight when the deadline passes are canceled
+// It returns the secrets that did not load, either because they failed or the deadline passed
+func (sg SecretGetter) Preload(names []string, concurrency int, deadline time.Duration) []string {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if deadline > 0 {
+		ctx, cancel = context.WithTimeout(ctx, deadline)
+		defer cancel()
+	}
+
+	var mu sync.Mutex
+	pending := map[string]bool{}
+	for _, name := range names {
+		pending[name] = true
+	}
+
+	jobs := make(chan string)
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for name := range jobs {
+				if ctx.Err() != nil {
+					continue
+				}
+				// Fallbacks do not count as loaded, as the secret is not there
+				resolution, err := sg.ResolveContext(ctx, name, "")
+				if err != nil || resolution.IsFallback() {
+					continue
+				}
+
+				mu.Lock()
+				delete(pending, name)
+				mu.Unlock()
+			}
+		}()
+	}
+
+	go func() {
+		// Names not sent by the deadline are not fetched at all, and stay pending
+	dispatch:
+		for _, name := range names {
+			select {
+			case jobs <- name:
+			case <-ctx.Done():
+				break dispatch
+			}
+		}
+		close(jobs)
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		slog.Warn("preload deadline exceeded", "deadline", deadline)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	missing := make([]string, 0, len(pending))
+	for name := range pending {
+		missing = append(missing, name)
+	}
+	sort.Strings(missing)
+	return missing
+}
@@ -0,0 +1,65 @@
+package secrets
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// blockingProvider holds every fetch until the context is done, counting them
+type blockingProvider struct {
+	calls *atomic.Int32
+}
+
+func (p blockingProvider) GetSecret(ctx context.Context, name string) (string, error) {
+	p.calls.Add(1)
+	<-ctx.Done()
+	return "", ctx.Err()
+}
+
+func TestPreloadStopsAtTheDeadline(t *testing.T) {
+	var calls atomic.Int32
+	sg := SecretGetter{
+		Provider:        blockingProvider{calls: &calls},
+		Breaker:         NewCircuitBreaker(1, time.Minute, nil),
+		RequireFallback: true,
+	}
+	names := []string{"a", "b", "c", "d", "e", "f", "g", "h"}
+
+	missing := sg.Preload(names, 2, 20*time.Millisecond)
+	if len(missing) != len(names) {
+		t.Errorf("expected every secret missing, got %v", missing)
+	}
+	// Only the fetches in flight when the deadline passed reached the provider
+	if calls.Load() > 2 {
+		t.Errorf("expected at most 2 fetches, got %d", calls.Load())
+	}
+	// Running out of time is the deadline of the preload, not a failure of the backend
+	if sg.Breaker.Open() {
+		t.Error("expected the circuit breaker to stay closed")
+	}
+}
+
+func TestCircuitBreakerIgnoresCallers(t *testing.T) {
+	tests := []struct {
+		name         string
+		err          error
+		expectedOpen bool
+	}{
+		{name: "transient failure", err: statusError{StatusCode: http.StatusServiceUnavailable}, expectedOpen: true},
+		{name: "canceled caller", err: context.Canceled},
+		{name: "not found", err: ErrSecretNotFound},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			breaker := NewCircuitBreaker(1, time.Minute, nil)
+			breaker.Observe(test.err)
+			if breaker.Open() != test.expectedOpen {
+				t.Errorf("expected open %t, got %t", test.expectedOpen, breaker.Open())
+			}
+		})
+	}
+}
@@ -0,0 +1,87 @@
+package secrets
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// projectKey is the context key of the project a request asked for
+type projectKey struct{}
+
+// WithProject returns a context resolving secrets from the project instead of the configured one
+func WithProject(ctx context.Context, project string) context.Context {
+	return context.WithValue(ctx, projectKey{}, project)
+}
+
+// projectFromContext returns the project the request asked for, empty when it did not ask for one
+func projectFromContext(ctx context.Context) string {
+	project, _ := ctx.Value(projectKey{}).(string)
+	return project
+}
+
+// cacheKeySeparator separates the project from the name on the cache keys of secrets of other projects, it cannot
+// be part of a name so the two can always be told apart
+const cacheKeySeparator = "\x00"
+
+// cacheKey returns the key the secret is cached with, secrets of projects asked for by the request are cached
+// apart from the ones of the configured project, which can have the same names
+func cacheKey(project string, name string) string {
+	if project != "" {
+		return project + cacheKeySeparator + name
+	}
+	return name
+}
+
+// splitCacheKey returns the project and the name a cache key was made of, the project is empty for the configured one
+func splitCacheKey(key string) (string, string) {
+	if project, name, ok := strings.Cut(key, cacheKeySeparator); ok {
+		return project, name
+	}
+	return "", key
+}
+
+// displayKey returns the cache key as project/name for logs and reports, or the name for the configured project
+func displayKey(key string) string {
+	if project, name := splitCacheKey(key); project != "" {
+		return project + "/" + name
+	}
+	return key
+}
+
+// keyContext returns the context for fetching the secret of the cache key, scoped to its project if it has one
+func keyContext(ctx context.Context, key string) (context.Context, string) {
+	project, name := splitCacheKey(key)
+	if project != "" {
+		ctx = WithProject(ctx, project)
+	}
+	return ctx, name
+}
+
+// ProjectRoute sends the secrets whose names start with the prefix to the project
+type ProjectRoute struct {
+	Prefix  string
+	Project string
+}
+
+// parseProjectRoutes parses a comma separated list of PREFIX=PROJECT, sorted so the longest prefix is tried first
+func parseProjectRoutes(value string) ([]ProjectRoute, error) {
+	var routes []ProjectRoute
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		prefix, project, ok := strings.Cut(entry, "=")
+		if !ok || prefix == "" || project == "" {
+			return nil, fmt.Errorf("invalid route %q, expected PREFIX=PROJECT", entry)
+		}
+		routes = append(routes, ProjectRoute{Prefix: prefix, Project: project})
+	}
+
+	sort.SliceStable(routes, func(i, j int) bool {
+		return len(routes[i].Prefix) > len(routes[j].Prefix)
+	})
+	return routes, nil
+}
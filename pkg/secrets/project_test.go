@@ -0,0 +1,210 @@
+package secrets
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// projectProvider serves every secret as the project it was asked on, the configured one when none, and its name
+type projectProvider struct {
+	generation atomic.Int32
+}
+
+func (p *projectProvider) GetSecret(ctx context.Context, name string) (string, error) {
+	project := projectFromContext(ctx)
+	if project == "" {
+		project = "configured"
+	}
+	if name == "gone" {
+		return "", ErrSecretNotFound
+	}
+	return fmt.Sprintf("%s/%s@%d", project, name, p.generation.Load()), nil
+}
+
+func (p *projectProvider) GetVersionMetadata(ctx context.Context, name string) (VersionMetadata, error) {
+	value, err := p.GetSecret(ctx, name)
+	return VersionMetadata{Version: value}, err
+}
+
+func TestCacheKey(t *testing.T) {
+	tests := []struct {
+		project         string
+		name            string
+		expectedDisplay string
+	}{
+		{name: "db-password", expectedDisplay: "db-password"},
+		{project: "other", name: "db-password", expectedDisplay: "other/db-password"},
+		{project: "other", name: "team/db-password", expectedDisplay: "other/team/db-password"},
+		{name: "other/db-password", expectedDisplay: "other/db-password"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.expectedDisplay, func(t *testing.T) {
+			key := cacheKey(test.project, test.name)
+			project, name := splitCacheKey(key)
+			if project != test.project || name != test.name {
+				t.Errorf("expected %q and %q, got %q and %q", test.project, test.name, project, name)
+			}
+			if display := displayKey(key); display != test.expectedDisplay {
+				t.Errorf("expected %q, got %q", test.expectedDisplay, display)
+			}
+		})
+	}
+
+	// A name that looks like project/name is not the key of that project
+	if cacheKey("other", "db-password") == cacheKey("", "other/db-password") {
+		t.Error("expected the keys of a project and of a name with a slash to differ")
+	}
+}
+
+func TestProjectScopedCache(t *testing.T) {
+	provider := &projectProvider{}
+	clock := NewFakeClock(time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC))
+	sg := SecretGetter{
+		Provider:             provider,
+		Cache:                NewMemoryCache(time.Hour, clock),
+		VersionMetadataCache: NewTTLCache(time.Hour, clock),
+		RequireFallback:      true,
+	}
+	configured := context.Background()
+	other := WithProject(configured, "other")
+
+	resolve := func(ctx context.Context, name string) string {
+		t.Helper()
+		resolution, err := sg.ResolveContext(ctx, name, "")
+		if err != nil {
+			t.Fatalf("resolving %s: %s", name, err)
+		}
+		return resolution.Value
+	}
+	metadata := func(ctx context.Context, name string) string {
+		t.Helper()
+		metadata, err := sg.GetVersionMetadata(ctx, name)
+		if err != nil {
+			t.Fatalf("getting metadata of %s: %s", name, err)
+		}
+		return metadata.Version
+	}
+
+	// Every project has its own entries
+	if value := resolve(configured, "db-password"); value != "configured/db-password@0" {
+		t.Errorf("expected the value of the configured project, got %s", value)
+	}
+	if value := resolve(other, "db-password"); value != "other/db-password@0" {
+		t.Errorf("expected the value of the other project, got %s", value)
+	}
+	if version := metadata(other, "db-password"); version != "other/db-password@0" {
+		t.Errorf("expected the metadata of the other project, got %s", version)
+	}
+
+	// Refreshing fetches every entry from its own project
+	provider.generation.Store(1)
+	report := sg.RefreshCache(context.Background(), 2)
+	if report.Refreshed != 2 || report.Failed != 0 {
+		t.Errorf("expected 2 refreshed, got %+v", report)
+	}
+	if value := resolve(other, "db-password"); value != "other/db-password@1" {
+		t.Errorf("expected the refreshed value of the other project, got %s", value)
+	}
+	if value := resolve(configured, "db-password"); value != "configured/db-password@1" {
+		t.Errorf("expected the refreshed value of the configured project, got %s", value)
+	}
+
+	// Invalidating a secret drops it from every project, values and metadata alike
+	provider.generation.Store(2)
+	sg.Invalidate("db-password")
+	if value := resolve(other, "db-password"); value != "other/db-password@2" {
+		t.Errorf("expected the latest value of the other project, got %s", value)
+	}
+	if value := resolve(configured, "db-password"); value != "configured/db-password@2" {
+		t.Errorf("expected the latest value of the configured project, got %s", value)
+	}
+	if version := metadata(other, "db-password"); version != "other/db-password@2" {
+		t.Errorf("expected the latest metadata of the other project, got %s", version)
+	}
+}
+
+func TestRefreshCacheReportsProjects(t *testing.T) {
+	sg := SecretGetter{Provider: &projectProvider{}, Cache: NewMemoryCache(time.Hour, RealClock{})}
+	sg.Cache.Set(cacheKey("other", "gone"), "stale")
+
+	report := sg.RefreshCache(context.Background(), 1)
+	if report.Failed != 1 || len(report.FailedNames) != 1 || report.FailedNames[0] != "other/gone" {
+		t.Errorf("expected other/gone to fail, got %+v", report)
+	}
+	if _, ok := sg.Cache.Get(cacheKey("other", "gone")); ok {
+		t.Error("expected the missing secret to be removed from the cache")
+	}
+	_, err := sg.GetVersionMetadata(WithProject(context.Background(), "other"), "gone")
+	if !errors.Is(err, ErrSecretNotFound) {
+		t.Errorf("expected not found, got %v", err)
+	}
+}
+
+func TestWritesForgetEveryProject(t *testing.T) {
+	tests := []struct {
+		name  string
+		write func(sg SecretGetter) error
+	}{
+		{
+			name: "put",
+			write: func(sg SecretGetter) error {
+				_, _, err := sg.PutSecret(context.Background(), "db-password", "rotated")
+				return err
+			},
+		},
+		{
+			name: "disable",
+			write: func(sg SecretGetter) error {
+				return sg.RevokeVersion(context.Background(), "db-password", "1", false)
+			},
+		},
+		{
+			name: "destroy",
+			write: func(sg SecretGetter) error {
+				return sg.RevokeVersion(context.Background(), "db-password", "1", true)
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sg := SecretGetter{
+				Provider:             NewMemoryProvider(map[string]string{"db-password": "hunter2"}),
+				Cache:                NewMemoryCache(time.Hour, nil),
+				StaleCache:           NewTTLCache(time.Hour, nil),
+				VersionMetadataCache: NewTTLCache(time.Hour, nil),
+				DiskCache:            LoadDiskCache(filepath.Join(t.TempDir(), "cache")),
+			}
+			keys := []string{"db-password", cacheKey("other", "db-password"), "api-key"}
+			for _, key := range keys {
+				sg.remember(key, "cached")
+				sg.VersionMetadataCache.Set(key, VersionMetadata{Version: "1"})
+			}
+
+			if err := test.write(sg); err != nil {
+				t.Fatalf("writing: %s", err)
+			}
+			for _, key := range keys {
+				expected := key == "api-key"
+				if _, ok := sg.Cache.Get(key); ok != expected {
+					t.Errorf("%s: expected cached %t", displayKey(key), expected)
+				}
+				if _, ok := sg.StaleCache.Get(key); ok != expected {
+					t.Errorf("%s: expected stale value %t", displayKey(key), expected)
+				}
+				if _, ok := sg.VersionMetadataCache.Get(key); ok != expected {
+					t.Errorf("%s: expected version metadata %t", displayKey(key), expected)
+				}
+				if _, ok := sg.DiskCache.Get(key); ok != expected {
+					t.Errorf("%s: expected on disk %t", displayKey(key), expected)
+				}
+			}
+		})
+	}
+}
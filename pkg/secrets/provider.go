@@ -0,0 +1,78 @@
+// Package secrets resolves secrets from the configured backends, with the caching, fallbacks and retries the server
+// serves them with, so Go services can embed the getter instead of calling the server
+package secrets
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// SecretProvider gets secrets from a backend
+// Missing secrets are ErrSecretNotFound and denied ones ErrPermissionDenied, any other error is transient
+type SecretProvider interface {
+	GetSecret(ctx context.Context, name string) (string, error)
+}
+
+// sourcer is implemented by providers that tell where their values come from
+type sourcer interface {
+	Source() Source
+}
+
+// SourceProvider is the source of values from providers that do not tell their own
+const SourceProvider Source = "provider"
+
+// ProviderSource returns the source of the values coming from the provider
+func ProviderSource(provider SecretProvider) Source {
+	if s, ok := provider.(sourcer); ok {
+		return s.Source()
+	}
+	return SourceProvider
+}
+
+// ProviderFactory builds a provider, reading its configuration from environment values
+type ProviderFactory func() (SecretProvider, error)
+
+// providerFactories are the registered backends, by name
+var providerFactories = map[string]ProviderFactory{}
+
+// RegisterProvider makes a backend selectable by name through SECRET_BACKEND
+func RegisterProvider(name string, factory ProviderFactory) {
+	providerFactories[name] = factory
+}
+
+// NewProvider builds the provider of the backend registered with the name
+func NewProvider(name string) (SecretProvider, error) {
+	factory, ok := providerFactories[name]
+	if !ok {
+		names := make([]string, 0, len(providerFactories))
+		for registered := range providerFactories {
+			names = append(names, registered)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("unknown backend %q, expected env or one of %s", name, strings.Join(names, ", "))
+	}
+	return factory()
+}
+
+// maxResponseSize bounds how much of an upstream response body is read
+const maxResponseSize = 1 << 20
+
+// ReadBody reads and closes the response body
+// A read error is a failure even if some data came along with it, so partial JSON is never parsed
+func ReadBody(rs *http.Response) ([]byte, error) {
+	defer rs.Body.Close()
+
+	bytes, err := ioutil.ReadAll(io.LimitReader(rs.Body, maxResponseSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("reading response body after %d bytes: %w", len(bytes), err)
+	}
+	if len(bytes) > maxResponseSize {
+		return nil, fmt.Errorf("response body exceeds %d bytes", maxResponseSize)
+	}
+	return bytes, nil
+}
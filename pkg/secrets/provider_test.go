@@ -0,0 +1,106 @@
+package secrets
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// partialReader returns its data together with the error on the first read, as some readers do on a broken stream
+type partialReader struct {
+	data string
+	err  error
+	done bool
+}
+
+func (r *partialReader) Read(p []byte) (int, error) {
+	if r.done {
+		return 0, r.err
+	}
+	r.done = true
+	return copy(p, r.data), r.err
+}
+
+func TestReadBody(t *testing.T) {
+	errReset := errors.New("connection reset by peer")
+
+	tests := []struct {
+		name        string
+		body        io.Reader
+		expected    string
+		expectedErr error
+	}{
+		{name: "complete", body: strings.NewReader(`{"a":1}`), expected: `{"a":1}`},
+		{name: "empty", body: strings.NewReader(""), expected: ""},
+		{name: "data with error", body: &partialReader{data: `{"access_token":"ab`, err: errReset}, expectedErr: errReset},
+		{name: "complete data with error", body: &partialReader{data: `{"a":1}`, err: errReset}, expectedErr: errReset},
+		{name: "data with unexpected EOF", body: &partialReader{data: `{"a":`, err: io.ErrUnexpectedEOF}, expectedErr: io.ErrUnexpectedEOF},
+		{name: "data with EOF", body: &partialReader{data: `{"a":1}`, err: io.EOF}, expected: `{"a":1}`},
+		{name: "too large", body: strings.NewReader(strings.Repeat("a", maxResponseSize+1)), expectedErr: errAny},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rs := &http.Response{StatusCode: http.StatusOK, Body: ioutil.NopCloser(test.body)}
+			actual, err := ReadBody(rs)
+
+			if test.expectedErr == nil {
+				if err != nil {
+					t.Fatalf("expected no error, got %s", err)
+				}
+				if string(actual) != test.expected {
+					t.Errorf("expected %q, got %q", test.expected, actual)
+				}
+				return
+			}
+
+			if err == nil || (test.expectedErr != errAny && !errors.Is(err, test.expectedErr)) {
+				t.Fatalf("expected %v, got %v", test.expectedErr, err)
+			}
+			// Partial data is never handed out, so it cannot be parsed as if it were complete
+			if actual != nil {
+				t.Errorf("expected no data, got %q", actual)
+			}
+		})
+	}
+}
+
+// errAny matches any error on table tests
+var errAny = errors.New("any error")
+
+func TestPartialResponses(t *testing.T) {
+	errReset := errors.New("connection reset by peer")
+
+	tests := []struct {
+		name string
+		body string
+	}{
+		{name: "cut inside the payload", body: `{"payload":{"data":"aHVudGVy`},
+		{name: "complete payload", body: `{"payload":{"data":"aHVudGVyMg=="}}`},
+	}
+
+	for _, test := range tests {
+		t.Run("token "+test.name, func(t *testing.T) {
+			rs := &http.Response{StatusCode: http.StatusOK, Body: ioutil.NopCloser(&partialReader{data: test.body, err: errReset})}
+			token, err := readTokenResponse(context.Background(), rs, "test")
+			if !errors.Is(err, errReset) {
+				t.Errorf("expected the read error, got token %q and %v", token.AccessToken, err)
+			}
+		})
+
+		t.Run("secret "+test.name, func(t *testing.T) {
+			stubUpstreamTransport(t, func(rq *http.Request) (*http.Response, error) {
+				return &http.Response{StatusCode: http.StatusOK, Body: ioutil.NopCloser(&partialReader{data: test.body, err: errReset}), Request: rq}, nil
+			})
+
+			value, err := GCPProvider{Project: "my-project"}.fetchSecret(context.Background(), "db-password", "latest", "token")
+			if !errors.Is(err, errReset) {
+				t.Errorf("expected the read error, got value %q and %v", value, err)
+			}
+		})
+	}
+}
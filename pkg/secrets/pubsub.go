@@ -0,0 +1,149 @@
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+)
+
+const (
+	// pubsubMaxMessages is how many notifications are pulled at once
+	pubsubMaxMessages = 100
+	// pubsubRetryInterval is how long pulling waits after a failure, so an outage is not hammered
+	pubsubRetryInterval = 5 * time.Second
+)
+
+// PubSubInvalidator pulls the notifications Secret Manager publishes for the secrets of a topic, and invalidates
+// the cached values of the secrets they are about, so a new version is served without waiting for the TTL
+type PubSubInvalidator struct {
+	// Subscription is the pull subscription, as projects/<project>/subscriptions/<subscription>
+	Subscription string
+
+	secretGetter SecretGetter
+	credentials  GCPCredentials
+}
+
+// NewPubSubInvalidator returns an invalidator pulling from the subscription, which can be a full name or only the
+// name of a subscription on the project
+func NewPubSubInvalidator(secretGetter SecretGetter, subscription string, project string) (*PubSubInvalidator, error) {
+	if !strings.HasPrefix(subscription, "projects/") {
+		if project == "" {
+			return nil, fmt.Errorf("GCP_PROJECT is required for the subscription %q", subscription)
+		}
+		subscription = fmt.Sprintf("projects/%s/subscriptions/%s", project, subscription)
+	}
+
+	credentials, err := FindDefaultCredentials()
+	if err != nil {
+		return nil, err
+	}
+	return &PubSubInvalidator{
+		Subscription: subscription,
+		secretGetter: secretGetter,
+		credentials:  &CachedCredentials{Credentials: credentials},
+	}, nil
+}
+
+// pubsubMessage is a notification of Secret Manager, its attributes tell the secret and the event
+type pubsubMessage struct {
+	AckID   string `json:"ackId"`
+	Message struct {
+		Attributes struct {
+			EventType string `json:"eventType"`
+			SecretID  string `json:"secretId"`
+		} `json:"attributes"`
+	} `json:"message"`
+}
+
+// Run pulls and handles notifications until stop is closed
+func (p *PubSubInvalidator) Run(stop <-chan struct{}) {
+	for {
+		select {
+		case <-stop:
+			return
+		default:
+		}
+
+		err := p.pullOnce(p.secretGetter.clockContext(context.Background()))
+		if err != nil {
+			slog.Error("pulling secret notifications", "subscription", p.Subscription, "error", err)
+			select {
+			case <-stop:
+				return
+			case <-time.After(pubsubRetryInterval):
+			}
+		}
+	}
+}
+
+// pullOnce pulls a batch of notifications, invalidates their secrets and acknowledges them
+// Every event invalidates the secret, as versions being added, disabled or destroyed all change what should be served
+func (p *PubSubInvalidator) pullOnce(ctx context.Context) error {
+	var pulled struct {
+		ReceivedMessages []pubsubMessage `json:"receivedMessages"`
+	}
+	err := p.call(ctx, "pull", map[string]interface{}{"maxMessages": pubsubMaxMessages}, &pulled)
+	if err != nil {
+		return err
+	}
+	if len(pulled.ReceivedMessages) == 0 {
+		return nil
+	}
+
+	ackIDs := make([]string, 0, len(pulled.ReceivedMessages))
+	for _, message := range pulled.ReceivedMessages {
+		ackIDs = append(ackIDs, message.AckID)
+
+		// The secret ID is projects/<project>/secrets/<secret>, and only the secrets with the prefix are served
+		name, ok := strings.CutPrefix(path.Base(message.Message.Attributes.SecretID), p.secretGetter.Prefix)
+		if !ok || message.Message.Attributes.SecretID == "" {
+			continue
+		}
+		p.secretGetter.Invalidate(name)
+		slog.Info("invalidated secret", "name", name, "event", message.Message.Attributes.EventType)
+	}
+
+	return p.call(ctx, "acknowledge", map[string]interface{}{"ackIds": ackIDs}, nil)
+}
+
+// call posts the body to the method of the subscription, reading the response into the target when there is one
+func (p *PubSubInvalidator) call(ctx context.Context, method string, body interface{}, target interface{}) error {
+	token, err := p.credentials.Token(ctx)
+	if err != nil {
+		return err
+	}
+
+	content, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	rq, err := http.NewRequestWithContext(ctx, http.MethodPost, fmt.Sprintf("https://pubsub.googleapis.com/v1/%s:%s", p.Subscription, method), bytes.NewReader(content))
+	if err != nil {
+		return err
+	}
+	rq.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token.AccessToken))
+	rq.Header.Set("Content-Type", "application/json")
+	rs, err := UpstreamClient.Do(rq)
+	if err != nil {
+		return err
+	}
+
+	response, err := ReadBody(rs)
+	if err != nil {
+		return err
+	}
+	if rs.StatusCode != http.StatusOK {
+		return fmt.Errorf("error %d - %s", rs.StatusCode, response)
+	}
+	if target == nil {
+		return nil
+	}
+	return json.Unmarshal(response, target)
+}
@@ -0,0 +1,68 @@
+package secrets
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sort"
+	"sync"
+)
+
+// RefreshReport summarizes a refresh of the cache, it never includes secret values
+type RefreshReport struct {
+	Refreshed   int      `json:"refreshed"`
+	Failed      int      `json:"failed"`
+	FailedNames []string `json:"failedNames"`
+}
+
+// RefreshCache fetches again every secret on the cache, with up to concurrency fetches at a time
+// Secrets that no longer exist or are denied are removed from the cache and reported as failed, and so are the ones
+// left when the context is done
+func (sg SecretGetter) RefreshCache(ctx context.Context, concurrency int) RefreshReport {
+	report := RefreshReport{FailedNames: []string{}}
+	if sg.Cache == nil || sg.Provider == nil {
+		return report
+	}
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	semaphore := make(chan struct{}, concurrency)
+
+	for _, key := range sg.Cache.Names() {
+		wg.Add(1)
+		semaphore <- struct{}{}
+		go func(key string) {
+			defer wg.Done()
+			defer func() { <-semaphore }()
+
+			// Names on the cache already carry the prefix, and secrets of other projects are fetched from them
+			ctx, name := keyContext(ctx, key)
+			value, err := sg.fetchSecretValue(ctx, name)
+			switch {
+			case err == nil:
+				sg.remember(key, value)
+			case errors.Is(err, ErrSecretNotFound), errors.Is(err, ErrPermissionDenied):
+				sg.forget(key)
+			}
+			if err != nil {
+				slog.Error("refreshing secret", "name", displayKey(key), "error", err)
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				report.Failed++
+				report.FailedNames = append(report.FailedNames, displayKey(key))
+				return
+			}
+			report.Refreshed++
+		}(key)
+	}
+
+	wg.Wait()
+	sort.Strings(report.FailedNames)
+	return report
+}
@@ -0,0 +1,208 @@
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"time"
+)
+
+// RetryPolicy tells how many times and for how long an upstream call is attempted
+type RetryPolicy struct {
+	// Attempts is the total number of attempts, anything below 1 means a single attempt
+	Attempts int
+	// Timeout bounds every attempt, zero means no timeout
+	Timeout time.Duration
+	// BaseDelay is the wait before the second attempt, doubling on every attempt after it
+	BaseDelay time.Duration
+	// MaxDelay caps the wait between attempts, zero means no cap
+	MaxDelay time.Duration
+}
+
+// upstreamRetryPolicy is the default for the metadata server and Secret Manager, so a single blip is retried
+// instead of ending on a fallback value
+var upstreamRetryPolicy = RetryPolicy{Attempts: 3, BaseDelay: 100 * time.Millisecond, MaxDelay: 2 * time.Second}
+
+// do calls fn until it succeeds, it fails with a non retryable error or the attempts run out
+// A retry override on the context replaces the settings it has, so every backend honours per secret overrides
+func (p RetryPolicy) do(ctx context.Context, fn func(ctx context.Context) error) error {
+	if override, ok := ctx.Value(retryOverrideKey{}).(RetryOverride); ok {
+		p = override.apply(p)
+	}
+
+	var err error
+	for attempt := 1; ; attempt++ {
+		err = p.attempt(ctx, fn)
+		if err == nil || !isRetryable(err) || attempt >= p.Attempts || ctx.Err() != nil {
+			return err
+		}
+
+		if delay := p.delay(attempt); delay > 0 {
+			select {
+			case <-ctx.Done():
+				return err
+			case <-time.After(delay):
+			}
+		}
+	}
+}
+
+// delay returns the wait after the given attempt, a random duration between half and the whole of the backoff,
+// so the instances failing on the same blip do not retry in lockstep
+func (p RetryPolicy) delay(attempt int) time.Duration {
+	if p.BaseDelay <= 0 {
+		return 0
+	}
+
+	backoff := p.BaseDelay << min(attempt-1, 30)
+	if backoff <= 0 || (p.MaxDelay > 0 && backoff > p.MaxDelay) {
+		backoff = p.MaxDelay
+	}
+	if backoff <= 0 {
+		return 0
+	}
+	return backoff/2 + rand.N(backoff/2+1)
+}
+
+// attempt calls fn once, bounded by the timeout
+func (p RetryPolicy) attempt(ctx context.Context, fn func(ctx context.Context) error) error {
+	if p.Timeout <= 0 {
+		return fn(ctx)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, p.Timeout)
+	defer cancel()
+	return fn(ctx)
+}
+
+// statusError is an error answered by an upstream, it keeps the status code so retrying can tell the transient ones
+type statusError struct {
+	StatusCode int
+	err        error
+}
+
+func (e statusError) Error() string {
+	return e.err.Error()
+}
+
+func (e statusError) Unwrap() error {
+	return e.err
+}
+
+// withStatus attaches the status code the upstream answered with to the error
+func withStatus(statusCode int, err error) error {
+	if err == nil {
+		return nil
+	}
+	return statusError{StatusCode: statusCode, err: err}
+}
+
+// isRetryable tells if an error could go away by trying again, which are throttling, server errors and network failures
+// Not found and permission denied are authoritative, and anything else the upstream answered would be answered again
+func isRetryable(err error) bool {
+	if errors.Is(err, ErrSecretNotFound) || errors.Is(err, ErrPermissionDenied) {
+		return false
+	}
+
+	// A success status carrying an error, like an empty token body, is told apart by the error itself
+	var status statusError
+	if errors.As(err, &status) && status.StatusCode >= http.StatusMultipleChoices {
+		return status.StatusCode == http.StatusTooManyRequests || status.StatusCode >= http.StatusInternalServerError
+	}
+
+	// Failures of the connection are reported as net errors, including attempts timing out, bodies cut short
+	// as unexpected EOF, and failures getting a token are transient unless the token endpoint answered otherwise
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, context.DeadlineExceeded) ||
+		errors.Is(err, ErrTokenUnavailable)
+}
+
+// RetryOverride replaces some settings of the retry policy of the backend for a secret, zero values keep the policy's
+type RetryOverride struct {
+	Attempts  int
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	Timeout   time.Duration
+}
+
+// apply returns the policy with the settings of the override
+func (o RetryOverride) apply(policy RetryPolicy) RetryPolicy {
+	if o.Attempts != 0 {
+		policy.Attempts = o.Attempts
+	}
+	if o.BaseDelay != 0 {
+		policy.BaseDelay = o.BaseDelay
+	}
+	if o.MaxDelay != 0 {
+		policy.MaxDelay = o.MaxDelay
+	}
+	if o.Timeout != 0 {
+		policy.Timeout = o.Timeout
+	}
+	return policy
+}
+
+// retryOverrideKey is the context key of the retry override of the secret being fetched
+type retryOverrideKey struct{}
+
+// withRetryOverride returns a context whose retried calls use the override
+func withRetryOverride(ctx context.Context, override RetryOverride) context.Context {
+	return context.WithValue(ctx, retryOverrideKey{}, override)
+}
+
+// withoutRetryOverride returns a context whose retried calls use their own policy, for calls that are not about
+// the secret, like getting a token
+func withoutRetryOverride(ctx context.Context) context.Context {
+	return context.WithValue(ctx, retryOverrideKey{}, nil)
+}
+
+// LoadRetryOverrides reads per secret retry overrides from a JSON file
+// Every override has attempts, baseDelay, maxDelay and timeout, the ones missing are taken from the backend policy
+func LoadRetryOverrides(path string) (map[string]RetryOverride, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := map[string]struct {
+		Attempts  int    `json:"attempts"`
+		BaseDelay string `json:"baseDelay"`
+		MaxDelay  string `json:"maxDelay"`
+		Timeout   string `json:"timeout"`
+	}{}
+	err = json.Unmarshal(content, &entries)
+	if err != nil {
+		return nil, err
+	}
+
+	overrides := map[string]RetryOverride{}
+	for name, entry := range entries {
+		override := RetryOverride{Attempts: entry.Attempts}
+		if entry.BaseDelay != "" {
+			override.BaseDelay, err = time.ParseDuration(entry.BaseDelay)
+			if err != nil {
+				return nil, fmt.Errorf("%s: baseDelay: %w", name, err)
+			}
+		}
+		if entry.MaxDelay != "" {
+			override.MaxDelay, err = time.ParseDuration(entry.MaxDelay)
+			if err != nil {
+				return nil, fmt.Errorf("%s: maxDelay: %w", name, err)
+			}
+		}
+		if entry.Timeout != "" {
+			override.Timeout, err = time.ParseDuration(entry.Timeout)
+			if err != nil {
+				return nil, fmt.Errorf("%s: timeout: %w", name, err)
+			}
+		}
+		overrides[name] = override
+	}
+	return overrides, nil
+}
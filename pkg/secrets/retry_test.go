@@ -0,0 +1,210 @@
+package secrets
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// flakyUpstream fails the first calls for every secret with 503, counting the calls by secret
+type flakyUpstream struct {
+	failures int
+	// names are the secrets told apart on the requests
+	names []string
+
+	mu    sync.Mutex
+	calls map[string]int
+}
+
+func (u *flakyUpstream) handle(w http.ResponseWriter, rq *http.Request) {
+	// The secret is on the path or on the query, depending on the API
+	var name string
+	for _, candidate := range u.names {
+		if strings.Contains(rq.URL.String(), candidate) {
+			name = candidate
+		}
+	}
+
+	u.mu.Lock()
+	u.calls[name]++
+	calls := u.calls[name]
+	u.mu.Unlock()
+
+	if calls <= u.failures {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	// The answer has the value where every backend of the test looks for it
+	_, _ = w.Write([]byte(`{"payload":{"data":"dmFsdWU="},"value":{"computed":"value"}}`))
+}
+
+func (u *flakyUpstream) callsFor(name string) int {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.calls[name]
+}
+
+func TestRetryOverridesApplyToEveryBackend(t *testing.T) {
+	defaultPolicy := RetryPolicy{Attempts: 3}
+	backends := []struct {
+		name     string
+		provider SecretProvider
+	}{
+		{
+			name: "gcp",
+			provider: GCPProvider{
+				Project: "my-project",
+				Credentials: credentialsFunc(func(ctx context.Context) (GCPToken, error) {
+					return GCPToken{AccessToken: "token", Expiry: time.Now().Add(time.Hour)}, nil
+				}),
+				MetadataRetry:      RetryPolicy{Attempts: 1},
+				SecretManagerRetry: defaultPolicy,
+			},
+		},
+		{name: "consul", provider: ConsulProvider{Address: "http://consul:8500", Retry: defaultPolicy}},
+		{name: "doppler", provider: DopplerProvider{Address: "https://doppler", Token: "token", Project: "p", Config: "c", Retry: defaultPolicy}},
+	}
+
+	// Every secret fails three times before succeeding
+	tests := []struct {
+		name          string
+		override      *RetryOverride
+		expectedCalls int
+		expectedOK    bool
+	}{
+		{name: "db-password", override: &RetryOverride{Attempts: 5}, expectedCalls: 4, expectedOK: true},
+		{name: "cache-key", override: &RetryOverride{Attempts: 1}, expectedCalls: 1},
+		{name: "api-key", expectedCalls: 3},
+	}
+
+	for _, backend := range backends {
+		t.Run(backend.name, func(t *testing.T) {
+			upstream := &flakyUpstream{failures: 3, names: []string{"db-password", "cache-key", "api-key"}, calls: map[string]int{}}
+			stubUpstream(t, upstream.handle)
+
+			sg := SecretGetter{Provider: backend.provider, RequireFallback: true, RetryOverrides: map[string]RetryOverride{}}
+			for _, test := range tests {
+				if test.override != nil {
+					sg.RetryOverrides[test.name] = *test.override
+				}
+			}
+
+			for _, test := range tests {
+				_, err := sg.Resolve(test.name, "")
+				if (err == nil) != test.expectedOK {
+					t.Errorf("%s: expected success %t, got %v", test.name, test.expectedOK, err)
+				}
+				if calls := upstream.callsFor(test.name); calls != test.expectedCalls {
+					t.Errorf("%s: expected %d calls, got %d", test.name, test.expectedCalls, calls)
+				}
+			}
+		})
+	}
+}
+
+func TestRetryOverrideDoesNotApplyToTokens(t *testing.T) {
+	var tokenCalls int
+	provider := GCPProvider{
+		Project: "my-project",
+		Credentials: credentialsFunc(func(ctx context.Context) (GCPToken, error) {
+			tokenCalls++
+			return GCPToken{}, ErrTokenUnavailable
+		}),
+		MetadataRetry:      RetryPolicy{Attempts: 2},
+		SecretManagerRetry: RetryPolicy{Attempts: 1},
+	}
+	sg := SecretGetter{Provider: provider, RequireFallback: true, RetryOverrides: map[string]RetryOverride{"db-password": {Attempts: 5}}}
+
+	_, err := sg.Resolve("db-password", "")
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	if tokenCalls != 2 {
+		t.Errorf("expected the metadata policy of 2 attempts, got %d", tokenCalls)
+	}
+}
+
+func TestLoadRetryOverrides(t *testing.T) {
+	tests := []struct {
+		name     string
+		content  string
+		expected map[string]RetryOverride
+		err      bool
+	}{
+		{
+			name:    "every setting",
+			content: `{"db-password":{"attempts":5,"baseDelay":"200ms","maxDelay":"5s","timeout":"1s"}}`,
+			expected: map[string]RetryOverride{
+				"db-password": {Attempts: 5, BaseDelay: 200 * time.Millisecond, MaxDelay: 5 * time.Second, Timeout: time.Second},
+			},
+		},
+		{
+			name:     "some settings",
+			content:  `{"cache-key":{"attempts":1},"api-key":{"baseDelay":"1s"}}`,
+			expected: map[string]RetryOverride{"cache-key": {Attempts: 1}, "api-key": {BaseDelay: time.Second}},
+		},
+		{name: "invalid duration", content: `{"cache-key":{"baseDelay":"soon"}}`, err: true},
+		{name: "not JSON", content: `cache-key: 1`, err: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "retry.json")
+			err := ioutil.WriteFile(path, []byte(test.content), 0600)
+			if err != nil {
+				t.Fatalf("writing file: %s", err)
+			}
+
+			overrides, err := LoadRetryOverrides(path)
+			if (err != nil) != test.err {
+				t.Fatalf("expected error %t, got %v", test.err, err)
+			}
+			if len(overrides) != len(test.expected) {
+				t.Fatalf("expected %d overrides, got %d", len(test.expected), len(overrides))
+			}
+			for name, expected := range test.expected {
+				if overrides[name] != expected {
+					t.Errorf("%s: expected %+v, got %+v", name, expected, overrides[name])
+				}
+			}
+		})
+	}
+}
+
+func TestRetryOverrideApply(t *testing.T) {
+	policy := RetryPolicy{Attempts: 3, Timeout: time.Second, BaseDelay: 100 * time.Millisecond, MaxDelay: 2 * time.Second}
+
+	tests := []struct {
+		name     string
+		override RetryOverride
+		expected RetryPolicy
+	}{
+		{name: "empty keeps the policy", expected: policy},
+		{name: "attempts only", override: RetryOverride{Attempts: 1}, expected: RetryPolicy{Attempts: 1, Timeout: time.Second, BaseDelay: 100 * time.Millisecond, MaxDelay: 2 * time.Second}},
+		{
+			name:     "every setting",
+			override: RetryOverride{Attempts: 5, Timeout: 2 * time.Second, BaseDelay: time.Second, MaxDelay: time.Minute},
+			expected: RetryPolicy{Attempts: 5, Timeout: 2 * time.Second, BaseDelay: time.Second, MaxDelay: time.Minute},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if actual := test.override.apply(policy); actual != test.expected {
+				t.Errorf("expected %+v, got %+v", test.expected, actual)
+			}
+		})
+	}
+}
+
+// credentialsFunc lets a function get the tokens of credentials
+type credentialsFunc func(ctx context.Context) (GCPToken, error)
+
+func (f credentialsFunc) Token(ctx context.Context) (GCPToken, error) {
+	return f(ctx)
+}
@@ -0,0 +1,200 @@
+package secrets
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// SecretChange tells a watched secret rotated, it never includes the value
+type SecretChange struct {
+	Name string `json:"name"`
+	// Version is the new version, empty on backends that do not keep versions
+	Version string    `json:"version,omitempty"`
+	Time    time.Time `json:"time"`
+}
+
+// maxWatchedSecrets bounds how many secrets are watched at once, as every one is polled on each check
+const maxWatchedSecrets = 1000
+
+// ErrTooManyWatched is returned when subscribing to a secret would watch more than maxWatchedSecrets
+var ErrTooManyWatched = errors.New("too many secrets are watched")
+
+// RotationWatcher polls the watched secrets and tells its listeners and subscribers when one changes
+// Changes are detected by the latest version on backends that keep versions, and by a hash of the value otherwise,
+// the values themselves are never kept
+type RotationWatcher struct {
+	secretGetter SecretGetter
+
+	mu sync.Mutex
+	// watchers counts why every secret is watched, being configured and every subscriber count once
+	watchers     map[string]int
+	fingerprints map[string]string
+	listeners    []func(SecretChange)
+	subscribers  map[string]map[chan SecretChange]bool
+}
+
+// NewRotationWatcher returns a watcher of the given secrets, more can be watched while subscribed to
+func NewRotationWatcher(secretGetter SecretGetter, names []string) *RotationWatcher {
+	w := &RotationWatcher{
+		secretGetter: secretGetter,
+		watchers:     map[string]int{},
+		fingerprints: map[string]string{},
+		subscribers:  map[string]map[chan SecretChange]bool{},
+	}
+	for _, name := range names {
+		w.watchers[name]++
+	}
+	return w
+}
+
+// Subscribe watches the secret until the returned function is called, sending its changes on the channel
+// The current version is recorded right away, so a rotation right after subscribing is not missed
+// Changes are dropped for subscribers that are not keeping up, they get the next ones
+func (w *RotationWatcher) Subscribe(ctx context.Context, name string) (<-chan SecretChange, func(), error) {
+	w.mu.Lock()
+	if w.watchers[name] == 0 && len(w.watchers) >= maxWatchedSecrets {
+		w.mu.Unlock()
+		return nil, nil, ErrTooManyWatched
+	}
+	w.watchers[name]++
+	changes := make(chan SecretChange, 1)
+	if w.subscribers[name] == nil {
+		w.subscribers[name] = map[chan SecretChange]bool{}
+	}
+	w.subscribers[name][changes] = true
+	_, seen := w.fingerprints[name]
+	w.mu.Unlock()
+
+	if !seen {
+		if _, fingerprint, err := w.fingerprint(ctx, name); err == nil {
+			w.mu.Lock()
+			if _, seen := w.fingerprints[name]; !seen {
+				w.fingerprints[name] = fingerprint
+			}
+			w.mu.Unlock()
+		}
+	}
+
+	unsubscribe := func() {
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		delete(w.subscribers[name], changes)
+		if len(w.subscribers[name]) == 0 {
+			delete(w.subscribers, name)
+		}
+		w.watchers[name]--
+		if w.watchers[name] == 0 {
+			delete(w.watchers, name)
+			delete(w.fingerprints, name)
+		}
+	}
+	return changes, unsubscribe, nil
+}
+
+// OnChange adds a listener called with every change, listeners are called in order on the polling goroutine
+func (w *RotationWatcher) OnChange(listener func(SecretChange)) {
+	if w == nil {
+		return
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.listeners = append(w.listeners, listener)
+}
+
+// Run checks the secrets right away and then on the given interval, until stop is closed
+// The first check only records the current versions, so starting does not count as a rotation
+func (w *RotationWatcher) Run(interval time.Duration, stop <-chan struct{}) {
+	w.Check(context.Background())
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			w.Check(context.Background())
+		}
+	}
+}
+
+// Check gets the fingerprint of every secret and calls the listeners for the ones that changed
+// Secrets that cannot be checked keep their previous fingerprint, so an outage is not taken for a rotation
+func (w *RotationWatcher) Check(ctx context.Context) {
+	w.mu.Lock()
+	names := sortedKeys(w.watchers)
+	w.mu.Unlock()
+
+	for _, name := range names {
+		version, fingerprint, err := w.fingerprint(ctx, name)
+		if err != nil {
+			slog.Warn("checking secret rotation", "name", name, "error", err)
+			continue
+		}
+
+		change := SecretChange{Name: name, Version: version, Time: w.secretGetter.Now()}
+		w.mu.Lock()
+		previous, seen := w.fingerprints[name]
+		// Secrets no longer watched while they were checked are left out
+		if w.watchers[name] > 0 {
+			w.fingerprints[name] = fingerprint
+		}
+		listeners := w.listeners
+		if seen && previous != fingerprint {
+			for subscriber := range w.subscribers[name] {
+				select {
+				case subscriber <- change:
+				default:
+				}
+			}
+		}
+		w.mu.Unlock()
+		if !seen || previous == fingerprint {
+			continue
+		}
+
+		slog.Info("secret rotated", "name", name, "version", version)
+		for _, listener := range listeners {
+			listener(change)
+		}
+	}
+}
+
+// fingerprint returns the latest version of the secret and what identifies it, bypassing every cache
+func (w *RotationWatcher) fingerprint(ctx context.Context, name string) (string, string, error) {
+	sg := w.secretGetter
+	if provider, ok := sg.Provider.(VersionedProvider); ok {
+		metadata, err := provider.GetVersionMetadata(sg.providerContext(ctx, sg.Prefix+name), sg.Prefix+name)
+		if err != nil {
+			return "", "", err
+		}
+		return metadata.Version, "version:" + metadata.Version, nil
+	}
+
+	var value string
+	if sg.Provider == nil {
+		var t trace
+		resolution, err := sg.lookupEnv(sg.Prefix+name, "", &t)
+		if err == nil && resolution.IsFallback() {
+			err = ErrSecretNotFound
+		}
+		if err != nil {
+			return "", "", err
+		}
+		value = resolution.Value
+	} else {
+		var err error
+		value, err = sg.fetchSecretValue(ctx, sg.Prefix+name)
+		if err != nil {
+			return "", "", err
+		}
+	}
+	hash := sha256.Sum256([]byte(value))
+	return "", "sha256:" + hex.EncodeToString(hash[:]), nil
+}
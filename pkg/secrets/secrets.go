@@ -0,0 +1,108 @@
+package secrets
+
+import (
+	"context"
+	"errors"
+	"path"
+	"strings"
+
+	"cloud.google.com/go/secretmanager/apiv1/secretmanagerpb"
+	"google.golang.org/api/iterator"
+)
+
+// ErrListUnavailable is returned when secrets are listed on a provider that cannot list them
+var ErrListUnavailable = errors.New("listing secrets is not available on this backend")
+
+// SecretInfo describes a secret visible to the provider, it never includes the value
+type SecretInfo struct {
+	Name       string            `json:"name"`
+	CreateTime string            `json:"createTime,omitempty"`
+	Labels     map[string]string `json:"labels,omitempty"`
+}
+
+// SecretPage is a page of secrets, NextPageToken is empty on the last page
+type SecretPage struct {
+	Secrets       []SecretInfo `json:"secrets"`
+	NextPageToken string       `json:"nextPageToken,omitempty"`
+}
+
+// SecretLister is implemented by providers that can list the secrets they can see
+type SecretLister interface {
+	ListSecrets(ctx context.Context, pageSize int, pageToken string) (SecretPage, error)
+}
+
+// ListSecrets lists a page of the secrets the provider can see, a zero page size leaves it to the provider
+// With a prefix, only the secrets that have it are listed, and without it, so the names can be asked for as they are
+func (sg SecretGetter) ListSecrets(ctx context.Context, pageSize int, pageToken string) (SecretPage, error) {
+	lister, ok := sg.Provider.(SecretLister)
+	if !ok {
+		return SecretPage{}, ErrListUnavailable
+	}
+
+	page, err := lister.ListSecrets(sg.clockContext(ctx), pageSize, pageToken)
+	if err != nil {
+		return SecretPage{}, err
+	}
+
+	secrets := make([]SecretInfo, 0, len(page.Secrets))
+	for _, secret := range page.Secrets {
+		if !strings.HasPrefix(secret.Name, sg.Prefix) {
+			continue
+		}
+		secret.Name = strings.TrimPrefix(secret.Name, sg.Prefix)
+		secrets = append(secrets, secret)
+	}
+	page.Secrets = secrets
+	return page, nil
+}
+
+// ListSecrets lists a page of the secrets of the project, or of the location when using regional endpoints
+func (p GCPProvider) ListSecrets(ctx context.Context, pageSize int, pageToken string) (SecretPage, error) {
+	var page SecretPage
+	err := p.withToken(ctx, func(token string) error {
+		return p.SecretManagerRetry.do(ctx, func(ctx context.Context) error {
+			var err error
+			page, err = p.fetchSecretPage(ctx, pageSize, pageToken, token)
+			return err
+		})
+	})
+	return page, err
+}
+
+// fetchSecretPage calls the list API of Secret Manager
+func (p GCPProvider) fetchSecretPage(ctx context.Context, pageSize int, pageToken string, token string) (SecretPage, error) {
+	parent, err := p.secretsParent(p.projectFor(ctx, ""))
+	if err != nil {
+		return SecretPage{}, err
+	}
+	client, err := p.secretManagerClient(ctx, token)
+	if err != nil {
+		return SecretPage{}, err
+	}
+	defer client.Close()
+
+	// The pager needs a size, the largest one is asked for when the caller has none
+	if pageSize <= 0 {
+		pageSize = MaxListPageSize
+	}
+	var secrets []*secretmanagerpb.Secret
+	listing := client.ListSecrets(ctx, &secretmanagerpb.ListSecretsRequest{Parent: parent})
+	nextPageToken, err := iterator.NewPager(listing, pageSize, pageToken).NextPage(&secrets)
+	if err != nil {
+		return SecretPage{}, secretManagerError(err)
+	}
+
+	// The names are projects/<project>/secrets/<secret>
+	page := SecretPage{Secrets: make([]SecretInfo, 0, len(secrets)), NextPageToken: nextPageToken}
+	for _, secret := range secrets {
+		page.Secrets = append(page.Secrets, SecretInfo{
+			Name:       path.Base(secret.GetName()),
+			CreateTime: formatTimestamp(secret.GetCreateTime()),
+			Labels:     secret.GetLabels(),
+		})
+	}
+	return page, nil
+}
+
+// MaxListPageSize bounds the page size callers may ask for
+const MaxListPageSize = 1000
@@ -0,0 +1,148 @@
+// Package secretstest serves secrets from memory with the HTTP API of the server, so integration tests of client
+// applications can run against it without any cloud credentials
+package secretstest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"secret-manager-demo/pkg/secrets"
+)
+
+// maxBatchSize is how many secrets can be asked for at once, as on the server
+const maxBatchSize = 100
+
+// NewServer starts a server answering /get-secret, /get-secrets and /get-secret-metadata like the real one, serving
+// the values from memory without caching or fallbacks
+// The provider is returned to change the secrets during the test, the server has to be closed when done
+func NewServer(values map[string]string) (*httptest.Server, *secrets.MemoryProvider) {
+	provider := secrets.NewMemoryProvider(values)
+	secretGetter := secrets.SecretGetter{Provider: provider, RequireFallback: true}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /get-secret", getSecretHandler(secretGetter))
+	mux.HandleFunc("POST /get-secrets", getSecretsHandler(secretGetter))
+	mux.HandleFunc("GET /get-secret-metadata", getSecretMetadataHandler(secretGetter))
+	return httptest.NewServer(mux), provider
+}
+
+// secretResponse is the answer for a secret, the version is only set when one was asked for
+type secretResponse struct {
+	Name    string `json:"name"`
+	Status  int    `json:"status,omitempty"`
+	Value   string `json:"value,omitempty"`
+	Version string `json:"version,omitempty"`
+}
+
+// getSecretHandler answers the secret named on the secret header or the name query, at ?version= when there is one
+func getSecretHandler(secretGetter secrets.SecretGetter) http.HandlerFunc {
+	return func(w http.ResponseWriter, rq *http.Request) {
+		name := rq.Header.Get("secret")
+		if name == "" {
+			name = rq.URL.Query().Get("name")
+		}
+		if name == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		version := rq.URL.Query().Get("version")
+		value, status := resolve(rq.Context(), secretGetter, name, version)
+		if status != http.StatusOK {
+			w.WriteHeader(status)
+			return
+		}
+		writeJSON(w, http.StatusOK, secretResponse{Name: name, Value: value, Version: version})
+	}
+}
+
+// getSecretsHandler answers every secret of the JSON array of names, in the same order
+func getSecretsHandler(secretGetter secrets.SecretGetter) http.HandlerFunc {
+	return func(w http.ResponseWriter, rq *http.Request) {
+		var names []string
+		err := json.NewDecoder(rq.Body).Decode(&names)
+		if err != nil || len(names) > maxBatchSize {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		answers := make([]secretResponse, len(names))
+		for i, name := range names {
+			answers[i] = secretResponse{Name: name}
+			answers[i].Value, answers[i].Status = resolve(rq.Context(), secretGetter, name, "")
+		}
+		writeJSON(w, http.StatusOK, struct {
+			Secrets []secretResponse `json:"secrets"`
+		}{
+			Secrets: answers,
+		})
+	}
+}
+
+// getSecretMetadataHandler answers the latest version of the secret and its create time, never its value
+func getSecretMetadataHandler(secretGetter secrets.SecretGetter) http.HandlerFunc {
+	return func(w http.ResponseWriter, rq *http.Request) {
+		name := rq.Header.Get("secret")
+		if name == "" {
+			name = rq.URL.Query().Get("name")
+		}
+
+		metadata, err := secretGetter.GetVersionMetadata(rq.Context(), name)
+		if err != nil {
+			w.WriteHeader(errorStatus(err))
+			return
+		}
+		writeJSON(w, http.StatusOK, struct {
+			Name string `json:"name"`
+			secrets.VersionMetadata
+		}{
+			Name:            name,
+			VersionMetadata: metadata,
+		})
+	}
+}
+
+// resolve gets the value of the secret at the version, or the latest one when there is none
+func resolve(ctx context.Context, secretGetter secrets.SecretGetter, name string, version string) (string, int) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	var value string
+	var err error
+	if version == "" {
+		var resolution secrets.Resolution
+		resolution, err = secretGetter.ResolveContext(ctx, name, "")
+		value = resolution.Value
+	} else {
+		value, err = secretGetter.GetSecretVersion(ctx, name, version)
+	}
+	if err != nil {
+		return "", errorStatus(err)
+	}
+	return value, http.StatusOK
+}
+
+// errorStatus returns the status the server answers for the error
+func errorStatus(err error) int {
+	if errors.Is(err, secrets.ErrSecretNotFound) {
+		return http.StatusNotFound
+	}
+	return http.StatusBadGateway
+}
+
+// writeJSON answers the body as JSON with the status
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	bytes, err := json.Marshal(body)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_, _ = w.Write(bytes)
+}
@@ -0,0 +1,97 @@
+package secretstest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+
+	"secret-manager-demo/pkg/client"
+)
+
+func TestServerAnswersLikeTheRealOne(t *testing.T) {
+	server, provider := NewServer(map[string]string{"db-password": "first"})
+	defer server.Close()
+	c := client.New(server.URL)
+	ctx := context.Background()
+
+	value, err := c.Get(ctx, "db-password")
+	if err != nil || value != "first" {
+		t.Fatalf("expected first, got %q (%v)", value, err)
+	}
+
+	// Secrets changed during the test are served right away, without caching
+	provider.Set("db-password", "second")
+	value, err = c.Get(ctx, "db-password")
+	if err != nil || value != "second" {
+		t.Errorf("expected second, got %q (%v)", value, err)
+	}
+
+	secret, err := c.GetSecret(ctx, "db-password", "1")
+	if err != nil || secret.Value != "first" || secret.Version != "1" {
+		t.Errorf("expected first at version 1, got %+v (%v)", secret, err)
+	}
+
+	_, err = c.Get(ctx, "api-key")
+	if !errors.Is(err, client.ErrNotFound) {
+		t.Errorf("expected not found, got %v", err)
+	}
+
+	provider.Delete("db-password")
+	_, err = c.Get(ctx, "db-password")
+	if !errors.Is(err, client.ErrNotFound) {
+		t.Errorf("expected deleted secret to be not found, got %v", err)
+	}
+}
+
+func TestServerBatch(t *testing.T) {
+	server, _ := NewServer(map[string]string{"db-password": "hunter2", "api-key": "abc"})
+	defer server.Close()
+
+	secrets, err := client.New(server.URL).GetMany(context.Background(), []string{"api-key", "missing", "db-password"})
+	if err != nil {
+		t.Fatalf("getting secrets: %s", err)
+	}
+	if len(secrets) != 3 {
+		t.Fatalf("expected 3 secrets, got %d", len(secrets))
+	}
+	if secrets[0].Value != "abc" || secrets[0].Err != nil {
+		t.Errorf("expected abc, got %+v", secrets[0])
+	}
+	if !errors.Is(secrets[1].Err, client.ErrNotFound) {
+		t.Errorf("expected not found, got %+v", secrets[1])
+	}
+	if secrets[2].Value != "hunter2" || secrets[2].Err != nil {
+		t.Errorf("expected hunter2, got %+v", secrets[2])
+	}
+}
+
+func TestServerMetadata(t *testing.T) {
+	server, provider := NewServer(map[string]string{"db-password": "first"})
+	defer server.Close()
+	provider.Set("db-password", "second")
+
+	rq, err := http.NewRequest(http.MethodGet, server.URL+"/get-secret-metadata", nil)
+	if err != nil {
+		t.Fatalf("creating request: %s", err)
+	}
+	rq.Header.Set("secret", "db-password")
+	rs, err := http.DefaultClient.Do(rq)
+	if err != nil {
+		t.Fatalf("sending request: %s", err)
+	}
+	defer rs.Body.Close()
+
+	var metadata struct {
+		Name       string `json:"name"`
+		Version    string `json:"version"`
+		CreateTime string `json:"createTime"`
+	}
+	if err := json.NewDecoder(rs.Body).Decode(&metadata); err != nil {
+		t.Fatalf("decoding response: %s", err)
+	}
+	if metadata.Name != "db-password" || metadata.Version != "2" || metadata.CreateTime == "" {
+		t.Errorf("expected version 2 of db-password with a create time, got %+v", metadata)
+	}
+}
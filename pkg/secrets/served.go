@@ -0,0 +1,70 @@
+package secrets
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ServedSecrets tracks the distinct secrets that were served and their sources, never their values
+// A nil ServedSecrets tracks nothing
+type ServedSecrets struct {
+	mu      sync.Mutex
+	sources map[string]map[Source]bool
+}
+
+// NewServedSecrets returns an empty tracker
+func NewServedSecrets() *ServedSecrets {
+	return &ServedSecrets{sources: map[string]map[Source]bool{}}
+}
+
+// Record adds the source the secret was served from
+func (s *ServedSecrets) Record(name string, source Source) {
+	if s == nil || source == "" {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.sources[name] == nil {
+		s.sources[name] = map[Source]bool{}
+	}
+	s.sources[name][source] = true
+}
+
+// Snapshot returns every served secret with its sources, sorted
+func (s *ServedSecrets) Snapshot() map[string][]Source {
+	snapshot := map[string][]Source{}
+	if s == nil {
+		return snapshot
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for name, sources := range s.sources {
+		for source := range sources {
+			snapshot[name] = append(snapshot[name], source)
+		}
+		sort.Slice(snapshot[name], func(i, j int) bool { return snapshot[name][i] < snapshot[name][j] })
+	}
+	return snapshot
+}
+
+// Summary returns a single line with the number of distinct secrets and how many came from every source
+func (s *ServedSecrets) Summary() string {
+	snapshot := s.Snapshot()
+	perSource := map[Source]int{}
+	for _, sources := range snapshot {
+		for _, source := range sources {
+			perSource[source]++
+		}
+	}
+
+	counts := make([]string, 0, len(perSource))
+	for source, count := range perSource {
+		counts = append(counts, fmt.Sprintf("%s=%d", source, count))
+	}
+	sort.Strings(counts)
+	return fmt.Sprintf("serving %d distinct secrets (%s)", len(snapshot), strings.Join(counts, ", "))
+}
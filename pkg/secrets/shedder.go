@@ -0,0 +1,90 @@
+package secrets
+
+import (
+	"sync"
+	"time"
+)
+
+// shedderProbeInterval is how often a request goes through to the backend while shedding
+// Without probes the latency would never be measured again, so shedding would never stop
+const shedderProbeInterval = time.Second
+
+// LoadShedder tracks a rolling average of the backend latency, and tells when to stop calling it
+// A nil LoadShedder never sheds
+type LoadShedder struct {
+	threshold time.Duration
+	clock     Clock
+	mu        sync.Mutex
+	average   time.Duration
+	lastProbe time.Time
+}
+
+// NewLoadShedder returns a shedder for the given latency threshold, a zero threshold disables shedding
+func NewLoadShedder(threshold time.Duration, clock Clock) *LoadShedder {
+	if threshold <= 0 {
+		return nil
+	}
+	if clock == nil {
+		clock = RealClock{}
+	}
+	return &LoadShedder{threshold: threshold, clock: clock}
+}
+
+// Observe adds a backend latency to the rolling average
+func (s *LoadShedder) Observe(latency time.Duration) {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.average == 0 {
+		s.average = latency
+		return
+	}
+
+	// Exponentially weighted, so recent latencies count more
+	s.average = (s.average*4 + latency) / 5
+}
+
+// Allow tells if the backend can be called, which is always true unless shedding and no probe is due
+func (s *LoadShedder) Allow() bool {
+	if s == nil {
+		return true
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.average <= s.threshold {
+		return true
+	}
+
+	now := s.clock.Now()
+	if now.Sub(s.lastProbe) >= shedderProbeInterval {
+		s.lastProbe = now
+		return true
+	}
+	return false
+}
+
+// Shedding tells if the rolling average is above the threshold
+func (s *LoadShedder) Shedding() bool {
+	if s == nil {
+		return false
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.average > s.threshold
+}
+
+// Average returns the rolling average of the backend latency
+func (s *LoadShedder) Average() time.Duration {
+	if s == nil {
+		return 0
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.average
+}
@@ -0,0 +1,66 @@
+package secrets
+
+import (
+	"context"
+	"sync"
+)
+
+// SingleFlight collapses concurrent calls for the same key into one, the callers arriving while it is in flight
+// wait for it and share its result, so a cold cache does not send a call to the backend per request
+// A nil SingleFlight makes every call
+type SingleFlight struct {
+	mu    sync.Mutex
+	calls map[string]*flight
+}
+
+// flight is a call in progress, done is closed once its result is set
+type flight struct {
+	done  chan struct{}
+	value string
+	err   error
+}
+
+// NewSingleFlight returns an empty SingleFlight
+func NewSingleFlight() *SingleFlight {
+	return &SingleFlight{calls: map[string]*flight{}}
+}
+
+// Do calls fn for the key unless a call for it is in flight, in which case it waits for that one instead
+// The call is not cancelled when the caller that started it goes away, as others may be waiting for it, but it keeps
+// its deadline, and callers stop waiting when their own context is done
+func (s *SingleFlight) Do(ctx context.Context, key string, fn func(ctx context.Context) (string, error)) (string, error) {
+	if s == nil {
+		return fn(ctx)
+	}
+
+	s.mu.Lock()
+	call, ok := s.calls[key]
+	if !ok {
+		call = &flight{done: make(chan struct{})}
+		s.calls[key] = call
+		s.mu.Unlock()
+
+		go func() {
+			callCtx, cancel := context.WithoutCancel(ctx), context.CancelFunc(func() {})
+			if deadline, ok := ctx.Deadline(); ok {
+				callCtx, cancel = context.WithDeadline(callCtx, deadline)
+			}
+			defer cancel()
+
+			call.value, call.err = fn(callCtx)
+			s.mu.Lock()
+			delete(s.calls, key)
+			s.mu.Unlock()
+			close(call.done)
+		}()
+	} else {
+		s.mu.Unlock()
+	}
+
+	select {
+	case <-ctx.Done():
+		return "", ctx.Err()
+	case <-call.done:
+		return call.value, call.err
+	}
+}
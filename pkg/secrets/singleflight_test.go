@@ -0,0 +1,121 @@
+package secrets
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSingleFlightSharesTheCall(t *testing.T) {
+	flights := NewSingleFlight()
+	var calls atomic.Int32
+	started := make(chan struct{})
+	release := make(chan struct{})
+	fn := func(ctx context.Context) (string, error) {
+		if calls.Add(1) == 1 {
+			close(started)
+		}
+		<-release
+		return "hunter2", nil
+	}
+
+	var wg sync.WaitGroup
+	results := make(chan string, 3)
+	do := func() {
+		defer wg.Done()
+		value, err := flights.Do(context.Background(), "db-password", fn)
+		if err != nil {
+			t.Errorf("expected no error, got %s", err)
+		}
+		results <- value
+	}
+	wg.Add(1)
+	go do()
+	<-started
+	wg.Add(2)
+	go do()
+	go do()
+
+	// Other keys are not held by the call in flight
+	other, err := flights.Do(context.Background(), "api-key", func(ctx context.Context) (string, error) { return "abc", nil })
+	if err != nil || other != "abc" {
+		t.Errorf("expected abc, got %q and %v", other, err)
+	}
+
+	// The later callers get a moment to join the call in flight before it returns
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	close(results)
+	for value := range results {
+		if value != "hunter2" {
+			t.Errorf("expected hunter2, got %q", value)
+		}
+	}
+	if calls.Load() != 1 {
+		t.Errorf("expected 1 call, got %d", calls.Load())
+	}
+
+	// A key is called again once its call is done
+	if _, err := flights.Do(context.Background(), "db-password", fn); err != nil || calls.Load() != 2 {
+		t.Errorf("expected a new call, got %d calls and %v", calls.Load(), err)
+	}
+}
+
+func TestSingleFlightOutlivesTheCaller(t *testing.T) {
+	flights := NewSingleFlight()
+	deadline := time.Now().Add(time.Hour)
+	release := make(chan struct{})
+	callCtx := make(chan context.Context, 1)
+	fn := func(ctx context.Context) (string, error) {
+		callCtx <- ctx
+		<-release
+		return "hunter2", ctx.Err()
+	}
+
+	// The caller that started the call goes away, the call carries on with its deadline for the ones waiting
+	ctx, cancel := context.WithDeadline(context.Background(), deadline)
+	done := make(chan error, 1)
+	go func() {
+		_, err := flights.Do(ctx, "db-password", fn)
+		done <- err
+	}()
+	started := <-callCtx
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Errorf("expected the caller to stop waiting, got %v", err)
+	}
+	if started.Err() != nil {
+		t.Errorf("expected the call to go on, got %v", started.Err())
+	}
+	if callDeadline, ok := started.Deadline(); !ok || !callDeadline.Equal(deadline) {
+		t.Errorf("expected the deadline of the caller, got %s", callDeadline)
+	}
+
+	waiting := make(chan string, 1)
+	go func() {
+		value, _ := flights.Do(context.Background(), "db-password", fn)
+		waiting <- value
+	}()
+	close(release)
+	if value := <-waiting; value != "hunter2" {
+		t.Errorf("expected the waiting caller to get hunter2, got %q", value)
+	}
+}
+
+func TestNilSingleFlightCallsEveryTime(t *testing.T) {
+	var flights *SingleFlight
+	var calls int
+	for i := 0; i < 2; i++ {
+		_, _ = flights.Do(context.Background(), "db-password", func(ctx context.Context) (string, error) {
+			calls++
+			return "", nil
+		})
+	}
+	if calls != 2 {
+		t.Errorf("expected 2 calls, got %d", calls)
+	}
+}
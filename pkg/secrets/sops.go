@@ -0,0 +1,651 @@
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// SourceSOPS is the source of values coming from SOPS files
+const SourceSOPS Source = "sops"
+
+func init() {
+	RegisterProvider("sops", newSOPSProviderFromEnv)
+}
+
+// SOPSProvider gets secrets from SOPS encrypted YAML or JSON files, so secrets kept encrypted on git are served as
+// they are, decrypting the data key of every file with Cloud KMS, AWS KMS or the age keys of SOPS_AGE_KEY or
+// SOPS_AGE_KEY_FILE
+// Nested keys are joined with underscores into the secret names, and lists of values with commas
+// The files are decrypted again when they change, and a file that cannot be decrypted keeps its previous values
+type SOPSProvider struct {
+	files []*sopsFile
+}
+
+// sopsFile is a SOPS file and the values it had when it was last decrypted
+type sopsFile struct {
+	path string
+
+	mu      sync.Mutex
+	values  map[string]string
+	modTime time.Time
+	size    int64
+}
+
+// NewSOPSProvider returns a provider of the files, which are decrypted right away so a missing key fails on start
+func NewSOPSProvider(paths []string) (*SOPSProvider, error) {
+	p := &SOPSProvider{}
+	for _, path := range paths {
+		file := &sopsFile{path: path}
+		_, err := file.current(context.Background())
+		if err != nil {
+			return nil, err
+		}
+		p.files = append(p.files, file)
+	}
+	return p, nil
+}
+
+// Source tells the values come from SOPS files
+func (p *SOPSProvider) Source() Source {
+	return SourceSOPS
+}
+
+// GetSecret gets the secret from the last file that has it, so later files override earlier ones
+func (p *SOPSProvider) GetSecret(ctx context.Context, name string) (string, error) {
+	for i := len(p.files) - 1; i >= 0; i-- {
+		values, err := p.files[i].current(ctx)
+		if err != nil {
+			return "", err
+		}
+		if value, ok := values[name]; ok {
+			return value, nil
+		}
+	}
+	return "", ErrSecretNotFound
+}
+
+// current returns the values of the file, decrypting it again when it changed since it was last decrypted
+func (f *sopsFile) current(ctx context.Context) (map[string]string, error) {
+	info, err := os.Stat(f.path)
+	if err != nil {
+		return nil, err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.values != nil && info.ModTime().Equal(f.modTime) && info.Size() == f.size {
+		return f.values, nil
+	}
+
+	content, err := ioutil.ReadFile(f.path)
+	var values map[string]string
+	if err == nil {
+		values, err = decryptSOPS(ctx, f.path, content)
+	}
+	if err != nil {
+		if f.values != nil {
+			slog.Error("decrypting SOPS file, keeping previous values", "path", f.path, "error", err)
+			return f.values, nil
+		}
+		return nil, fmt.Errorf("decrypting %s: %w", f.path, err)
+	}
+	f.values, f.modTime, f.size = values, info.ModTime(), info.Size()
+	return values, nil
+}
+
+// sopsComment is a comment of a SOPS document, which is encrypted and covered by the MAC like the values
+type sopsComment string
+
+// sopsItem is a key of a SOPS document and its value, which is a string, a sopsBranch or a list of those
+// Comments are items without a key
+type sopsItem struct {
+	Key   string
+	Value interface{}
+}
+
+// sopsBranch is a map of a SOPS document, keeping the order of its keys as the MAC depends on it
+type sopsBranch []sopsItem
+
+// get returns the value of the key, nil when missing
+func (b sopsBranch) get(key string) interface{} {
+	for _, item := range b {
+		if item.Key == key {
+			return item.Value
+		}
+	}
+	return nil
+}
+
+// getString returns the value of the key when it is a string
+func (b sopsBranch) getString(key string) string {
+	value, _ := b.get(key).(string)
+	return value
+}
+
+// getBranches returns the maps of the list of the key
+func (b sopsBranch) getBranches(key string) []sopsBranch {
+	list, _ := b.get(key).([]interface{})
+	var branches []sopsBranch
+	for _, item := range list {
+		if branch, ok := item.(sopsBranch); ok {
+			branches = append(branches, branch)
+		}
+	}
+	return branches
+}
+
+// decryptSOPS decrypts the document into its names and values, checking its MAC so tampered files are refused
+func decryptSOPS(ctx context.Context, path string, content []byte) (map[string]string, error) {
+	var document sopsBranch
+	var err error
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		document, err = parseSOPSJSON(content)
+	case ".yaml", ".yml":
+		document, err = parseSOPSYAML(content)
+	default:
+		return nil, errors.New("expected a .json, .yaml or .yml file")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	metadata, ok := document.get("sops").(sopsBranch)
+	if !ok {
+		return nil, errors.New("not a SOPS file, there is no sops metadata")
+	}
+	key, err := sopsDataKey(ctx, metadata)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &sopsDecryption{
+		key:               key,
+		unencryptedSuffix: metadata.getString("unencrypted_suffix"),
+		encryptedSuffix:   metadata.getString("encrypted_suffix"),
+		macOnlyEncrypted:  metadata.getString("mac_only_encrypted") == "true",
+		hash:              sha512.New(),
+		values:            map[string]string{},
+	}
+	for field, target := range map[string]**regexp.Regexp{"unencrypted_regex": &d.unencryptedRegex, "encrypted_regex": &d.encryptedRegex} {
+		if expression := metadata.getString(field); expression != "" {
+			*target, err = regexp.Compile(expression)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", field, err)
+			}
+		}
+	}
+
+	err = d.branch(document, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// The MAC is the hash of every value, encrypted with the time of the last change as additional data
+	mac, _, err := decryptSOPSValue(key, metadata.getString("mac"), metadata.getString("lastmodified"))
+	if err != nil {
+		return nil, fmt.Errorf("decrypting the MAC: %w", err)
+	}
+	if subtle.ConstantTimeCompare([]byte(mac), []byte(fmt.Sprintf("%X", d.hash.Sum(nil)))) != 1 {
+		return nil, errors.New("the MAC does not match, the file was changed without SOPS")
+	}
+	return d.values, nil
+}
+
+// sopsDecryption walks a document in order, decrypting the values and hashing them for the MAC
+type sopsDecryption struct {
+	key               []byte
+	unencryptedSuffix string
+	encryptedSuffix   string
+	unencryptedRegex  *regexp.Regexp
+	encryptedRegex    *regexp.Regexp
+	macOnlyEncrypted  bool
+	hash              hash.Hash
+	values            map[string]string
+}
+
+// branch decrypts every item of the map, the metadata at the top is left out
+func (d *sopsDecryption) branch(branch sopsBranch, path []string) error {
+	for _, item := range branch {
+		if comment, ok := item.Value.(sopsComment); ok && item.Key == "" {
+			_, err := d.leaf(string(comment), path, true)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		if len(path) == 0 && item.Key == "sops" {
+			continue
+		}
+
+		err := d.value(item.Value, append(path[:len(path):len(path)], item.Key))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// value decrypts a value of the document, items of lists keep the path of the list
+func (d *sopsDecryption) value(value interface{}, path []string) error {
+	switch value := value.(type) {
+	case sopsBranch:
+		return d.branch(value, path)
+	case sopsComment:
+		_, err := d.leaf(string(value), path, true)
+		return err
+	case []interface{}:
+		for _, item := range value {
+			err := d.value(item, path)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	case string:
+		plaintext, err := d.leaf(value, path, false)
+		if err != nil {
+			return fmt.Errorf("%s: %w", strings.Join(path, "."), err)
+		}
+		name := strings.Join(path, "_")
+		if previous, ok := d.values[name]; ok {
+			plaintext = previous + "," + plaintext
+		}
+		d.values[name] = plaintext
+		return nil
+	default:
+		return nil
+	}
+}
+
+// leaf decrypts a value or a comment when its path is encrypted, and adds it to the hash
+// Comments that cannot be decrypted are taken as they are, as SOPS does
+func (d *sopsDecryption) leaf(value string, path []string, comment bool) (string, error) {
+	encrypted := d.encrypted(path)
+	plaintext := value
+	if encrypted {
+		decrypted, kind, err := decryptSOPSValue(d.key, value, strings.Join(path, ":")+":")
+		switch {
+		case err == nil:
+			plaintext = canonicalSOPSValue(decrypted, kind)
+		case !comment:
+			return "", err
+		}
+	}
+
+	if encrypted || !d.macOnlyEncrypted {
+		d.hash.Write([]byte(plaintext))
+	}
+	return plaintext, nil
+}
+
+// encrypted tells if the values on the path are encrypted, according to the suffixes and expressions of the file
+func (d *sopsDecryption) encrypted(path []string) bool {
+	encrypted := d.encryptedSuffix == "" && d.encryptedRegex == nil
+	for _, key := range path {
+		switch {
+		case d.unencryptedSuffix != "" && strings.HasSuffix(key, d.unencryptedSuffix):
+			return false
+		case d.unencryptedRegex != nil && d.unencryptedRegex.MatchString(key):
+			return false
+		case d.encryptedSuffix != "" && strings.HasSuffix(key, d.encryptedSuffix):
+			encrypted = true
+		case d.encryptedRegex != nil && d.encryptedRegex.MatchString(key):
+			encrypted = true
+		}
+	}
+	return encrypted
+}
+
+// sopsValuePattern matches the encrypted values, with the base64 ciphertext, nonce and tag and the type of the value
+var sopsValuePattern = regexp.MustCompile(`^ENC\[AES256_GCM,data:(.*),iv:(.*),tag:(.*),type:(.*)\]$`)
+
+// decryptSOPSValue decrypts an encrypted value with the data key, returning its plaintext and its type
+func decryptSOPSValue(key []byte, value string, additionalData string) (string, string, error) {
+	match := sopsValuePattern.FindStringSubmatch(value)
+	if match == nil {
+		return "", "", errors.New("not an encrypted value")
+	}
+	var parts [3][]byte
+	for i := range parts {
+		var err error
+		parts[i], err = base64.StdEncoding.DecodeString(match[i+1])
+		if err != nil {
+			return "", "", err
+		}
+	}
+	data, iv, tag := parts[0], parts[1], parts[2]
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return "", "", err
+	}
+	aead, err := cipher.NewGCMWithNonceSize(block, len(iv))
+	if err != nil {
+		return "", "", err
+	}
+	plaintext, err := aead.Open(nil, iv, append(data, tag...), []byte(additionalData))
+	if err != nil {
+		return "", "", errors.New("the value cannot be decrypted with the data key")
+	}
+	return string(plaintext), match[4], nil
+}
+
+// canonicalSOPSValue formats the plaintext of the type the way SOPS hashes it
+func canonicalSOPSValue(plaintext string, kind string) string {
+	switch kind {
+	case "float":
+		if parsed, err := strconv.ParseFloat(plaintext, 64); err == nil {
+			return strconv.FormatFloat(parsed, 'f', -1, 64)
+		}
+	case "bool":
+		if parsed, err := strconv.ParseBool(plaintext); err == nil {
+			return strconv.FormatBool(parsed)
+		}
+	}
+	return plaintext
+}
+
+// sopsDataKey decrypts the data key of the file with the first of its Cloud KMS, AWS KMS or age keys that works
+func sopsDataKey(ctx context.Context, metadata sopsBranch) ([]byte, error) {
+	var errs []error
+	for _, key := range metadata.getBranches("gcp_kms") {
+		encrypted, err := base64.StdEncoding.DecodeString(key.getString("enc"))
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		credentials, err := FindDefaultCredentials()
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		dataKey, err := kmsDecrypt(ctx, credentials, key.getString("resource_id"), encrypted)
+		if err == nil {
+			return dataKey, nil
+		}
+		errs = append(errs, err)
+	}
+	for _, key := range metadata.getBranches("kms") {
+		if key.getString("role") != "" {
+			errs = append(errs, fmt.Errorf("%s: assuming roles is not supported", key.getString("arn")))
+			continue
+		}
+		context := map[string]string{}
+		if branch, ok := key.get("context").(sopsBranch); ok {
+			for _, item := range branch {
+				if value, ok := item.Value.(string); ok {
+					context[item.Key] = value
+				}
+			}
+		}
+		dataKey, err := awsKMSDecrypt(ctx, key.getString("arn"), key.getString("enc"), context)
+		if err == nil {
+			return dataKey, nil
+		}
+		errs = append(errs, err)
+	}
+
+	if keys := metadata.getBranches("age"); len(keys) > 0 {
+		identities, err := ageIdentitiesFromEnv()
+		if err != nil {
+			errs = append(errs, err)
+			keys = nil
+		}
+		for _, key := range keys {
+			dataKey, err := ageDecrypt(identities, []byte(key.getString("enc")))
+			if err == nil {
+				return dataKey, nil
+			}
+			errs = append(errs, fmt.Errorf("%s: %w", key.getString("recipient"), err))
+		}
+	}
+
+	if len(errs) == 0 {
+		return nil, errors.New("the file has no Cloud KMS, AWS KMS or age key, PGP keys are not supported")
+	}
+	return nil, errors.Join(errs...)
+}
+
+// awsKMSDecrypt decrypts the base64 ciphertext with the AWS KMS key of the ARN
+func awsKMSDecrypt(ctx context.Context, arn string, ciphertext string, encryptionContext map[string]string) ([]byte, error) {
+	// The ARN is arn:aws:kms:<region>:<account>:key/<id>
+	fields := strings.Split(arn, ":")
+	if len(fields) < 6 || fields[2] != "kms" {
+		return nil, fmt.Errorf("invalid AWS KMS key %q", arn)
+	}
+	region := fields[3]
+	credentials, err := newAWSCredentialsChain(region).get(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := json.Marshal(struct {
+		CiphertextBlob    string            `json:"CiphertextBlob"`
+		KeyId             string            `json:"KeyId"`
+		EncryptionContext map[string]string `json:"EncryptionContext,omitempty"`
+	}{CiphertextBlob: ciphertext, KeyId: arn, EncryptionContext: encryptionContext})
+	if err != nil {
+		return nil, err
+	}
+	rq, err := http.NewRequestWithContext(ctx, http.MethodPost, fmt.Sprintf("https://kms.%s.amazonaws.com/", region), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	rq.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	rq.Header.Set("X-Amz-Target", "TrentService.Decrypt")
+	err = signAWSRequest(rq, credentials, region, "kms", clockFromContext(ctx).Now())
+	if err != nil {
+		return nil, err
+	}
+	rs, err := UpstreamClient.Do(rq)
+	if err != nil {
+		return nil, err
+	}
+
+	content, err := ReadBody(rs)
+	if err != nil {
+		return nil, err
+	}
+	if rs.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("decrypting with %s: %w", arn, awsError(rs.StatusCode, content))
+	}
+	decryptResponse := struct {
+		Plaintext string `json:"Plaintext"`
+	}{}
+	err = json.Unmarshal(content, &decryptResponse)
+	if err != nil {
+		return nil, err
+	}
+	return base64.StdEncoding.DecodeString(decryptResponse.Plaintext)
+}
+
+// parseSOPSJSON parses a JSON document keeping the order of its keys
+func parseSOPSJSON(content []byte) (sopsBranch, error) {
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	decoder.UseNumber()
+	value, err := decodeSOPSJSON(decoder)
+	if err != nil {
+		return nil, err
+	}
+	branch, ok := value.(sopsBranch)
+	if !ok {
+		return nil, errors.New("expected an object")
+	}
+	return branch, nil
+}
+
+// decodeSOPSJSON decodes the next value of the decoder, numbers and booleans are kept as their text
+func decodeSOPSJSON(decoder *json.Decoder) (interface{}, error) {
+	token, err := decoder.Token()
+	if err != nil {
+		if err == io.EOF {
+			return nil, io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+
+	switch token := token.(type) {
+	case json.Delim:
+		if token == '[' {
+			list := []interface{}{}
+			for decoder.More() {
+				item, err := decodeSOPSJSON(decoder)
+				if err != nil {
+					return nil, err
+				}
+				list = append(list, item)
+			}
+			_, err = decoder.Token()
+			return list, err
+		}
+
+		branch := sopsBranch{}
+		for decoder.More() {
+			key, err := decoder.Token()
+			if err != nil {
+				return nil, err
+			}
+			value, err := decodeSOPSJSON(decoder)
+			if err != nil {
+				return nil, err
+			}
+			branch = append(branch, sopsItem{Key: key.(string), Value: value})
+		}
+		_, err = decoder.Token()
+		return branch, err
+	case string:
+		return token, nil
+	case json.Number:
+		return token.String(), nil
+	case bool:
+		return strconv.FormatBool(token), nil
+	default:
+		return nil, nil
+	}
+}
+
+// parseSOPSYAML parses a YAML document keeping the order of its keys and its comments, as the SOPS YAML store does
+func parseSOPSYAML(content []byte) (sopsBranch, error) {
+	var document yaml.Node
+	err := yaml.Unmarshal(content, &document)
+	if err != nil {
+		return nil, err
+	}
+	if len(document.Content) == 0 {
+		return nil, errors.New("the document is empty")
+	}
+	root := document.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return nil, errors.New("expected a map")
+	}
+
+	branch := yamlBranch(root)
+	for _, comment := range sopsComments(document.FootComment) {
+		branch = append(branch, sopsItem{Value: comment})
+	}
+	return branch, nil
+}
+
+// yamlValue converts the node, scalars are kept as their text like in JSON documents
+// Comments of lists are items of the list
+func yamlValue(node *yaml.Node) interface{} {
+	switch node.Kind {
+	case yaml.MappingNode:
+		return yamlBranch(node)
+	case yaml.SequenceNode:
+		list := []interface{}{}
+		for _, item := range node.Content {
+			for _, comment := range sopsComments(item.HeadComment, item.LineComment) {
+				list = append(list, comment)
+			}
+			list = append(list, yamlValue(item))
+			for _, comment := range sopsComments(item.FootComment) {
+				list = append(list, comment)
+			}
+		}
+		return list
+	case yaml.AliasNode:
+		return yamlValue(node.Alias)
+	}
+	if node.Tag == "!!null" {
+		return ""
+	}
+	return node.Value
+}
+
+// yamlBranch converts the keys of the map node in order, with the comments around them
+func yamlBranch(node *yaml.Node) sopsBranch {
+	branch := sopsBranch{}
+	comments := func(comments ...string) {
+		for _, comment := range sopsComments(comments...) {
+			branch = append(branch, sopsItem{Value: comment})
+		}
+	}
+
+	comments(node.HeadComment)
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+		comments(key.HeadComment, key.LineComment)
+		// The comments of maps and lists are taken with their items, only those of scalars are on the value
+		scalar := value.Kind == yaml.ScalarNode || value.Kind == yaml.AliasNode
+		if scalar {
+			comments(value.HeadComment, value.LineComment)
+		}
+		branch = append(branch, sopsItem{Key: key.Value, Value: yamlValue(value)})
+		if scalar {
+			comments(value.FootComment)
+		}
+		comments(key.FootComment)
+	}
+	return branch
+}
+
+// sopsComments splits the comments of a node in their lines, without the leading #
+func sopsComments(comments ...string) []sopsComment {
+	var lines []sopsComment
+	for _, comment := range comments {
+		for _, line := range strings.Split(comment, "\n") {
+			if line != "" {
+				lines = append(lines, sopsComment(strings.TrimPrefix(line, "#")))
+			}
+		}
+	}
+	return lines
+}
+
+// newSOPSProviderFromEnv builds the provider of the comma separated files on SOPS_FILES
+func newSOPSProviderFromEnv() (SecretProvider, error) {
+	var paths []string
+	for _, path := range strings.Split(GetEnv("SOPS_FILES", ""), ",") {
+		if path = strings.TrimSpace(path); path != "" {
+			paths = append(paths, path)
+		}
+	}
+	if len(paths) == 0 {
+		return nil, errors.New("SOPS_FILES is required for the sops backend")
+	}
+	return NewSOPSProvider(paths)
+}
@@ -0,0 +1,66 @@
+package secrets
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseSOPSYAML(t *testing.T) {
+	tests := []struct {
+		name        string
+		document    string
+		expected    sopsBranch
+		expectedErr bool
+	}{
+		{
+			name:     "keys in order",
+			document: "zeta: ENC[z]\nalpha: ENC[a]\nport: 8080\nenabled: true\n",
+			expected: sopsBranch{{Key: "zeta", Value: "ENC[z]"}, {Key: "alpha", Value: "ENC[a]"}, {Key: "port", Value: "8080"}, {Key: "enabled", Value: "true"}},
+		},
+		{
+			name:     "comments",
+			document: "#ENC[head]\nkey: ENC[k] #ENC[line]\nnested:\n    #ENC[inner]\n    inner: ENC[i]\n",
+			expected: sopsBranch{
+				{Value: sopsComment("ENC[head]")},
+				{Value: sopsComment("ENC[line]")},
+				{Key: "key", Value: "ENC[k]"},
+				{Key: "nested", Value: sopsBranch{{Value: sopsComment("ENC[inner]")}, {Key: "inner", Value: "ENC[i]"}}},
+			},
+		},
+		{
+			name:     "lists",
+			document: "hosts:\n    - ENC[a]\n    #ENC[comment]\n    - ENC[b]\nempty: []\nkeys:\n    - recipient: age1\n      enc: ENC[e]\n",
+			expected: sopsBranch{
+				{Key: "hosts", Value: []interface{}{"ENC[a]", sopsComment("ENC[comment]"), "ENC[b]"}},
+				{Key: "empty", Value: []interface{}{}},
+				{Key: "keys", Value: []interface{}{sopsBranch{{Key: "recipient", Value: "age1"}, {Key: "enc", Value: "ENC[e]"}}}},
+			},
+		},
+		{
+			name:     "block scalars",
+			document: "kept: |\n    line one\n    line two\nchomped: |-\n    line\nmissing:\n",
+			expected: sopsBranch{{Key: "kept", Value: "line one\nline two\n"}, {Key: "chomped", Value: "line"}, {Key: "missing", Value: ""}},
+		},
+		{name: "empty", document: "", expectedErr: true},
+		{name: "not a map", document: "- item\n", expectedErr: true},
+		{name: "invalid", document: "key: [\n", expectedErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			document, err := parseSOPSYAML([]byte(test.document))
+			if test.expectedErr {
+				if err == nil {
+					t.Errorf("expected an error, got %v", document)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parsing: %s", err)
+			}
+			if !reflect.DeepEqual(document, test.expected) {
+				t.Errorf("expected %#v, got %#v", test.expected, document)
+			}
+		})
+	}
+}
@@ -0,0 +1,138 @@
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// SourceAWSParameterStore is the source of values coming from AWS Systems Manager Parameter Store
+const SourceAWSParameterStore Source = "aws-parameter-store"
+
+func init() {
+	RegisterProvider("aws-ssm", newSSMProviderFromEnv)
+}
+
+// SSMProvider gets parameters from AWS Systems Manager Parameter Store, authenticating with the credentials chain of
+// the workload
+// SecureString parameters are decrypted by Parameter Store with their KMS key, so the role needs kms:Decrypt on it
+type SSMProvider struct {
+	Region string
+	// Endpoint replaces the regional endpoint, for VPC endpoints or local emulators
+	Endpoint string
+	// Path is the hierarchy the names are looked up under, like /myapp/prod/, as names cannot have slashes
+	Path string
+	// Retry applies to every parameter fetch
+	Retry       RetryPolicy
+	credentials *awsCredentialsChain
+}
+
+// Source tells the values come from Parameter Store
+func (p SSMProvider) Source() Source {
+	return SourceAWSParameterStore
+}
+
+// GetSecret gets the latest version of the parameter
+func (p SSMProvider) GetSecret(ctx context.Context, name string) (string, error) {
+	return p.GetSecretVersion(ctx, name, "")
+}
+
+// GetSecretVersion gets a version of the parameter, retried according to Retry
+// Versions are either version numbers or labels, which Parameter Store takes after the name as name:version
+func (p SSMProvider) GetSecretVersion(ctx context.Context, name string, version string) (string, error) {
+	name = p.Path + name
+	if version != "" {
+		name += ":" + version
+	}
+
+	var value string
+	err := p.Retry.do(ctx, func(ctx context.Context) error {
+		var err error
+		value, err = p.fetchParameter(ctx, name)
+		return err
+	})
+	return value, err
+}
+
+// fetchParameter calls GetParameter with decryption, StringList parameters are returned comma separated as they are
+func (p SSMProvider) fetchParameter(ctx context.Context, name string) (string, error) {
+	credentials, err := p.credentials.get(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	body, err := json.Marshal(struct {
+		Name           string `json:"Name"`
+		WithDecryption bool   `json:"WithDecryption"`
+	}{Name: name, WithDecryption: true})
+	if err != nil {
+		return "", err
+	}
+
+	rq, err := http.NewRequestWithContext(ctx, http.MethodPost, p.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+
+	rq.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	rq.Header.Set("X-Amz-Target", "AmazonSSM.GetParameter")
+	err = signAWSRequest(rq, credentials, p.Region, "ssm", clockFromContext(ctx).Now())
+	if err != nil {
+		return "", err
+	}
+	rs, err := UpstreamClient.Do(rq)
+	if err != nil {
+		return "", err
+	}
+
+	bytes, err := ReadBody(rs)
+	if err != nil {
+		return "", err
+	}
+
+	if rs.StatusCode != http.StatusOK {
+		return "", awsError(rs.StatusCode, bytes)
+	}
+
+	parameterResponse := struct {
+		Parameter struct {
+			Value string `json:"Value"`
+		} `json:"Parameter"`
+	}{}
+	err = json.Unmarshal(bytes, &parameterResponse)
+	if err != nil {
+		return "", withStatus(rs.StatusCode, err)
+	}
+	return parameterResponse.Parameter.Value, nil
+}
+
+// newSSMProviderFromEnv builds the Parameter Store provider from the standard AWS environment values
+func newSSMProviderFromEnv() (SecretProvider, error) {
+	region := GetEnv("AWS_REGION", GetEnv("AWS_DEFAULT_REGION", ""))
+	if region == "" {
+		return nil, errors.New("AWS_REGION is required for the aws-ssm backend")
+	}
+
+	// The path always ends with a slash, so it can be given as /myapp/prod
+	path := GetEnv("AWS_SSM_PATH", "")
+	if path != "" && !strings.HasSuffix(path, "/") {
+		path += "/"
+	}
+
+	retry, err := getRetryPolicy("AWS_SSM", RetryPolicy{Attempts: 1})
+	if err != nil {
+		return nil, err
+	}
+
+	return SSMProvider{
+		Region:      region,
+		Endpoint:    GetEnv("AWS_ENDPOINT_URL_SSM", fmt.Sprintf("https://ssm.%s.amazonaws.com/", region)),
+		Path:        path,
+		Retry:       retry,
+		credentials: newAWSCredentialsChain(region),
+	}, nil
+}
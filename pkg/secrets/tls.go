@@ -0,0 +1,48 @@
+package secrets
+
+import (
+	"crypto/tls"
+	"fmt"
+	"strings"
+)
+
+// tlsVersions are the minimum TLS versions that can be configured
+var tlsVersions = map[string]uint16{
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// NewTLSConfig returns the TLS configuration for the server
+// The minimum version is 1.2 or 1.3, and the cipher suites are a comma separated list of names
+// An empty list keeps the Go defaults, cipher suites do not apply to TLS 1.3
+func NewTLSConfig(minVersion string, cipherSuites string) (*tls.Config, error) {
+	if minVersion == "" {
+		minVersion = "1.2"
+	}
+
+	version, ok := tlsVersions[minVersion]
+	if !ok {
+		return nil, fmt.Errorf("unknown minimum TLS version %q, expected 1.2 or 1.3", minVersion)
+	}
+
+	config := &tls.Config{MinVersion: version}
+	if cipherSuites == "" {
+		return config, nil
+	}
+
+	// Only the suites Go considers secure can be configured
+	available := map[string]uint16{}
+	for _, suite := range tls.CipherSuites() {
+		available[suite.Name] = suite.ID
+	}
+
+	for _, name := range strings.Split(cipherSuites, ",") {
+		name = strings.TrimSpace(name)
+		id, ok := available[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown or insecure cipher suite %q", name)
+		}
+		config.CipherSuites = append(config.CipherSuites, id)
+	}
+	return config, nil
+}
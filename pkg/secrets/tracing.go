@@ -0,0 +1,316 @@
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Kinds of spans, as numbered by OTLP
+const (
+	spanKindInternal = 1
+	spanKindServer   = 2
+	spanKindClient   = 3
+)
+
+const (
+	// traceparentHeader carries the trace context between services, as defined by W3C Trace Context
+	traceparentHeader = "Traceparent"
+	// tracerBatchSize is how many spans are exported at most on a single request
+	tracerBatchSize = 512
+	// tracerFlushInterval is how often the spans are exported when there are less than a batch
+	tracerFlushInterval = 5 * time.Second
+)
+
+// Tracer exports the spans of traced requests to an OpenTelemetry collector over OTLP/HTTP on the background
+// Spans are dropped rather than blocking the request path when the buffer is full, a nil Tracer traces nothing
+type Tracer struct {
+	url     string
+	service string
+	client  *http.Client
+	clock   Clock
+	spans   chan *Span
+	dropped uint64
+}
+
+// NewTracer returns a tracer exporting to the OTLP traces URL, buffering up to size spans, timed with the clock
+func NewTracer(url string, service string, size int, clock Clock) *Tracer {
+	if clock == nil {
+		clock = RealClock{}
+	}
+	t := &Tracer{
+		url:     url,
+		service: service,
+		client:  &http.Client{Timeout: 10 * time.Second, Transport: http.DefaultTransport},
+		clock:   clock,
+		spans:   make(chan *Span, size),
+	}
+	go t.run()
+	return t
+}
+
+// Span is an operation of a trace, it never includes secret values
+type Span struct {
+	tracer     *Tracer
+	traceID    [16]byte
+	spanID     [8]byte
+	parentID   [8]byte
+	sampled    bool
+	name       string
+	kind       int
+	start      time.Time
+	end        time.Time
+	attributes map[string]string
+	err        error
+}
+
+// spanContextKey is the context key of the current span
+type spanContextKey struct{}
+
+// StartSpan starts a child of the span on the context, the context of the child is returned
+// Without a span on the context nothing is traced and the span is nil, which is safe to use
+func StartSpan(ctx context.Context, name string) (context.Context, *Span) {
+	return startSpan(ctx, name, spanKindInternal)
+}
+
+func startSpan(ctx context.Context, name string, kind int) (context.Context, *Span) {
+	parent, _ := ctx.Value(spanContextKey{}).(*Span)
+	if parent == nil {
+		return ctx, nil
+	}
+
+	span := &Span{
+		tracer:   parent.tracer,
+		traceID:  parent.traceID,
+		parentID: parent.spanID,
+		sampled:  parent.sampled,
+		name:     name,
+		kind:     kind,
+		start:    parent.tracer.clock.Now(),
+	}
+	_, _ = rand.Read(span.spanID[:])
+	return context.WithValue(ctx, spanContextKey{}, span), span
+}
+
+// SetAttribute adds an attribute to the span
+func (s *Span) SetAttribute(key string, value string) {
+	if s == nil {
+		return
+	}
+	if s.attributes == nil {
+		s.attributes = map[string]string{}
+	}
+	s.attributes[key] = value
+}
+
+// End ends the span, marking it as failed when there is an error, and queues it for export when sampled
+func (s *Span) End(err error) {
+	if s == nil || !s.sampled {
+		return
+	}
+	s.err = err
+	s.end = s.tracer.clock.Now()
+
+	select {
+	case s.tracer.spans <- s:
+	default:
+		atomic.AddUint64(&s.tracer.dropped, 1)
+	}
+}
+
+// traceparent formats the trace context of the span for outgoing requests
+func (s *Span) traceparent() string {
+	flags := "00"
+	if s.sampled {
+		flags = "01"
+	}
+	return fmt.Sprintf("00-%s-%s-%s", hex.EncodeToString(s.traceID[:]), hex.EncodeToString(s.spanID[:]), flags)
+}
+
+// StartRequestSpan starts the server span of a request, continuing the trace of the caller when it sent one
+// New traces are always sampled, while continued traces keep the decision of the caller
+func (t *Tracer) StartRequestSpan(rq *http.Request, name string) (context.Context, *Span) {
+	span := &Span{tracer: t, sampled: true, name: name, kind: spanKindServer, start: t.clock.Now()}
+	if !parseTraceparent(rq.Header.Get(traceparentHeader), span) {
+		_, _ = rand.Read(span.traceID[:])
+	}
+	_, _ = rand.Read(span.spanID[:])
+	return context.WithValue(rq.Context(), spanContextKey{}, span), span
+}
+
+// parseTraceparent reads the trace ID, parent span ID and sampled flag of the header into the span
+// Headers of unknown versions are read the same, as the specification asks
+func parseTraceparent(header string, span *Span) bool {
+	parts := strings.Split(strings.TrimSpace(header), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return false
+	}
+
+	traceID, errTrace := hex.DecodeString(parts[1])
+	parentID, errParent := hex.DecodeString(parts[2])
+	flags, errFlags := hex.DecodeString(parts[3])
+	if errTrace != nil || errParent != nil || errFlags != nil {
+		return false
+	}
+	copy(span.traceID[:], traceID)
+	copy(span.parentID[:], parentID)
+	if span.traceID == [16]byte{} || span.parentID == [8]byte{} {
+		return false
+	}
+	span.sampled = flags[0]&1 == 1
+	return true
+}
+
+// TracingTransport sends the trace context on outgoing requests made within a span, each on its own client span
+type TracingTransport struct {
+	Base http.RoundTripper
+}
+
+func (t TracingTransport) RoundTrip(rq *http.Request) (*http.Response, error) {
+	ctx, span := startSpan(rq.Context(), fmt.Sprintf("%s %s", rq.Method, rq.URL.Host), spanKindClient)
+	if span == nil {
+		return t.Base.RoundTrip(rq)
+	}
+
+	// The query is left out, as some providers take credentials on it
+	span.SetAttribute("http.request.method", rq.Method)
+	span.SetAttribute("url.full", rq.URL.Scheme+"://"+rq.URL.Host+rq.URL.Path)
+
+	// Round trippers must not change the request, so the header goes on a copy
+	rq = rq.Clone(ctx)
+	rq.Header.Set(traceparentHeader, span.traceparent())
+	rs, err := t.Base.RoundTrip(rq)
+	if err == nil {
+		span.SetAttribute("http.response.status_code", strconv.Itoa(rs.StatusCode))
+	}
+	span.End(err)
+	return rs, err
+}
+
+// Dropped returns how many spans were dropped because the buffer was full
+func (t *Tracer) Dropped() uint64 {
+	if t == nil {
+		return 0
+	}
+	return atomic.LoadUint64(&t.dropped)
+}
+
+// run exports the queued spans in batches, a batch is sent when full or on every flush interval
+func (t *Tracer) run() {
+	ticker := time.NewTicker(tracerFlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]*Span, 0, tracerBatchSize)
+	for {
+		select {
+		case span := <-t.spans:
+			batch = append(batch, span)
+			if len(batch) < tracerBatchSize {
+				continue
+			}
+		case <-ticker.C:
+			if len(batch) == 0 {
+				continue
+			}
+		}
+
+		err := t.export(batch)
+		if err != nil {
+			slog.Error("exporting spans", "spans", len(batch), "error", err)
+		}
+		batch = batch[:0]
+	}
+}
+
+// export posts the spans as an OTLP/HTTP JSON request
+func (t *Tracer) export(spans []*Span) error {
+	type attribute struct {
+		Key   string `json:"key"`
+		Value struct {
+			StringValue string `json:"stringValue"`
+		} `json:"value"`
+	}
+	attributes := func(values map[string]string) []attribute {
+		list := make([]attribute, 0, len(values))
+		for _, key := range sortedKeys(values) {
+			a := attribute{Key: key}
+			a.Value.StringValue = values[key]
+			list = append(list, a)
+		}
+		return list
+	}
+
+	type otlpStatus struct {
+		Code    int    `json:"code"`
+		Message string `json:"message,omitempty"`
+	}
+	type otlpSpan struct {
+		TraceID           string      `json:"traceId"`
+		SpanID            string      `json:"spanId"`
+		ParentSpanID      string      `json:"parentSpanId,omitempty"`
+		Name              string      `json:"name"`
+		Kind              int         `json:"kind"`
+		StartTimeUnixNano string      `json:"startTimeUnixNano"`
+		EndTimeUnixNano   string      `json:"endTimeUnixNano"`
+		Attributes        []attribute `json:"attributes,omitempty"`
+		Status            otlpStatus  `json:"status"`
+	}
+
+	exported := make([]otlpSpan, 0, len(spans))
+	for _, span := range spans {
+		s := otlpSpan{
+			TraceID:           hex.EncodeToString(span.traceID[:]),
+			SpanID:            hex.EncodeToString(span.spanID[:]),
+			Name:              span.name,
+			Kind:              span.kind,
+			StartTimeUnixNano: strconv.FormatInt(span.start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(span.end.UnixNano(), 10),
+			Attributes:        attributes(span.attributes),
+		}
+		if span.parentID != [8]byte{} {
+			s.ParentSpanID = hex.EncodeToString(span.parentID[:])
+		}
+		// Status codes are 1 for ok and 2 for error
+		s.Status.Code = 1
+		if span.err != nil {
+			s.Status = otlpStatus{Code: 2, Message: span.err.Error()}
+		}
+		exported = append(exported, s)
+	}
+
+	body, err := json.Marshal(map[string]interface{}{
+		"resourceSpans": []interface{}{map[string]interface{}{
+			"resource": map[string]interface{}{"attributes": attributes(map[string]string{"service.name": t.service})},
+			"scopeSpans": []interface{}{map[string]interface{}{
+				"scope": map[string]string{"name": "secret-manager-demo"},
+				"spans": exported,
+			}},
+		}},
+	})
+	if err != nil {
+		return err
+	}
+
+	rs, err := t.client.Post(t.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer rs.Body.Close()
+	_, _ = io.Copy(ioutil.Discard, rs.Body)
+
+	if rs.StatusCode >= 300 {
+		return fmt.Errorf("collector answered %d", rs.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,140 @@
+package secrets
+
+import (
+	"sync"
+	"time"
+)
+
+// TTLCache keeps values in memory until their time to live expires
+// Expired values are dropped once per time to live when storing, so keys that are not asked for again do not pile up
+// A nil TTLCache is valid and caches nothing
+type TTLCache struct {
+	ttl     time.Duration
+	clock   Clock
+	mu      sync.Mutex
+	entries map[string]ttlEntry
+	// sweptAt is when the expired values were last dropped
+	sweptAt time.Time
+}
+
+// ttlEntry is a cached value together with the time it expires at
+type ttlEntry struct {
+	value      interface{}
+	expiresAt  time.Time
+	accessedAt time.Time
+}
+
+// NewTTLCache returns a cache that keeps values for the given time, a zero ttl disables the cache
+func NewTTLCache(ttl time.Duration, clock Clock) *TTLCache {
+	if ttl <= 0 {
+		return nil
+	}
+	if clock == nil {
+		clock = RealClock{}
+	}
+	return &TTLCache{ttl: ttl, clock: clock, entries: map[string]ttlEntry{}, sweptAt: clock.Now()}
+}
+
+// SetTTL changes the time to live of the values stored from now on, the ones already stored keep their expiry
+func (c *TTLCache) SetTTL(ttl time.Duration) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ttl = ttl
+}
+
+// Get returns the value for the key, if present and not expired
+func (c *TTLCache) Get(key string) (interface{}, bool) {
+	if c == nil {
+		return nil, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+
+	now := c.clock.Now()
+	if !now.Before(entry.expiresAt) {
+		delete(c.entries, key)
+		return nil, false
+	}
+
+	entry.accessedAt = now
+	c.entries[key] = entry
+	return entry.value, true
+}
+
+// Set stores the value for the key
+func (c *TTLCache) Set(key string, value interface{}) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.clock.Now()
+	if now.Sub(c.sweptAt) >= c.ttl {
+		for key, entry := range c.entries {
+			if !now.Before(entry.expiresAt) {
+				delete(c.entries, key)
+			}
+		}
+		c.sweptAt = now
+	}
+	c.entries[key] = ttlEntry{value: value, expiresAt: now.Add(c.ttl), accessedAt: now}
+}
+
+// Delete removes the value for the key
+func (c *TTLCache) Delete(key string) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.entries, key)
+}
+
+// Keys returns the keys with a value that has not expired
+func (c *TTLCache) Keys() []string {
+	if c == nil {
+		return nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.clock.Now()
+	keys := make([]string, 0, len(c.entries))
+	for key, entry := range c.entries {
+		if now.Before(entry.expiresAt) {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// EvictIdle removes the values that were not accessed within the idle window, and the expired ones
+// It returns how many values were removed
+func (c *TTLCache) EvictIdle(idle time.Duration) int {
+	if c == nil {
+		return 0
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.clock.Now()
+	evicted := 0
+	for key, entry := range c.entries {
+		if now.Sub(entry.accessedAt) >= idle || !now.Before(entry.expiresAt) {
+			delete(c.entries, key)
+			evicted++
+		}
+	}
+	return evicted
+}
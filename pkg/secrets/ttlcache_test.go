@@ -0,0 +1,94 @@
+package secrets
+
+import (
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTTLCacheExpiresValues(t *testing.T) {
+	clock := NewFakeClock(time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC))
+	cache := NewMemoryCache(5*time.Minute, clock)
+	cache.Set("db-password", "secret")
+
+	tests := []struct {
+		name          string
+		advance       time.Duration
+		expectedValue string
+		expectedOk    bool
+	}{
+		{name: "fresh", expectedValue: "secret", expectedOk: true},
+		{name: "before the ttl", advance: 5*time.Minute - time.Second, expectedValue: "secret", expectedOk: true},
+		{name: "at the ttl", advance: time.Second},
+		{name: "after the ttl", advance: time.Minute},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			clock.Advance(test.advance)
+			value, ok := cache.Get("db-password")
+			if value != test.expectedValue || ok != test.expectedOk {
+				t.Errorf("expected %q %t, got %q %t", test.expectedValue, test.expectedOk, value, ok)
+			}
+		})
+	}
+}
+
+func TestTTLCacheSetTTL(t *testing.T) {
+	clock := NewFakeClock(time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC))
+	cache := NewMemoryCache(time.Hour, clock)
+	cache.Set("before", "value")
+	cache.(TTLSetter).SetTTL(time.Minute)
+	cache.Set("after", "value")
+
+	// Values already stored keep the expiry they were stored with
+	clock.Advance(2 * time.Minute)
+	if _, ok := cache.Get("before"); !ok {
+		t.Error("expected the value stored before the change to be cached")
+	}
+	if _, ok := cache.Get("after"); ok {
+		t.Error("expected the value stored after the change to expire")
+	}
+}
+
+func TestTTLCacheDropsExpiredValues(t *testing.T) {
+	clock := NewFakeClock(time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC))
+	cache := NewTTLCache(time.Minute, clock)
+	cache.Set("first", "value")
+	cache.Set("second", "value")
+
+	// Storing within the ttl of the last sweep leaves the values, expired or not, as they are
+	clock.Advance(30 * time.Second)
+	cache.Set("third", "value")
+	clock.Advance(40 * time.Second)
+	if len(cache.entries) != 3 {
+		t.Fatalf("expected 3 values, got %d", len(cache.entries))
+	}
+
+	// A ttl after the last sweep, storing drops the expired ones
+	cache.Set("fourth", "value")
+	var keys []string
+	for key := range cache.entries {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	if strings.Join(keys, ",") != "fourth,third" {
+		t.Errorf("expected fourth,third, got %s", strings.Join(keys, ","))
+	}
+}
+
+func TestTTLCacheDisabled(t *testing.T) {
+	cache := NewTTLCache(0, nil)
+	if cache != nil {
+		t.Fatal("expected a zero ttl to disable the cache")
+	}
+
+	cache.Set("db-password", "secret")
+	if _, ok := cache.Get("db-password"); ok {
+		t.Error("expected a disabled cache to cache nothing")
+	}
+	if keys := cache.Keys(); len(keys) != 0 {
+		t.Errorf("expected no keys, got %v", keys)
+	}
+}
@@ -0,0 +1,126 @@
+package secrets
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"time"
+)
+
+// UpstreamClient is the client every call to the providers, their token endpoints and the other services goes through
+// It is kept apart from http.DefaultClient, so its timeouts and pool are not shared with anything else in the process
+// The defaults are always valid, so the error can be left out
+var UpstreamClient, _ = newUpstreamClient(defaultUpstreamConfig)
+
+// upstreamConfig tells how the connections to the upstreams are made and bounded
+type upstreamConfig struct {
+	// ConnectTimeout bounds dialing and the TLS handshake
+	ConnectTimeout time.Duration
+	// ReadTimeout bounds the wait for the response headers once the request is sent
+	ReadTimeout time.Duration
+	// Timeout bounds the whole call, including reading the body
+	Timeout time.Duration
+	// MaxIdleConnsPerHost is how many connections are kept open to every host to be reused
+	MaxIdleConnsPerHost int
+	// MaxConnsPerHost bounds the connections to every host, zero means no bound
+	MaxConnsPerHost int
+	// MinTLSVersion is 1.2 or 1.3
+	MinTLSVersion string
+	// CipherSuites is a comma separated list of names, empty keeps the Go defaults
+	CipherSuites string
+	// CAFile is a PEM bundle trusted on top of the system roots, for upstreams behind a private CA
+	CAFile string
+}
+
+// defaultUpstreamConfig bounds every call, as the default client waits forever on a stalled upstream
+var defaultUpstreamConfig = upstreamConfig{
+	ConnectTimeout:      5 * time.Second,
+	ReadTimeout:         30 * time.Second,
+	Timeout:             time.Minute,
+	MaxIdleConnsPerHost: 10,
+}
+
+// newUpstreamClient returns a client with its own transport for the configuration
+func newUpstreamClient(config upstreamConfig) (*http.Client, error) {
+	tlsConfig, err := NewTLSConfig(config.MinTLSVersion, config.CipherSuites)
+	if err != nil {
+		return nil, err
+	}
+	if config.CAFile != "" {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		content, err := ioutil.ReadFile(config.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		if !pool.AppendCertsFromPEM(content) {
+			return nil, errors.New("no certificates found")
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	dialer := &net.Dialer{Timeout: config.ConnectTimeout, KeepAlive: 30 * time.Second}
+	return &http.Client{
+		Timeout: config.Timeout,
+		Transport: &http.Transport{
+			Proxy:                 http.ProxyFromEnvironment,
+			DialContext:           dialer.DialContext,
+			TLSClientConfig:       tlsConfig,
+			TLSHandshakeTimeout:   config.ConnectTimeout,
+			ResponseHeaderTimeout: config.ReadTimeout,
+			MaxIdleConns:          100,
+			MaxIdleConnsPerHost:   config.MaxIdleConnsPerHost,
+			MaxConnsPerHost:       config.MaxConnsPerHost,
+			IdleConnTimeout:       90 * time.Second,
+			ForceAttemptHTTP2:     true,
+		},
+	}, nil
+}
+
+// NewUpstreamClientFromEnv builds the upstream client from the UPSTREAM_ environment values
+func NewUpstreamClientFromEnv() (*http.Client, error) {
+	config := defaultUpstreamConfig
+	var err error
+	config.ConnectTimeout, err = GetEnvDuration("UPSTREAM_CONNECT_TIMEOUT", config.ConnectTimeout)
+	if err != nil {
+		return nil, err
+	}
+	config.ReadTimeout, err = GetEnvDuration("UPSTREAM_READ_TIMEOUT", config.ReadTimeout)
+	if err != nil {
+		return nil, err
+	}
+	config.Timeout, err = GetEnvDuration("UPSTREAM_TIMEOUT", config.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	config.MaxIdleConnsPerHost, err = GetEnvInt("UPSTREAM_MAX_IDLE_CONNS_PER_HOST", config.MaxIdleConnsPerHost)
+	if err != nil {
+		return nil, err
+	}
+	config.MaxConnsPerHost, err = GetEnvInt("UPSTREAM_MAX_CONNS_PER_HOST", config.MaxConnsPerHost)
+	if err != nil {
+		return nil, err
+	}
+	config.MinTLSVersion = GetEnv("UPSTREAM_TLS_MIN_VERSION", "")
+	config.CipherSuites = GetEnv("UPSTREAM_TLS_CIPHER_SUITES", "")
+	config.CAFile = GetEnv("UPSTREAM_CA_FILE", "")
+
+	client, err := newUpstreamClient(config)
+	if err != nil {
+		return nil, fmt.Errorf("upstream client: %w", err)
+	}
+	return client, nil
+}
+
+// upstreamTransport sends requests through UpstreamClient, for the libraries that take their own client
+// UpstreamClient is looked up on every request, as it is replaced once the configuration is read
+type upstreamTransport struct{}
+
+func (upstreamTransport) RoundTrip(rq *http.Request) (*http.Response, error) {
+	return UpstreamClient.Do(rq)
+}
@@ -0,0 +1,36 @@
+package secrets
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// roundTripFunc lets a function answer the requests of a client
+type roundTripFunc func(rq *http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(rq *http.Request) (*http.Response, error) {
+	return f(rq)
+}
+
+// stubUpstream makes every call going through UpstreamClient be answered by the handler for the rest of the test
+func stubUpstream(t *testing.T, handler http.HandlerFunc) {
+	t.Helper()
+	stubUpstreamTransport(t, func(rq *http.Request) (*http.Response, error) {
+		recorder := httptest.NewRecorder()
+		handler(recorder, rq)
+		rs := recorder.Result()
+		rs.Request = rq
+		return rs, nil
+	})
+}
+
+// stubUpstreamTransport makes every call going through UpstreamClient be answered by the function for the rest of the test
+func stubUpstreamTransport(t *testing.T, transport roundTripFunc) {
+	t.Helper()
+	previous := UpstreamClient
+	UpstreamClient = &http.Client{Transport: transport}
+	t.Cleanup(func() {
+		UpstreamClient = previous
+	})
+}
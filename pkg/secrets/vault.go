@@ -0,0 +1,321 @@
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SourceVault is the source of values coming from HashiCorp Vault
+const SourceVault Source = "vault"
+
+func init() {
+	RegisterProvider("vault", newVaultProviderFromEnv)
+}
+
+// VaultProvider gets secrets from a KV v2 engine of HashiCorp Vault
+// Every secret is a path on the engine, and its value is one field of the data stored there
+type VaultProvider struct {
+	// Address is the base URL of Vault, like https://vault.example.com:8200
+	Address string
+	// Namespace is sent on every request for Vault Enterprise, it is optional
+	Namespace string
+	// Mount is the path the KV v2 engine is mounted on
+	Mount string
+	// Field is the field of the data that holds the value
+	Field string
+	// Retry applies to every secret fetch
+	Retry RetryPolicy
+	auth  *vaultAuth
+}
+
+// Source tells the values come from Vault
+func (p VaultProvider) Source() Source {
+	return SourceVault
+}
+
+// GetSecret gets the latest version of the secret
+func (p VaultProvider) GetSecret(ctx context.Context, name string) (string, error) {
+	return p.GetSecretVersion(ctx, name, "")
+}
+
+// GetSecretVersion gets a version of the secret, retried according to Retry
+func (p VaultProvider) GetSecretVersion(ctx context.Context, name string, version string) (string, error) {
+	var value string
+	err := p.Retry.do(ctx, func(ctx context.Context) error {
+		var err error
+		value, err = p.fetchSecret(ctx, name, version)
+		// A token that was revoked or expired early is denied, logging in again tells it apart from a real denial
+		if errors.Is(err, ErrPermissionDenied) && p.auth.invalidate() {
+			value, err = p.fetchSecret(ctx, name, version)
+		}
+		return err
+	})
+	return value, err
+}
+
+// fetchSecret reads the secret from the KV v2 engine, the latest version when version is empty
+func (p VaultProvider) fetchSecret(ctx context.Context, name string, version string) (string, error) {
+	token, err := p.auth.get(ctx, p)
+	if err != nil {
+		return "", err
+	}
+
+	secretPath := fmt.Sprintf("/v1/%s/data/%s", p.Mount, name)
+	if version != "" {
+		secretPath += "?version=" + url.QueryEscape(version)
+	}
+	rs, err := p.do(ctx, http.MethodGet, secretPath, token, nil)
+	if err != nil {
+		return "", err
+	}
+
+	bytes, err := ReadBody(rs)
+	if err != nil {
+		return "", err
+	}
+	if rs.StatusCode != http.StatusOK {
+		return "", vaultError(rs.StatusCode, bytes)
+	}
+
+	secretResponse := struct {
+		Data struct {
+			Data map[string]interface{} `json:"data"`
+		} `json:"data"`
+	}{}
+	err = json.Unmarshal(bytes, &secretResponse)
+	if err != nil {
+		return "", err
+	}
+
+	// A deleted latest version is answered with null data
+	value, ok := secretResponse.Data.Data[p.Field]
+	if !ok {
+		return "", fmt.Errorf("%w: field %s not found on %s", ErrSecretNotFound, p.Field, name)
+	}
+	if text, ok := value.(string); ok {
+		return text, nil
+	}
+	// Values that are not strings are served as their JSON
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+	return string(encoded), nil
+}
+
+// do sends a request to Vault, with the token and namespace when there are
+func (p VaultProvider) do(ctx context.Context, method string, path string, token string, body interface{}) (*http.Response, error) {
+	var content []byte
+	if body != nil {
+		var err error
+		content, err = json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	rq, err := http.NewRequestWithContext(ctx, method, p.Address+path, bytes.NewReader(content))
+	if err != nil {
+		return nil, err
+	}
+
+	if token != "" {
+		rq.Header.Set("X-Vault-Token", token)
+	}
+	if p.Namespace != "" {
+		rq.Header.Set("X-Vault-Namespace", p.Namespace)
+	}
+	return UpstreamClient.Do(rq)
+}
+
+// vaultError maps the error body of Vault, not found and permission denied can be told apart with errors.Is
+func vaultError(statusCode int, body []byte) error {
+	errorResponse := struct {
+		Errors []string `json:"errors"`
+	}{}
+	// The body is only used for the message, the status code tells the error apart
+	_ = json.Unmarshal(body, &errorResponse)
+	message := strings.Join(errorResponse.Errors, "; ")
+
+	switch statusCode {
+	case http.StatusNotFound:
+		return fmt.Errorf("%w: error %d - %s", ErrSecretNotFound, statusCode, message)
+	case http.StatusForbidden:
+		return fmt.Errorf("%w: error %d - %s", ErrPermissionDenied, statusCode, message)
+	default:
+		return withStatus(statusCode, fmt.Errorf("error %d - %s", statusCode, message))
+	}
+}
+
+// Vault auth methods
+const (
+	vaultAuthToken      = "token"
+	vaultAuthAppRole    = "approle"
+	vaultAuthKubernetes = "kubernetes"
+)
+
+// vaultTokenRefreshWindow is how long before the lease ends the token is obtained again
+const vaultTokenRefreshWindow = time.Minute
+
+// vaultAuth gets the token requests are sent with, logging in again when the lease is about to end
+type vaultAuth struct {
+	method string
+	// mount is the path the auth method is mounted on
+	mount string
+	// login is the body sent to the login endpoint, it is built on every login so rotated files are read again
+	login func() (map[string]string, error)
+
+	mu        sync.Mutex
+	token     string
+	expiresAt time.Time
+}
+
+// get returns the current token, logging in when there is none or its lease is about to end
+func (a *vaultAuth) get(ctx context.Context, p VaultProvider) (string, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.token != "" && (a.expiresAt.IsZero() || clockFromContext(ctx).Now().Before(a.expiresAt.Add(-vaultTokenRefreshWindow))) {
+		return a.token, nil
+	}
+
+	body, err := a.login()
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrTokenUnavailable, err)
+	}
+	ctx, span := StartSpan(ctx, "token fetch")
+	span.SetAttribute("vault.auth.method", a.method)
+	rs, err := p.do(ctx, http.MethodPost, fmt.Sprintf("/v1/auth/%s/login", a.mount), "", body)
+	span.End(err)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrTokenUnavailable, err)
+	}
+	bytes, err := ReadBody(rs)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrTokenUnavailable, err)
+	}
+	if rs.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%w: logging in with %s: %v", ErrTokenUnavailable, a.method, vaultError(rs.StatusCode, bytes))
+	}
+
+	loginResponse := struct {
+		Auth struct {
+			ClientToken   string `json:"client_token"`
+			LeaseDuration int    `json:"lease_duration"`
+		} `json:"auth"`
+	}{}
+	err = json.Unmarshal(bytes, &loginResponse)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrTokenUnavailable, err)
+	}
+	if loginResponse.Auth.ClientToken == "" {
+		return "", fmt.Errorf("%w: logging in with %s answered without a token", ErrTokenUnavailable, a.method)
+	}
+
+	a.token = loginResponse.Auth.ClientToken
+	a.expiresAt = time.Time{}
+	if loginResponse.Auth.LeaseDuration > 0 {
+		a.expiresAt = clockFromContext(ctx).Now().Add(time.Duration(loginResponse.Auth.LeaseDuration) * time.Second)
+	}
+	return a.token, nil
+}
+
+// invalidate drops the token so the next request logs in again, it tells if there is a login to do
+// Static tokens cannot be obtained again, so they are kept
+func (a *vaultAuth) invalidate() bool {
+	if a.method == vaultAuthToken {
+		return false
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.token = ""
+	return true
+}
+
+// readSecretFile reads a credential from a file, trimming the trailing newline editors and mounts add
+func readSecretFile(path string) (string, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(content)), nil
+}
+
+// newVaultProviderFromEnv builds the Vault provider, with the auth method on VAULT_AUTH_METHOD
+func newVaultProviderFromEnv() (SecretProvider, error) {
+	address := strings.TrimSuffix(GetEnv("VAULT_ADDR", ""), "/")
+	if address == "" {
+		return nil, errors.New("VAULT_ADDR is required for the vault backend")
+	}
+
+	retry, err := getRetryPolicy("VAULT", RetryPolicy{Attempts: 1})
+	if err != nil {
+		return nil, err
+	}
+
+	auth := &vaultAuth{method: GetEnv("VAULT_AUTH_METHOD", vaultAuthToken)}
+	auth.mount = GetEnv("VAULT_AUTH_MOUNT", auth.method)
+	switch auth.method {
+	case vaultAuthToken:
+		auth.token = GetEnv("VAULT_TOKEN", "")
+		if tokenFile := GetEnv("VAULT_TOKEN_FILE", ""); tokenFile != "" {
+			auth.token, err = readSecretFile(tokenFile)
+			if err != nil {
+				return nil, fmt.Errorf("VAULT_TOKEN_FILE: %w", err)
+			}
+		}
+		if auth.token == "" {
+			return nil, errors.New("VAULT_TOKEN or VAULT_TOKEN_FILE is required for the token auth method")
+		}
+	case vaultAuthAppRole:
+		roleID := GetEnv("VAULT_ROLE_ID", "")
+		if roleID == "" {
+			return nil, errors.New("VAULT_ROLE_ID is required for the approle auth method")
+		}
+		auth.login = func() (map[string]string, error) {
+			secretID := GetEnv("VAULT_SECRET_ID", "")
+			if secretIDFile := GetEnv("VAULT_SECRET_ID_FILE", ""); secretIDFile != "" {
+				var err error
+				secretID, err = readSecretFile(secretIDFile)
+				if err != nil {
+					return nil, err
+				}
+			}
+			return map[string]string{"role_id": roleID, "secret_id": secretID}, nil
+		}
+	case vaultAuthKubernetes:
+		role := GetEnv("VAULT_ROLE", "")
+		if role == "" {
+			return nil, errors.New("VAULT_ROLE is required for the kubernetes auth method")
+		}
+		tokenFile := GetEnv("VAULT_KUBERNETES_TOKEN_FILE", "/var/run/secrets/kubernetes.io/serviceaccount/token")
+		auth.login = func() (map[string]string, error) {
+			jwt, err := readSecretFile(tokenFile)
+			if err != nil {
+				return nil, err
+			}
+			return map[string]string{"role": role, "jwt": jwt}, nil
+		}
+	default:
+		return nil, fmt.Errorf("VAULT_AUTH_METHOD: expected %s, %s or %s", vaultAuthToken, vaultAuthAppRole, vaultAuthKubernetes)
+	}
+
+	return VaultProvider{
+		Address:   address,
+		Namespace: GetEnv("VAULT_NAMESPACE", ""),
+		Mount:     strings.Trim(GetEnv("VAULT_KV_MOUNT", "secret"), "/"),
+		Field:     GetEnv("VAULT_KV_FIELD", "value"),
+		Retry:     retry,
+		auth:      auth,
+	}, nil
+}
@@ -0,0 +1,128 @@
+package secrets
+
+import (
+	"context"
+	"errors"
+	"path"
+
+	"cloud.google.com/go/secretmanager/apiv1/secretmanagerpb"
+)
+
+// ErrMetadataUnavailable is returned when version metadata is requested from a provider that does not keep versions
+var ErrMetadataUnavailable = errors.New("version metadata is not available on this backend")
+
+// ErrVersionsUnavailable is returned when a specific version is requested from a provider that cannot get one
+var ErrVersionsUnavailable = errors.New("specific versions are not available on this backend")
+
+// VersionGetter is implemented by providers that can get a specific version of a secret
+type VersionGetter interface {
+	GetSecretVersion(ctx context.Context, name string, version string) (string, error)
+}
+
+// GetSecretVersion gets a specific version of the secret, to pin it during a rollback
+// Caches and fallbacks are skipped, as a pinned version is either the one asked for or an error
+func (sg SecretGetter) GetSecretVersion(ctx context.Context, name string, version string) (string, error) {
+	provider, ok := sg.Provider.(VersionGetter)
+	if !ok {
+		return "", ErrVersionsUnavailable
+	}
+
+	value, err := provider.GetSecretVersion(sg.providerContext(ctx, sg.Prefix+name), sg.Prefix+name, version)
+	if err != nil {
+		return "", err
+	}
+	sg.Served.Record(name, ProviderSource(sg.Provider))
+	sg.Redactor.Add(value)
+	return value, nil
+}
+
+// PinLatest resolves the latest version of the secret and gets the value of that version, so related reads can use
+// the returned version and get the same value even if the secret rotates meanwhile
+// The metadata is asked to the provider rather than taken from the cache, as it has to be the latest version now
+func (sg SecretGetter) PinLatest(ctx context.Context, name string) (string, string, error) {
+	provider, ok := sg.Provider.(VersionedProvider)
+	if _, canGetVersions := sg.Provider.(VersionGetter); !ok || !canGetVersions {
+		return "", "", ErrVersionsUnavailable
+	}
+
+	metadata, err := provider.GetVersionMetadata(sg.providerContext(ctx, sg.Prefix+name), sg.Prefix+name)
+	if err != nil {
+		return "", "", err
+	}
+	value, err := sg.GetSecretVersion(ctx, name, metadata.Version)
+	if err != nil {
+		return "", "", err
+	}
+	return value, metadata.Version, nil
+}
+
+// VersionMetadata describes the latest enabled version of a secret, it never includes the value
+type VersionMetadata struct {
+	Version    string `json:"version"`
+	CreateTime string `json:"createTime"`
+}
+
+// VersionedProvider is implemented by providers that keep versions of their secrets
+type VersionedProvider interface {
+	GetVersionMetadata(ctx context.Context, name string) (VersionMetadata, error)
+}
+
+// GetVersionMetadata gets the metadata of the latest enabled version, so clients can tell the age of a secret
+func (sg SecretGetter) GetVersionMetadata(ctx context.Context, name string) (VersionMetadata, error) {
+	provider, ok := sg.Provider.(VersionedProvider)
+	if !ok {
+		return VersionMetadata{}, ErrMetadataUnavailable
+	}
+	name = sg.Prefix + name
+
+	// The metadata of other projects asked for by the request is cached apart, like their values
+	key := cacheKey(projectFromContext(ctx), name)
+	if cached, ok := sg.VersionMetadataCache.Get(key); ok {
+		return cached.(VersionMetadata), nil
+	}
+
+	metadata, err := provider.GetVersionMetadata(sg.providerContext(ctx, name), name)
+	if err != nil {
+		return VersionMetadata{}, err
+	}
+
+	sg.VersionMetadataCache.Set(key, metadata)
+	return metadata, nil
+}
+
+// GetVersionMetadata gets the latest enabled version from Secret Manager, retried like the secret fetch
+func (p GCPProvider) GetVersionMetadata(ctx context.Context, name string) (VersionMetadata, error) {
+	var metadata VersionMetadata
+	err := p.withToken(ctx, func(token string) error {
+		return p.SecretManagerRetry.do(ctx, func(ctx context.Context) error {
+			var err error
+			metadata, err = p.fetchVersionMetadata(ctx, name, token)
+			return err
+		})
+	})
+	return metadata, err
+}
+
+// fetchVersionMetadata gets the latest version of the secret, without accessing its value
+func (p GCPProvider) fetchVersionMetadata(ctx context.Context, name string, token string) (VersionMetadata, error) {
+	versionName, err := p.secretVersionName(p.projectFor(ctx, name), name, "latest")
+	if err != nil {
+		return VersionMetadata{}, err
+	}
+	client, err := p.secretManagerClient(ctx, token)
+	if err != nil {
+		return VersionMetadata{}, err
+	}
+	defer client.Close()
+
+	versionResponse, err := client.GetSecretVersion(ctx, &secretmanagerpb.GetSecretVersionRequest{Name: versionName})
+	if err != nil {
+		return VersionMetadata{}, secretManagerError(err)
+	}
+
+	// The name is projects/<project>/secrets/<secret>/versions/<version>
+	return VersionMetadata{
+		Version:    path.Base(versionResponse.GetName()),
+		CreateTime: formatTimestamp(versionResponse.GetCreateTime()),
+	}, nil
+}
@@ -0,0 +1,65 @@
+package secrets
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestPinLatest(t *testing.T) {
+	provider := NewMemoryProvider(map[string]string{"db-password": "first"})
+	sg := SecretGetter{Provider: provider}
+	ctx := context.Background()
+
+	value, version, err := sg.PinLatest(ctx, "db-password")
+	if err != nil {
+		t.Fatalf("pinning latest: %s", err)
+	}
+	if value != "first" || version != "1" {
+		t.Errorf("expected first at version 1, got %s at %s", value, version)
+	}
+
+	// After a rotation, the pinned version still reads its own value
+	_, _, err = provider.PutSecret(ctx, "db-password", "second")
+	if err != nil {
+		t.Fatalf("rotating: %s", err)
+	}
+	pinned, err := sg.GetSecretVersion(ctx, "db-password", version)
+	if err != nil || pinned != "first" {
+		t.Errorf("expected first, got %q (%v)", pinned, err)
+	}
+	value, version, err = sg.PinLatest(ctx, "db-password")
+	if err != nil || value != "second" || version != "2" {
+		t.Errorf("expected second at version 2, got %q at %q (%v)", value, version, err)
+	}
+
+	// A disabled latest version is skipped, as reading latest does
+	err = provider.RevokeVersion(ctx, "db-password", "2", false)
+	if err != nil {
+		t.Fatalf("disabling version: %s", err)
+	}
+	value, version, err = sg.PinLatest(ctx, "db-password")
+	if err != nil || value != "first" || version != "1" {
+		t.Errorf("expected first at version 1, got %q at %q (%v)", value, version, err)
+	}
+
+	_, _, err = sg.PinLatest(ctx, "api-key")
+	if !errors.Is(err, ErrSecretNotFound) {
+		t.Errorf("expected not found, got %v", err)
+	}
+}
+
+func TestPinLatestWithoutVersions(t *testing.T) {
+	sg := SecretGetter{Provider: staticProvider{}}
+	_, _, err := sg.PinLatest(context.Background(), "db-password")
+	if !errors.Is(err, ErrVersionsUnavailable) {
+		t.Errorf("expected versions unavailable, got %v", err)
+	}
+}
+
+// staticProvider has no versions, serving the same value for every secret
+type staticProvider struct{}
+
+func (staticProvider) GetSecret(ctx context.Context, name string) (string, error) {
+	return "static", nil
+}
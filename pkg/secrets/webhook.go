@@ -0,0 +1,187 @@
+package secrets
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// AccessEvent records a secret access, it never includes the value
+type AccessEvent struct {
+	Name   string    `json:"name"`
+	Time   time.Time `json:"time"`
+	Client string    `json:"client"`
+	Result string    `json:"result"`
+}
+
+// WebhookEmitter posts access events to a webhook on the background
+// Events are dropped rather than blocking the request path when the buffer is full
+type WebhookEmitter struct {
+	url     string
+	client  *http.Client
+	events  chan AccessEvent
+	dropped uint64
+}
+
+// NewWebhookEmitter returns an emitter posting to the given url, buffering up to size events
+func NewWebhookEmitter(url string, size int) *WebhookEmitter {
+	e := &WebhookEmitter{
+		url:    url,
+		client: &http.Client{Timeout: 5 * time.Second},
+		events: make(chan AccessEvent, size),
+	}
+	go e.run()
+	return e
+}
+
+// Emit queues the event, it never blocks
+func (e *WebhookEmitter) Emit(event AccessEvent) {
+	if e == nil {
+		return
+	}
+
+	select {
+	case e.events <- event:
+	default:
+		atomic.AddUint64(&e.dropped, 1)
+	}
+}
+
+// Dropped returns how many events were dropped because the buffer was full
+func (e *WebhookEmitter) Dropped() uint64 {
+	if e == nil {
+		return 0
+	}
+	return atomic.LoadUint64(&e.dropped)
+}
+
+// run posts the queued events one at a time
+func (e *WebhookEmitter) run() {
+	for event := range e.events {
+		err := e.post(event)
+		if err != nil {
+			slog.Error("posting access event", "error", err)
+		}
+	}
+}
+
+// post sends a single event to the webhook
+func (e *WebhookEmitter) post(event AccessEvent) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	rs, err := e.client.Post(e.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer rs.Body.Close()
+	_, _ = io.Copy(ioutil.Discard, rs.Body)
+
+	if rs.StatusCode >= 300 {
+		return fmt.Errorf("webhook answered %d", rs.StatusCode)
+	}
+	return nil
+}
+
+// changeSignatureHeader carries the HMAC-SHA256 of the timestamp and the body, so receivers can tell the POST is ours
+const changeSignatureHeader = "X-Signature-256"
+
+// changeTimestampHeader carries the time the POST was signed at, so receivers can reject replays of old ones
+const changeTimestampHeader = "X-Signature-Timestamp"
+
+// ChangeNotifier posts secret changes to webhooks on the background, signed with a shared key
+// Changes are dropped rather than blocking the rotation watcher when the buffer is full
+type ChangeNotifier struct {
+	urls       []string
+	signingKey []byte
+	client     *http.Client
+	clock      Clock
+	changes    chan SecretChange
+}
+
+// NewChangeNotifier returns a notifier posting to every url, buffering up to size changes, signed with the time of
+// the clock
+func NewChangeNotifier(urls []string, signingKey string, size int, clock Clock) *ChangeNotifier {
+	if clock == nil {
+		clock = RealClock{}
+	}
+	n := &ChangeNotifier{
+		urls:       urls,
+		signingKey: []byte(signingKey),
+		client:     &http.Client{Timeout: 5 * time.Second},
+		clock:      clock,
+		changes:    make(chan SecretChange, size),
+	}
+	go n.run()
+	return n
+}
+
+// Notify queues the change, it never blocks
+func (n *ChangeNotifier) Notify(change SecretChange) {
+	if n == nil {
+		return
+	}
+
+	select {
+	case n.changes <- change:
+	default:
+		slog.Error("dropping secret change, the webhook buffer is full", "name", change.Name)
+	}
+}
+
+// run posts the queued changes to every webhook, one at a time
+func (n *ChangeNotifier) run() {
+	for change := range n.changes {
+		for _, url := range n.urls {
+			err := n.post(url, change)
+			if err != nil {
+				slog.Error("posting secret change", "url", url, "name", change.Name, "error", err)
+			}
+		}
+	}
+}
+
+// post sends a single change to the webhook, signing the timestamp and the body with the key
+func (n *ChangeNotifier) post(url string, change SecretChange) error {
+	body, err := json.Marshal(change)
+	if err != nil {
+		return err
+	}
+
+	timestamp := strconv.FormatInt(n.clock.Now().Unix(), 10)
+	mac := hmac.New(sha256.New, n.signingKey)
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+
+	rq, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	rq.Header.Set("Content-Type", "application/json")
+	rq.Header.Set(changeTimestampHeader, timestamp)
+	rq.Header.Set(changeSignatureHeader, "sha256="+hex.EncodeToString(mac.Sum(nil)))
+
+	rs, err := n.client.Do(rq)
+	if err != nil {
+		return err
+	}
+	defer rs.Body.Close()
+	_, _ = io.Copy(ioutil.Discard, rs.Body)
+
+	if rs.StatusCode >= 300 {
+		return fmt.Errorf("webhook answered %d", rs.StatusCode)
+	}
+	return nil
+}
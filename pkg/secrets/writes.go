@@ -0,0 +1,162 @@
+package secrets
+
+import (
+	"context"
+	"errors"
+	"path"
+
+	"cloud.google.com/go/secretmanager/apiv1/secretmanagerpb"
+)
+
+// ErrWritesUnavailable is returned when a secret is written on a provider that cannot write them
+var ErrWritesUnavailable = errors.New("writing secrets is not available on this backend")
+
+// MaxSecretSize is the largest payload Secret Manager accepts for a version
+const MaxSecretSize = 64 << 10
+
+// SecretWriter is implemented by providers that can write secrets
+// PutSecret adds a version with the value, creating the secret when it does not exist yet
+type SecretWriter interface {
+	PutSecret(ctx context.Context, name string, value string) (version string, created bool, err error)
+}
+
+// SecretVersionRevoker is implemented by providers that can disable or destroy versions of secrets
+// Disabled versions can be enabled again, destroyed ones are gone for good
+type SecretVersionRevoker interface {
+	RevokeVersion(ctx context.Context, name string, version string, destroy bool) error
+}
+
+// PutSecret writes a new version of the secret, the cached values of every project are dropped so the new one is
+// served next, whichever project the write went to
+func (sg SecretGetter) PutSecret(ctx context.Context, name string, value string) (string, bool, error) {
+	writer, ok := sg.Provider.(SecretWriter)
+	if !ok {
+		return "", false, ErrWritesUnavailable
+	}
+
+	name = sg.Prefix + name
+	version, created, err := writer.PutSecret(sg.providerContext(ctx, name), name, value)
+	if err != nil {
+		return "", false, err
+	}
+	sg.forgetEveryProject(name)
+	return version, created, nil
+}
+
+// RevokeVersion disables or destroys a version of the secret, the cached values of every project are dropped as they may
+// be that version, the last known good values and the disk cache too, so a revoked value is never served again
+func (sg SecretGetter) RevokeVersion(ctx context.Context, name string, version string, destroy bool) error {
+	revoker, ok := sg.Provider.(SecretVersionRevoker)
+	if !ok {
+		return ErrWritesUnavailable
+	}
+
+	name = sg.Prefix + name
+	err := revoker.RevokeVersion(sg.providerContext(ctx, name), name, version, destroy)
+	if err != nil {
+		return err
+	}
+	sg.forgetEveryProject(name)
+	return nil
+}
+
+// PutSecret adds a version to the secret, creating it with automatic replication when it does not exist
+// Writes are not retried, as adding the same version twice would leave an extra version behind, but a rejected token
+// wrote nothing so they are made again with a new one
+func (p GCPProvider) PutSecret(ctx context.Context, name string, value string) (string, bool, error) {
+	once := RetryPolicy{Timeout: p.SecretManagerRetry.Timeout}
+	var version string
+	var created bool
+	err := p.withToken(ctx, func(token string) error {
+		err := once.attempt(ctx, func(ctx context.Context) error {
+			var err error
+			version, err = p.addVersion(ctx, name, value, token)
+			return err
+		})
+		if !errors.Is(err, ErrSecretNotFound) {
+			return err
+		}
+
+		err = once.attempt(ctx, func(ctx context.Context) error {
+			err := p.createSecret(ctx, name, token)
+			if err != nil {
+				return err
+			}
+			version, err = p.addVersion(ctx, name, value, token)
+			return err
+		})
+		created = err == nil
+		return err
+	})
+	return version, created, err
+}
+
+// addVersion adds a version with the value to an existing secret, it returns the number of the version
+func (p GCPProvider) addVersion(ctx context.Context, name string, value string, token string) (string, error) {
+	secretName, err := p.secretName(p.projectFor(ctx, name), name)
+	if err != nil {
+		return "", err
+	}
+	client, err := p.secretManagerClient(ctx, token)
+	if err != nil {
+		return "", err
+	}
+	defer client.Close()
+
+	version, err := client.AddSecretVersion(ctx, &secretmanagerpb.AddSecretVersionRequest{
+		Parent:  secretName,
+		Payload: &secretmanagerpb.SecretPayload{Data: []byte(value)},
+	})
+	if err != nil {
+		return "", secretManagerError(err)
+	}
+
+	// The name is projects/<project>/secrets/<secret>/versions/<version>
+	return path.Base(version.GetName()), nil
+}
+
+// createSecret creates the secret without any version, regional secrets take the location of the endpoint
+func (p GCPProvider) createSecret(ctx context.Context, name string, token string) error {
+	parent, err := p.secretsParent(p.projectFor(ctx, name))
+	if err != nil {
+		return err
+	}
+	client, err := p.secretManagerClient(ctx, token)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	secret := &secretmanagerpb.Secret{}
+	if p.Location == "" {
+		secret.Replication = &secretmanagerpb.Replication{
+			Replication: &secretmanagerpb.Replication_Automatic_{Automatic: &secretmanagerpb.Replication_Automatic{}},
+		}
+	}
+	_, err = client.CreateSecret(ctx, &secretmanagerpb.CreateSecretRequest{Parent: parent, SecretId: name, Secret: secret})
+	return secretManagerError(err)
+}
+
+// RevokeVersion disables or destroys the version, both are idempotent so they are retried
+func (p GCPProvider) RevokeVersion(ctx context.Context, name string, version string, destroy bool) error {
+	versionName, err := p.secretVersionName(p.projectFor(ctx, name), name, version)
+	if err != nil {
+		return err
+	}
+	return p.withToken(ctx, func(token string) error {
+		return p.SecretManagerRetry.do(ctx, func(ctx context.Context) error {
+			client, err := p.secretManagerClient(ctx, token)
+			if err != nil {
+				return err
+			}
+			defer client.Close()
+
+			if destroy {
+				_, err = client.DestroySecretVersion(ctx, &secretmanagerpb.DestroySecretVersionRequest{Name: versionName})
+			} else {
+				_, err = client.DisableSecretVersion(ctx, &secretmanagerpb.DisableSecretVersionRequest{Name: versionName})
+			}
+			return secretManagerError(err)
+		})
+	})
+}